@@ -165,6 +165,11 @@ export GOPATH=$(pwd):%{gopath}
 go build -tags "seccomp selinux" -ldflags "-s -w" ./cmd/broker
 go build -tags "seccomp selinux" -ldflags "-s -w" ./cmd/migration
 go build -tags "seccomp selinux" -ldflags "-s -w" ./cmd/dashboard-redirector
+go build -tags "seccomp selinux" -ldflags "-s -w" ./cmd/reencrypt
+go build -tags "seccomp selinux" -ldflags "-s -w" ./cmd/backup
+go build -tags "seccomp selinux" -ldflags "-s -w" ./cmd/fsck
+go build -tags "seccomp selinux" -ldflags "-s -w" ./cmd/dead-letters
+go build -tags "seccomp selinux" -ldflags "-s -w" ./cmd/upgrade-records
 
 #Build selinux modules
 # create selinux-friendly version from VR and replace it inplace
@@ -195,6 +200,12 @@ install -d -p %{buildroot}%{_bindir}
 install -p -m 755 broker %{buildroot}%{_bindir}/asbd
 install -p -m 755 migration %{buildroot}%{_bindir}/migration
 install -p -m 755 dashboard-redirector %{buildroot}%{_bindir}/dashboard-redirector
+install -p -m 755 reencrypt %{buildroot}%{_bindir}/reencrypt
+install -p -m 755 backup %{buildroot}%{_bindir}/backup
+install -p -m 755 fsck %{buildroot}%{_bindir}/fsck
+%{_bindir}/dead-letters
+install -p -m 755 dead-letters %{buildroot}%{_bindir}/dead-letters
+install -p -m 755 upgrade-records %{buildroot}%{_bindir}/upgrade-records
 # broker apb
 mkdir -p %{buildroot}/opt/apb/ %{buildroot}/opt/ansible/roles/automation-broker-apb
 mv ansible_role/playbooks %{buildroot}/opt/apb/actions
@@ -306,6 +317,11 @@ export GOPATH=%{buildroot}/%{gopath}:$(pwd)/Godeps/_workspace:%{gopath}
 %{_bindir}/asbd
 %{_bindir}/migration
 %{_bindir}/dashboard-redirector
+%{_bindir}/reencrypt
+%{_bindir}/backup
+%{_bindir}/fsck
+%{_bindir}/dead-letters
+%{_bindir}/upgrade-records
 %attr(750, ansibleservicebroker, ansibleservicebroker) %dir %{_sysconfdir}/%{name}
 %attr(640, ansibleservicebroker, ansibleservicebroker) %config %{_sysconfdir}/%{name}/config.yaml
 %{_unitdir}/%{name}.service
@@ -0,0 +1,117 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// backup exports the broker state, the specs, service instances, bind
+// instances, job states and extracted credentials, to an archive and imports
+// such an archive into the data store of a broker config. The records are
+// archived as they are stored, so encrypted records can only be imported by a
+// broker with the same encryption keys.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/automationbroker/bundle-lib/runtime"
+	"github.com/automationbroker/config"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/dao/backup"
+	"github.com/openshift/ansible-service-broker/pkg/dao/encryption"
+	"github.com/openshift/ansible-service-broker/pkg/dao/migration"
+	log "github.com/sirupsen/logrus"
+)
+
+var options struct {
+	ConfigFile  string
+	Export      string
+	Import      string
+	OnConflict  string
+	Credentials bool
+}
+
+func init() {
+	flag.StringVar(&options.ConfigFile, "config", "/etc/ansible-service-broker/config.yaml", "broker config file with the dao section to back up or restore")
+	flag.StringVar(&options.Export, "export", "", "write the broker state to this archive")
+	flag.StringVar(&options.Import, "import", "", "restore the broker state from this archive")
+	flag.StringVar(&options.OnConflict, "on-conflict", "fail", "what to do with records that are different in the data store: skip, overwrite or fail")
+	flag.BoolVar(&options.Credentials, "credentials", true, "include the extracted credentials, which are kept in secrets")
+	flag.Parse()
+}
+
+func main() {
+	if (options.Export == "") == (options.Import == "") {
+		log.Fatal("Either --export or --import is required")
+	}
+	onConflict, err := migration.ParseConflictMode(options.OnConflict)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c, err := config.CreateConfig(options.ConfigFile)
+	if err != nil {
+		log.Fatalf("Unable to read the config file - %v", err)
+	}
+
+	d, err := dao.InitFromConfig(c)
+	if err != nil {
+		log.Fatalf("Unable to connect to the dao - %v", err)
+	}
+	d = dao.Backend(d)
+	var creds runtime.ExtractedCredential
+	if options.Credentials {
+		creds = encryption.SecretCredentials{}
+	}
+	ns := c.GetString("openshift.namespace")
+
+	if options.Export != "" {
+		a, err := backup.Export(d, creds, ns)
+		if err != nil {
+			log.Fatalf("Unable to export the broker state - %v", err)
+		}
+		f, err := os.OpenFile(options.Export, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Fatalf("Unable to create the archive - %v", err)
+		}
+		if err := a.Write(f); err != nil {
+			f.Close()
+			log.Fatalf("Unable to write the archive - %v", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("Unable to write the archive - %v", err)
+		}
+		log.Infof("Exported the broker state to %s", options.Export)
+		return
+	}
+
+	f, err := os.Open(options.Import)
+	if err != nil {
+		log.Fatalf("Unable to open the archive - %v", err)
+	}
+	a, err := backup.Read(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Unable to read the archive - %v", err)
+	}
+	log.Infof("Restoring the broker state from %s, created %v", options.Import, a.Created)
+	report, err := backup.Restore(a, d, creds, ns, onConflict)
+	if err != nil {
+		log.Fatalf("Unable to restore the broker state - %v", err)
+	}
+	log.Infof("Created [ %d ], updated [ %d ], skipped [ %d ], failed [ %d ] records",
+		report.Created, report.Updated, report.Skipped, report.Failed)
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
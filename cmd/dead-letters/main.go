@@ -0,0 +1,143 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// dead-letters lists the job messages the subscribers of the broker failed to
+// handle, stored as dead letters, and with --replay asks the broker to hand
+// them once more to their subscribers. The subscribers only exist in the
+// running broker, so the replay goes through its admin route.
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/automationbroker/config"
+	"github.com/openshift/ansible-service-broker/pkg/broker"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
+	log "github.com/sirupsen/logrus"
+)
+
+var options struct {
+	ConfigFile   string
+	Replay       bool
+	BrokerURL    string
+	Username     string
+	PasswordFile string
+	TokenFile    string
+	Insecure     bool
+}
+
+func init() {
+	flag.StringVar(&options.ConfigFile, "config", "/etc/ansible-service-broker/config.yaml", "broker config file with the dao section holding the dead letters")
+	flag.BoolVar(&options.Replay, "replay", false, "ask the broker to hand the dead letters to their subscribers once more")
+	flag.StringVar(&options.BrokerURL, "broker-url", "https://localhost:1338/ansible-service-broker", "the url of the broker, with its route prefix")
+	flag.StringVar(&options.Username, "username", "", "the user of the basic auth of the broker")
+	flag.StringVar(&options.PasswordFile, "password-file", "", "a file holding the password of the basic auth of the broker")
+	flag.StringVar(&options.TokenFile, "token-file", "", "a file holding the bearer token sent to the broker")
+	flag.BoolVar(&options.Insecure, "insecure", false, "do not verify the certificate of the broker")
+	flag.Parse()
+}
+
+func main() {
+	c, err := config.CreateConfig(options.ConfigFile)
+	if err != nil {
+		log.Fatalf("Unable to read the config file - %v", err)
+	}
+
+	d, err := dao.InitFromConfig(c)
+	if err != nil {
+		log.Fatalf("Unable to connect to the dao - %v", err)
+	}
+
+	letters, err := d.BatchGetDeadLetters()
+	if err != nil {
+		log.Fatalf("Unable to get the dead letters - %v", err)
+	}
+	for _, letter := range letters {
+		fmt.Printf("%s %s subscriber=%s topic=%s token=%s attempts=%d superseded=%t error=%q\n",
+			letter.Created.Format(time.RFC3339), letter.ID, letter.Subscriber, letter.Topic, letter.Token, letter.Attempts,
+			letter.Superseded, letter.Error)
+	}
+	log.Infof("Found [ %d ] dead letters", len(letters))
+	if !options.Replay || len(letters) == 0 {
+		return
+	}
+
+	resp, err := replay()
+	if err != nil {
+		log.Fatalf("Unable to replay the dead letters - %v", err)
+	}
+	log.Infof("Replayed [ %d ] dead letters, [ %d ] left, [ %d ] superseded", resp.Replayed, resp.Left, resp.Superseded)
+	if resp.Left > 0 {
+		os.Exit(1)
+	}
+}
+
+// replay - asks the broker to replay the dead letters through its admin
+// route.
+func replay() (*broker.ReplayResponse, error) {
+	url := strings.TrimSuffix(options.BrokerURL, "/") + "/v2/admin/dead_letters/replay"
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if options.TokenFile != "" {
+		token, err := ioutil.ReadFile(options.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	} else if options.Username != "" {
+		password := []byte{}
+		if options.PasswordFile != "" {
+			if password, err = ioutil.ReadFile(options.PasswordFile); err != nil {
+				return nil, err
+			}
+		}
+		req.SetBasicAuth(options.Username, strings.TrimSpace(string(password)))
+	}
+
+	client := &http.Client{
+		Timeout: 5 * time.Minute,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: options.Insecure},
+		},
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the broker answered %s - %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	resp := &broker.ReplayResponse{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,76 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// fsck reports the records of the broker state that refer to records that no
+// longer exist: bindings whose service instance is gone, service instance
+// binding ids without a binding, and unfinished jobs whose service instance or
+// binding is gone. With --repair the problems that can be safely fixed are.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/automationbroker/config"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/dao/fsck"
+	log "github.com/sirupsen/logrus"
+)
+
+var options struct {
+	ConfigFile  string
+	Repair      bool
+	GracePeriod time.Duration
+}
+
+func init() {
+	flag.StringVar(&options.ConfigFile, "config", "/etc/ansible-service-broker/config.yaml", "broker config file with the dao section to check")
+	flag.BoolVar(&options.Repair, "repair", false, "repair the problems that can be safely fixed")
+	flag.DurationVar(&options.GracePeriod, "grace-period", 10*time.Minute, "how long an unfinished job of a missing instance or binding is left alone")
+	flag.Parse()
+}
+
+func main() {
+	c, err := config.CreateConfig(options.ConfigFile)
+	if err != nil {
+		log.Fatalf("Unable to read the config file - %v", err)
+	}
+
+	d, err := dao.InitFromConfig(c)
+	if err != nil {
+		log.Fatalf("Unable to connect to the dao - %v", err)
+	}
+
+	problems, err := fsck.NewChecker(d, options.GracePeriod).Run(options.Repair)
+	if err != nil {
+		log.Fatalf("Unable to check the dao - %v", err)
+	}
+	unrepaired := 0
+	for _, p := range problems {
+		if p.Repaired {
+			fmt.Printf("%v (repaired)\n", p)
+			continue
+		}
+		fmt.Println(p)
+		unrepaired++
+	}
+	log.Infof("Found [ %d ] problems, [ %d ] left", len(problems), unrepaired)
+	if unrepaired > 0 {
+		os.Exit(1)
+	}
+}
@@ -14,6 +14,10 @@
 // limitations under the License.
 //
 
+// migration copies the broker state between two dao backends, in either
+// direction. Records already in the target are skipped, so it can be run again
+// after a failure, and with --checkpoint an interrupted migration resumes where
+// it stopped. Once done the target is compared with the source.
 package main
 
 import (
@@ -21,11 +25,16 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	apb "github.com/automationbroker/bundle-lib/bundle"
 	"github.com/automationbroker/bundle-lib/clients"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
 	crd "github.com/openshift/ansible-service-broker/pkg/dao/crd"
 	etcd "github.com/openshift/ansible-service-broker/pkg/dao/etcd"
+	etcdv3 "github.com/openshift/ansible-service-broker/pkg/dao/etcdv3"
+	file "github.com/openshift/ansible-service-broker/pkg/dao/file"
+	"github.com/openshift/ansible-service-broker/pkg/dao/migration"
 	"github.com/sirupsen/logrus"
 )
 
@@ -36,6 +45,10 @@ var options struct {
 	EtcdClientKey      string
 	EtcdPort           int
 	MigrationNamespace string
+	From               string
+	To                 string
+	DryRun             bool
+	CheckpointFile     string
 }
 
 func init() {
@@ -45,291 +58,166 @@ func init() {
 	flag.StringVar(&options.EtcdClientCert, "client-cert", "", "client cert file path to authenticate to etcd server. If used must also use client-key")
 	flag.StringVar(&options.EtcdClientKey, "client-key", "", "client key file path to authenticate to etcd server. If used must also use client-cert")
 	flag.StringVar(&options.MigrationNamespace, "namespace", "", "namepsace that the migration should run in")
+	flag.StringVar(&options.From, "from", "etcd", "backend to migrate from: etcd, etcd3, crd or file:<path>")
+	flag.StringVar(&options.To, "to", "crd", "backend to migrate to: etcd, etcd3, crd or file:<path>")
+	flag.BoolVar(&options.DryRun, "dry-run", false, "only report what would be migrated")
+	flag.StringVar(&options.CheckpointFile, "checkpoint", "", "file recording the migrated records, used to resume an interrupted migration")
 	flag.Parse()
 }
 
-var crdDao *crd.Dao
-var etcdDao *etcd.Dao
-
-type extCredsMigration struct {
-	ExtCreds  apb.ExtractedCredentials
-	ID        string
-	Namespace string
-}
-
 func main() {
-	con := clients.EtcdConfig{
-		EtcdHost:       options.EtcdHost,
-		EtcdPort:       options.EtcdPort,
-		EtcdCaFile:     options.EtcdCAFile,
-		EtcdClientKey:  options.EtcdClientKey,
-		EtcdClientCert: options.EtcdClientCert,
-	}
 	logrus.SetLevel(logrus.DebugLevel)
-	clients.InitEtcdConfig(con)
-	logrus.Infof("etcd configuration: %v", con)
-	var err error
-	etcdDao, err = etcd.NewDao()
-	if err != nil {
-		panic(fmt.Sprintf("Unable to connect to etcd - %v", err))
+	if options.From == options.To {
+		logrus.Fatalf("Unable to migrate %s to itself", options.From)
 	}
-	crdDao, err = crd.NewDao(options.MigrationNamespace)
+	if options.From == "etcd" || options.To == "etcd" {
+		con := etcdConfig()
+		clients.InitEtcdConfig(con)
+		logrus.Infof("etcd configuration: %v", con)
+	}
+
+	source, err := newBackend(options.From)
 	if err != nil {
-		panic(fmt.Sprintf("Unable to create crd client - %v", err))
+		logrus.Fatalf("Unable to connect to %s - %v", options.From, err)
 	}
-	k8scli, err := clients.Kubernetes()
+	target, err := newBackend(options.To)
 	if err != nil {
-		panic(fmt.Sprintf("Unable to get kubernetes client - %v", err))
+		logrus.Fatalf("Unable to connect to %s - %v", options.To, err)
 	}
 
-	//
-	// convert specs to bundles
-	//
-	etcdSpecs, err := etcdDao.BatchGetSpecs("/spec")
-	if err != nil && !etcdDao.IsNotFoundError(err) {
-		panic(fmt.Sprintf("Unable to get all specs from etcd - %v", err))
-	}
-	crdSavedSpecs := []*apb.Spec{}
-	for _, s := range etcdSpecs {
-		err := crdDao.SetSpec(s.ID, s)
+	var checkpoint *migration.Checkpoint
+	if options.CheckpointFile != "" {
+		checkpoint, err = migration.LoadCheckpoint(options.CheckpointFile, options.From, options.To)
 		if err != nil {
-			logrus.Errorf("unable to save crd spec - %v", err)
-			revertCrdSavedSpecs(crdSavedSpecs)
-			os.Exit(2)
+			logrus.Fatalf("Unable to load the checkpoint - %v", err)
 		}
-		crdSavedSpecs = append(crdSavedSpecs, s)
-	}
-
-	//
-	// convert all the service instances
-	//
-	siSaved := []*apb.ServiceInstance{}
-	siJSONStrs, err := etcdDao.BatchGetRaw("/service_instance")
-	if err != nil && !etcdDao.IsNotFoundError(err) {
-		revertServiceInstance(siSaved)
-		revertCrdSavedSpecs(crdSavedSpecs)
-		panic(fmt.Sprintf("Unable to migrate all the service instances - %v", err))
-	}
-	if siJSONStrs != nil {
-		for _, str := range *siJSONStrs {
-			si := apb.ServiceInstance{}
-			err := json.Unmarshal([]byte(str), &si)
-			if err != nil {
-				revertServiceInstance(siSaved)
-				revertCrdSavedSpecs(crdSavedSpecs)
-				panic(fmt.Sprintf("Unable to migrate all the service instances json unmarshal error - %v", err))
-			}
-			err = crdDao.SetServiceInstance(si.ID.String(), &si)
-			if err != nil {
-				revertServiceInstance(siSaved)
-				revertCrdSavedSpecs(crdSavedSpecs)
-				panic(fmt.Sprintf("Unable to migrate all the service instances set service instance - %v", err))
-			}
-			siSaved = append(siSaved, &si)
+		if len(checkpoint.Migrated) > 0 {
+			logrus.Infof("Resuming the migration, [ %d ] records were already migrated", len(checkpoint.Migrated))
 		}
 	}
 
-	//
-	// convert all the service bindings
-	//
-	biSaved := []*apb.BindInstance{}
-	biJSONStrs, err := etcdDao.BatchGetRaw("/bind_instance")
-	if err != nil && !etcdDao.IsNotFoundError(err) {
-		revertServiceInstance(siSaved)
-		revertCrdSavedSpecs(crdSavedSpecs)
-		panic(fmt.Sprintf("Unable to migrate all the service instances - %v", err))
+	m := &migration.Migrator{
+		Source:     source,
+		Target:     target,
+		DryRun:     options.DryRun,
+		Checkpoint: checkpoint,
 	}
-	if biJSONStrs != nil {
-		for _, str := range *biJSONStrs {
-			bi := apb.BindInstance{}
-			err := json.Unmarshal([]byte(str), &bi)
-			if err != nil {
-				revertBindInstance(biSaved)
-				revertServiceInstance(siSaved)
-				revertCrdSavedSpecs(crdSavedSpecs)
-				panic(fmt.Sprintf("Unable to migrate all the binding instances - %v", err))
-			}
-			err = crdDao.SetBindInstance(bi.ID.String(), &bi)
-			if err != nil {
-				revertBindInstance(biSaved)
-				revertServiceInstance(siSaved)
-				revertCrdSavedSpecs(crdSavedSpecs)
-				panic(fmt.Sprintf("Unable to migrate all the binding instances - %v", err))
-			}
-			biSaved = append(biSaved, &bi)
-		}
+	logrus.Infof("Migrating from %s to %s", options.From, options.To)
+	report, err := m.Run()
+	logrus.Infof("Created [ %d ], updated [ %d ], skipped [ %d ], failed [ %d ] records",
+		report.Created, report.Updated, report.Skipped, report.Failed)
+	if err != nil {
+		logrus.Fatalf("Migration stopped - %v", err)
 	}
 
-	//
-	// convert job states
-	//
-	jsSaved := []*apb.JobState{}
-	for _, si := range siSaved {
-		// Convert all the job states
-		jobStateNodes, err := etcdDao.BatchGetRaw(fmt.Sprintf("/state/%v/job", si.ID.String()))
-		if err != nil && !etcdDao.IsNotFoundError(err) {
-			revertBindInstance(biSaved)
-			revertServiceInstance(siSaved)
-			revertCrdSavedSpecs(crdSavedSpecs)
-			panic(fmt.Sprintf("Unable to migrate all the jobs states - %v", err))
-		}
-		if jobStateNodes != nil {
-			for _, jsStr := range *jobStateNodes {
-				js := apb.JobState{}
-				err := json.Unmarshal([]byte(jsStr), &js)
-				if err != nil {
-					revertBindInstance(biSaved)
-					revertServiceInstance(siSaved)
-					revertCrdSavedSpecs(crdSavedSpecs)
-					panic(fmt.Sprintf("Unable to migrate all the job states- %v", err))
-				}
-				_, err = crdDao.SetState(si.ID.String(), js)
-				if err != nil {
-					revertBindInstance(biSaved)
-					revertServiceInstance(siSaved)
-					revertCrdSavedSpecs(crdSavedSpecs)
-					panic(fmt.Sprintf("Unable to migrate all the job states  - %v", err))
-				}
-				jsSaved = append(jsSaved, &js)
-			}
+	// etcd brokers older than the move to secrets kept the extracted
+	// credentials in etcd.
+	if legacy, ok := source.(*etcd.Dao); ok {
+		if err := migrateLegacyCredentials(legacy); err != nil {
+			logrus.Fatalf("Unable to migrate the extracted credentials - %v", err)
 		}
 	}
 
-	//
-	// Convert extracted credentials
-	//
-	extCredsSaved := []extCredsMigration{}
-	for _, si := range siSaved {
-		labels := map[string]string{"apbAction": "provision", "apbName": si.Spec.FQName}
-		extcredsJSON, err := etcdDao.GetRaw(fmt.Sprintf("/extracted_credentials/%v", si.ID))
-		if etcdDao.IsNotFoundError(err) {
-			continue
-		}
-		if err != nil {
-			revertExtractedCredentials(extCredsSaved)
-			revertBindInstance(biSaved)
-			revertServiceInstance(siSaved)
-			revertCrdSavedSpecs(crdSavedSpecs)
-			panic(fmt.Sprintf("Unable to migrate all extracted credentials - %v", err))
-		}
-
-		ec := apb.ExtractedCredentials{}
-		err = json.Unmarshal([]byte(extcredsJSON), &ec)
-		if err != nil {
-			revertExtractedCredentials(extCredsSaved)
-			revertBindInstance(biSaved)
-			revertServiceInstance(siSaved)
-			revertCrdSavedSpecs(crdSavedSpecs)
-			panic(fmt.Sprintf("Unable to migrate all extracted credentials - %v", err))
-		}
-		err = k8scli.SaveExtractedCredentialSecret(si.ID.String(), options.MigrationNamespace, ec.Credentials, labels)
-		if err != nil {
-			revertExtractedCredentials(extCredsSaved)
-			revertBindInstance(biSaved)
-			revertServiceInstance(siSaved)
-			revertCrdSavedSpecs(crdSavedSpecs)
-			panic(fmt.Sprintf("Unable to migrate all the binding instances - %v", err))
-		}
-		extCredsSaved = append(extCredsSaved, extCredsMigration{
-			ExtCreds:  ec,
-			ID:        si.ID.String(),
-			Namespace: options.MigrationNamespace,
-		})
+	diffs, err := m.Verify()
+	if err != nil {
+		logrus.Fatalf("Unable to verify the migration - %v", err)
 	}
-	for _, bi := range biSaved {
-		labels := map[string]string{"apbAction": "bind"}
-		extcredsJSON, err := etcdDao.GetRaw(fmt.Sprintf("/extracted_credentials/%v", bi.ID))
-		if err != nil && !etcdDao.IsNotFoundError(err) {
-			revertExtractedCredentials(extCredsSaved)
-			revertBindInstance(biSaved)
-			revertServiceInstance(siSaved)
-			revertCrdSavedSpecs(crdSavedSpecs)
-			panic(fmt.Sprintf("Unable to migrate all extracted credentials - %v", err))
-		} else if etcdDao.IsNotFoundError(err) {
-			// We need to get the service instances binding.
-			extcredsJSON, err = etcdDao.GetRaw(fmt.Sprintf("/extracted_credentials/%v", bi.ServiceID))
-			if err != nil {
-				revertExtractedCredentials(extCredsSaved)
-				revertBindInstance(biSaved)
-				revertServiceInstance(siSaved)
-				revertCrdSavedSpecs(crdSavedSpecs)
-				panic(fmt.Sprintf("Unable to migrate all extracted credentials - %v", err))
-			}
-
-		}
-
-		ec := apb.ExtractedCredentials{}
-		err = json.Unmarshal([]byte(extcredsJSON), &ec)
-		if err != nil {
-			revertExtractedCredentials(extCredsSaved)
-			revertBindInstance(biSaved)
-			revertServiceInstance(siSaved)
-			revertCrdSavedSpecs(crdSavedSpecs)
-			panic(fmt.Sprintf("Unable to migrate all extracted credentials - %v", err))
-		}
-		k8scli, err := clients.Kubernetes()
-		if err != nil {
-			revertExtractedCredentials(extCredsSaved)
-			revertBindInstance(biSaved)
-			revertServiceInstance(siSaved)
-			revertCrdSavedSpecs(crdSavedSpecs)
-			panic(fmt.Sprintf("Unable to migrate all extracted credentials - %v", err))
-		}
-		err = k8scli.SaveExtractedCredentialSecret(bi.ID.String(), options.MigrationNamespace, ec.Credentials, labels)
-		if err != nil {
-			revertExtractedCredentials(extCredsSaved)
-			revertBindInstance(biSaved)
-			revertServiceInstance(siSaved)
-			revertCrdSavedSpecs(crdSavedSpecs)
-			panic(fmt.Sprintf("Unable to migrate all the binding instances - %v", err))
-		}
-		extCredsSaved = append(extCredsSaved, extCredsMigration{
-			ExtCreds:  ec,
-			ID:        bi.ID.String(),
-			Namespace: options.MigrationNamespace,
-		})
+	for _, d := range diffs {
+		logrus.Infof("%s is %s in %s", d.Key, d.Reason, options.To)
 	}
-}
-
-func revertCrdSavedSpecs(specs []*apb.Spec) {
-	err := crdDao.BatchDeleteSpecs(specs)
-	if err != nil {
-		panic(fmt.Sprintf("revert failed - %v", err))
+	if options.DryRun {
+		logrus.Infof("Dry run, [ %d ] records would be written", len(diffs))
+		return
+	}
+	if report.Failed > 0 || len(diffs) > 0 {
+		logrus.Errorf("Migration incomplete, [ %d ] records differ - run it again to retry them", len(diffs))
+		os.Exit(1)
 	}
-	logrus.Infof("reverted saved specs - exiting now - migration failed")
+	if err := checkpoint.Remove(); err != nil {
+		logrus.Warningf("Unable to remove the checkpoint - %v", err)
+	}
+	logrus.Infof("Migration from %s to %s complete", options.From, options.To)
 }
 
-func revertBindInstance(bindInstnaces []*apb.BindInstance) {
-	for _, bi := range bindInstnaces {
-		err := crdDao.DeleteBindInstance(bi.ID.String())
-		if err != nil {
-			panic(fmt.Sprintf("revert failed - %v", err))
-		}
-	}
-	logrus.Infof("reverted saved binding instances")
+// newBackend - connects to the backend named by a --from or --to option.
+func newBackend(name string) (dao.Dao, error) {
+	switch {
+	case name == "etcd":
+		return etcd.NewDao()
+	case name == "etcd3":
+		return etcdv3.NewDao(etcdConfig(), 0)
+	case name == "crd":
+		if options.MigrationNamespace == "" {
+			return nil, fmt.Errorf("--namespace is required for crd")
+		}
+		return crd.NewDao(options.MigrationNamespace)
+	case strings.HasPrefix(name, "file:"):
+		return file.NewDao(strings.TrimPrefix(name, "file:"))
+	}
+	return nil, fmt.Errorf("unknown backend %s", name)
 }
 
-func revertServiceInstance(siSaved []*apb.ServiceInstance) {
-	for _, si := range siSaved {
-		err := crdDao.DeleteServiceInstance(si.ID.String())
-		if err != nil {
-			panic(fmt.Sprintf("revert failed - %v", err))
-		}
+// etcdConfig - the etcd connection given by the options, the same for the v2
+// and v3 APIs.
+func etcdConfig() clients.EtcdConfig {
+	return clients.EtcdConfig{
+		EtcdHost:       options.EtcdHost,
+		EtcdPort:       options.EtcdPort,
+		EtcdCaFile:     options.EtcdCAFile,
+		EtcdClientKey:  options.EtcdClientKey,
+		EtcdClientCert: options.EtcdClientCert,
 	}
-	logrus.Infof("reverted service instances")
 }
 
-func revertExtractedCredentials(extCredsSaved []extCredsMigration) {
-	for _, extCred := range extCredsSaved {
-		//no DAO to delete saved state. Going to the CRD Client
-		k8sclient, err := clients.Kubernetes()
-		if err != nil {
-			panic(fmt.Sprintf("revert failed - %v", err))
+// migrateLegacyCredentials - saves the extracted credentials etcd still has
+// for the migrated instances and bindings as secrets, unless there already is
+// one. Bindings without their own credentials get the ones of their instance.
+func migrateLegacyCredentials(source *etcd.Dao) error {
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		return err
+	}
+	migrate := func(id, fallbackID string, labels map[string]string) error {
+		data, err := source.GetRaw(fmt.Sprintf("/extracted_credentials/%v", id))
+		if source.IsNotFoundError(err) && fallbackID != "" {
+			data, err = source.GetRaw(fmt.Sprintf("/extracted_credentials/%v", fallbackID))
+		}
+		if source.IsNotFoundError(err) {
+			return nil
 		}
-		err = k8sclient.DeleteExtractedCredentialSecret(extCred.ID, extCred.Namespace)
 		if err != nil {
-			panic(fmt.Sprintf("revert failed - %v", err))
+			return err
+		}
+		if _, err := k8scli.GetExtractedCredentialSecretData(id, options.MigrationNamespace); err == nil {
+			logrus.Debugf("Skipping extracted credentials %s, they are already migrated", id)
+			return nil
 		}
+		ec := apb.ExtractedCredentials{}
+		if err := json.Unmarshal([]byte(data), &ec); err != nil {
+			return err
+		}
+		if options.DryRun {
+			logrus.Infof("Would write extracted credentials %s", id)
+			return nil
+		}
+		return k8scli.SaveExtractedCredentialSecret(id, options.MigrationNamespace, ec.Credentials, labels)
 	}
-	fmt.Printf("reverted extracted credentials")
+
+	return dao.ForEachServiceInstancePage(source, dao.DefaultPageSize, func(instances []*apb.ServiceInstance) error {
+		for _, si := range instances {
+			labels := map[string]string{"apbAction": "provision"}
+			if si.Spec != nil {
+				labels["apbName"] = si.Spec.FQName
+			}
+			if err := migrate(si.ID.String(), "", labels); err != nil {
+				return err
+			}
+			for id := range si.BindingIDs {
+				if err := migrate(id, si.ID.String(), map[string]string{"apbAction": "bind"}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
 }
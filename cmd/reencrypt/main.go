@@ -0,0 +1,129 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// reencrypt rewrites the parameters of every service and bind instance, and
+// their extracted credentials, the job records and the dead letters encrypted
+// with the primary key of the broker's encryption key file. Run it after
+// adding a new primary key to the key file, once every broker replica uses the
+// new file. Records that are not encrypted yet are encrypted as well.
+package main
+
+import (
+	"flag"
+	"os"
+
+	apb "github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/config"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/dao/encryption"
+	log "github.com/sirupsen/logrus"
+)
+
+var options struct {
+	ConfigFile string
+}
+
+func init() {
+	flag.StringVar(&options.ConfigFile, "config", "/etc/ansible-service-broker/config.yaml", "broker config file with the dao section to re-encrypt")
+	flag.Parse()
+}
+
+func main() {
+	c, err := config.CreateConfig(options.ConfigFile)
+	if err != nil {
+		log.Fatalf("Unable to read the config file - %v", err)
+	}
+	keyFile := c.GetString("dao.encryption_key_file")
+	if keyFile == "" {
+		log.Fatal("dao.encryption_key_file is not set, there is nothing to encrypt with")
+	}
+	keys, err := encryption.LoadKeyFile(keyFile)
+	if err != nil {
+		log.Fatalf("Unable to load the encryption key file - %v", err)
+	}
+
+	d, err := dao.InitFromConfig(c)
+	if err != nil {
+		log.Fatalf("Unable to connect to the dao - %v", err)
+	}
+	// the records are read from the data store itself, around the cache if
+	// there is one.
+	encrypted := dao.NewEncryptedDao(dao.Backend(d), keys)
+	creds := encryption.NewCredentialStore(keys, nil)
+	ns := c.GetString("openshift.namespace")
+
+	log.Infof("Re-encrypting records with key [ %s ]", keys.PrimaryKeyID())
+	var rotated, failed int
+	rotate := func(kind, id string, fn func(string) (bool, error)) {
+		ok, err := fn(id)
+		switch {
+		case encrypted.IsNotFoundError(err):
+			log.Debugf("Skipping missing %s [ %s ]", kind, id)
+		case err != nil:
+			log.Errorf("Unable to re-encrypt %s [ %s ] - %v", kind, id, err)
+			failed++
+		case ok:
+			log.Debugf("Re-encrypted %s [ %s ]", kind, id)
+			rotated++
+		}
+	}
+	rotateCreds := func(id string) (bool, error) {
+		return creds.Rotate(id, ns)
+	}
+
+	// walk the stored instances without decrypting them, a record using an
+	// unknown key should not stop the others from being re-encrypted.
+	err = dao.ForEachServiceInstancePage(encrypted.Dao, dao.DefaultPageSize, func(page []*apb.ServiceInstance) error {
+		for _, si := range page {
+			id := si.ID.String()
+			rotate("service instance", id, encrypted.ReencryptServiceInstance)
+			rotate("extracted credentials", id, rotateCreds)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Unable to list the service instances - %v", err)
+	}
+	// the bindings are listed on their own, to reach the bindings whose
+	// instance is gone as well.
+	bindings, err := encrypted.Dao.BatchGetBindInstances()
+	if err != nil && !encrypted.IsNotFoundError(err) {
+		log.Fatalf("Unable to list the bind instances - %v", err)
+	}
+	for _, bi := range bindings {
+		id := bi.ID.String()
+		rotate("bind instance", id, encrypted.ReencryptBindInstance)
+		rotate("extracted credentials", id, rotateCreds)
+	}
+
+	for kind, fn := range map[string]func() (int, int, error){
+		"job records":  encrypted.ReencryptJobRecords,
+		"dead letters": encrypted.ReencryptDeadLetters,
+	} {
+		count, errs, err := fn()
+		if err != nil {
+			log.Fatalf("Unable to list the %s - %v", kind, err)
+		}
+		log.Debugf("Re-encrypted [ %d ] %s", count, kind)
+		rotated += count
+		failed += errs
+	}
+
+	log.Infof("Re-encrypted [ %d ] records, [ %d ] failed", rotated, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
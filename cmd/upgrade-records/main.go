@@ -0,0 +1,67 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// upgrade-records rewrites the records of the broker state stored with an
+// older schema version in the current one. Brokers upgrade the records they
+// read on the fly, this upgrades the records that are not written again so
+// the upgrades of old schema versions can eventually be dropped.
+package main
+
+import (
+	"flag"
+
+	"github.com/automationbroker/config"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
+	log "github.com/sirupsen/logrus"
+)
+
+var options struct {
+	ConfigFile string
+	DryRun     bool
+}
+
+func init() {
+	flag.StringVar(&options.ConfigFile, "config", "/etc/ansible-service-broker/config.yaml", "broker config file with the dao section to upgrade")
+	flag.BoolVar(&options.DryRun, "dry-run", false, "only count the records that need to be upgraded")
+	flag.Parse()
+}
+
+func main() {
+	c, err := config.CreateConfig(options.ConfigFile)
+	if err != nil {
+		log.Fatalf("Unable to read the config file - %v", err)
+	}
+
+	d, err := dao.InitFromConfig(c)
+	if err != nil {
+		log.Fatalf("Unable to connect to the dao - %v", err)
+	}
+
+	upgrader, ok := dao.Backend(d).(dao.RecordUpgrader)
+	if !ok {
+		log.Infof("The %s dao does not store versioned records, nothing to upgrade", dao.BackendType(c))
+		return
+	}
+	count, err := upgrader.UpgradeRecords(options.DryRun)
+	if err != nil {
+		log.Fatalf("Unable to upgrade the records, [ %d ] were upgraded - %v", count, err)
+	}
+	if options.DryRun {
+		log.Infof("[ %d ] records need to be upgraded", count)
+		return
+	}
+	log.Infof("Upgraded [ %d ] records", count)
+}
@@ -19,6 +19,7 @@ package app
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"strings"
@@ -42,9 +43,12 @@ import (
 	"github.com/automationbroker/bundle-lib/registries"
 	agnosticruntime "github.com/automationbroker/bundle-lib/runtime"
 	"github.com/automationbroker/config"
+	"github.com/openshift/ansible-service-broker/pkg/audit"
 	"github.com/openshift/ansible-service-broker/pkg/auth"
 	"github.com/openshift/ansible-service-broker/pkg/broker"
 	"github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/dao/encryption"
+	"github.com/openshift/ansible-service-broker/pkg/dao/fsck"
 	"github.com/openshift/ansible-service-broker/pkg/handler"
 	logutil "github.com/openshift/ansible-service-broker/pkg/util/logging"
 	"github.com/openshift/ansible-service-broker/pkg/version"
@@ -64,7 +68,8 @@ const (
 	ClusterURLPreFix = "/osb"
 	// MsgBufferSize - The buffer for the message channel.
 	MsgBufferSize = 20
-	// SubscriberTimeout - the amount of time in seconds that subscribers have to complete their action
+	// SubscriberTimeout - the default amount of time in seconds that subscribers have to complete
+	// their action, broker.subscriber_timeout overrides it.
 	SubscriberTimeout = 3
 )
 
@@ -76,6 +81,12 @@ type App struct {
 	dao      dao.Dao
 	registry []registries.Registry
 	engine   *broker.WorkEngine
+	auditor  *audit.Auditor
+	// auditRecords is the sink storing the audit events in the dao, nil when
+	// they are not.
+	auditRecords *audit.DaoSink
+	// steppingDown is set once the leader is stepping down.
+	steppingDown int32
 }
 
 func apiServer(config *config.Config,
@@ -174,7 +185,16 @@ func CreateApp(args Args, regs []registries.Registry) App {
 	// Initialize Runtime
 	log.Debug("Connecting to Cluster")
 	brokerNS := app.config.GetString("openshift.namespace")
-	agnosticruntime.NewRuntime(agnosticruntime.Configuration{StateMasterNamespace: brokerNS})
+	runtimeConfig := agnosticruntime.Configuration{StateMasterNamespace: brokerNS}
+	if keyFile := app.config.GetString("dao.encryption_key_file"); keyFile != "" {
+		keys, err := encryption.LoadKeyFile(keyFile)
+		if err != nil {
+			log.Errorf("Unable to load the encryption key file - %v", err)
+			os.Exit(1)
+		}
+		runtimeConfig.ExtractedCredential = encryption.NewCredentialStore(keys, nil)
+	}
+	agnosticruntime.NewRuntime(runtimeConfig)
 	agnosticruntime.Provider.ValidateRuntime()
 	if err != nil {
 		log.Error(err.Error())
@@ -182,7 +202,7 @@ func CreateApp(args Args, regs []registries.Registry) App {
 	}
 
 	log.Debug("Connecting Dao")
-	app.dao, err = dao.NewDao(app.config)
+	app.dao, err = dao.InitFromConfig(app.config)
 	if err != nil {
 		log.Error(err.Error())
 		os.Exit(1)
@@ -233,6 +253,9 @@ func CreateApp(args Args, regs []registries.Registry) App {
 	log.Debug("Initializing WorkEngine")
 	stateSubscriber := broker.NewJobStateSubscriber(app.dao)
 	app.engine = broker.NewWorkEngine(MsgBufferSize, SubscriberTimeout, app.dao)
+	app.engine.SetLimits(workLimits(app.config))
+	app.engine.SetRetryPolicy(retryPolicy(app.config))
+	app.engine.SetDeliveryPolicy(deliveryPolicy(app.config))
 	err = app.engine.AttachSubscriber(
 		stateSubscriber,
 		broker.ProvisionTopic)
@@ -268,6 +291,32 @@ func CreateApp(args Args, regs []registries.Registry) App {
 		log.Errorf("Failed to attach subscriber to WorkEngine: %s", err.Error())
 		os.Exit(1)
 	}
+	webhooks := map[string]bool{}
+	for _, webhookConfig := range app.config.GetSubConfigArray("webhooks") {
+		name := webhookConfig.GetString("name")
+		if webhooks[name] {
+			log.Errorf("Failed to attach webhook %s to WorkEngine: the name is used by another webhook", name)
+			os.Exit(1)
+		}
+		webhooks[name] = true
+		if err = attachWebhook(app.engine, webhookConfig); err != nil {
+			log.Errorf("Failed to attach webhook %s to WorkEngine: %s", name, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if app.config.GetBool("broker.job_events") {
+		if err = attachEventSubscriber(app.engine, app.dao, app.config); err != nil {
+			log.Errorf("Failed to attach the event subscriber to WorkEngine: %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	app.auditor, app.auditRecords, err = configureAudit(app.engine, app.dao, dao.BackendType(app.config), app.config.GetSubConfig("audit"))
+	if err != nil {
+		log.Errorf("Failed to set up the audit log: %s", err.Error())
+		os.Exit(1)
+	}
 
 	rules := []bundle.AssociationRule{}
 	for _, secretConfig := range app.config.GetSubConfigArray("secrets") {
@@ -323,6 +372,79 @@ func (a *App) Start() {
 	fmt.Println("==           Starting Ansible Service Broker...           ==")
 	fmt.Println("============================================================")
 
+	var elector *broker.LeaderElector
+	if a.config.GetBool("broker.leader_election") {
+		var err error
+		if elector, err = a.leaderElector(); err != nil {
+			log.Errorf("Unable to set up leader election - %v", err)
+			os.Exit(1)
+		}
+		go a.campaign(elector)
+	} else {
+		a.lead(context.Background())
+	}
+
+	//Retrieve the auth providers if basic auth is configured.
+	providers := auth.GetProviders(a.config)
+
+	genericserver, servererr := apiServer(a.config, providers)
+	if servererr != nil {
+		log.Errorf("problem creating apiserver. %v", servererr)
+		panic(servererr)
+	}
+
+	userAuthRuleToCheck := a.config.GetString("broker.user_auth_rule")
+	if userAuthRuleToCheck == "" {
+		// Maintains backwards compatibility if the new user_auth_rule config value
+		// is missing. Previously, we simply checked for "access".
+		userAuthRuleToCheck = "access"
+	}
+	authorizer, err := k8sauthorization.NewAuthorizer("automationbroker.io", userAuthRuleToCheck, "create")
+	var clusterURL = ClusterURLPreFix
+
+	brokerHandler := handler.NewHandler(a.broker, a.config, clusterURL, providers, authorizer, a.auditor)
+	if elector != nil {
+		brokerHandler = leaderHandler(brokerHandler, a.accepting(elector), elector)
+		if err := genericserver.AddHealthzChecks(leaderCheck(a.accepting(elector), elector)); err != nil {
+			log.Errorf("Unable to add the leader health check - %v", err)
+			os.Exit(1)
+		}
+	}
+	daHandler := prometheus.InstrumentHandler("ansible-service-broker", brokerHandler)
+
+	genericserver.Handler.NonGoRestfulMux.HandlePrefix(fmt.Sprintf("%v/", clusterURL), daHandler)
+
+	defaultMetrics := routes.DefaultMetrics{}
+	defaultMetrics.Install(genericserver.Handler.NonGoRestfulMux)
+
+	blmetrics.RegisterCollector()
+
+	log.Infof("Listening on https://%s", genericserver.SecureServingInfo.Listener.Addr().String())
+
+	log.Info("Ansible Service Broker Starting")
+	err = genericserver.PrepareRun().Run(wait.NeverStop)
+	log.Errorf("unable to start ansible service broker - %v", err)
+
+	//TODO: Add Flag so we can still use the old way of doing this.
+}
+
+// lead - does the work only one broker sharing the dao may do: finishing
+// the work left behind by a broker that stopped, refreshing the specs and the
+// background jobs. The background work stops once ctx is done.
+func (a *App) lead(ctx context.Context) {
+	// finish the cleanups a broker that stopped part of the way left behind.
+	if err := broker.ReplayIntents(a.dao); err != nil {
+		log.Errorf("Unable to replay the pending intents - %v", err)
+	}
+
+	// the accepted jobs that had not run are resumed before recovery, which
+	// leaves them alone.
+	if resumed, err := a.broker.ResumeJobs(); err != nil {
+		log.Errorf("Unable to resume the accepted jobs - %v", err)
+	} else if resumed > 0 {
+		log.Infof("Resumed [ %d ] accepted jobs", resumed)
+	}
+
 	if a.config.GetBool("broker.recovery") {
 		log.Info("Initiating Recovery Process")
 		a.Recover()
@@ -346,8 +468,6 @@ func (a *App) Start() {
 		log.Error("Not using a refresh interval")
 	} else {
 		ticker := time.NewTicker(interval)
-		ctx, cancelFunc := context.WithCancel(context.Background())
-		defer cancelFunc()
 		go func() {
 			for {
 				select {
@@ -366,43 +486,326 @@ func (a *App) Start() {
 			}
 		}()
 	}
-	//Retrieve the auth providers if basic auth is configured.
-	providers := auth.GetProviders(a.config)
+	a.startJobStateGC(ctx)
+	a.startConsistencyCheck(ctx)
+	if a.auditRecords != nil {
+		go a.auditRecords.Run(ctx, auditPruneInterval)
+	}
+}
 
-	genericserver, servererr := apiServer(a.config, providers)
-	if servererr != nil {
-		log.Errorf("problem creating apiserver. %v", servererr)
-		panic(servererr)
+// defaultRetryBackoff and defaultRetryMaxBackoff - the delays between the
+// attempts of a failed job when only broker.job_retry_max_attempts is set.
+const (
+	defaultRetryBackoff    = 10 * time.Second
+	defaultRetryMaxBackoff = 5 * time.Minute
+)
+
+// retryPolicy - the retry policy of the work engine from the broker config.
+// Invalid delays are logged and replaced by the defaults.
+func retryPolicy(c *config.Config) broker.RetryPolicy {
+	policy := broker.RetryPolicy{
+		MaxAttempts: c.GetInt("broker.job_retry_max_attempts"),
+		Backoff:     defaultRetryBackoff,
+		MaxBackoff:  defaultRetryMaxBackoff,
+	}
+	if backoff := c.GetString("broker.job_retry_backoff"); backoff != "" {
+		d, err := time.ParseDuration(backoff)
+		if err != nil {
+			log.Errorf("Invalid job retry backoff [ %s ], using %v", backoff, policy.Backoff)
+		} else {
+			policy.Backoff = d
+		}
+	}
+	if maxBackoff := c.GetString("broker.job_retry_max_backoff"); maxBackoff != "" {
+		d, err := time.ParseDuration(maxBackoff)
+		if err != nil {
+			log.Errorf("Invalid job retry max backoff [ %s ], using %v", maxBackoff, policy.MaxBackoff)
+		} else {
+			policy.MaxBackoff = d
+		}
+	}
+	if policy.MaxAttempts > 1 {
+		log.Infof("Retrying failed jobs up to [ %d ] attempts, waiting %v doubling up to %v",
+			policy.MaxAttempts, policy.Backoff, policy.MaxBackoff)
 	}
+	return policy
+}
 
-	userAuthRuleToCheck := a.config.GetString("broker.user_auth_rule")
-	if userAuthRuleToCheck == "" {
-		// Maintains backwards compatibility if the new user_auth_rule config value
-		// is missing. Previously, we simply checked for "access".
-		userAuthRuleToCheck = "access"
+// defaultDeliveryAttempts, defaultDeliveryBackoff and
+// defaultDeliveryMaxBackoff - how many times a job message is handed to a
+// subscriber, and the delays in between, when the broker config does not say.
+const (
+	defaultDeliveryAttempts   = 3
+	defaultDeliveryBackoff    = time.Second
+	defaultDeliveryMaxBackoff = 30 * time.Second
+)
+
+// deliveryPolicy - how the work engine delivers the job messages to the
+// subscribers from the broker config, the timeouts of the subscribers are
+// keyed by their ID. Invalid durations are logged and replaced by the
+// defaults.
+func deliveryPolicy(c *config.Config) broker.DeliveryPolicy {
+	policy := broker.DeliveryPolicy{
+		Timeout:  SubscriberTimeout * time.Second,
+		Timeouts: map[string]time.Duration{},
+		Retry: broker.RetryPolicy{
+			MaxAttempts: c.GetInt("broker.subscriber_retry_max_attempts"),
+			Backoff:     configDuration(c, "broker.subscriber_retry_backoff", defaultDeliveryBackoff),
+			MaxBackoff:  configDuration(c, "broker.subscriber_retry_max_backoff", defaultDeliveryMaxBackoff),
+		},
 	}
-	authorizer, err := k8sauthorization.NewAuthorizer("automationbroker.io", userAuthRuleToCheck, "create")
-	var clusterURL = ClusterURLPreFix
+	if policy.Retry.MaxAttempts == 0 {
+		policy.Retry.MaxAttempts = defaultDeliveryAttempts
+	}
+	policy.Timeout = configDuration(c, "broker.subscriber_timeout", policy.Timeout)
+	for subscriber := range c.GetSubConfig("broker.subscriber_timeouts").ToMap() {
+		policy.Timeouts[subscriber] = configDuration(c, "broker.subscriber_timeouts."+subscriber, policy.Timeout)
+	}
+	log.Infof("Delivering job messages to subscribers within %v %v, up to [ %d ] attempts, waiting %v doubling up to %v",
+		policy.Timeout, policy.Timeouts, policy.Retry.MaxAttempts, policy.Retry.Backoff, policy.Retry.MaxBackoff)
+	return policy
+}
 
-	daHandler := prometheus.InstrumentHandler(
-		"ansible-service-broker",
-		handler.NewHandler(a.broker, a.config, clusterURL, providers, authorizer),
-	)
+// configDuration - the duration of the key, or d when it is not set or is
+// invalid.
+func configDuration(c *config.Config, key string, d time.Duration) time.Duration {
+	value := c.GetString(key)
+	if value == "" {
+		return d
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Errorf("Invalid %s [ %s ], using %v", key, value, d)
+		return d
+	}
+	return parsed
+}
 
-	genericserver.Handler.NonGoRestfulMux.HandlePrefix(fmt.Sprintf("%v/", clusterURL), daHandler)
+// actionTopics - the work topic of each action, as named in the broker config.
+var actionTopics = map[string]broker.WorkTopic{
+	"provision":   broker.ProvisionTopic,
+	"deprovision": broker.DeprovisionTopic,
+	"update":      broker.UpdateTopic,
+	"bind":        broker.BindingTopic,
+	"unbind":      broker.UnbindingTopic,
+}
 
-	defaultMetrics := routes.DefaultMetrics{}
-	defaultMetrics.Install(genericserver.Handler.NonGoRestfulMux)
+// attachWebhook - attaches the webhook of the config to the work topics of
+// its actions, all of them when it lists none.
+func attachWebhook(engine *broker.WorkEngine, c *config.Config) error {
+	webhookConfig := broker.WebhookConfig{
+		Name:        c.GetString("name"),
+		URL:         c.GetString("url"),
+		Timeout:     configDuration(c, "timeout", 0),
+		MaxAttempts: c.GetInt("max_attempts"),
+		QueueSize:   c.GetInt("queue_size"),
+	}
+	for _, state := range c.GetSliceOfStrings("states") {
+		webhookConfig.States = append(webhookConfig.States, bundle.State(state))
+	}
+	for _, method := range c.GetSliceOfStrings("methods") {
+		webhookConfig.Methods = append(webhookConfig.Methods, bundle.JobMethod(method))
+	}
+	if secretFile := c.GetString("secret_file"); secretFile != "" {
+		secret, err := ioutil.ReadFile(secretFile)
+		if err != nil {
+			return err
+		}
+		webhookConfig.Secret = []byte(strings.TrimSpace(string(secret)))
+	}
+	subscriber, err := broker.NewWebhookSubscriber(webhookConfig)
+	if err != nil {
+		return err
+	}
+	actions := c.GetSliceOfStrings("actions")
+	if len(actions) == 0 {
+		for action := range actionTopics {
+			actions = append(actions, action)
+		}
+	}
+	for _, action := range actions {
+		topic, ok := actionTopics[action]
+		if !ok {
+			return fmt.Errorf("unknown action %s", action)
+		}
+		if err := engine.AttachSubscriber(subscriber, topic); err != nil {
+			return err
+		}
+	}
+	log.Infof("Posting the job messages of %v to webhook [ %s ]", actions, webhookConfig.Name)
+	return nil
+}
 
-	blmetrics.RegisterCollector()
+// attachEventSubscriber - attaches the subscriber recording the kubernetes
+// events of the jobs to every work topic.
+func attachEventSubscriber(engine *broker.WorkEngine, d dao.Dao, c *config.Config) error {
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		return err
+	}
+	eventConfig := broker.EventConfig{
+		Burst:    c.GetInt("broker.job_events_burst"),
+		Interval: configDuration(c, "broker.job_events_interval", 0),
+	}
+	subscriber := broker.NewEventSubscriber(d, broker.NewKubernetesEventWriter(k8scli.Client.CoreV1()),
+		broker.NewCatalogInstanceResolver(k8scli.Client.Discovery().RESTClient()), eventConfig)
+	for _, topic := range actionTopics {
+		if err := engine.AttachSubscriber(subscriber, topic); err != nil {
+			return err
+		}
+	}
+	log.Info("Recording the progress of the jobs as events in the namespace of their instance")
+	return nil
+}
 
-	log.Infof("Listening on https://%s", genericserver.SecureServingInfo.Listener.Addr().String())
+// defaultCRDAuditMaxRecords - how many audit records the crd dao keeps when
+// the audit config does not say, each one is a config map of the broker
+// namespace listed whenever the records are pruned.
+const defaultCRDAuditMaxRecords = 500
+
+// auditPruneInterval - how often the leader prunes the audit records stored
+// in the dao, on top of the pruning asked by the writes.
+const auditPruneInterval = 10 * time.Minute
+
+// configureAudit - creates the auditor writing to the sinks of the audit log
+// config, and attaches the subscriber recording the job outcomes when there
+// are any. The dao sink is returned as well when the events are stored in the
+// dao, for the leader to prune its records.
+func configureAudit(engine *broker.WorkEngine, d dao.Dao, daoType string, c *config.Config) (*audit.Auditor, *audit.DaoSink, error) {
+	sinks := []audit.Sink{}
+	if path := c.GetString("file"); path != "" {
+		sink, err := audit.NewFileSink(path, int64(c.GetInt("max_size_mb"))*1024*1024, c.GetInt("max_backups"))
+		if err != nil {
+			return nil, nil, err
+		}
+		sinks = append(sinks, sink)
+		log.Infof("Writing the audit log to [ %s ]", path)
+	}
+	var records *audit.DaoSink
+	if c.GetBool("dao") {
+		maxRecords := c.GetInt("dao_max_records")
+		if maxRecords == 0 && daoType == "crd" {
+			// the crd dao stores each record in a config map of its own.
+			maxRecords = defaultCRDAuditMaxRecords
+		}
+		records = audit.NewDaoSink(d, maxRecords)
+		sinks = append(sinks, records)
+		log.Infof("Storing the audit log in the dao, keeping [ %d ] records", maxRecords)
+	}
+	auditor := audit.NewAuditor(sinks, c.GetSliceOfStrings("redact_keys")...)
+	if !auditor.Enabled() {
+		log.Debug("The audit log is disabled")
+		return auditor, nil, nil
+	}
+	subscriber := broker.NewAuditSubscriber(auditor)
+	for _, topic := range actionTopics {
+		if err := engine.AttachSubscriber(subscriber, topic); err != nil {
+			return nil, nil, err
+		}
+	}
+	return auditor, records, nil
+}
 
-	log.Info("Ansible Service Broker Starting")
-	err = genericserver.PrepareRun().Run(wait.NeverStop)
-	log.Errorf("unable to start ansible service broker - %v", err)
+// workLimits - the concurrency limits of the work engine from the broker
+// config, the per topic limits are keyed by the name of the action.
+func workLimits(c *config.Config) broker.WorkLimits {
+	limits := broker.WorkLimits{
+		Global:    c.GetInt("broker.max_concurrent_jobs"),
+		Namespace: c.GetInt("broker.max_concurrent_jobs_per_namespace"),
+		Topics:    map[broker.WorkTopic]int{},
+	}
+	for action, topic := range actionTopics {
+		if limit := c.GetInt("broker.max_concurrent_jobs_per_topic." + action); limit > 0 {
+			limits.Topics[topic] = limit
+		}
+	}
+	if limits.Global > 0 || limits.Namespace > 0 || len(limits.Topics) > 0 {
+		log.Infof("Running up to [ %d ] jobs at once, [ %d ] per namespace, per action %v",
+			limits.Global, limits.Namespace, c.GetSubConfig("broker.max_concurrent_jobs_per_topic").ToMap())
+	}
+	return limits
+}
 
-	//TODO: Add Flag so we can still use the old way of doing this.
+// startJobStateGC - periodically prunes old and orphaned job states when a
+// job state garbage collection interval is configured.
+func (a *App) startJobStateGC(ctx context.Context) {
+	gcInterval := a.config.GetString("broker.job_state_gc_interval")
+	if gcInterval == "" {
+		log.Debug("Job state garbage collection is disabled")
+		return
+	}
+	interval, err := time.ParseDuration(gcInterval)
+	if err != nil || interval <= 0 {
+		log.Errorf("Invalid job state gc interval [ %s ], not pruning job states", gcInterval)
+		return
+	}
+	policy := broker.JobStateRetention{
+		MaxCount:          a.config.GetInt("broker.job_state_max_count"),
+		OrphanGracePeriod: interval,
+	}
+	if maxAge := a.config.GetString("broker.job_state_max_age"); maxAge != "" {
+		if policy.MaxAge, err = time.ParseDuration(maxAge); err != nil {
+			log.Errorf("Invalid job state max age [ %s ], not pruning job states by age", maxAge)
+		}
+	}
+	log.Infof("Pruning job states every %v, keeping [ %d ] finished jobs for up to %v",
+		interval, policy.MaxCount, policy.MaxAge)
+
+	gc := broker.NewJobStateGC(a.dao, policy)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			if _, err := gc.Run(); err != nil {
+				log.Errorf("Job state garbage collection failed - %v", err)
+			}
+		}
+	}()
+}
+
+// startConsistencyCheck - periodically checks the references between the
+// stored records when a consistency check interval is configured, repairing
+// the problems it safely can when asked to.
+func (a *App) startConsistencyCheck(ctx context.Context) {
+	checkInterval := a.config.GetString("broker.consistency_check_interval")
+	if checkInterval == "" {
+		log.Debug("Consistency checks are disabled")
+		return
+	}
+	interval, err := time.ParseDuration(checkInterval)
+	if err != nil || interval <= 0 {
+		log.Errorf("Invalid consistency check interval [ %s ], not checking the dao", checkInterval)
+		return
+	}
+	repair := a.config.GetBool("broker.consistency_check_repair")
+	log.Infof("Checking the dao consistency every %v, repair: %v", interval, repair)
+
+	checker := fsck.NewChecker(a.dao, interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			problems, err := checker.Run(repair)
+			if err != nil {
+				log.Errorf("Consistency check failed - %v", err)
+				continue
+			}
+			for _, p := range problems {
+				log.Warningf("Consistency check found %v, repaired: %v", p, p.Repaired)
+			}
+			log.Infof("Consistency check found [ %d ] problems", len(problems))
+		}
+	}()
 }
 
 func initClients(c *config.Config) error {
@@ -415,35 +818,8 @@ func initClients(c *config.Config) error {
 	// dependencies / make sure things are ready.
 	log.Info("Initializing clients...")
 
-	if strings.ToLower(c.GetString("dao.type")) != "crd" {
-
-		log.Debug("Trying to connect to etcd")
-		// Initialize the etcd configuration
-		con := clients.EtcdConfig{
-			EtcdHost:       c.GetString("dao.etcd_host"),
-			EtcdPort:       c.GetInt("dao.etcd_port"),
-			EtcdCaFile:     c.GetString("dao.etcd_ca_file"),
-			EtcdClientKey:  c.GetString("dao.etcd_client_key"),
-			EtcdClientCert: c.GetString("dao.etcd_client_cert"),
-		}
-		clients.InitEtcdConfig(con)
-
-		etcdClient, err := clients.Etcd()
-		if err != nil {
-			return err
-		}
-
-		ctx, cancelFunc := context.WithCancel(context.Background())
-		defer cancelFunc()
-
-		version, err := etcdClient.GetVersion(ctx)
-		if err != nil {
-			return err
-		}
-
-		log.Infof("Etcd Version [Server: %s, Cluster: %s]", version.Server, version.Cluster)
-	}
-
+	// the client of the data store is initialized along with the dao, see
+	// dao.InitFromConfig.
 	_, err := clients.Kubernetes()
 	if err != nil {
 		return err
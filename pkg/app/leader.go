@@ -0,0 +1,167 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/openshift/ansible-service-broker/pkg/broker"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apiserver/pkg/server/healthz"
+)
+
+const (
+	// defaultLeaseName - the name of the lease the brokers compete for when
+	// the config does not say.
+	defaultLeaseName = "ansible-service-broker"
+	// defaultDrainTimeout - how long a leader stepping down waits for its
+	// running jobs when the config does not say.
+	defaultDrainTimeout = 30 * time.Second
+)
+
+// leaderElector - the leader elector of the broker config. The identity
+// defaults to the host name, the pod name in a cluster, made unique. The
+// file and memory daos are not shared by the brokers and are refused.
+func (a *App) leaderElector() (*broker.LeaderElector, error) {
+	switch t := dao.BackendType(a.config); t {
+	case "file", "memory":
+		return nil, fmt.Errorf("dao type %s - %v", t, types.ErrLeasesUnsupported)
+	}
+	identity := a.config.GetString("broker.leader_election_identity")
+	if identity == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		identity = fmt.Sprintf("%s_%s", host, uuid.New())
+	}
+	name := a.config.GetString("broker.leader_election_lease")
+	if name == "" {
+		name = defaultLeaseName
+	}
+	return broker.NewLeaderElector(a.dao, broker.LeaderElectionConfig{
+		Name:          name,
+		Identity:      identity,
+		LeaseDuration: configDuration(a.config, "broker.leader_election_lease_duration", 0),
+		RenewDeadline: configDuration(a.config, "broker.leader_election_renew_deadline", 0),
+		RetryPeriod:   configDuration(a.config, "broker.leader_election_retry_period", 0),
+	})
+}
+
+// campaign - leads while the broker holds the leader lease. On SIGTERM or
+// SIGINT the broker stops accepting work, lets its running jobs finish within
+// the drain timeout and releases the lease, for another broker to take over
+// right away. A broker that loses the lease stops accepting work as well, and
+// lets its running jobs finish for as long as the other brokers still honour
+// the lease before it exits, the new leader resumes the jobs left.
+func (a *App) campaign(elector *broker.LeaderElector) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- elector.Run(ctx, a.lead)
+	}()
+
+	timeout := configDuration(a.config, "broker.leader_election_drain_timeout", defaultDrainTimeout)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-done:
+		atomic.StoreInt32(&a.steppingDown, 1)
+		log.Errorf("Stepping down, lost the leader lease - %v", err)
+		if grace := elector.Grace(); grace < timeout {
+			timeout = grace
+		}
+		a.drain(timeout)
+		log.Error("Exiting, the new leader resumes the jobs of this broker")
+		os.Exit(1)
+	case sig := <-signals:
+		log.Infof("Received %v, stepping down", sig)
+	}
+
+	atomic.StoreInt32(&a.steppingDown, 1)
+	if elector.IsLeader() {
+		a.drain(timeout)
+	}
+	cancel()
+	<-done
+	os.Exit(0)
+}
+
+// drain - stops starting the queued jobs and waits up to timeout for the
+// running ones to finish.
+func (a *App) drain(timeout time.Duration) {
+	if running := a.engine.Drain(timeout); running > 0 {
+		log.Warningf("[ %d ] jobs still running after %v, the new leader runs them again", running, timeout)
+	}
+}
+
+// accepting - whether the broker accepts the requests changing it, only the
+// leader does until it steps down.
+func (a *App) accepting(elector *broker.LeaderElector) func() bool {
+	return func() bool {
+		return elector.IsLeader() && atomic.LoadInt32(&a.steppingDown) == 0
+	}
+}
+
+// leaderHandler - serves the reads on every broker, and the requests changing
+// the broker only while it is accepting them. The others, which only reach a
+// follower while the readiness of the brokers settles, are answered with 503
+// for the platform to retry them.
+func leaderHandler(h http.Handler, accepting func() bool, elector *broker.LeaderElector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || accepting() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(broker.ErrorResponse{Description: notLeader(elector)})
+	})
+}
+
+// leaderCheck - the health check of /healthz failing on the brokers not
+// accepting the requests changing the broker. The readiness probe then keeps
+// the followers out of the broker service, which only routes to the leader.
+func leaderCheck(accepting func() bool, elector *broker.LeaderElector) healthz.HealthzChecker {
+	return healthz.NamedCheck("leader", func(*http.Request) error {
+		if accepting() {
+			return nil
+		}
+		return errors.New(notLeader(elector))
+	})
+}
+
+// notLeader - why a follower turns the requests changing the broker down.
+func notLeader(elector *broker.LeaderElector) string {
+	description := "This broker is not the leader"
+	if leader := elector.Leader(); leader != "" {
+		description = fmt.Sprintf("%s, %s is", description, leader)
+	}
+	return description
+}
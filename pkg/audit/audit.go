@@ -0,0 +1,185 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package audit records who did what to which service instance or binding, and
+// when, along with the outcome of the jobs run for it. The events are written
+// by an Auditor to the sinks set up when the broker starts, with their
+// sensitive parameters redacted.
+package audit
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// KindRequest - the event of a request to the broker.
+	KindRequest = "request"
+	// KindJob - the event of a job finishing.
+	KindJob = "job"
+	// Redacted - the value replacing the sensitive parameters.
+	Redacted = "<redacted>"
+)
+
+// Event - an audit event.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Kind is KindRequest or KindJob.
+	Kind string `json:"kind"`
+	// Action is the operation requested, or the method of the job.
+	Action     string `json:"action"`
+	InstanceID string `json:"instance_id,omitempty"`
+	BindingID  string `json:"binding_id,omitempty"`
+	ServiceID  string `json:"service_id,omitempty"`
+	PlanID     string `json:"plan_id,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	// User is the user the platform made the request for.
+	User *User `json:"user,omitempty"`
+	// Principal is who the broker authenticated.
+	Principal  *Principal             `json:"principal,omitempty"`
+	RemoteAddr string                 `json:"remote_addr,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	// Status is the status code the broker answered the request with.
+	Status int `json:"status,omitempty"`
+	// Operation is the token of the job, returned for asynchronous requests.
+	Operation string `json:"operation,omitempty"`
+	// State is the state the job finished in.
+	State   string `json:"state,omitempty"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// User - the user from the originating identity of the request.
+type User struct {
+	Username string   `json:"username"`
+	UID      string   `json:"uid,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// Principal - the identity an auth provider authenticated.
+type Principal struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// Sink - where the audit events are written.
+type Sink interface {
+	Write(event Event) error
+}
+
+// sensitiveKeys - the parameters redacted whatever the config says, matched
+// as part of the parameter name regardless of case.
+var sensitiveKeys = []string{"password", "passwd", "secret", "token", "credential", "key", "cert"}
+
+// Auditor - writes the audit events to its sinks, with their sensitive
+// parameters redacted. An auditor without sinks, or a nil one, records
+// nothing.
+type Auditor struct {
+	sinks      []Sink
+	redactKeys map[string]bool
+}
+
+// NewAuditor - creates an auditor writing to the sinks, redacting the
+// parameters named by redactKeys on top of the ones that look sensitive.
+func NewAuditor(sinks []Sink, redactKeys ...string) *Auditor {
+	a := &Auditor{sinks: sinks, redactKeys: map[string]bool{}}
+	for _, key := range redactKeys {
+		a.redactKeys[strings.ToLower(key)] = true
+	}
+	return a
+}
+
+// Enabled - whether the audit events are written anywhere.
+func (a *Auditor) Enabled() bool {
+	return a != nil && len(a.sinks) > 0
+}
+
+// Record - writes the event, with its parameters redacted, to every sink.
+// Returns the first error of the sinks, every sink is written regardless.
+func (a *Auditor) Record(event Event) error {
+	if !a.Enabled() {
+		return nil
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Parameters = a.Redact(event.Parameters)
+	var first error
+	for _, sink := range a.sinks {
+		if err := sink.Write(event); err != nil {
+			log.Errorf("Unable to write the %s audit event of %s - %v", event.Action, event.InstanceID, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Redact - a copy of the parameters with the sensitive ones redacted, in the
+// maps and lists they hold as well.
+func (a *Auditor) Redact(params map[string]interface{}) map[string]interface{} {
+	redactKeys := map[string]bool{}
+	if a != nil {
+		redactKeys = a.redactKeys
+	}
+	return redactMap(params, redactKeys)
+}
+
+func redactMap(params map[string]interface{}, redactKeys map[string]bool) map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		if isSensitive(key, redactKeys) {
+			redacted[key] = Redacted
+			continue
+		}
+		redacted[key] = redactValue(value, redactKeys)
+	}
+	return redacted
+}
+
+func redactValue(value interface{}, redactKeys map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return redactMap(v, redactKeys)
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redactValue(item, redactKeys)
+		}
+		return redacted
+	default:
+		return value
+	}
+}
+
+func isSensitive(key string, redactKeys map[string]bool) bool {
+	key = strings.ToLower(key)
+	if redactKeys[key] {
+		return true
+	}
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
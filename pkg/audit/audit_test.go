@@ -0,0 +1,149 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+type recordingSink struct {
+	events []Event
+}
+
+func (s *recordingSink) Write(event Event) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	sink := &recordingSink{}
+	auditor := NewAuditor([]Sink{sink}, "db_name")
+
+	ft.AssertTrue(t, auditor.Enabled())
+	params := map[string]interface{}{
+		"db_name":         "mydb",
+		"admin_password":  "hunter2",
+		"SSH_Private_Key": "-----BEGIN",
+		"size":            3,
+		"nested":          map[string]interface{}{"api_token": "t", "region": "eu"},
+		"users":           []interface{}{map[string]interface{}{"name": "bob", "password": "x"}, "plain"},
+	}
+	ft.AssertNil(t, auditor.Record(Event{Kind: KindRequest, Action: "provision", Parameters: params}))
+
+	ft.AssertEqual(t, 1, len(sink.events))
+	event := sink.events[0]
+	ft.AssertFalse(t, event.Time.IsZero())
+	ft.AssertEqual(t, Redacted, event.Parameters["db_name"])
+	ft.AssertEqual(t, Redacted, event.Parameters["admin_password"])
+	ft.AssertEqual(t, Redacted, event.Parameters["SSH_Private_Key"])
+	ft.AssertEqual(t, 3, event.Parameters["size"])
+	nested := event.Parameters["nested"].(map[string]interface{})
+	ft.AssertEqual(t, Redacted, nested["api_token"])
+	ft.AssertEqual(t, "eu", nested["region"])
+	// the maps in lists are redacted as well.
+	users := event.Parameters["users"].([]interface{})
+	ft.AssertEqual(t, Redacted, users[0].(map[string]interface{})["password"])
+	ft.AssertEqual(t, "bob", users[0].(map[string]interface{})["name"])
+	ft.AssertEqual(t, "plain", users[1])
+	// the parameters of the caller are left alone.
+	ft.AssertEqual(t, "hunter2", params["admin_password"])
+	ft.AssertEqual(t, "x", params["users"].([]interface{})[0].(map[string]interface{})["password"])
+
+	// an auditor without sinks, or a nil one, records nothing.
+	for _, disabled := range []*Auditor{NewAuditor(nil), nil} {
+		ft.AssertFalse(t, disabled.Enabled())
+		ft.AssertNil(t, disabled.Record(Event{Kind: KindRequest, Action: "bind"}))
+	}
+	ft.AssertEqual(t, 1, len(sink.events))
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "logs", "audit.log")
+
+	sink, err := NewFileSink(path, 300, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		ft.AssertNil(t, sink.Write(Event{Kind: KindRequest, Action: "provision", InstanceID: "0123456789abcdef0123456789abcdef"}))
+	}
+	ft.AssertNil(t, sink.Close())
+	ft.AssertNotNil(t, sink.Write(Event{Kind: KindRequest, Action: "bind"}))
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ft.AssertTrue(t, info.Size() <= 300)
+		ft.AssertEqual(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	_, err = os.Stat(path + ".3")
+	ft.AssertTrue(t, os.IsNotExist(err))
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	ft.AssertTrue(t, scanner.Scan())
+	event := Event{}
+	ft.AssertNil(t, json.Unmarshal(scanner.Bytes(), &event))
+	ft.AssertEqual(t, "provision", event.Action)
+}
+
+func TestDaoSink(t *testing.T) {
+	d, _ := memory.NewDao()
+	sink := NewDaoSink(d, 10)
+	now := time.Now()
+	for i := 0; i < 12; i++ {
+		ft.AssertNil(t, sink.Write(Event{Time: now.Add(time.Duration(i) * time.Second), Kind: KindJob, Action: "provision"}))
+	}
+	// the records are not pruned while they are written
+	records, err := d.BatchGetAuditRecords()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 12, len(records))
+
+	// the writes asked for a pruning, which does not wait for the ticker
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sink.Run(ctx, time.Hour)
+	for i := 0; i < 100 && len(records) > 10; i++ {
+		time.Sleep(10 * time.Millisecond)
+		records, err = d.BatchGetAuditRecords()
+		ft.AssertNil(t, err)
+	}
+	ft.AssertEqual(t, 10, len(records))
+	ft.AssertTrue(t, records[0].Time.Equal(now.Add(2*time.Second)))
+	ft.AssertEqual(t, "provision", records[9].Event["action"])
+}
@@ -0,0 +1,129 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// DAO - the dao methods the dao sink uses.
+type DAO interface {
+	SetAuditRecord(types.AuditRecord) error
+	DeleteAuditRecord(string) error
+	BatchGetAuditRecords() ([]types.AuditRecord, error)
+}
+
+// DaoSink - stores the audit events in the dao, keeping the most recent ones.
+// The records past the ones kept are pruned in the background by Run, never
+// while an event is written.
+type DaoSink struct {
+	lock sync.Mutex
+	dao  DAO
+	// maxRecords is how many records are kept, 0 keeps them all.
+	maxRecords int
+	// written counts the records stored since the last pruning was asked.
+	written int
+	// pruning asks Run to prune without waiting for its ticker.
+	pruning chan struct{}
+}
+
+// NewDaoSink - creates a new dao sink keeping the maxRecords most recent
+// records.
+func NewDaoSink(d DAO, maxRecords int) *DaoSink {
+	return &DaoSink{dao: d, maxRecords: maxRecords, pruning: make(chan struct{}, 1)}
+}
+
+// Write - stores the event. Once a tenth of maxRecords have been stored since
+// the last time, Run is asked to prune the oldest records, so the dao holds
+// about 10% more records than it keeps.
+func (s *DaoSink) Write(event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	record := types.AuditRecord{ID: uuid.New(), Time: event.Time, Event: map[string]interface{}{}}
+	if err := json.Unmarshal(payload, &record.Event); err != nil {
+		return err
+	}
+	if err := s.dao.SetAuditRecord(record); err != nil {
+		return err
+	}
+	if s.maxRecords <= 0 {
+		return nil
+	}
+
+	s.lock.Lock()
+	s.written++
+	due := s.written >= s.maxRecords/10+1
+	if due {
+		s.written = 0
+	}
+	s.lock.Unlock()
+	if due {
+		select {
+		case s.pruning <- struct{}{}:
+		default:
+			// a pruning is already pending.
+		}
+	}
+	return nil
+}
+
+// Run - prunes the records every interval, and whenever Write asks, until
+// the context is done. It returns right away when all the records are kept.
+func (s *DaoSink) Run(ctx context.Context, interval time.Duration) {
+	if s.maxRecords <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.pruning:
+		case <-ctx.Done():
+			return
+		}
+		if err := s.prune(); err != nil {
+			log.Errorf("Unable to prune the audit records - %v", err)
+		}
+	}
+}
+
+// prune - deletes the records past the ones kept, oldest first.
+func (s *DaoSink) prune() error {
+	records, err := s.dao.BatchGetAuditRecords()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(records)-s.maxRecords; i++ {
+		if err := s.dao.DeleteAuditRecord(records[i].ID); err != nil {
+			return err
+		}
+	}
+	if pruned := len(records) - s.maxRecords; pruned > 0 {
+		log.Debugf("Pruned [ %d ] audit records", pruned)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/audit"
+	log "github.com/sirupsen/logrus"
+)
+
+// AuditSubscriber - records the outcome of the jobs in the audit log.
+type AuditSubscriber struct {
+	auditor *audit.Auditor
+}
+
+// NewAuditSubscriber - creates a new audit subscriber recording with the
+// auditor.
+func NewAuditSubscriber(auditor *audit.Auditor) *AuditSubscriber {
+	return &AuditSubscriber{auditor: auditor}
+}
+
+// ID is used as an identifier for the type of subscriber
+func (as *AuditSubscriber) ID() string {
+	return "audit"
+}
+
+// Notify records the outcome of the job.
+func (as *AuditSubscriber) Notify(msg JobMsg) {
+	if err := as.Deliver(msg); err != nil {
+		log.Errorf("Error unable to record the outcome of job %s in the audit log : %v", msg.JobToken, err)
+	}
+}
+
+// Deliver records the job once it has finished, the messages of the jobs in
+// progress are left out.
+func (as *AuditSubscriber) Deliver(msg JobMsg) error {
+	switch msg.State.State {
+	case bundle.StateSucceeded, bundle.StateFailed, StateCancelled:
+	default:
+		return nil
+	}
+	event := audit.Event{
+		Kind:       audit.KindJob,
+		Action:     string(msg.State.Method),
+		InstanceID: msg.InstanceUUID,
+		BindingID:  msg.BindingUUID,
+		Operation:  msg.JobToken,
+		State:      string(msg.State.State),
+		Message:    msg.State.Description,
+		Error:      msg.Error,
+	}
+	if event.Error == "" {
+		event.Error = msg.State.Error
+	}
+	return as.auditor.Record(event)
+}
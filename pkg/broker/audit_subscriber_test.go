@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"testing"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/audit"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+type auditSink struct {
+	events []audit.Event
+}
+
+func (s *auditSink) Write(event audit.Event) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestAuditSubscriber(t *testing.T) {
+	sink := &auditSink{}
+	sub := NewAuditSubscriber(audit.NewAuditor([]audit.Sink{sink}))
+
+	inProgress := JobMsg{InstanceUUID: "i", JobToken: "t",
+		State: bundle.JobState{Token: "t", State: bundle.StateInProgress, Method: bundle.JobMethodProvision}}
+	ft.AssertNil(t, sub.Deliver(inProgress))
+	ft.AssertEqual(t, 0, len(sink.events))
+
+	failed := JobMsg{InstanceUUID: "i", BindingUUID: "b", JobToken: "t",
+		State: bundle.JobState{Token: "t", State: bundle.StateFailed, Method: bundle.JobMethodBind,
+			Description: "bind failed", Error: "pod errored"}}
+	ft.AssertNil(t, sub.Deliver(failed))
+	ft.AssertEqual(t, 1, len(sink.events))
+	event := sink.events[0]
+	ft.AssertEqual(t, audit.KindJob, event.Kind)
+	ft.AssertEqual(t, "bind", event.Action)
+	ft.AssertEqual(t, "b", event.BindingID)
+	ft.AssertEqual(t, "t", event.Operation)
+	ft.AssertEqual(t, "failed", event.State)
+	ft.AssertEqual(t, "bind failed", event.Message)
+	ft.AssertEqual(t, "pod errored", event.Error)
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/automationbroker/bundle-lib/registries"
 	"github.com/automationbroker/config"
 	"github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
 	"github.com/openshift/ansible-service-broker/pkg/metrics"
 	"github.com/pborman/uuid"
 	log "github.com/sirupsen/logrus"
@@ -107,6 +108,12 @@ type DevBroker interface {
 	RemoveSpecs() error
 }
 
+// AdminBroker - Interface for the administration routes of the broker.
+type AdminBroker interface {
+	CancelJob(token string) error
+	ReplayDeadLetters() (*ReplayResponse, error)
+}
+
 // AnsibleBroker - Broker using ansible and images to interact with oc/kubernetes/etcd
 type AnsibleBroker struct {
 	dao          dao.Dao
@@ -190,15 +197,23 @@ func (a AnsibleBroker) Bootstrap() (*BootstrapResponse, error) {
 	var specs []*bundle.Spec
 	var imageCount int
 
-	// Get specs from datastore
-	dir := "/spec"
-	specs, err = a.dao.BatchGetSpecs(dir)
+	// Walk the specs in the datastore a page at a time, remembering which
+	// ones exist and which are marked for deletion.
+	daoSpecIDs := make(map[string]bool)
+	markedSpecs := make(map[string]*bundle.Spec)
+	err = dao.ForEachSpecPage(a.dao, dao.DefaultPageSize, func(page []*bundle.Spec) error {
+		for _, spec := range page {
+			daoSpecIDs[spec.ID] = true
+		}
+		for id, spec := range getMarkedSpecs(page) {
+			markedSpecs[id] = spec
+		}
+		return nil
+	})
 	if err != nil {
 		log.Errorf("Something went real bad trying to retrieve batch specs... - %v", err)
 		return nil, err
 	}
-	// Get list of marked specs in datastore
-	markedSpecs := getMarkedSpecs(specs)
 	// Get list of specs safe to delete
 	unwantedSpecs := getSafeToDeleteSpecs(a, markedSpecs)
 	// Delete the unwanted specs
@@ -210,16 +225,11 @@ func (a AnsibleBroker) Bootstrap() (*BootstrapResponse, error) {
 	log.Infof("%v specs deleted", len(unwantedSpecs))
 	metrics.SpecsDeleted(len(unwantedSpecs))
 
-	// Getting specs again so that deleted specs do not end up in further comparisons
-	specs, err = a.dao.BatchGetSpecs(dir)
-	if err != nil {
-		log.Errorf("Something went real bad trying to retrieve batch specs... - %v", err)
-		return nil, err
+	// Forget the deleted specs so that they do not end up in further comparisons
+	for _, spec := range unwantedSpecs {
+		delete(daoSpecIDs, spec.ID)
 	}
 
-	daoSpecs := convertSpecListToMap(specs)
-	specs = []*bundle.Spec{}
-
 	// Load Specs for each registry
 	registryErrors := []error{}
 	for _, r := range a.registry {
@@ -245,8 +255,18 @@ func (a AnsibleBroker) Bootstrap() (*BootstrapResponse, error) {
 		return nil, errors.New("all registries failed on bootstrap")
 	}
 
-	specManifest := getSpecManifest(daoSpecs, specs)
-	markedSpecs = markSpecsForDeletion(daoSpecs, specManifest)
+	specManifest := getSpecManifest(daoSpecIDs, specs)
+	markedSpecs = make(map[string]*bundle.Spec)
+	err = dao.ForEachSpecPage(a.dao, dao.DefaultPageSize, func(page []*bundle.Spec) error {
+		for id, spec := range markSpecsForDeletion(convertSpecListToMap(page), specManifest) {
+			markedSpecs[id] = spec
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Something went real bad trying to retrieve batch specs... - %v", err)
+		return nil, err
+	}
 
 	metrics.SpecsMarkedForDeletion(len(markedSpecs))
 
@@ -277,13 +297,13 @@ func markSpecsForDeletion(daoSpecs map[string]*bundle.Spec, specManifest bundle.
 	return markedSpecs
 }
 
-func getSpecManifest(daoSpecs map[string]*bundle.Spec, specs []*bundle.Spec) bundle.SpecManifest {
+func getSpecManifest(daoSpecIDs map[string]bool, specs []*bundle.Spec) bundle.SpecManifest {
 	specManifest := make(map[string]*bundle.Spec)
 
 	for _, s := range specs {
 		// If condition is just for logging. It is useful information
 		// as to which specs were added and which were updated
-		if _, ok := daoSpecs[s.ID]; ok {
+		if daoSpecIDs[s.ID] {
 			log.Debugf("spec '%v|%v' needs to be updated", s.ID, s.FQName)
 			specManifest[s.ID] = s
 			s.Delete = false
@@ -305,29 +325,46 @@ func getSpecManifest(daoSpecs map[string]*bundle.Spec, specs []*bundle.Spec) bun
 	return specManifest
 }
 
+// errAllSpecsInUse - stops walking the service instances once every spec
+// marked for deletion turned out to still be in use.
+var errAllSpecsInUse = errors.New("all marked specs are in use")
+
 // getSafeToDeleteSpecs - will return a list of specs that are safe to delete.
+// The specs in use are found by walking all of the service instances, rather
+// than through the secondary indexes of the dao, which some backends only
+// keep on a best effort basis.
 func getSafeToDeleteSpecs(a AnsibleBroker, markedSpecs map[string]*bundle.Spec) []*bundle.Spec {
 	safeToDeleteSpecs := make([]*bundle.Spec, 0)
-	bundleInstances, err := a.dao.BatchGetBundleInstances()
-	if err != nil {
+	log.Debugf("markedSpecs: %+v\n", markedSpecs)
+	inUse := map[string]bool{}
+	err := dao.ForEachServiceInstancePage(a.dao, dao.DefaultPageSize, func(page []*bundle.ServiceInstance) error {
+		for _, bundleInstance := range page {
+			if bundleInstance.Spec == nil {
+				continue
+			}
+			if _, ok := markedSpecs[bundleInstance.Spec.ID]; ok && !inUse[bundleInstance.Spec.ID] {
+				log.Debugf("spec '%v' not safe to delete, used by instance %v", bundleInstance.Spec.ID, bundleInstance.ID)
+				inUse[bundleInstance.Spec.ID] = true
+				if len(inUse) == len(markedSpecs) {
+					return errAllSpecsInUse
+				}
+			}
+		}
+		return nil
+	})
+	if err == errAllSpecsInUse {
+		return safeToDeleteSpecs
+	} else if err != nil {
 		log.Errorf("error getting bundle instances '%+v'", err)
 		// returning nil instead of checking the error,
 		// the broker can simply ignore to delete the specs and hope
 		// that the error won't repeat during next bootstrap cycle
 		return nil
 	}
-	log.Debugf("markedSpecs: %+v\n", markedSpecs)
-	for _, bundleInstance := range bundleInstances {
-		log.Debugf("bundle instance: %+v\n", bundleInstance.ID)
-		if _, ok := markedSpecs[bundleInstance.Spec.ID]; ok {
-			log.Debugf("spec '%v' not safe to delete", bundleInstance.Spec.ID)
-			delete(markedSpecs, bundleInstance.Spec.ID)
-			if len(markedSpecs) == 0 {
-				break
-			}
+	for id, spec := range markedSpecs {
+		if inUse[id] {
+			continue
 		}
-	}
-	for _, spec := range markedSpecs {
 		log.Debugf("spec '%v' safe to delete", spec.ID)
 		safeToDeleteSpecs = append(safeToDeleteSpecs, spec)
 	}
@@ -431,6 +468,10 @@ func (a AnsibleBroker) Recover() (string, error) {
 
 	// let's see if we need to recover any of these
 	for _, rs := range recoverStatuses {
+		if a.engine.hasJob(rs.State.Token) {
+			log.Debugf("Job %s was resumed from its job record, skipping", rs.State.Token)
+			continue
+		}
 
 		// We have an in progress job
 		instanceID := rs.InstanceID.String()
@@ -518,42 +559,39 @@ func (a AnsibleBroker) Recover() (string, error) {
 func (a AnsibleBroker) Catalog() (*CatalogResponse, error) {
 	log.Info("AnsibleBroker::Catalog")
 
-	var specs []*bundle.Spec
-	var err error
-	var services []Service
-	dir := "/spec"
-
-	if specs, err = a.dao.BatchGetSpecs(dir); err != nil {
-		log.Error("Something went real bad trying to retrieve batch specs...")
-		return nil, err
-	}
-
-	log.Debugf("Filtering secret parameters out of specs...")
-	specs, err = bundle.FilterSecrets(specs)
-	if err != nil {
-		// Should we blow up or warn and continue?
-		log.Errorf("Something went real bad trying to load secrets %v", err)
-		return nil, err
-	}
-
-	services = []Service{}
-	for _, spec := range specs {
-		ser, err := SpecToService(spec)
+	services := []Service{}
+	err := dao.ForEachSpecPage(a.dao, dao.DefaultPageSize, func(specs []*bundle.Spec) error {
+		log.Debugf("Filtering secret parameters out of specs...")
+		specs, err := bundle.FilterSecrets(specs)
 		if err != nil {
-			log.Errorf("not adding spec %v to list of services due to error transforming to service - %v", spec.FQName, err)
-		} else {
-			// Bug 1539542 - in order for async bind to work,
-			// bindings_retrievable needs to be set to true. We only want to
-			// set BindingsRetrievable to true if the service is bindable
-			// AND we the broker is configured to launch apbs on bind
-			if ser.Bindable && a.brokerConfig.LaunchApbOnBind {
-				ser.BindingsRetrievable = true
-			}
-			if !spec.Delete {
-				// add only the specs that are not marked for deletion.
-				services = append(services, ser)
+			// Should we blow up or warn and continue?
+			log.Errorf("Something went real bad trying to load secrets %v", err)
+			return err
+		}
+
+		for _, spec := range specs {
+			ser, err := SpecToService(spec)
+			if err != nil {
+				log.Errorf("not adding spec %v to list of services due to error transforming to service - %v", spec.FQName, err)
+			} else {
+				// Bug 1539542 - in order for async bind to work,
+				// bindings_retrievable needs to be set to true. We only want to
+				// set BindingsRetrievable to true if the service is bindable
+				// AND we the broker is configured to launch apbs on bind
+				if ser.Bindable && a.brokerConfig.LaunchApbOnBind {
+					ser.BindingsRetrievable = true
+				}
+				if !spec.Delete {
+					// add only the specs that are not marked for deletion.
+					services = append(services, ser)
+				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		log.Error("Something went real bad trying to retrieve batch specs...")
+		return nil, err
 	}
 
 	return &CatalogResponse{services}, nil
@@ -1099,8 +1137,7 @@ func (a AnsibleBroker) Bind(instance bundle.ServiceInstance, bindingUUID uuid.UU
 			return nil, false, err
 		}
 		//TODO are we only setting the bindingUUID if sync?
-		instance.AddBinding(bindingUUID)
-		if err := a.dao.SetServiceInstance(instance.ID.String(), &instance); err != nil {
+		if err := a.addBinding(instance.ID.String(), bindingUUID); err != nil {
 			return nil, false, err
 		}
 	} else {
@@ -1113,8 +1150,7 @@ func (a AnsibleBroker) Bind(instance bundle.ServiceInstance, bindingUUID uuid.UU
 			log.Errorf("Unable to create new binding extracted creds from provision creds - %v", err)
 			return nil, false, err
 		}
-		instance.AddBinding(bindingUUID)
-		if err := a.dao.SetServiceInstance(instance.ID.String(), &instance); err != nil {
+		if err := a.addBinding(instance.ID.String(), bindingUUID); err != nil {
 			return nil, false, err
 		}
 	}
@@ -1124,6 +1160,16 @@ func (a AnsibleBroker) Bind(instance bundle.ServiceInstance, bindingUUID uuid.UU
 	return resp, false, err
 }
 
+// addBinding - records the binding on the latest copy of the service
+// instance, retrying when another request or a job updated it first.
+func (a AnsibleBroker) addBinding(instanceID string, bindingUUID uuid.UUID) error {
+	_, err := dao.UpdateServiceInstance(a.dao, instanceID, func(si *bundle.ServiceInstance) error {
+		si.AddBinding(bindingUUID)
+		return nil
+	})
+	return err
+}
+
 // Unbind - unbind a service's previous binding. Parameter "async" declares
 // whether the caller is willing to have the operation run asynchronously. The
 // returned bool will be true if the operation actually ran asynchronously.
@@ -1228,11 +1274,13 @@ func (a AnsibleBroker) Unbind(
 		}
 	} else {
 		log.Warning("Broker configured to *NOT* launch and run APB unbind")
-		if err := a.dao.DeleteBinding(bindInstance, serviceInstance); err != nil {
+		batch := types.DeleteBindingBatch(bindInstance.ID.String(), serviceInstance.ID.String())
+		batch.Intents = []types.Intent{deleteCredentialsIntent(bindInstance.ID.String())}
+		if err := a.dao.Apply(batch); err != nil {
 			log.Errorf("Failed to delete binding when launch_apb_on_bind is false: %v", err)
 			return nil, false, err
 		}
-		if err := bundle.DeleteExtractedCredentials(bindInstance.ID.String()); err != nil {
+		if err := completeIntents(a.dao, batch.Intents); err != nil {
 			log.Errorf("Failed to delete extracted credentials secret when launch_apb_on_bind is false: %v", err)
 			return nil, false, err
 		}
@@ -1418,8 +1466,13 @@ func (a AnsibleBroker) Update(instanceUUID uuid.UUID, req *UpdateRequest, async
 		(*si.Parameters)[newParamKey] = newParamVal
 	}
 
-	// We're ready to provision so save
-	if err = a.dao.SetServiceInstance(instanceUUID.String(), si); err != nil {
+	// We're ready to provision so save. Only the parameters are written onto
+	// the latest copy so that bindings added in the meantime are not lost.
+	si, err = dao.UpdateServiceInstance(a.dao, instanceUUID.String(), func(latest *bundle.ServiceInstance) error {
+		latest.Parameters = si.Parameters
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -1597,3 +1650,62 @@ func (a AnsibleBroker) RemoveSpecs() error {
 	metrics.SpecsDeleted(len(specs))
 	return nil
 }
+
+// ResumeJobs - start again, under the same token, the jobs accepted before
+// the broker stopped that had not run yet. Returns how many were started.
+func (a AnsibleBroker) ResumeJobs() (int, error) {
+	records, err := a.dao.BatchGetJobRecords()
+	if err != nil {
+		return 0, err
+	}
+	resumed := 0
+	for _, record := range records {
+		job, topic, err := a.recordWork(record)
+		if err != nil {
+			log.Errorf("Unable to resume job %s, dropping it - %v", record.Token, err)
+			if err := a.dao.DeleteJobRecord(record.Token); err != nil {
+				log.Errorf("Unable to delete the record of job %s - %v", record.Token, err)
+			}
+			continue
+		}
+		log.Infof("Resuming %s job %s accepted at %v", record.Method, record.Token, record.Created)
+		if _, err := a.engine.StartNewAsyncJob(record.Token, job, topic); err != nil {
+			return resumed, err
+		}
+		resumed++
+	}
+	return resumed, nil
+}
+
+// recordWork - the work of the job record and the topic it reports to.
+func (a AnsibleBroker) recordWork(record types.JobRecord) (Work, WorkTopic, error) {
+	si := record.ServiceInstance
+	if si == nil || si.Spec == nil {
+		return nil, "", fmt.Errorf("the job record has no service instance")
+	}
+	switch record.Method {
+	case bundle.JobMethodProvision:
+		return a.workFactory.NewProvisionJob(si), ProvisionTopic, nil
+	case bundle.JobMethodUpdate:
+		return a.workFactory.NewUpdateJob(si), UpdateTopic, nil
+	case bundle.JobMethodDeprovision:
+		return a.workFactory.NewDeprovisionJob(si, record.SkipExecution), DeprovisionTopic, nil
+	case bundle.JobMethodBind:
+		return a.workFactory.NewBindJob(record.BindingID, record.Parameters, si), BindingTopic, nil
+	case bundle.JobMethodUnbind:
+		return a.workFactory.NewUnbindJob(record.BindingID, record.Parameters, si, record.SkipExecution), UnbindingTopic, nil
+	}
+	return nil, "", fmt.Errorf("unrecognized job method %s", record.Method)
+}
+
+// CancelJob - cancel the job of the token running or queued in the work
+// engine of this broker.
+func (a AnsibleBroker) CancelJob(token string) error {
+	return a.engine.CancelJob(token)
+}
+
+// ReplayDeadLetters - hand the job messages the subscribers failed to handle
+// to them again.
+func (a AnsibleBroker) ReplayDeadLetters() (*ReplayResponse, error) {
+	return a.engine.ReplayDeadLetters()
+}
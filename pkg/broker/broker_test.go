@@ -17,6 +17,7 @@
 package broker
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"testing"
@@ -26,10 +27,14 @@ import (
 	"errors"
 	"github.com/automationbroker/bundle-lib/bundle"
 	"github.com/automationbroker/bundle-lib/registries"
+	"github.com/automationbroker/bundle-lib/runtime"
 	"github.com/automationbroker/config"
+	asbdao "github.com/openshift/ansible-service-broker/pkg/dao"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
 	"github.com/openshift/ansible-service-broker/pkg/dao/mocks"
 	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
 	"github.com/pborman/uuid"
+	tmock "github.com/stretchr/testify/mock"
 	"os"
 )
 
@@ -306,7 +311,7 @@ func TestGetSafeToDeleteSpecs(t *testing.T) {
 	}
 	for _, _t := range tc {
 		m := getMarkedSpecs(specs)
-		_t.dao.On("BatchGetBundleInstances").Return(_t.returnArgSI, _t.returnArgErr)
+		_t.dao.On("GetServiceInstancesPage", "", asbdao.DefaultPageSize).Return(_t.returnArgSI, "", _t.returnArgErr)
 		a.dao = _t.dao
 		s := getSafeToDeleteSpecs(a, m)
 		if !reflect.DeepEqual(convertSpecListToMap(s), convertSpecListToMap(_t.expectedOutput)) {
@@ -346,7 +351,10 @@ func TestGetSpecManifest(t *testing.T) {
 		t.Fail()
 	}
 	defer f.Close()
-	daoSpecs := convertSpecListToMap(specs)
+	daoSpecIDs := make(map[string]bool)
+	for _, spec := range specs {
+		daoSpecIDs[spec.ID] = true
+	}
 	newF, err := os.Open("./testdata/updatedSpecs.json")
 	if err != nil {
 		t.Fail()
@@ -356,7 +364,7 @@ func TestGetSpecManifest(t *testing.T) {
 		t.Fail()
 	}
 	defer newF.Close()
-	n := getSpecManifest(daoSpecs, newSpecs)
+	n := getSpecManifest(daoSpecIDs, newSpecs)
 	if _, ok := n["0e991006d21029e47abe71acc255e807"]; !ok {
 		t.Fail()
 	}
@@ -400,3 +408,137 @@ func TestMarkSpecsForDeletion(t *testing.T) {
 		}
 	}
 }
+
+// flowWork - completes successfully as soon as it is run.
+type flowWork struct {
+	id     string
+	method bundle.JobMethod
+	msg    JobMsg
+}
+
+func (w *flowWork) ID() string {
+	return w.id
+}
+
+func (w *flowWork) Method() bundle.JobMethod {
+	return w.method
+}
+
+func (w *flowWork) Run(ctx context.Context, token string, msgBuffer chan<- JobMsg) {
+	msg := w.msg
+	msg.JobToken = token
+	msg.State = bundle.JobState{Token: token, State: bundle.StateSucceeded, Method: w.method}
+	msgBuffer <- msg
+}
+
+type flowWorkFactory struct{}
+
+func (f flowWorkFactory) work(si *bundle.ServiceInstance, bindingID string, method bundle.JobMethod) Work {
+	id := si.ID.String()
+	if bindingID != "" {
+		id = bindingID
+	}
+	return &flowWork{id: id, method: method, msg: JobMsg{
+		InstanceUUID: si.ID.String(),
+		BindingUUID:  bindingID,
+		SpecID:       si.Spec.ID,
+	}}
+}
+
+func (f flowWorkFactory) NewProvisionJob(si *bundle.ServiceInstance) Work {
+	return f.work(si, "", bundle.JobMethodProvision)
+}
+
+func (f flowWorkFactory) NewDeprovisionJob(si *bundle.ServiceInstance, skipExecution bool) Work {
+	return f.work(si, "", bundle.JobMethodDeprovision)
+}
+
+func (f flowWorkFactory) NewUnbindJob(bindingID string, params *bundle.Parameters, si *bundle.ServiceInstance, skipExecution bool) Work {
+	return f.work(si, bindingID, bundle.JobMethodUnbind)
+}
+
+func (f flowWorkFactory) NewBindJob(bindingID string, bindingParams *bundle.Parameters, si *bundle.ServiceInstance) Work {
+	return f.work(si, bindingID, bundle.JobMethodBind)
+}
+
+func (f flowWorkFactory) NewUpdateJob(si *bundle.ServiceInstance) Work {
+	return f.work(si, "", bundle.JobMethodUpdate)
+}
+
+// waitFor - polls until check returns true, subscribers are notified
+// asynchronously even for synchronous jobs.
+func waitFor(t *testing.T, what string, check func() bool) {
+	for i := 0; i < 100; i++ {
+		if check() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestProvisionBindUnbindDeprovisionFlow(t *testing.T) {
+	rt := &runtime.MockRuntime{}
+	rt.On("GetExtractedCredential", tmock.Anything, tmock.Anything).Return(map[string]interface{}{"user": "admin"}, nil)
+	rt.On("CreateExtractedCredential", tmock.Anything, tmock.Anything, tmock.Anything, tmock.Anything).Return(nil)
+	rt.On("DeleteExtractedCredential", tmock.Anything, tmock.Anything).Return(nil)
+	runtime.Provider = rt
+
+	d, _ := memory.NewDao()
+	engine := NewWorkEngine(20, 2, d)
+	sub := NewJobStateSubscriber(d)
+	for topic := range workTopicSet {
+		engine.AttachSubscriber(sub, topic)
+	}
+	a, _ := NewAnsibleBroker(d, []registries.Registry{}, *engine, &config.Config{}, "new-space", flowWorkFactory{})
+
+	spec := &bundle.Spec{ID: "spec-id", FQName: "dh-test-apb", Plans: []bundle.Plan{{ID: "plan-id", Name: "default"}}}
+	if err := d.SetSpec(spec.ID, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	instanceID := uuid.NewRandom()
+	pr, err := a.Provision(instanceID, &ProvisionRequest{
+		ServiceID: spec.ID,
+		PlanID:    "plan-id",
+		Context:   bundle.Context{Namespace: "project"},
+	}, false, UserInfo{Username: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "provision to succeed", func() bool {
+		js, err := d.GetState(instanceID.String(), pr.Operation)
+		return err == nil && js.State == bundle.StateSucceeded
+	})
+
+	instance, err := a.GetServiceInstance(instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindingID := uuid.NewRandom()
+	if _, _, err := a.Bind(instance, bindingID, &BindRequest{ServiceID: spec.ID, PlanID: "plan-id"}, false, UserInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	instance, _ = a.GetServiceInstance(instanceID)
+	ft.AssertTrue(t, instance.BindingIDs[bindingID.String()], "binding not added to instance")
+
+	bindInstance, err := a.GetBindInstance(bindingID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.Unbind(instance, bindInstance, "plan-id", false, false, UserInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.GetBindInstance(bindingID)
+	ft.AssertEqual(t, ErrorNotFound, err)
+
+	instance, _ = a.GetServiceInstance(instanceID)
+	ft.AssertFalse(t, instance.BindingIDs[bindingID.String()], "binding not removed from instance")
+	if _, err := a.Deprovision(instance, "plan-id", false, false, UserInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "instance to be removed", func() bool {
+		_, err := a.GetServiceInstance(instanceID)
+		return err == ErrorNotFound
+	})
+}
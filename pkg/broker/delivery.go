@@ -0,0 +1,423 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/openshift/ansible-service-broker/pkg/metrics"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeliveryPolicy - how the work engine delivers the job messages to its
+// subscribers.
+type DeliveryPolicy struct {
+	// Timeout is how long a subscriber has to handle a message, 0 keeps the
+	// timeout the engine was created with.
+	Timeout time.Duration
+	// Timeouts overrides the timeout of the subscribers, by subscriber ID.
+	Timeouts map[string]time.Duration
+	// Retry is how a failed delivery is retried before the message is stored
+	// as a dead letter.
+	Retry RetryPolicy
+}
+
+// PolicySubscriber - a subscriber overriding the delivery policy of the work
+// engine for its messages.
+type PolicySubscriber interface {
+	DeliveryPolicy(defaults DeliveryPolicy) DeliveryPolicy
+}
+
+// QueuedSubscriber - a subscriber the engine hands the job messages to
+// through a bounded queue of its own, without waiting. A worker delivers the
+// queued messages one after the other, retries and dead letters included, so
+// that a slow subscriber never holds up the jobs and the other subscribers.
+type QueuedSubscriber interface {
+	// QueueSize - how many messages wait for the worker, the messages beyond
+	// it are stored as dead letters right away.
+	QueueSize() int
+}
+
+// StateSubscriber - a subscriber writing the job states. Its dead letters
+// record the job state the message was to replace, and are only replayed
+// while that job state is still the stored one, so that an old message never
+// overwrites a later outcome of the job.
+type StateSubscriber interface {
+	ReliableWorkSubscriber
+	// StoredState - the job state the message replaces.
+	StoredState(msg JobMsg) (types.PriorState, error)
+	// Redeliver - handles the message again, storing its job state only if
+	// the stored one is still at version, errStateMoved otherwise.
+	Redeliver(msg JobMsg, version string) error
+}
+
+// queuedMsg - a job message waiting for the worker of its subscriber.
+type queuedMsg struct {
+	token string
+	topic WorkTopic
+	msg   JobMsg
+}
+
+// errDeliveryTimeout - the subscriber did not handle the message in time.
+var errDeliveryTimeout = errors.New("timed out")
+
+// errQueueFull - the queue of the subscriber had no room for the message.
+var errQueueFull = errors.New("queue full")
+
+// errStateMoved - the job state was written past the message of the dead
+// letter.
+var errStateMoved = errors.New("job state moved on")
+
+// timeout - how long the subscriber has to handle a message.
+func (p DeliveryPolicy) timeout(subscriber string, defaultTimeout time.Duration) time.Duration {
+	if timeout, ok := p.Timeouts[subscriber]; ok && timeout > 0 {
+		return timeout
+	}
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return defaultTimeout
+}
+
+// SetDeliveryPolicy - sets how the job messages are delivered to the
+// subscribers.
+func (engine *WorkEngine) SetDeliveryPolicy(policy DeliveryPolicy) {
+	engine.queue.lock.Lock()
+	defer engine.queue.lock.Unlock()
+	engine.queue.delivery = policy
+}
+
+// deliveryPolicy - the delivery policy of the engine, overridden by the
+// subscriber.
+func (engine *WorkEngine) deliveryPolicy(sub WorkSubscriber) DeliveryPolicy {
+	engine.queue.lock.Lock()
+	policy := engine.queue.delivery
+	engine.queue.lock.Unlock()
+	if ps, ok := sub.(PolicySubscriber); ok {
+		return ps.DeliveryPolicy(policy)
+	}
+	return policy
+}
+
+// notify - hands the message to the subscriber. The channel returned receives
+// the outcome once the subscriber is done with the message.
+func notify(sub WorkSubscriber, msg JobMsg) <-chan error {
+	return run(func() error {
+		if rs, ok := sub.(ReliableWorkSubscriber); ok {
+			return rs.Deliver(msg)
+		}
+		sub.Notify(msg)
+		return nil
+	})
+}
+
+// run - runs handle in the background. The channel returned receives the
+// outcome once handle is done.
+func run(handle func() error) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- handle()
+	}()
+	return result
+}
+
+// awaitDelivery - the outcome of the delivery, or errDeliveryTimeout when the
+// subscriber is not done with the message within the timeout.
+func awaitDelivery(result <-chan error, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return errDeliveryTimeout
+	}
+}
+
+// deliver - hands the message of the job to the subscriber until it handles
+// it or the attempts of the delivery policy are used up, after which the
+// message is stored as a dead letter. An attempt never overlaps the previous
+// one: an attempt that timed out is given the backoff to finish instead, and
+// the message is stored as a dead letter right away if it does not.
+func (engine *WorkEngine) deliver(token string, topic WorkTopic, sub WorkSubscriber, msg JobMsg) {
+	policy := engine.deliveryPolicy(sub)
+	timeout := policy.timeout(sub.ID(), engine.subscriberTimeout*time.Second)
+	for attempt := 1; ; attempt++ {
+		result := notify(sub, msg)
+		err := awaitDelivery(result, timeout)
+		if err == nil {
+			engine.supersede(sub.ID(), token)
+			return
+		}
+		if attempt >= policy.Retry.MaxAttempts {
+			engine.supersedable(sub.ID(), token, engine.deadLetter(token, topic, sub, msg, attempt, err))
+			return
+		}
+		delay := policy.Retry.delay(attempt)
+		log.Warningf("Subscriber %s failed to handle a message of job %s, attempt %d of %d, retrying in %v - %v",
+			sub.ID(), token, attempt, policy.Retry.MaxAttempts, delay, err)
+		if err != errDeliveryTimeout {
+			time.Sleep(delay)
+			continue
+		}
+		select {
+		case err = <-result:
+			if err == nil {
+				log.Infof("Subscriber %s handled the message of job %s after timing out", sub.ID(), token)
+				engine.supersede(sub.ID(), token)
+				return
+			}
+		case <-time.After(delay):
+			err = fmt.Errorf("still handling the message %v after timing out", delay)
+			engine.supersedable(sub.ID(), token, engine.deadLetter(token, topic, sub, msg, attempt, err))
+			return
+		}
+	}
+}
+
+// startQueue - creates the queue of the subscriber and starts its worker,
+// once per subscriber however many topics it is attached to.
+func (engine *WorkEngine) startQueue(sub WorkSubscriber, size int) {
+	engine.queue.lock.Lock()
+	defer engine.queue.lock.Unlock()
+	if _, ok := engine.queue.subscriberQueues[sub.ID()]; ok {
+		return
+	}
+	if size < 1 {
+		size = 1
+	}
+	queue := make(chan queuedMsg, size)
+	engine.queue.subscriberQueues[sub.ID()] = queue
+	go func() {
+		for queued := range queue {
+			engine.deliver(queued.token, queued.topic, sub, queued.msg)
+		}
+	}()
+}
+
+// enqueue - hands the message to the worker of the subscriber without
+// waiting, the message is stored as a dead letter when the queue is full. The
+// messages ahead of it in the queue do not supersede that dead letter.
+func (engine *WorkEngine) enqueue(token string, topic WorkTopic, sub WorkSubscriber, msg JobMsg) {
+	engine.queue.lock.Lock()
+	queue := engine.queue.subscriberQueues[sub.ID()]
+	engine.queue.lock.Unlock()
+	select {
+	case queue <- queuedMsg{token: token, topic: topic, msg: msg}:
+	default:
+		engine.deadLetter(token, topic, sub, msg, 0, errQueueFull)
+	}
+}
+
+// deadLetter - stores the message the subscriber failed to handle so that it
+// can be replayed, and returns the id of the dead letter, empty when it could
+// not be stored.
+func (engine *WorkEngine) deadLetter(token string, topic WorkTopic, sub WorkSubscriber, msg JobMsg, attempts int, failure error) string {
+	log.Errorf("Subscriber %s failed to handle a message of job %s after %d attempts, storing it as a dead letter - %v",
+		sub.ID(), token, attempts, failure)
+	metrics.DeadLettered(sub.ID())
+	message, err := messageMap(msg)
+	if err != nil {
+		log.Errorf("Unable to store the dead letter of job %s for subscriber %s - %v", token, sub.ID(), err)
+		return ""
+	}
+	letter := types.DeadLetter{
+		ID:         uuid.New(),
+		Subscriber: sub.ID(),
+		Topic:      string(topic),
+		Token:      token,
+		Message:    message,
+		Error:      failure.Error(),
+		Attempts:   attempts,
+		Created:    time.Now(),
+	}
+	if ss, ok := sub.(StateSubscriber); ok {
+		prior, err := ss.StoredState(msg)
+		if err != nil {
+			log.Warningf("Unable to read the job state %s replaced by the dead letter, it is replayed unchecked - %v", token, err)
+		} else {
+			letter.Prior = &prior
+		}
+	}
+	if err := engine.dao.SetDeadLetter(letter); err != nil {
+		log.Errorf("Unable to store the dead letter of job %s for subscriber %s - %v", token, sub.ID(), err)
+		return ""
+	}
+	return letter.ID
+}
+
+// supersedable - keeps the dead letter of the job for the subscriber until
+// the subscriber handles a later message of the job.
+func (engine *WorkEngine) supersedable(subscriber string, token string, id string) {
+	if id == "" {
+		return
+	}
+	key := deadLetterKey(subscriber, token)
+	engine.queue.lock.Lock()
+	engine.queue.deadLetters[key] = append(engine.queue.deadLetters[key], id)
+	engine.queue.lock.Unlock()
+}
+
+// supersede - drops the dead letters of the job for the subscriber, which
+// has just handled a later message of the job.
+func (engine *WorkEngine) supersede(subscriber string, token string) {
+	key := deadLetterKey(subscriber, token)
+	engine.queue.lock.Lock()
+	ids := engine.queue.deadLetters[key]
+	delete(engine.queue.deadLetters, key)
+	engine.queue.lock.Unlock()
+	for _, id := range ids {
+		log.Infof("Dropping dead letter %s of job %s, subscriber %s handled a later message", id, token, subscriber)
+		if err := engine.dao.DeleteDeadLetter(id); err != nil {
+			log.Errorf("Unable to delete dead letter %s - %v", id, err)
+		}
+	}
+}
+
+// ReplayDeadLetters - hands the dead letters, oldest first, to the subscribers
+// they are for, once each. The dead letters handled are deleted, the others
+// are kept with their attempts counted, as are the later dead letters of the
+// same job and subscriber so that they are handled in order. The dead letters
+// of a job whose state moved on past their message are marked superseded and
+// kept, they are never replayed.
+func (engine *WorkEngine) ReplayDeadLetters() (*ReplayResponse, error) {
+	letters, err := engine.dao.BatchGetDeadLetters()
+	if err != nil {
+		return nil, err
+	}
+	resp := &ReplayResponse{}
+	kept := map[string]bool{}
+	replayed := map[string]bool{}
+	for _, letter := range letters {
+		if letter.Superseded {
+			resp.Superseded++
+			continue
+		}
+		key := deadLetterKey(letter.Subscriber, letter.Token)
+		sub := engine.subscriber(WorkTopic(letter.Topic), letter.Subscriber)
+		if sub == nil {
+			log.Warningf("No subscriber %s on topic %s, keeping dead letter %s", letter.Subscriber, letter.Topic, letter.ID)
+		}
+		if sub == nil || kept[key] {
+			kept[key] = true
+			resp.Left++
+			continue
+		}
+		msg := JobMsg{}
+		if err := letterMessage(letter, &msg); err != nil {
+			return resp, err
+		}
+		timeout := engine.deliveryPolicy(sub).timeout(sub.ID(), engine.subscriberTimeout*time.Second)
+		err := awaitDelivery(replay(sub, letter, msg, replayed[key]), timeout)
+		if err == errStateMoved {
+			log.Infof("Dead letter %s of job %s is superseded, the job state moved on past it", letter.ID, letter.Token)
+			letter.Superseded = true
+			if err := engine.dao.SetDeadLetter(letter); err != nil {
+				return resp, err
+			}
+			resp.Superseded++
+			continue
+		}
+		if err != nil {
+			log.Warningf("Subscriber %s failed to handle dead letter %s - %v", letter.Subscriber, letter.ID, err)
+			letter.Attempts++
+			letter.Error = err.Error()
+			if err := engine.dao.SetDeadLetter(letter); err != nil {
+				return resp, err
+			}
+			kept[key] = true
+			resp.Left++
+			continue
+		}
+		if err := engine.dao.DeleteDeadLetter(letter.ID); err != nil {
+			return resp, err
+		}
+		resp.Replayed++
+		replayed[key] = true
+	}
+	log.Infof("Replayed [ %d ] dead letters, [ %d ] left, [ %d ] superseded", resp.Replayed, resp.Left, resp.Superseded)
+	return resp, nil
+}
+
+// replay - hands the dead letter to the subscriber once more. The message of
+// a subscriber writing the job states is handed over only while the job state
+// is the one it was to replace, or the one written by the earlier dead letter
+// of the job just replayed, and written as long as it stays so.
+func replay(sub WorkSubscriber, letter types.DeadLetter, msg JobMsg, chained bool) <-chan error {
+	ss, ok := sub.(StateSubscriber)
+	if !ok || letter.Prior == nil {
+		return notify(sub, msg)
+	}
+	return run(func() error {
+		current, err := ss.StoredState(msg)
+		if err != nil {
+			return err
+		}
+		if !chained && !unchanged(*letter.Prior, current) {
+			return errStateMoved
+		}
+		return ss.Redeliver(msg, current.Version)
+	})
+}
+
+// unchanged - whether the job state is still the prior one. The states are
+// compared when the versions differ, since the version may be the one of the
+// binding or instance holding the job state, written for other reasons.
+func unchanged(prior types.PriorState, current types.PriorState) bool {
+	if prior.Found != current.Found {
+		return false
+	}
+	return !prior.Found || prior.Version == current.Version || prior.State == current.State
+}
+
+// subscriber - the subscriber of the topic with the ID.
+func (engine *WorkEngine) subscriber(topic WorkTopic, id string) WorkSubscriber {
+	for _, sub := range engine.subscribers[topic] {
+		if sub.ID() == id {
+			return sub
+		}
+	}
+	return nil
+}
+
+func deadLetterKey(subscriber string, token string) string {
+	return fmt.Sprintf("%s/%s", subscriber, token)
+}
+
+// messageMap - the job message as the map stored in a dead letter.
+func messageMap(msg JobMsg) (map[string]interface{}, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	message := map[string]interface{}{}
+	return message, json.Unmarshal(payload, &message)
+}
+
+// letterMessage - the job message of the dead letter.
+func letterMessage(letter types.DeadLetter, msg *JobMsg) error {
+	payload, err := json.Marshal(letter.Message)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, msg)
+}
@@ -0,0 +1,312 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+type reliableSubscriber struct {
+	lock      sync.Mutex
+	failures  int
+	delivered []JobMsg
+}
+
+func (rs *reliableSubscriber) ID() string {
+	return "reliable"
+}
+
+func (rs *reliableSubscriber) Notify(msg JobMsg) {
+	rs.Deliver(msg)
+}
+
+// Deliver fails while there are failures left.
+func (rs *reliableSubscriber) Deliver(msg JobMsg) error {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if rs.failures > 0 {
+		rs.failures--
+		return errors.New("dao down")
+	}
+	rs.delivered = append(rs.delivered, msg)
+	return nil
+}
+
+func (rs *reliableSubscriber) fail(failures int) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.failures = failures
+}
+
+func newDeliveryEngine(t *testing.T, sub WorkSubscriber) (*WorkEngine, *memory.Dao) {
+	d, err := memory.NewDao()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewWorkEngine(10, 1, d)
+	engine.SetDeliveryPolicy(DeliveryPolicy{Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}})
+	engine.AttachSubscriber(sub, ProvisionTopic)
+	return engine, d
+}
+
+func sendMessages(msgs ...JobMsg) Work {
+	return &mockWork{funcToCall: func(msg chan<- JobMsg) {
+		for _, m := range msgs {
+			msg <- m
+		}
+	}}
+}
+
+func TestDeliveryPolicyTimeout(t *testing.T) {
+	policy := DeliveryPolicy{Timeouts: map[string]time.Duration{"slow": time.Minute}}
+	ft.AssertEqual(t, time.Second, policy.timeout("jobstate", time.Second))
+	ft.AssertEqual(t, time.Minute, policy.timeout("slow", time.Second))
+	policy.Timeout = 5 * time.Second
+	ft.AssertEqual(t, 5*time.Second, policy.timeout("jobstate", time.Second))
+}
+
+func TestDeliverRetries(t *testing.T) {
+	sub := &reliableSubscriber{failures: 2}
+	engine, d := newDeliveryEngine(t, sub)
+
+	ft.AssertNil(t, engine.StartNewSyncJob("token", sendMessages(JobMsg{Msg: "done"}), ProvisionTopic))
+	ft.AssertEqual(t, 1, len(sub.delivered))
+	letters, _ := d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 0, len(letters))
+}
+
+func TestDeliverDeadLetter(t *testing.T) {
+	sub := &reliableSubscriber{failures: 3}
+	engine, d := newDeliveryEngine(t, sub)
+
+	msg := JobMsg{Msg: "in progress", ExtractedCredentials: bundle.ExtractedCredentials{Credentials: map[string]interface{}{"user": "admin"}}}
+	ft.AssertNil(t, engine.StartNewSyncJob("token", sendMessages(msg), ProvisionTopic))
+	ft.AssertEqual(t, 0, len(sub.delivered))
+	letters, _ := d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 1, len(letters))
+	ft.AssertEqual(t, "reliable", letters[0].Subscriber)
+	ft.AssertEqual(t, "token", letters[0].Token)
+	ft.AssertEqual(t, 3, letters[0].Attempts)
+	ft.AssertEqual(t, "dao down", letters[0].Error)
+
+	// the dead letter is replayed into the subscriber with the message intact
+	resp, err := engine.ReplayDeadLetters()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 1, resp.Replayed)
+	ft.AssertEqual(t, 0, resp.Left)
+	ft.AssertEqual(t, "in progress", sub.delivered[0].Msg)
+	ft.AssertEqual(t, "admin", sub.delivered[0].ExtractedCredentials.Credentials["user"])
+	letters, _ = d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 0, len(letters))
+}
+
+func TestDeliverSupersedesDeadLetter(t *testing.T) {
+	sub := &reliableSubscriber{failures: 3}
+	engine, d := newDeliveryEngine(t, sub)
+
+	// the messages are delivered one after the other, so the first one uses
+	// up the failures and is dead lettered before the second one is handled.
+	ft.AssertNil(t, engine.StartNewSyncJob("token", sendMessages(JobMsg{Msg: "in progress"}, JobMsg{Msg: "succeeded"}), ProvisionTopic))
+	ft.AssertEqual(t, 1, len(sub.delivered))
+	ft.AssertEqual(t, "succeeded", sub.delivered[0].Msg)
+	letters, _ := d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 0, len(letters))
+}
+
+func TestReplayDeadLettersInOrder(t *testing.T) {
+	sub := &reliableSubscriber{failures: 6}
+	engine, d := newDeliveryEngine(t, sub)
+	engine.SetDeliveryPolicy(DeliveryPolicy{})
+
+	ft.AssertNil(t, engine.StartNewSyncJob("token", sendMessages(JobMsg{Msg: "first"}, JobMsg{Msg: "second"}), ProvisionTopic))
+	letters, _ := d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 2, len(letters))
+
+	// the first dead letter fails again, the second one is kept behind it
+	sub.fail(1)
+	resp, err := engine.ReplayDeadLetters()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 0, resp.Replayed)
+	ft.AssertEqual(t, 2, resp.Left)
+	letters, _ = d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 2, letters[0].Attempts)
+	ft.AssertEqual(t, 1, letters[1].Attempts)
+
+	resp, err = engine.ReplayDeadLetters()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 2, resp.Replayed)
+	ft.AssertEqual(t, "first", sub.delivered[0].Msg)
+	ft.AssertEqual(t, "second", sub.delivered[1].Msg)
+}
+
+// queuedSubscriber - a reliable subscriber handling its messages once
+// released.
+type queuedSubscriber struct {
+	reliableSubscriber
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (qs *queuedSubscriber) ID() string {
+	return "queued"
+}
+
+func (qs *queuedSubscriber) Deliver(msg JobMsg) error {
+	qs.entered <- struct{}{}
+	<-qs.release
+	return qs.reliableSubscriber.Deliver(msg)
+}
+
+func (qs *queuedSubscriber) QueueSize() int {
+	return 1
+}
+
+func TestDeliverQueued(t *testing.T) {
+	sub := &queuedSubscriber{entered: make(chan struct{}, 3), release: make(chan struct{})}
+	engine, d := newDeliveryEngine(t, sub)
+
+	// the job is not held up by the subscriber, the message beyond the queue
+	// is stored as a dead letter right away
+	work := &mockWork{funcToCall: func(msg chan<- JobMsg) {
+		msg <- JobMsg{Msg: "started"}
+		<-sub.entered
+		msg <- JobMsg{Msg: "in progress"}
+		msg <- JobMsg{Msg: "succeeded"}
+	}}
+	ft.AssertNil(t, engine.StartNewSyncJob("token", work, ProvisionTopic))
+	letters, _ := d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 1, len(letters))
+	ft.AssertEqual(t, "queued", letters[0].Subscriber)
+	ft.AssertEqual(t, "queue full", letters[0].Error)
+
+	close(sub.release)
+	for i := 0; i < 100; i++ {
+		sub.lock.Lock()
+		delivered := len(sub.delivered)
+		sub.lock.Unlock()
+		if delivered == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	ft.AssertEqual(t, 2, len(sub.delivered))
+	ft.AssertEqual(t, "started", sub.delivered[0].Msg)
+	// the messages queued ahead of the dead letter do not supersede it
+	letters, _ = d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 1, len(letters))
+}
+
+// flakyStateDAO - a dao failing to set the job states while there are
+// failures left.
+type flakyStateDAO struct {
+	*memory.Dao
+	failures int
+}
+
+func (fd *flakyStateDAO) SetState(id string, state bundle.JobState) (string, error) {
+	if fd.failures > 0 {
+		fd.failures--
+		return "", errors.New("dao down")
+	}
+	return fd.Dao.SetState(id, state)
+}
+
+func provisionMsg(state bundle.State) JobMsg {
+	return JobMsg{
+		InstanceUUID: "instance",
+		State:        bundle.JobState{Token: "token", State: state, Method: bundle.JobMethodProvision},
+	}
+}
+
+func TestReplaySupersededDeadLetter(t *testing.T) {
+	d, err := memory.NewDao()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewWorkEngine(10, 1, d)
+	engine.SetDeliveryPolicy(DeliveryPolicy{Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}})
+	engine.AttachSubscriber(NewJobStateSubscriber(&flakyStateDAO{Dao: d, failures: 3}), ProvisionTopic)
+
+	ft.AssertNil(t, engine.StartNewSyncJob("token", sendMessages(provisionMsg(bundle.StateInProgress)), ProvisionTopic))
+	letters, _ := d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 1, len(letters))
+	ft.AssertFalse(t, letters[0].Prior.Found)
+
+	// the job state is written past the dead letter, by another broker for
+	// instance, which the replay must not overwrite
+	_, err = d.SetState("instance", provisionMsg(bundle.StateSucceeded).State)
+	ft.AssertNil(t, err)
+	for i := 0; i < 2; i++ {
+		resp, err := engine.ReplayDeadLetters()
+		ft.AssertNil(t, err)
+		ft.AssertEqual(t, 0, resp.Replayed)
+		ft.AssertEqual(t, 0, resp.Left)
+		ft.AssertEqual(t, 1, resp.Superseded)
+	}
+	state, err := d.GetState("instance", "token")
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, bundle.StateSucceeded, state.State)
+	letters, _ = d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 1, len(letters))
+	ft.AssertTrue(t, letters[0].Superseded)
+}
+
+func TestReplayStateDeadLettersInOrder(t *testing.T) {
+	d, err := memory.NewDao()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := NewWorkEngine(10, 1, d)
+	engine.SetDeliveryPolicy(DeliveryPolicy{Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}})
+	engine.AttachSubscriber(NewJobStateSubscriber(&flakyStateDAO{Dao: d, failures: 6}), ProvisionTopic)
+
+	msgs := sendMessages(provisionMsg(bundle.StateInProgress), provisionMsg(bundle.StateSucceeded))
+	ft.AssertNil(t, engine.StartNewSyncJob("token", msgs, ProvisionTopic))
+	letters, _ := d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 2, len(letters))
+
+	// the second dead letter follows the job state the first one wrote
+	resp, err := engine.ReplayDeadLetters()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 2, resp.Replayed)
+	ft.AssertEqual(t, 0, resp.Superseded)
+	state, err := d.GetState("instance", "token")
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, bundle.StateSucceeded, state.State)
+}
+
+func TestUnchanged(t *testing.T) {
+	running := bundle.JobState{Token: "token", State: bundle.StateInProgress}
+	prior := types.PriorState{Found: true, State: running, Version: "1"}
+	ft.AssertTrue(t, unchanged(prior, prior))
+	// the owner of the job state was written for other reasons
+	ft.AssertTrue(t, unchanged(prior, types.PriorState{Found: true, State: running, Version: "2"}))
+	done := bundle.JobState{Token: "token", State: bundle.StateSucceeded}
+	ft.AssertFalse(t, unchanged(prior, types.PriorState{Found: true, State: done, Version: "2"}))
+	ft.AssertFalse(t, unchanged(types.PriorState{}, prior))
+	ft.AssertTrue(t, unchanged(types.PriorState{}, types.PriorState{}))
+}
@@ -0,0 +1,439 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// eventSource - the component the events are reported by.
+	eventSource = "ansible-service-broker"
+	// eventDedupWindow - how long an event recorded again is updated rather
+	// than created anew.
+	eventDedupWindow = 10 * time.Minute
+	// defaultEventBurst - how many progress events an instance gets in a row
+	// when the config does not say.
+	defaultEventBurst = 5
+	// defaultEventInterval - how often an instance earns another progress
+	// event when the config does not say.
+	defaultEventInterval = 10 * time.Second
+	// eventStateTTL - how long the event state of an instance, or the start
+	// of a job, is kept without a message, for the jobs whose outcome never
+	// came.
+	eventStateTTL = time.Hour
+	// eventResolveRetry - how long the events of an instance whose
+	// ServiceInstance was not found name its external ID before it is looked
+	// up again.
+	eventResolveRetry = time.Minute
+	// catalogAPIVersion - the API version of the service catalog resources.
+	catalogAPIVersion = "servicecatalog.k8s.io/v1beta1"
+)
+
+// Reasons of the events recorded for the jobs.
+const (
+	EventReasonJobStarted   = "JobStarted"
+	EventReasonJobProgress  = "JobProgress"
+	EventReasonJobSucceeded = "JobSucceeded"
+	EventReasonJobFailed    = "JobFailed"
+	EventReasonJobCancelled = "JobCancelled"
+)
+
+// EventConfig - how many progress events are recorded per instance. The
+// start and the outcome of the jobs are always recorded.
+type EventConfig struct {
+	// Burst is how many progress events of an instance are recorded in a row.
+	Burst int
+	// Interval is how often an instance earns another progress event once
+	// its burst is used up.
+	Interval time.Duration
+}
+
+// EventWriter - records the kubernetes events.
+type EventWriter interface {
+	Create(namespace string, event *v1.Event) (*v1.Event, error)
+	Update(namespace string, event *v1.Event) (*v1.Event, error)
+}
+
+type kubernetesEventWriter struct {
+	client corev1.EventsGetter
+}
+
+// NewKubernetesEventWriter - an event writer recording the events through
+// the kubernetes client.
+func NewKubernetesEventWriter(client corev1.EventsGetter) EventWriter {
+	return kubernetesEventWriter{client: client}
+}
+
+func (k kubernetesEventWriter) Create(namespace string, event *v1.Event) (*v1.Event, error) {
+	return k.client.Events(namespace).Create(event)
+}
+
+func (k kubernetesEventWriter) Update(namespace string, event *v1.Event) (*v1.Event, error) {
+	return k.client.Events(namespace).Update(event)
+}
+
+// InstanceResolver - finds the ServiceInstance of the platform the broker
+// knows by its external ID.
+type InstanceResolver interface {
+	// Resolve - the ServiceInstance of the namespace with the external ID,
+	// nil when there is none.
+	Resolve(namespace string, externalID string) (*v1.ObjectReference, error)
+}
+
+type catalogInstanceResolver struct {
+	client rest.Interface
+}
+
+// NewCatalogInstanceResolver - an instance resolver listing the
+// ServiceInstances of the service catalog through the REST client, which is
+// rooted at the API server like the discovery client.
+func NewCatalogInstanceResolver(client rest.Interface) InstanceResolver {
+	return catalogInstanceResolver{client: client}
+}
+
+func (c catalogInstanceResolver) Resolve(namespace string, externalID string) (*v1.ObjectReference, error) {
+	body, err := c.client.Get().AbsPath("/apis", catalogAPIVersion, "namespaces", namespace, "serviceinstances").Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+	return findServiceInstance(body, externalID)
+}
+
+// findServiceInstance - the ServiceInstance of the list with the external ID,
+// nil when there is none.
+func findServiceInstance(list []byte, externalID string) (*v1.ObjectReference, error) {
+	instances := struct {
+		Items []struct {
+			metav1.ObjectMeta `json:"metadata"`
+			Spec              struct {
+				ExternalID string `json:"externalID"`
+			} `json:"spec"`
+		} `json:"items"`
+	}{}
+	if err := json.Unmarshal(list, &instances); err != nil {
+		return nil, err
+	}
+	for _, item := range instances.Items {
+		if item.Spec.ExternalID == externalID {
+			return &v1.ObjectReference{
+				APIVersion: catalogAPIVersion,
+				Kind:       "ServiceInstance",
+				Namespace:  item.Namespace,
+				Name:       item.Name,
+				UID:        item.UID,
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// eventBucket - the progress events an instance has left, refilled one per
+// interval up to the burst.
+type eventBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// instanceEvents - the event state of an instance. The lock serializes the
+// messages of the instance so that the events of its jobs keep their order,
+// without holding up the messages of the other instances.
+type instanceEvents struct {
+	lock sync.Mutex
+	// namespace keeps the namespace of the instance, for the messages sent
+	// once a deprovision has deleted the instance.
+	namespace string
+	// started holds when the jobs whose start was recorded started, by token.
+	started map[string]time.Time
+	bucket  *eventBucket
+	// recorded holds the events recorded lately, by their dedup key.
+	recorded map[string]*v1.Event
+	// object is the ServiceInstance the events involve once resolved, and
+	// resolveAfter when it is looked up again after it was not found.
+	object       *v1.ObjectReference
+	resolveAfter time.Time
+
+	// users and seen are guarded by the lock of the subscriber.
+	users int
+	seen  time.Time
+	gone  bool
+}
+
+// EventSubscriber - records kubernetes events in the namespace of the
+// service instance as its jobs start, progress and finish. The same event
+// recorded again within a while bumps the count of the existing one.
+//
+// The broker only knows the instance by the ID the platform provisioned it
+// with, the external ID of the ServiceInstance, so the ServiceInstance the
+// events involve is looked up by its external ID through the resolver. The
+// events name the external ID instead while it cannot be found.
+type EventSubscriber struct {
+	config   EventConfig
+	dao      SubscriberDAO
+	writer   EventWriter
+	resolver InstanceResolver
+	now      func() time.Time
+
+	// lock guards instances, the kubernetes calls are made under the lock of
+	// the instance only.
+	lock      sync.Mutex
+	instances map[string]*instanceEvents
+}
+
+// NewEventSubscriber - creates a new event subscriber, the events name the
+// external ID of the instances when resolver is nil.
+func NewEventSubscriber(dao SubscriberDAO, writer EventWriter, resolver InstanceResolver, config EventConfig) *EventSubscriber {
+	if config.Burst <= 0 {
+		config.Burst = defaultEventBurst
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultEventInterval
+	}
+	return &EventSubscriber{
+		config:    config,
+		dao:       dao,
+		writer:    writer,
+		resolver:  resolver,
+		now:       time.Now,
+		instances: map[string]*instanceEvents{},
+	}
+}
+
+// ID is used as an identifier for the type of subscriber
+func (es *EventSubscriber) ID() string {
+	return "events"
+}
+
+// Notify records the events of the message. The events are best effort, the
+// failures to record them are logged and the message is not delivered again.
+func (es *EventSubscriber) Notify(msg JobMsg) {
+	inst := es.acquire(msg.InstanceUUID)
+	finished := false
+	switch msg.State.State {
+	case bundle.StateSucceeded, bundle.StateFailed, StateCancelled:
+		finished = true
+	}
+	defer func() {
+		es.release(msg.InstanceUUID, inst, finished && msg.State.Method == bundle.JobMethodDeprovision)
+	}()
+
+	inst.lock.Lock()
+	defer inst.lock.Unlock()
+	now := es.now()
+	for token, started := range inst.started {
+		if now.Sub(started) > eventStateTTL {
+			delete(inst.started, token)
+		}
+	}
+	if finished {
+		defer delete(inst.started, msg.JobToken)
+	}
+
+	namespace := es.namespace(inst, msg)
+	if namespace == "" {
+		log.Debugf("No namespace to record the events of job %s of instance %s in", msg.JobToken, msg.InstanceUUID)
+		return
+	}
+
+	if _, ok := inst.started[msg.JobToken]; !ok {
+		inst.started[msg.JobToken] = now
+		es.record(inst, namespace, msg, v1.EventTypeNormal, EventReasonJobStarted,
+			fmt.Sprintf("%s job %s started", msg.State.Method, msg.JobToken))
+	}
+	switch msg.State.State {
+	case bundle.StateSucceeded:
+		es.record(inst, namespace, msg, v1.EventTypeNormal, EventReasonJobSucceeded, eventMessage(msg, "succeeded"))
+	case bundle.StateFailed:
+		es.record(inst, namespace, msg, v1.EventTypeWarning, EventReasonJobFailed, eventMessage(msg, "failed"))
+	case StateCancelled:
+		es.record(inst, namespace, msg, v1.EventTypeWarning, EventReasonJobCancelled, eventMessage(msg, "cancelled"))
+	default:
+		if msg.State.Description == "" {
+			return
+		}
+		if !es.allow(inst) {
+			log.Debugf("Dropping the progress event of job %s, instance %s is over its rate", msg.JobToken, msg.InstanceUUID)
+			return
+		}
+		es.record(inst, namespace, msg, v1.EventTypeNormal, EventReasonJobProgress, eventMessage(msg, "in progress"))
+	}
+}
+
+// acquire - the event state of the instance, created when missing. The state
+// of the instances not seen for eventStateTTL is dropped.
+func (es *EventSubscriber) acquire(instanceID string) *instanceEvents {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	now := es.now()
+	for id, inst := range es.instances {
+		if inst.users == 0 && now.Sub(inst.seen) > eventStateTTL {
+			delete(es.instances, id)
+		}
+	}
+	inst, ok := es.instances[instanceID]
+	if !ok {
+		inst = &instanceEvents{started: map[string]time.Time{}, recorded: map[string]*v1.Event{}}
+		es.instances[instanceID] = inst
+	}
+	inst.users++
+	inst.seen = now
+	return inst
+}
+
+// release - gives the event state of the instance back, dropping it once the
+// instance is deprovisioned and no other message uses it.
+func (es *EventSubscriber) release(instanceID string, inst *instanceEvents, gone bool) {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	inst.users--
+	inst.gone = inst.gone || gone
+	if inst.gone && inst.users == 0 && es.instances[instanceID] == inst {
+		delete(es.instances, instanceID)
+	}
+}
+
+// namespace - the namespace of the instance of the message, empty when it
+// cannot be found.
+func (es *EventSubscriber) namespace(inst *instanceEvents, msg JobMsg) string {
+	if inst.namespace != "" {
+		return inst.namespace
+	}
+	instance, err := es.dao.GetServiceInstance(msg.InstanceUUID)
+	if err != nil {
+		log.Debugf("Unable to find instance %s for its events - %v", msg.InstanceUUID, err)
+		return ""
+	}
+	if instance.Context == nil {
+		return ""
+	}
+	inst.namespace = instance.Context.Namespace
+	return inst.namespace
+}
+
+// object - the ServiceInstance the events of the instance involve, named by
+// the external ID of the instance until the resolver finds it.
+func (es *EventSubscriber) object(inst *instanceEvents, namespace string, msg JobMsg) v1.ObjectReference {
+	if inst.object != nil {
+		return *inst.object
+	}
+	object := v1.ObjectReference{
+		APIVersion: catalogAPIVersion,
+		Kind:       "ServiceInstance",
+		Namespace:  namespace,
+		Name:       msg.InstanceUUID,
+	}
+	if es.resolver == nil || es.now().Before(inst.resolveAfter) {
+		return object
+	}
+	found, err := es.resolver.Resolve(namespace, msg.InstanceUUID)
+	if err != nil || found == nil {
+		log.Debugf("Unable to find the ServiceInstance of instance %s in namespace %s, naming its external ID - %v",
+			msg.InstanceUUID, namespace, err)
+		inst.resolveAfter = es.now().Add(eventResolveRetry)
+		return object
+	}
+	inst.object = found
+	return *found
+}
+
+// allow - whether the instance has a progress event left, taking it.
+func (es *EventSubscriber) allow(inst *instanceEvents) bool {
+	now := es.now()
+	bucket := inst.bucket
+	if bucket == nil {
+		bucket = &eventBucket{tokens: float64(es.config.Burst), last: now}
+		inst.bucket = bucket
+	}
+	bucket.tokens += float64(now.Sub(bucket.last)) / float64(es.config.Interval)
+	if bucket.tokens > float64(es.config.Burst) {
+		bucket.tokens = float64(es.config.Burst)
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// record - creates the event, or bumps the count of the same event recorded
+// within the dedup window.
+func (es *EventSubscriber) record(inst *instanceEvents, namespace string, msg JobMsg, eventType, reason, message string) {
+	now := es.now()
+	for key, event := range inst.recorded {
+		if now.Sub(event.LastTimestamp.Time) > eventDedupWindow {
+			delete(inst.recorded, key)
+		}
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s", namespace, eventType, reason, message)
+	if event, ok := inst.recorded[key]; ok {
+		updated := event.DeepCopy()
+		updated.Count++
+		updated.LastTimestamp = metav1.NewTime(now)
+		if stored, err := es.writer.Update(namespace, updated); err == nil {
+			inst.recorded[key] = stored
+			return
+		}
+		// the event may have expired, record it anew.
+		delete(inst.recorded, key)
+	}
+
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", msg.InstanceUUID, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: es.object(inst, namespace, msg),
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: eventSource},
+		FirstTimestamp: metav1.NewTime(now),
+		LastTimestamp:  metav1.NewTime(now),
+		Count:          1,
+	}
+	stored, err := es.writer.Create(namespace, event)
+	if err != nil {
+		log.Errorf("Unable to record the %s event of job %s in namespace %s - %v", reason, msg.JobToken, namespace, err)
+		return
+	}
+	inst.recorded[key] = stored
+}
+
+// eventMessage - the message of the event, with the description of the job
+// and its pod.
+func eventMessage(msg JobMsg, state string) string {
+	message := fmt.Sprintf("%s job %s %s", msg.State.Method, msg.JobToken, state)
+	if msg.State.Description != "" {
+		message = fmt.Sprintf("%s: %s", message, msg.State.Description)
+	}
+	if msg.PodName != "" {
+		message = fmt.Sprintf("%s (pod %s)", message, msg.PodName)
+	}
+	return message
+}
@@ -0,0 +1,253 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+	v1 "k8s.io/api/core/v1"
+)
+
+type eventWriter struct {
+	created []*v1.Event
+	updated []*v1.Event
+	err     error
+}
+
+func (w *eventWriter) Create(namespace string, event *v1.Event) (*v1.Event, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	w.created = append(w.created, event)
+	return event, nil
+}
+
+func (w *eventWriter) Update(namespace string, event *v1.Event) (*v1.Event, error) {
+	w.updated = append(w.updated, event)
+	return event, nil
+}
+
+func newEventSubscriber(t *testing.T, config EventConfig) (*EventSubscriber, *eventWriter, string, *time.Time) {
+	d, err := memory.NewDao()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.New()
+	instance := &bundle.ServiceInstance{ID: uuid.Parse(id), Context: &bundle.Context{Platform: "kubernetes", Namespace: "project"}}
+	if err := d.SetServiceInstance(id, instance); err != nil {
+		t.Fatal(err)
+	}
+	writer := &eventWriter{}
+	sub := NewEventSubscriber(d, writer, nil, config)
+	now := time.Now()
+	sub.now = func() time.Time { return now }
+	return sub, writer, id, &now
+}
+
+func eventMsg(id string, method bundle.JobMethod, state bundle.State, description string) JobMsg {
+	return JobMsg{InstanceUUID: id, JobToken: "token", PodName: "apb-pod",
+		State: bundle.JobState{Token: "token", State: state, Method: method, Description: description}}
+}
+
+func TestEventSubscriber(t *testing.T) {
+	sub, writer, id, _ := newEventSubscriber(t, EventConfig{})
+
+	sub.Notify(eventMsg(id, bundle.JobMethodProvision, bundle.StateInProgress, "creating the database"))
+	sub.Notify(eventMsg(id, bundle.JobMethodProvision, bundle.StateInProgress, "creating the database"))
+	writer.err = errors.New("forbidden")
+	sub.Notify(eventMsg(id, bundle.JobMethodProvision, bundle.StateInProgress, "creating the user"))
+	writer.err = nil
+	sub.Notify(eventMsg(id, bundle.JobMethodProvision, bundle.StateSucceeded, "provision job completed"))
+
+	ft.AssertEqual(t, 3, len(writer.created))
+	started := writer.created[0]
+	ft.AssertEqual(t, EventReasonJobStarted, started.Reason)
+	ft.AssertEqual(t, "project", started.Namespace)
+	ft.AssertEqual(t, "ServiceInstance", started.InvolvedObject.Kind)
+	ft.AssertEqual(t, id, started.InvolvedObject.Name)
+	progress := writer.created[1]
+	ft.AssertEqual(t, EventReasonJobProgress, progress.Reason)
+	ft.AssertEqual(t, "provision job token in progress: creating the database (pod apb-pod)", progress.Message)
+	ft.AssertEqual(t, v1.EventTypeNormal, progress.Type)
+	ft.AssertEqual(t, EventReasonJobSucceeded, writer.created[2].Reason)
+
+	// the repeated progress bumps the count of the event.
+	ft.AssertEqual(t, 1, len(writer.updated))
+	ft.AssertEqual(t, progress.Name, writer.updated[0].Name)
+	ft.AssertEqual(t, int32(2), writer.updated[0].Count)
+}
+
+func TestEventSubscriberRateLimit(t *testing.T) {
+	sub, writer, id, now := newEventSubscriber(t, EventConfig{Burst: 2, Interval: time.Minute})
+
+	for _, description := range []string{"step 1", "step 2", "step 3"} {
+		sub.Notify(eventMsg(id, bundle.JobMethodUpdate, bundle.StateInProgress, description))
+	}
+	// started and two progress events, the third is over the rate.
+	ft.AssertEqual(t, 3, len(writer.created))
+
+	*now = now.Add(time.Minute)
+	sub.Notify(eventMsg(id, bundle.JobMethodUpdate, bundle.StateInProgress, "step 4"))
+	sub.Notify(eventMsg(id, bundle.JobMethodUpdate, bundle.StateInProgress, "step 5"))
+	ft.AssertEqual(t, 4, len(writer.created))
+
+	// the outcome is recorded regardless of the rate.
+	sub.Notify(eventMsg(id, bundle.JobMethodUpdate, bundle.StateFailed, "update failed"))
+	ft.AssertEqual(t, 5, len(writer.created))
+	ft.AssertEqual(t, EventReasonJobFailed, writer.created[4].Reason)
+	ft.AssertEqual(t, v1.EventTypeWarning, writer.created[4].Type)
+}
+
+func TestEventSubscriberDeprovision(t *testing.T) {
+	sub, writer, id, _ := newEventSubscriber(t, EventConfig{})
+
+	sub.Notify(eventMsg(id, bundle.JobMethodDeprovision, bundle.StateInProgress, "removing the database"))
+	// the instance is gone once the deprovision has succeeded.
+	ft.AssertNil(t, sub.dao.DeleteServiceInstance(id))
+	sub.Notify(eventMsg(id, bundle.JobMethodDeprovision, bundle.StateSucceeded, ""))
+	ft.AssertEqual(t, 3, len(writer.created))
+	ft.AssertEqual(t, "project", writer.created[2].Namespace)
+	ft.AssertEqual(t, 0, len(sub.instances))
+
+	// without the instance there is no namespace to record the events in.
+	sub.Notify(eventMsg(uuid.New(), bundle.JobMethodProvision, bundle.StateInProgress, "creating"))
+	ft.AssertEqual(t, 3, len(writer.created))
+}
+
+func TestEventSubscriberExpiry(t *testing.T) {
+	sub, writer, id, now := newEventSubscriber(t, EventConfig{})
+
+	// the job never reports its outcome.
+	sub.Notify(eventMsg(id, bundle.JobMethodProvision, bundle.StateInProgress, "creating the database"))
+	ft.AssertEqual(t, 1, len(sub.instances))
+	ft.AssertEqual(t, 1, len(sub.instances[id].started))
+
+	*now = now.Add(eventStateTTL + time.Minute)
+	sub.Notify(eventMsg(uuid.New(), bundle.JobMethodProvision, bundle.StateInProgress, "creating"))
+	_, ok := sub.instances[id]
+	ft.AssertFalse(t, ok)
+	ft.AssertEqual(t, 2, len(writer.created))
+}
+
+// blockingEventWriter - blocks the events of the blocked namespace until
+// unblocked.
+type blockingEventWriter struct {
+	lock      sync.Mutex
+	blocked   string
+	unblock   chan struct{}
+	namespace []string
+}
+
+func (w *blockingEventWriter) Create(namespace string, event *v1.Event) (*v1.Event, error) {
+	if namespace == w.blocked {
+		<-w.unblock
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.namespace = append(w.namespace, namespace)
+	return event, nil
+}
+
+func (w *blockingEventWriter) Update(namespace string, event *v1.Event) (*v1.Event, error) {
+	return w.Create(namespace, event)
+}
+
+func TestEventSubscriberConcurrentInstances(t *testing.T) {
+	d, err := memory.NewDao()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{uuid.New(), uuid.New()}
+	for i, namespace := range []string{"slow", "fast"} {
+		instance := &bundle.ServiceInstance{ID: uuid.Parse(ids[i]), Context: &bundle.Context{Platform: "kubernetes", Namespace: namespace}}
+		if err := d.SetServiceInstance(ids[i], instance); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer := &blockingEventWriter{blocked: "slow", unblock: make(chan struct{})}
+	sub := NewEventSubscriber(d, writer, nil, EventConfig{})
+
+	done := make(chan struct{})
+	go func() {
+		sub.Notify(eventMsg(ids[0], bundle.JobMethodProvision, bundle.StateInProgress, "creating"))
+		close(done)
+	}()
+	// the events of the other instance are recorded while the slow one waits.
+	sub.Notify(eventMsg(ids[1], bundle.JobMethodProvision, bundle.StateInProgress, "creating"))
+	writer.lock.Lock()
+	ft.AssertEqual(t, 2, len(writer.namespace))
+	ft.AssertEqual(t, "fast", writer.namespace[0])
+	writer.lock.Unlock()
+
+	close(writer.unblock)
+	<-done
+	ft.AssertEqual(t, 4, len(writer.namespace))
+}
+
+type instanceResolver struct {
+	calls  int
+	object *v1.ObjectReference
+}
+
+func (r *instanceResolver) Resolve(namespace string, externalID string) (*v1.ObjectReference, error) {
+	r.calls++
+	return r.object, nil
+}
+
+func TestEventSubscriberResolvesInstance(t *testing.T) {
+	sub, writer, id, now := newEventSubscriber(t, EventConfig{})
+	resolver := &instanceResolver{}
+	sub.resolver = resolver
+
+	// the events name the external ID until the ServiceInstance shows up
+	sub.Notify(eventMsg(id, bundle.JobMethodProvision, bundle.StateInProgress, ""))
+	sub.Notify(eventMsg(id, bundle.JobMethodProvision, bundle.StateInProgress, "creating"))
+	ft.AssertEqual(t, id, writer.created[1].InvolvedObject.Name)
+	ft.AssertEqual(t, 1, resolver.calls)
+
+	resolver.object = &v1.ObjectReference{Kind: "ServiceInstance", Namespace: "project", Name: "my-db", UID: "uid"}
+	*now = now.Add(eventResolveRetry)
+	sub.Notify(eventMsg(id, bundle.JobMethodProvision, bundle.StateSucceeded, ""))
+	ft.AssertEqual(t, "my-db", writer.created[2].InvolvedObject.Name)
+	ft.AssertEqual(t, "uid", string(writer.created[2].InvolvedObject.UID))
+	sub.Notify(eventMsg(id, bundle.JobMethodUpdate, bundle.StateInProgress, ""))
+	ft.AssertEqual(t, "my-db", writer.created[3].InvolvedObject.Name)
+	ft.AssertEqual(t, 2, resolver.calls)
+}
+
+func TestFindServiceInstance(t *testing.T) {
+	list := []byte(`{"items": [
+		{"metadata": {"name": "other", "namespace": "project", "uid": "1"}, "spec": {"externalID": "a"}},
+		{"metadata": {"name": "my-db", "namespace": "project", "uid": "2"}, "spec": {"externalID": "b"}}]}`)
+	object, err := findServiceInstance(list, "b")
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "my-db", object.Name)
+	ft.AssertEqual(t, "project", object.Namespace)
+	ft.AssertEqual(t, "2", string(object.UID))
+	ft.AssertEqual(t, "servicecatalog.k8s.io/v1beta1", object.APIVersion)
+
+	object, err = findServiceInstance(list, "c")
+	ft.AssertNil(t, err)
+	ft.AssertTrue(t, object == nil)
+}
@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"fmt"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// IntentDAO - the dao calls used to replay the intents recorded along with
+// the writes of a batch.
+type IntentDAO interface {
+	intentCompleter
+	PendingIntents() ([]types.Intent, error)
+}
+
+type intentCompleter interface {
+	CompleteIntent(id string) error
+}
+
+// deleteCredentialsIntent - the intent deleting the extracted credentials of
+// the service or bind instance id.
+func deleteCredentialsIntent(id string) types.Intent {
+	return types.Intent{
+		ID:     uuid.New(),
+		Action: types.IntentDeleteExtractedCredentials,
+		Target: id,
+	}
+}
+
+// runIntent - carries out the action of the intent, it is safe to do so more
+// than once.
+func runIntent(intent types.Intent) error {
+	switch intent.Action {
+	case types.IntentDeleteExtractedCredentials:
+		// credentials that are already gone are not an error.
+		return bundle.DeleteExtractedCredentials(intent.Target)
+	}
+	return fmt.Errorf("unknown intent action: %s", intent.Action)
+}
+
+// completeIntents - carries out the actions of the intents and completes
+// them. An intent whose action fails stays pending, it is carried out again
+// when the intents are replayed. The first error is returned.
+func completeIntents(dao intentCompleter, intents []types.Intent) error {
+	var first error
+	for _, intent := range intents {
+		err := runIntent(intent)
+		if err == nil {
+			err = dao.CompleteIntent(intent.ID)
+		}
+		if err != nil {
+			log.Warningf("Unable to complete intent [ %s ] to %s [ %s ] - %v", intent.ID, intent.Action, intent.Target, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// ReplayIntents - Carry out the intents left pending by a broker that stopped
+// after applying their batch, replaying the batches it left unfinished first.
+func ReplayIntents(dao IntentDAO) error {
+	intents, err := dao.PendingIntents()
+	if err != nil {
+		log.Errorf("Unable to get the pending intents - %v", err)
+		return err
+	}
+	if len(intents) == 0 {
+		return nil
+	}
+	log.Infof("Replaying [ %d ] pending intents", len(intents))
+	return completeIntents(dao, intents)
+}
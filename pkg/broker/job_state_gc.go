@@ -0,0 +1,163 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"sort"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/openshift/ansible-service-broker/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	prunedByCount  = "count"
+	prunedByAge    = "age"
+	prunedAsOrphan = "orphan"
+)
+
+// JobStateRetention - describes which job states are kept by the job state
+// garbage collector. Job states that are still running are never pruned
+// unless their instance or binding is gone.
+type JobStateRetention struct {
+	// MaxCount is the number of finished job states kept for each instance
+	// or binding, 0 keeps all of them.
+	MaxCount int
+	// MaxAge is how long finished job states are kept, 0 keeps them forever.
+	MaxAge time.Duration
+	// OrphanGracePeriod is how long a job state is kept after its instance
+	// or binding no longer exists. It gives a new async bind time to save
+	// its binding.
+	OrphanGracePeriod time.Duration
+}
+
+// JobStateGC - prunes the stored job states according to a retention policy.
+type JobStateGC struct {
+	dao    dao.Dao
+	policy JobStateRetention
+	now    func() time.Time
+}
+
+// NewJobStateGC - creates a job state garbage collector for the dao.
+func NewJobStateGC(dao dao.Dao, policy JobStateRetention) *JobStateGC {
+	return &JobStateGC{dao: dao, policy: policy, now: time.Now}
+}
+
+// Run - makes a single pass over all of the job states and removes the ones
+// the retention policy does not keep. Returns how many were removed.
+func (gc *JobStateGC) Run() (int, error) {
+	records, err := gc.dao.BatchGetJobStates()
+	if err != nil {
+		log.Errorf("Unable to get the job states to prune - %v", err)
+		return 0, err
+	}
+
+	byID := map[string][]types.JobStateRecord{}
+	ids := []string{}
+	for _, r := range records {
+		if _, ok := byID[r.ID]; !ok {
+			ids = append(ids, r.ID)
+		}
+		byID[r.ID] = append(byID[r.ID], r)
+	}
+
+	pruned := map[string]int{}
+	for _, id := range ids {
+		orphaned, err := gc.isOrphaned(id)
+		if err != nil {
+			log.Warningf("Unable to determine if job states of [ %s ] are orphaned, skipping - %v", id, err)
+			continue
+		}
+		for _, p := range gc.selectPrunable(byID[id], orphaned) {
+			if err := gc.dao.DeleteState(id, p.record.State.Token); err != nil && !gc.dao.IsNotFoundError(err) {
+				log.Warningf("Unable to prune job state [ %s ] of [ %s ] - %v", p.record.State.Token, id, err)
+				continue
+			}
+			log.Debugf("Pruned job state [ %s ] of [ %s ], reason: %s", p.record.State.Token, id, p.reason)
+			pruned[p.reason]++
+		}
+	}
+
+	total := 0
+	for reason, count := range pruned {
+		metrics.JobStatesPruned(reason, count)
+		total += count
+	}
+	log.Infof("Job state garbage collection pruned [ %d ] of [ %d ] job states", total, len(records))
+	return total, nil
+}
+
+type prunable struct {
+	record types.JobStateRecord
+	reason string
+}
+
+// selectPrunable - picks the job states of a single instance or binding that
+// are not kept by the policy.
+func (gc *JobStateGC) selectPrunable(records []types.JobStateRecord, orphaned bool) []prunable {
+	now := gc.now()
+	prune := []prunable{}
+	if orphaned {
+		for _, r := range records {
+			if now.Sub(r.LastModified) >= gc.policy.OrphanGracePeriod {
+				prune = append(prune, prunable{record: r, reason: prunedAsOrphan})
+			}
+		}
+		return prune
+	}
+
+	finished := []types.JobStateRecord{}
+	for _, r := range records {
+		if r.State.State == bundle.StateSucceeded || r.State.State == bundle.StateFailed ||
+			r.State.State == StateCancelled {
+			finished = append(finished, r)
+		}
+	}
+	// newest first, records without a time predate tracking it so they sort
+	// as the oldest.
+	sort.SliceStable(finished, func(i, j int) bool {
+		return finished[i].LastModified.After(finished[j].LastModified)
+	})
+	for i, r := range finished {
+		switch {
+		case gc.policy.MaxCount > 0 && i >= gc.policy.MaxCount:
+			prune = append(prune, prunable{record: r, reason: prunedByCount})
+		case gc.policy.MaxAge > 0 && now.Sub(r.LastModified) > gc.policy.MaxAge:
+			prune = append(prune, prunable{record: r, reason: prunedByAge})
+		}
+	}
+	return prune
+}
+
+// isOrphaned - job states are orphaned when neither a service instance nor a
+// binding with the id exists anymore.
+func (gc *JobStateGC) isOrphaned(id string) (bool, error) {
+	if _, err := gc.dao.GetServiceInstance(id); err == nil {
+		return false, nil
+	} else if !gc.dao.IsNotFoundError(err) {
+		return false, err
+	}
+	if _, err := gc.dao.GetBindInstance(id); err == nil {
+		return false, nil
+	} else if !gc.dao.IsNotFoundError(err) {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,100 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+func newGCTestDao(t *testing.T) (*memory.Dao, string) {
+	d, _ := memory.NewDao()
+	id := uuid.NewRandom()
+	if err := d.SetServiceInstance(id.String(), &bundle.ServiceInstance{ID: id}); err != nil {
+		t.Fatal(err)
+	}
+	// five finished jobs, oldest first, and one still running.
+	for i := 0; i < 5; i++ {
+		d.SetState(id.String(), bundle.JobState{
+			Token:  fmt.Sprintf("done-%d", i),
+			State:  bundle.StateSucceeded,
+			Method: bundle.JobMethodUpdate,
+		})
+		time.Sleep(time.Millisecond)
+	}
+	d.SetState(id.String(), bundle.JobState{Token: "running", State: bundle.StateInProgress, Method: bundle.JobMethodUpdate})
+	return d, id.String()
+}
+
+func TestJobStateGCKeepsLastN(t *testing.T) {
+	d, id := newGCTestDao(t)
+	gc := NewJobStateGC(d, JobStateRetention{MaxCount: 2})
+
+	pruned, err := gc.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 3, pruned)
+
+	for _, token := range []string{"done-3", "done-4", "running"} {
+		_, err := d.GetState(id, token)
+		ft.AssertNil(t, err)
+	}
+	_, err = d.GetState(id, "done-0")
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+}
+
+func TestJobStateGCMaxAge(t *testing.T) {
+	d, id := newGCTestDao(t)
+	gc := NewJobStateGC(d, JobStateRetention{MaxAge: time.Hour})
+
+	pruned, _ := gc.Run()
+	ft.AssertEqual(t, 0, pruned)
+
+	gc.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	pruned, _ = gc.Run()
+	ft.AssertEqual(t, 5, pruned)
+	// running jobs are never pruned for their age.
+	_, err := d.GetState(id, "running")
+	ft.AssertNil(t, err)
+}
+
+func TestJobStateGCOrphans(t *testing.T) {
+	d, id := newGCTestDao(t)
+	bindingID := uuid.New()
+	d.SetState(bindingID, bundle.JobState{Token: "bind", State: bundle.StateSucceeded, Method: bundle.JobMethodBind})
+	if err := d.DeleteServiceInstance(id); err != nil {
+		t.Fatal(err)
+	}
+
+	gc := NewJobStateGC(d, JobStateRetention{OrphanGracePeriod: time.Minute})
+	pruned, _ := gc.Run()
+	ft.AssertEqual(t, 0, pruned)
+
+	gc.now = func() time.Time { return time.Now().Add(time.Hour) }
+	pruned, _ = gc.Run()
+	ft.AssertEqual(t, 7, pruned)
+
+	records, _ := d.BatchGetJobStates()
+	ft.AssertEqual(t, 0, len(records))
+}
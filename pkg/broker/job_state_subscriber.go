@@ -5,6 +5,8 @@ import (
 
 	"github.com/automationbroker/bundle-lib/bundle"
 	"github.com/automationbroker/bundle-lib/runtime"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -31,11 +33,58 @@ func (jss *JobStateSubscriber) ID() string {
 
 // Notify external API to notify this subscriber of a change in the Job
 func (jss *JobStateSubscriber) Notify(msg JobMsg) {
-	log.Debugf("JobStateSubscriber Notify : msg state %v ", msg.State)
-	id := msg.InstanceUUID
+	if err := jss.Deliver(msg); err != nil {
+		log.Errorf("Error JobStateSubscriber failed to handle the message of job %s : %v", msg.JobToken, err)
+	}
+}
+
+// stateID - the id the job state of the message is stored under, the binding
+// for the bind and unbind jobs and the instance otherwise.
+func stateID(msg JobMsg) string {
 	if isBinding(msg) {
-		id = msg.BindingUUID
+		return msg.BindingUUID
+	}
+	return msg.InstanceUUID
+}
+
+// Deliver persists the change in the Job. Failing to store the job state or
+// the dashboard url is reported so that the message is delivered again, the
+// failures cleaning up after a job are recorded in its state instead.
+func (jss *JobStateSubscriber) Deliver(msg JobMsg) error {
+	return jss.deliver(msg, func(id string, state bundle.JobState) error {
+		_, err := jss.dao.SetState(id, state)
+		return err
+	})
+}
+
+// StoredState - the job state the message replaces, read with its version.
+func (jss *JobStateSubscriber) StoredState(msg JobMsg) (types.PriorState, error) {
+	state, version, err := jss.dao.GetStateVersion(stateID(msg), msg.State.Token)
+	if jss.dao.IsNotFoundError(err) {
+		return types.PriorState{}, nil
+	}
+	if err != nil {
+		return types.PriorState{}, err
 	}
+	return types.PriorState{Found: true, State: state, Version: version}, nil
+}
+
+// Redeliver persists the change in the Job like Deliver, as long as the job
+// state has not been written since it was read at version.
+func (jss *JobStateSubscriber) Redeliver(msg JobMsg, version string) error {
+	return jss.deliver(msg, func(id string, state bundle.JobState) error {
+		_, err := jss.dao.CompareAndSetState(id, state, version)
+		if jss.dao.IsConflictError(err) {
+			return errStateMoved
+		}
+		return err
+	})
+}
+
+// deliver - handles the message, storing its job state with setState.
+func (jss *JobStateSubscriber) deliver(msg JobMsg, setState func(id string, state bundle.JobState) error) error {
+	log.Debugf("JobStateSubscriber Notify : msg state %v ", msg.State)
+	id := stateID(msg)
 
 	// Bug 1583064 - Should not launch multi unbind sandboxes frequently while
 	// unbind failed.
@@ -56,33 +105,33 @@ func (jss *JobStateSubscriber) Notify(msg JobMsg) {
 		}
 	}
 
-	if _, err := jss.dao.SetState(id, msg.State); err != nil {
-		log.Errorf("Error JobStateSubscriber failed to set state after action %v completed with state %s err: %v", msg.State.Method, msg.State.State, err)
-		return
+	if err := setState(id, msg.State); err != nil {
+		if err == errStateMoved {
+			return err
+		}
+		return fmt.Errorf("failed to set state after action %v completed with state %s err: %v", msg.State.Method, msg.State.State, err)
 	}
 	if msg.State.State == bundle.StateSucceeded {
 		if err := jss.handleSucceeded(msg); err != nil {
 			log.Errorf("Error after job succeeded : %v", err)
-			return
+			return nil
 		}
 	}
 	//TODO: Need to get the service instance
 	//TODO: NOTE: THIS NEEDS TO BREAK OUT TO OWN SUBSCRIBER
 	// Update with dashboard URL.
 	if msg.DashboardURL != "" {
-		instance, err := jss.dao.GetServiceInstance(msg.InstanceUUID)
-		if err != nil {
-			log.Errorf("Error after job succeeded : %v", err)
-			return
-		}
-		instance.DashboardURL = msg.DashboardURL
-		err = jss.dao.SetServiceInstance(msg.InstanceUUID, instance)
+		// the handlers may be changing the bindings of the same instance, so
+		// only the dashboard url is written back onto the latest copy.
+		_, err := dao.UpdateServiceInstance(jss.dao, msg.InstanceUUID, func(instance *bundle.ServiceInstance) error {
+			instance.DashboardURL = msg.DashboardURL
+			return nil
+		})
 		if err != nil {
-			log.Errorf("Error after job succeeded : %v", err)
-			return
+			return fmt.Errorf("failed to set the dashboard url after job succeeded : %v", err)
 		}
 	}
-	return
+	return nil
 }
 
 // handle specific logic for the succeeded state
@@ -103,7 +152,23 @@ func (jss *JobStateSubscriber) handleSucceeded(msg JobMsg) error {
 
 func (jss *JobStateSubscriber) cleanupAfterDeprovision(msg JobMsg) error {
 	log.Debugf("JobStateSubscriber cleanupAfterDeprovision : msg state %v ", msg.State)
-	if deleteErr := jss.dao.DeleteServiceInstance(msg.InstanceUUID); deleteErr != nil {
+	// The extracted credentials created from provision should only be deleted
+	// if the instance was successfully deleted, otherwise we will end up in a
+	// corrupt state. Recording the intent with the delete makes sure they are
+	// deleted even if the broker stops in between.
+	batch := types.Batch{
+		Ops:     []types.Op{{Type: types.OpDeleteServiceInstance, ID: msg.InstanceUUID}},
+		Intents: []types.Intent{deleteCredentialsIntent(msg.InstanceUUID)},
+	}
+	deleteErr := jss.dao.Apply(batch)
+	if jss.dao.IsNotFoundError(deleteErr) {
+		// the message is delivered again, or replayed as a dead letter, after
+		// the instance was deleted along with the intent to delete the
+		// credentials, which is carried out or replayed on start.
+		log.Infof("Service instance [ %s ] already deleted after deprovision", msg.InstanceUUID)
+		return nil
+	}
+	if deleteErr != nil {
 		msg.State.State = bundle.StateFailed
 		if _, err := jss.dao.SetState(msg.InstanceUUID, msg.State); err != nil {
 			return fmt.Errorf("Error setting failed state after error : %s deleting service instance : %s", deleteErr, err)
@@ -111,10 +176,7 @@ func (jss *JobStateSubscriber) cleanupAfterDeprovision(msg JobMsg) error {
 		return deleteErr
 	}
 
-	// Attempt to delete extracted credentials created from provision. The creds
-	// should only be deleted if the instance was successfully deleted, otherwise
-	// we will end up in a corrupt state.
-	if err := bundle.DeleteExtractedCredentials(msg.InstanceUUID); err != nil {
+	if err := completeIntents(jss.dao, batch.Intents); err != nil {
 		log.Infof("Attempted to delete extracted credentials from a provision but could not: %s", err.Error())
 	}
 	return nil
@@ -135,10 +197,21 @@ func (jss *JobStateSubscriber) cleanupAfterUnbind(msg JobMsg) error {
 		return setFailed(fmt.Errorf("Error getting service instance [ %s ] during cleanup of unbind job : %v", msg.InstanceUUID, err))
 	}
 	bindInstance, err := jss.dao.GetBindInstance(msg.BindingUUID)
+	if jss.dao.IsNotFoundError(err) {
+		// the message is delivered again, or replayed as a dead letter, after
+		// the binding was deleted.
+		log.Infof("Binding instance [ %s ] already deleted after unbind", msg.BindingUUID)
+		return nil
+	}
 	if err != nil {
 		return setFailed(fmt.Errorf("Error getting bind instance [ %s ] during cleanup of unbind job : %v", msg.BindingUUID, err))
 	}
-	if err := jss.dao.DeleteBinding(*bindInstance, *svcInstance); err != nil {
+	err = jss.dao.DeleteBinding(*bindInstance, *svcInstance)
+	if jss.dao.IsNotFoundError(err) {
+		log.Infof("Binding instance [ %s ] already deleted after unbind", bindInstance.ID.String())
+		return nil
+	}
+	if err != nil {
 		return setFailed(fmt.Errorf("Error cleaning up binding instance [ %s ] during cleanup of unbind job : %v", bindInstance.ID.String(), err))
 	}
 	log.Infof("Clean up of binding instance [ %s ] done. Unbinding successful", bindInstance.ID.String())
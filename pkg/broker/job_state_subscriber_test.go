@@ -8,6 +8,8 @@ import (
 	apb "github.com/automationbroker/bundle-lib/bundle"
 	"github.com/automationbroker/bundle-lib/runtime"
 	"github.com/openshift/ansible-service-broker/pkg/broker"
+	asbdao "github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
 	"github.com/openshift/ansible-service-broker/pkg/mock"
 	"github.com/pborman/uuid"
 	tmock "github.com/stretchr/testify/mock"
@@ -148,9 +150,20 @@ func TestJobStateSubscriber(t *testing.T) {
 					}
 					return nil
 				}
+				dao.AssertOn["Apply"] = func(args ...interface{}) error {
+					batch := args[0].(types.Batch)
+					if len(batch.Ops) != 1 || batch.Ops[0].Type != types.OpDeleteServiceInstance {
+						return fmt.Errorf("expected the batch to delete the service instance but got %v", batch.Ops)
+					}
+					if len(batch.Intents) != 1 || batch.Intents[0].Action != types.IntentDeleteExtractedCredentials {
+						return fmt.Errorf("expected the batch to delete the extracted credentials but got %v", batch.Intents)
+					}
+					return nil
+				}
 				expectedCalls := map[string]int{
-					"SetState":              1,
-					"DeleteServiceInstance": 1,
+					"SetState":       1,
+					"Apply":          1,
+					"CompleteIntent": 1,
 				}
 				return dao, expectedCalls
 			},
@@ -169,7 +182,7 @@ func TestJobStateSubscriber(t *testing.T) {
 			rt: *new(runtime.MockRuntime),
 			DAO: func() (*mock.SubscriberDAO, map[string]int) {
 				dao := mock.NewSubscriberDAO()
-				dao.Errs["DeleteServiceInstance"] = errors.New("failed")
+				dao.Errs["Apply"] = errors.New("failed")
 				calls := 0
 				dao.AssertOn["SetState"] = func(args ...interface{}) error {
 					calls++
@@ -186,8 +199,9 @@ func TestJobStateSubscriber(t *testing.T) {
 					return nil
 				}
 				expectedCalls := map[string]int{
-					"SetState":              2,
-					"DeleteServiceInstance": 1,
+					"SetState":       2,
+					"Apply":          1,
+					"CompleteIntent": 0,
 				}
 				return dao, expectedCalls
 			},
@@ -234,6 +248,64 @@ func TestJobStateSubscriber(t *testing.T) {
 				rt.On("DeleteExtractedCredential", tmock.Anything, tmock.Anything).Return(nil)
 			},
 		},
+		{
+			Name: "deprovision delivered again after the service instance was deleted should succeed",
+			JobMsg: []broker.JobMsg{{
+				State: apb.JobState{
+					State:  apb.StateSucceeded,
+					Method: apb.JobMethodDeprovision,
+				},
+			}},
+			rt: *new(runtime.MockRuntime),
+			DAO: func() (*mock.SubscriberDAO, map[string]int) {
+				dao := mock.NewSubscriberDAO()
+				notFound := errors.New("not found")
+				dao.Errs["Apply"] = notFound
+				dao.Errs["IsNotFoundError"] = notFound
+				dao.AssertOn["SetState"] = func(args ...interface{}) error {
+					state := args[1].(apb.JobState)
+					if state.State != apb.StateSucceeded {
+						return fmt.Errorf("expected the job state to be %v but got %v", apb.StateSucceeded, state.State)
+					}
+					return nil
+				}
+				expectedCalls := map[string]int{
+					"SetState":       1,
+					"Apply":          1,
+					"CompleteIntent": 0,
+				}
+				return dao, expectedCalls
+			},
+		},
+		{
+			Name: "unbind delivered again after the binding was deleted should succeed",
+			JobMsg: []broker.JobMsg{{
+				State: apb.JobState{
+					State:  apb.StateSucceeded,
+					Method: apb.JobMethodUnbind,
+				},
+			}},
+			rt: *new(runtime.MockRuntime),
+			DAO: func() (*mock.SubscriberDAO, map[string]int) {
+				dao := mock.NewSubscriberDAO()
+				notFound := errors.New("not found")
+				dao.Object["GetServiceInstance"] = &apb.ServiceInstance{}
+				dao.Errs["GetBindInstance"] = notFound
+				dao.Errs["IsNotFoundError"] = notFound
+				dao.AssertOn["SetState"] = func(args ...interface{}) error {
+					state := args[1].(apb.JobState)
+					if state.State != apb.StateSucceeded {
+						return fmt.Errorf("expected the job state to be %v but got %v", apb.StateSucceeded, state.State)
+					}
+					return nil
+				}
+				expectedCalls := map[string]int{
+					"SetState":      1,
+					"DeleteBinding": 0,
+				}
+				return dao, expectedCalls
+			},
+		},
 		{
 			Name: "Message state error action not found and bind",
 			JobMsg: []broker.JobMsg{{
@@ -285,7 +357,7 @@ func TestJobStateSubscriber(t *testing.T) {
 			rt: *new(runtime.MockRuntime),
 			DAO: func() (*mock.SubscriberDAO, map[string]int) {
 				dao := mock.NewSubscriberDAO()
-				dao.Object["GetServiceInstance"] = &apb.ServiceInstance{}
+				dao.Object["GetServiceInstanceVersion"] = &apb.ServiceInstance{}
 				dao.Object["GetBindInstance"] = &apb.BindInstance{ID: uID}
 				dao.Errs["DeleteBinding"] = errors.New("failed")
 				calls := 0
@@ -304,9 +376,9 @@ func TestJobStateSubscriber(t *testing.T) {
 					return nil
 				}
 				expectedCalls := map[string]int{
-					"SetState":           1,
-					"GetServiceInstance": 1,
-					"SetServiceInstance": 1,
+					"SetState":                     1,
+					"GetServiceInstanceVersion":    1,
+					"CompareAndSetServiceInstance": 1,
 				}
 				return dao, expectedCalls
 			},
@@ -314,6 +386,37 @@ func TestJobStateSubscriber(t *testing.T) {
 				rt.On("DeleteExtractedCredential", tmock.Anything, tmock.Anything).Return(nil)
 			},
 		},
+		{
+			Name: "Message state set dashboard url retries conflicting updates",
+			JobMsg: []broker.JobMsg{{
+				State: apb.JobState{
+					State:  apb.StateSucceeded,
+					Method: apb.JobMethodProvision,
+				},
+				DashboardURL: "https://google.com",
+			}},
+			rt: *new(runtime.MockRuntime),
+			DAO: func() (*mock.SubscriberDAO, map[string]int) {
+				dao := mock.NewSubscriberDAO()
+				dao.Object["GetServiceInstanceVersion"] = &apb.ServiceInstance{}
+				conflict := errors.New("conflict")
+				dao.Errs["CompareAndSetServiceInstance"] = conflict
+				dao.Errs["IsConflictError"] = conflict
+				dao.AssertOn["CompareAndSetServiceInstance"] = func(args ...interface{}) error {
+					si := args[1].(*apb.ServiceInstance)
+					if si.DashboardURL != "https://google.com" {
+						return fmt.Errorf("expected the dashboard url to be set but got %v", si.DashboardURL)
+					}
+					return nil
+				}
+				expectedCalls := map[string]int{
+					"SetState":                     1,
+					"GetServiceInstanceVersion":    asbdao.ConflictRetries,
+					"CompareAndSetServiceInstance": asbdao.ConflictRetries,
+				}
+				return dao, expectedCalls
+			},
+		},
 	}
 
 	for _, tc := range cases {
@@ -336,3 +439,21 @@ func TestJobStateSubscriber(t *testing.T) {
 		})
 	}
 }
+
+func TestJobStateSubscriberDeliver(t *testing.T) {
+	msg := broker.JobMsg{State: apb.JobState{State: apb.StateInProgress, Method: apb.JobMethodProvision}}
+
+	dao := mock.NewSubscriberDAO()
+	sub := broker.NewJobStateSubscriber(dao)
+	if err := sub.Deliver(msg); err != nil {
+		t.Fatal("unexpected error delivering the message ", err)
+	}
+
+	// failing to store the state is reported so that the message is retried
+	dao = mock.NewSubscriberDAO()
+	dao.Errs["SetState"] = errors.New("etcd timeout")
+	sub = broker.NewJobStateSubscriber(dao)
+	if err := sub.Deliver(msg); err == nil {
+		t.Fatal("expected an error when the state could not be set")
+	}
+}
@@ -21,10 +21,13 @@
 package broker
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/automationbroker/bundle-lib/bundle"
 	"github.com/automationbroker/bundle-lib/runtime"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
 	"github.com/openshift/ansible-service-broker/pkg/metrics"
 	log "github.com/sirupsen/logrus"
 )
@@ -32,15 +35,33 @@ import (
 type metricsHookFn func()
 type runFn func(bundle.Executor) <-chan bundle.StatusMessage
 
+// terminateFn - stops the bundle the job runs in the pod recorded on the job.
+// Returns whether the pod was found.
+type terminateFn func(pod *sandboxPod) (bool, error)
+
+// terminatePollInterval - how often a cancelled job tries to terminate the
+// bundle when its pod is not known or not created yet.
+var terminatePollInterval = 5 * time.Second
+
 type apbJob struct {
 	serviceInstanceID      string
 	specID                 string
+	namespace              string
 	bindingID              *string
 	method                 bundle.JobMethod
 	metricsJobStartHook    metricsHookFn
 	metricsJobFinishedHook metricsHookFn
 	executor               bundle.Executor
-	run                    runFn
+	// newExecutor, when set, creates the executor of each run of the job as
+	// an executor can only run once.
+	newExecutor   func() bundle.Executor
+	retryMetadata []map[string]interface{}
+	run           runFn
+	terminate     terminateFn
+	// instance and parameters are what the job runs with, they are kept in
+	// the job record.
+	instance   *bundle.ServiceInstance
+	parameters *bundle.Parameters
 
 	// NOTE: skipExecution is an artifact of an older time when we did not have
 	// spec level support for some async actions (like bind). In time, this should
@@ -59,7 +80,12 @@ func (j *apbJob) Method() bundle.JobMethod {
 	return j.method
 }
 
-func (j *apbJob) Run(token string, msgBuffer chan<- JobMsg) {
+// Namespace - the namespace of the service instance the job runs for.
+func (j *apbJob) Namespace() string {
+	return j.namespace
+}
+
+func (j *apbJob) Run(ctx context.Context, token string, msgBuffer chan<- JobMsg) {
 	var (
 		err     error
 		podName string
@@ -69,6 +95,10 @@ func (j *apbJob) Run(token string, msgBuffer chan<- JobMsg) {
 			"Error occurred during %s. Please contact administrator if the issue persists.", j.method)
 	)
 
+	if j.newExecutor != nil {
+		exec = j.newExecutor()
+	}
+
 	j.metricsJobStartHook()
 	defer j.metricsJobFinishedHook()
 
@@ -79,8 +109,14 @@ func (j *apbJob) Run(token string, msgBuffer chan<- JobMsg) {
 		return
 	}
 
+	pod := &sandboxPod{}
+	done := make(chan struct{})
+	defer close(done)
+	go j.terminateOnCancel(ctx, pod, done)
+
 	for status := range j.run(exec) {
 		podName = exec.PodName()
+		pod.setName(podName)
 		jobMsg = j.createJobMsg(podName, token, status.State, status.Description)
 		if status.State == bundle.StateInProgress {
 			// Only send intermediate messages since the final ones are processed
@@ -101,6 +137,13 @@ func (j *apbJob) Run(token string, msgBuffer chan<- JobMsg) {
 		}
 
 		jobMsg.State.State = bundle.StateFailed
+		jobMsg.Retryable = isRetryableError(err)
+		if ctx.Err() != nil {
+			// the bundle failed because it was terminated.
+			jobMsg.State.State = StateCancelled
+			jobMsg.Retryable = false
+			errMsg = fmt.Sprintf("%s job cancelled by an administrator", j.method)
+		}
 		// send error message, can't have
 		// an error type in a struct you want marshalled
 		// https://github.com/golang/go/issues/5161
@@ -125,6 +168,59 @@ func (j *apbJob) Run(token string, msgBuffer chan<- JobMsg) {
 	msgBuffer <- jobMsg
 }
 
+// JobRecord - the record of the job, with what it runs with.
+func (j *apbJob) JobRecord() types.JobRecord {
+	record := types.JobRecord{
+		Method:          j.method,
+		ServiceInstance: j.instance,
+		Parameters:      j.parameters,
+		SkipExecution:   j.skipExecution,
+	}
+	if j.bindingID != nil {
+		record.BindingID = *j.bindingID
+	}
+	return record
+}
+
+// RetryPolicy - the policy of the engine overridden by the retry metadata of
+// the spec, then of the plan.
+func (j *apbJob) RetryPolicy(defaults RetryPolicy) RetryPolicy {
+	policy := defaults
+	for _, metadata := range j.retryMetadata {
+		policy = overrideRetryPolicy(policy, metadata)
+	}
+	return policy
+}
+
+// terminateOnCancel - terminates the bundle of the job once ctx is done,
+// waiting for the executor to report its pod if need be, until the job is
+// done.
+func (j *apbJob) terminateOnCancel(ctx context.Context, pod *sandboxPod, done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	if j.terminate == nil {
+		return
+	}
+	log.Infof("Cancelling %s job for service instance [ %s ]", j.method, j.serviceInstanceID)
+	for {
+		terminated, err := j.terminate(pod)
+		if err != nil {
+			log.Warningf("Unable to terminate the %s bundle yet - %v", j.method, err)
+		}
+		if terminated {
+			return
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(terminatePollInterval):
+		}
+	}
+}
+
 func (j *apbJob) createJobMsg(
 	podName string, token string,
 	state bundle.State, description string,
@@ -149,6 +245,19 @@ func (j *apbJob) createJobMsg(
 	return jobMsg
 }
 
+// instanceNamespace - the namespace the service instance was created in.
+func instanceNamespace(si *bundle.ServiceInstance) string {
+	if si.Context == nil {
+		return ""
+	}
+	return si.Context.Namespace
+}
+
+// newExecutor - creates the executor of a run of a job.
+func newExecutor() bundle.Executor {
+	return bundle.NewExecutor(bundle.ExecutorConfig{})
+}
+
 type workFactory struct {
 }
 
@@ -161,10 +270,14 @@ func NewWorkFactory() WorkFactory {
 func (wf *workFactory) NewProvisionJob(si *bundle.ServiceInstance) Work {
 	return &provisionJob{
 		apbJob: apbJob{
-			executor:               bundle.NewExecutor(bundle.ExecutorConfig{}),
+			newExecutor:            newExecutor,
+			retryMetadata:          retryMetadata(si),
+			instance:               si,
 			serviceInstanceID:      si.ID.String(),
 			specID:                 si.Spec.ID,
+			namespace:              instanceNamespace(si),
 			method:                 bundle.JobMethodProvision,
+			terminate:              terminateSandboxPod(si),
 			metricsJobStartHook:    metrics.ProvisionJobStarted,
 			metricsJobFinishedHook: metrics.ProvisionJobFinished,
 			skipExecution:          false,
@@ -180,10 +293,14 @@ func (wf *workFactory) NewProvisionJob(si *bundle.ServiceInstance) Work {
 func (wf *workFactory) NewDeprovisionJob(si *bundle.ServiceInstance, skipExecution bool) Work {
 	return &deprovisionJob{
 		apbJob: apbJob{
-			executor:               bundle.NewExecutor(bundle.ExecutorConfig{}),
+			newExecutor:            newExecutor,
+			retryMetadata:          retryMetadata(si),
+			instance:               si,
 			serviceInstanceID:      si.ID.String(),
 			specID:                 si.Spec.ID,
+			namespace:              instanceNamespace(si),
 			method:                 bundle.JobMethodDeprovision,
+			terminate:              terminateSandboxPod(si),
 			metricsJobStartHook:    metrics.DeprovisionJobStarted,
 			metricsJobFinishedHook: metrics.DeprovisionJobFinished,
 			skipExecution:          skipExecution,
@@ -199,11 +316,16 @@ func (wf *workFactory) NewDeprovisionJob(si *bundle.ServiceInstance, skipExecuti
 func (wf *workFactory) NewUnbindJob(bindingID string, params *bundle.Parameters, si *bundle.ServiceInstance, skipExecution bool) Work {
 	return &unbindJob{
 		apbJob: apbJob{
-			executor:               bundle.NewExecutor(bundle.ExecutorConfig{}),
+			newExecutor:            newExecutor,
+			retryMetadata:          retryMetadata(si),
+			instance:               si,
 			serviceInstanceID:      si.ID.String(),
 			specID:                 si.Spec.ID,
+			namespace:              instanceNamespace(si),
 			bindingID:              &bindingID,
+			parameters:             params,
 			method:                 bundle.JobMethodUnbind,
+			terminate:              terminateSandboxPod(si),
 			metricsJobStartHook:    metrics.UnbindJobStarted,
 			metricsJobFinishedHook: metrics.UnbindJobFinished,
 			skipExecution:          skipExecution,
@@ -218,11 +340,16 @@ func (wf *workFactory) NewUnbindJob(bindingID string, params *bundle.Parameters,
 func (wf *workFactory) NewBindJob(bindingID string, bindingParams *bundle.Parameters, si *bundle.ServiceInstance) Work {
 	return &bindJob{
 		apbJob: apbJob{
-			executor:               bundle.NewExecutor(bundle.ExecutorConfig{}),
+			newExecutor:            newExecutor,
+			retryMetadata:          retryMetadata(si),
+			instance:               si,
 			serviceInstanceID:      si.ID.String(),
 			specID:                 si.Spec.ID,
+			namespace:              instanceNamespace(si),
 			bindingID:              &bindingID,
+			parameters:             bindingParams,
 			method:                 bundle.JobMethodBind,
+			terminate:              terminateSandboxPod(si),
 			metricsJobStartHook:    metrics.BindJobStarted,
 			metricsJobFinishedHook: metrics.BindJobFinished,
 			skipExecution:          false,
@@ -237,10 +364,14 @@ func (wf *workFactory) NewBindJob(bindingID string, bindingParams *bundle.Parame
 func (wf *workFactory) NewUpdateJob(si *bundle.ServiceInstance) Work {
 	return &updateJob{
 		apbJob: apbJob{
-			executor:               bundle.NewExecutor(bundle.ExecutorConfig{}),
+			newExecutor:            newExecutor,
+			retryMetadata:          retryMetadata(si),
+			instance:               si,
 			serviceInstanceID:      si.ID.String(),
 			specID:                 si.Spec.ID,
+			namespace:              instanceNamespace(si),
 			method:                 bundle.JobMethodUpdate,
+			terminate:              terminateSandboxPod(si),
 			metricsJobStartHook:    metrics.UpdateJobStarted,
 			metricsJobFinishedHook: metrics.UpdateJobFinished,
 			skipExecution:          false,
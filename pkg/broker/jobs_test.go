@@ -1,6 +1,7 @@
 package broker
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -478,7 +479,7 @@ func TestApbJobRun(t *testing.T) {
 
 			go func() {
 				fmt.Printf("Running test %s", tc.name)
-				tc.testJob.Run(token, msgBuffer)
+				tc.testJob.Run(context.Background(), token, msgBuffer)
 			}()
 
 			for {
@@ -498,6 +499,56 @@ func TestApbJobRun(t *testing.T) {
 	}
 }
 
+func TestApbJobCancel(t *testing.T) {
+	terminated := make(chan struct{})
+	job := &apbJob{
+		serviceInstanceID: "16235516-9e5e-4c68-a541-33bda63413ee",
+		method:            bundle.JobMethodProvision,
+		executor: func() bundle.Executor {
+			e := &bundle.MockExecutor{}
+			e.On("PodName").Return("bundle-1")
+			e.On("LastStatus").Return(bundle.StatusMessage{
+				State: bundle.StateFailed,
+				Error: fmt.Errorf("pod [ bundle-1 ] was unexpectedly deleted"),
+			})
+			return e
+		}(),
+		metricsJobStartHook:    func() {},
+		metricsJobFinishedHook: func() {},
+		run: func(exec bundle.Executor) <-chan bundle.StatusMessage {
+			statusChan := make(chan bundle.StatusMessage)
+			go func() {
+				statusChan <- bundle.StatusMessage{State: bundle.StateInProgress}
+				<-terminated
+				close(statusChan)
+			}()
+			return statusChan
+		},
+		terminate: func(pod *sandboxPod) (bool, error) {
+			// the pod reported by the executor is the one terminated
+			name, _ := pod.get()
+			assert.Equal(t, "bundle-1", name)
+			close(terminated)
+			return true, nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgBuffer := make(chan JobMsg)
+	go job.Run(ctx, "token", msgBuffer)
+	assert.Equal(t, bundle.StateInProgress, (<-msgBuffer).State.State)
+	cancel()
+
+	select {
+	case msg := <-msgBuffer:
+		assert.Equal(t, StateCancelled, msg.State.State)
+		assert.Equal(t, "provision job cancelled by an administrator", msg.State.Description)
+		assert.Equal(t, "pod [ bundle-1 ] was unexpectedly deleted", msg.State.Error)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cancelled job did not finish")
+	}
+}
+
 func TestWork(t *testing.T) {
 	cases := []struct {
 		Name     string
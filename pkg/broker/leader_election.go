@@ -0,0 +1,275 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/openshift/ansible-service-broker/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultLeaseDuration - how long followers wait for the leader to renew
+	// its lease before taking it over.
+	DefaultLeaseDuration = 15 * time.Second
+	// DefaultRenewDeadline - how long the leader keeps trying to renew its
+	// lease before it steps down.
+	DefaultRenewDeadline = 10 * time.Second
+	// DefaultRetryPeriod - how often the lease is tried.
+	DefaultRetryPeriod = 2 * time.Second
+)
+
+// ErrLeaseLost - the leader could not renew its lease within the renew
+// deadline, another broker may have taken it over.
+var ErrLeaseLost = errors.New("unable to renew the leader lease")
+
+// LeaseDAO - the dao methods the leader elector uses.
+type LeaseDAO interface {
+	GetLease(name string) (types.Lease, string, error)
+	CompareAndSetLease(lease types.Lease, version string) (string, error)
+	IsNotFoundError(err error) bool
+	IsConflictError(err error) bool
+}
+
+// LeaderElectionConfig - how the brokers compete for the leader lease.
+type LeaderElectionConfig struct {
+	// Name is the name of the lease.
+	Name string
+	// Identity is the identity of this broker, unique among the brokers.
+	Identity string
+	// LeaseDuration is how long followers wait, from the last change of the
+	// lease they saw, before taking it over. Measuring it on the clock of
+	// each follower keeps the election safe from clock skew.
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps trying to renew its lease
+	// before it steps down, shorter than LeaseDuration.
+	RenewDeadline time.Duration
+	// RetryPeriod is how often the lease is tried, shorter than
+	// RenewDeadline.
+	RetryPeriod time.Duration
+}
+
+// LeaderElector - elects one leader among the brokers sharing the dao, with
+// a lease the leader renews.
+type LeaderElector struct {
+	config LeaderElectionConfig
+	dao    LeaseDAO
+	now    func() time.Time
+
+	lock   sync.Mutex
+	leader bool
+	// holder is the holder of the lease last seen.
+	holder string
+	// observedVersion is the version of the lease last seen, observedTime
+	// when this broker saw it change.
+	observedVersion string
+	observedTime    time.Time
+}
+
+// NewLeaderElector - creates a leader elector, using the defaults for the
+// durations that are not set.
+func NewLeaderElector(dao LeaseDAO, config LeaderElectionConfig) (*LeaderElector, error) {
+	if config.Name == "" || config.Identity == "" {
+		return nil, fmt.Errorf("leader election needs a lease name and an identity")
+	}
+	if config.LeaseDuration <= 0 {
+		config.LeaseDuration = DefaultLeaseDuration
+	}
+	if config.RenewDeadline <= 0 {
+		config.RenewDeadline = DefaultRenewDeadline
+	}
+	if config.RetryPeriod <= 0 {
+		config.RetryPeriod = DefaultRetryPeriod
+	}
+	if config.RenewDeadline >= config.LeaseDuration || config.RetryPeriod >= config.RenewDeadline {
+		return nil, fmt.Errorf("leader election needs a retry period %v shorter than the renew deadline %v, "+
+			"itself shorter than the lease duration %v", config.RetryPeriod, config.RenewDeadline, config.LeaseDuration)
+	}
+	return &LeaderElector{config: config, dao: dao, now: time.Now}, nil
+}
+
+// IsLeader - whether this broker holds the lease.
+func (le *LeaderElector) IsLeader() bool {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	return le.leader
+}
+
+// Grace - how long the other brokers may still honour the lease once this
+// broker lost it, from the last renewal until it expires on their clocks.
+func (le *LeaderElector) Grace() time.Duration {
+	return le.config.LeaseDuration - le.config.RenewDeadline
+}
+
+// Leader - the identity of the leader last seen, empty when there is none.
+func (le *LeaderElector) Leader() string {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	return le.holder
+}
+
+// Run - campaigns for the lease until ctx is done. Once this broker holds
+// the lease lead is called with a context that is done when it stops
+// leading. When ctx is done the lease is released, so that another broker
+// takes over without waiting for it to expire. Returns ErrLeaseLost when the
+// lease could not be renewed, the work the broker led must be stopped since
+// another broker may be doing it already.
+func (le *LeaderElector) Run(ctx context.Context, lead func(context.Context)) error {
+	if !le.acquire(ctx) {
+		return nil
+	}
+	leaderCtx, cancel := context.WithCancel(ctx)
+	go lead(leaderCtx)
+
+	err := le.renew(ctx)
+	cancel()
+	le.setLeader(false)
+	if err != nil {
+		log.Errorf("Stopped leading, lease %s lost - %v", le.config.Name, err)
+		return err
+	}
+	le.release()
+	return nil
+}
+
+// acquire - tries the lease every retry period until this broker holds it
+// or ctx is done. Returns whether it holds the lease.
+func (le *LeaderElector) acquire(ctx context.Context) bool {
+	log.Infof("Campaigning for the leader lease %s as %s", le.config.Name, le.config.Identity)
+	ticker := time.NewTicker(le.config.RetryPeriod)
+	defer ticker.Stop()
+	for {
+		if le.tryAcquireOrRenew() {
+			le.setLeader(true)
+			log.Infof("Became the leader, holding lease %s", le.config.Name)
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew - renews the lease every retry period until ctx is done, or until
+// it could not be renewed for the renew deadline.
+func (le *LeaderElector) renew(ctx context.Context) error {
+	ticker := time.NewTicker(le.config.RetryPeriod)
+	defer ticker.Stop()
+	renewed := le.now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if le.tryAcquireOrRenew() {
+			renewed = le.now()
+			continue
+		}
+		if le.now().Sub(renewed) >= le.config.RenewDeadline {
+			return ErrLeaseLost
+		}
+	}
+}
+
+// tryAcquireOrRenew - takes the lease when it is free, released or expired,
+// or renews it when this broker holds it. Returns whether it holds the lease.
+func (le *LeaderElector) tryAcquireOrRenew() bool {
+	now := le.now()
+	lease, version, err := le.dao.GetLease(le.config.Name)
+	switch {
+	case le.dao.IsNotFoundError(err):
+		lease, version = types.Lease{Name: le.config.Name}, ""
+	case err != nil:
+		log.Warningf("Unable to read the leader lease %s - %v", le.config.Name, err)
+		return false
+	}
+
+	le.lock.Lock()
+	if version != le.observedVersion {
+		le.observedVersion = version
+		le.observedTime = now
+		le.holder = lease.Holder
+	}
+	duration := lease.Duration
+	if duration <= 0 {
+		duration = le.config.LeaseDuration
+	}
+	held := lease.Holder != "" && lease.Holder != le.config.Identity &&
+		now.Before(le.observedTime.Add(duration))
+	le.lock.Unlock()
+	if held {
+		return false
+	}
+
+	if lease.Holder != le.config.Identity {
+		if version != "" {
+			lease.Transitions++
+		}
+		lease.Holder = le.config.Identity
+		lease.Acquired = now
+	}
+	lease.Renewed = now
+	lease.Duration = le.config.LeaseDuration
+	newVersion, err := le.dao.CompareAndSetLease(lease, version)
+	if err != nil {
+		if !le.dao.IsConflictError(err) {
+			log.Warningf("Unable to write the leader lease %s - %v", le.config.Name, err)
+		}
+		return false
+	}
+
+	le.lock.Lock()
+	le.observedVersion = newVersion
+	le.observedTime = now
+	le.holder = le.config.Identity
+	le.lock.Unlock()
+	return true
+}
+
+// release - gives the lease up when this broker still holds it.
+func (le *LeaderElector) release() {
+	lease, version, err := le.dao.GetLease(le.config.Name)
+	if err != nil || lease.Holder != le.config.Identity {
+		return
+	}
+	lease.Holder = ""
+	lease.Renewed = le.now()
+	if _, err := le.dao.CompareAndSetLease(lease, version); err != nil {
+		log.Warningf("Unable to release the leader lease %s - %v", le.config.Name, err)
+		return
+	}
+	le.lock.Lock()
+	le.holder = ""
+	le.lock.Unlock()
+	log.Infof("Released the leader lease %s", le.config.Name)
+}
+
+func (le *LeaderElector) setLeader(leader bool) {
+	le.lock.Lock()
+	le.leader = leader
+	le.lock.Unlock()
+	metrics.Leader(leader)
+}
@@ -0,0 +1,195 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+var (
+	errLeaseNotFound = errors.New("lease not found")
+	errLeaseConflict = errors.New("lease conflict")
+)
+
+// testLeaseDAO - keeps versioned leases in memory, as a shared data store would.
+type testLeaseDAO struct {
+	lock     sync.Mutex
+	leases   map[string]types.Lease
+	versions map[string]int
+}
+
+func newTestLeaseDAO() *testLeaseDAO {
+	return &testLeaseDAO{leases: map[string]types.Lease{}, versions: map[string]int{}}
+}
+
+func (d *testLeaseDAO) GetLease(name string) (types.Lease, string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	lease, ok := d.leases[name]
+	if !ok {
+		return types.Lease{}, "", errLeaseNotFound
+	}
+	return lease, strconv.Itoa(d.versions[name]), nil
+}
+
+func (d *testLeaseDAO) CompareAndSetLease(lease types.Lease, version string) (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	current := ""
+	if _, ok := d.leases[lease.Name]; ok {
+		current = strconv.Itoa(d.versions[lease.Name])
+	}
+	if version != current {
+		return "", errLeaseConflict
+	}
+	d.leases[lease.Name] = lease
+	d.versions[lease.Name]++
+	return strconv.Itoa(d.versions[lease.Name]), nil
+}
+
+func (d *testLeaseDAO) IsNotFoundError(err error) bool {
+	return err == errLeaseNotFound
+}
+
+func (d *testLeaseDAO) IsConflictError(err error) bool {
+	return err == errLeaseConflict
+}
+
+func newTestElector(t *testing.T, d LeaseDAO, identity string, now *time.Time) *LeaderElector {
+	le, err := NewLeaderElector(d, LeaderElectionConfig{
+		Name:          "broker",
+		Identity:      identity,
+		LeaseDuration: 100 * time.Millisecond,
+		RenewDeadline: 50 * time.Millisecond,
+		RetryPeriod:   10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if now != nil {
+		le.now = func() time.Time { return *now }
+	}
+	return le
+}
+
+func TestNewLeaderElector(t *testing.T) {
+	d := newTestLeaseDAO()
+	_, err := NewLeaderElector(d, LeaderElectionConfig{Name: "broker"})
+	ft.AssertNotNil(t, err)
+	_, err = NewLeaderElector(d, LeaderElectionConfig{Name: "broker", Identity: "a", RenewDeadline: time.Minute})
+	ft.AssertNotNil(t, err)
+
+	le, err := NewLeaderElector(d, LeaderElectionConfig{Name: "broker", Identity: "a"})
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, DefaultLeaseDuration, le.config.LeaseDuration)
+	ft.AssertEqual(t, DefaultRenewDeadline, le.config.RenewDeadline)
+	ft.AssertEqual(t, DefaultRetryPeriod, le.config.RetryPeriod)
+	ft.AssertEqual(t, DefaultLeaseDuration-DefaultRenewDeadline, le.Grace())
+}
+
+func TestLeaderElectionTakeOver(t *testing.T) {
+	d := newTestLeaseDAO()
+	now := time.Now()
+	a := newTestElector(t, d, "a", &now)
+	b := newTestElector(t, d, "b", &now)
+
+	ft.AssertTrue(t, a.tryAcquireOrRenew())
+	ft.AssertFalse(t, b.tryAcquireOrRenew())
+	ft.AssertEqual(t, "a", b.Leader())
+
+	// a keeps the lease as long as it renews it.
+	now = now.Add(80 * time.Millisecond)
+	ft.AssertTrue(t, a.tryAcquireOrRenew())
+	now = now.Add(80 * time.Millisecond)
+	ft.AssertFalse(t, b.tryAcquireOrRenew())
+
+	// b takes the lease over once it has not changed for its duration.
+	now = now.Add(100 * time.Millisecond)
+	ft.AssertTrue(t, b.tryAcquireOrRenew())
+	ft.AssertFalse(t, a.tryAcquireOrRenew())
+	ft.AssertEqual(t, "b", a.Leader())
+
+	lease, _, err := d.GetLease("broker")
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "b", lease.Holder)
+	ft.AssertEqual(t, 1, lease.Transitions)
+	ft.AssertTrue(t, lease.Acquired.Equal(now))
+}
+
+func TestLeaderElectionRelease(t *testing.T) {
+	d := newTestLeaseDAO()
+	a := newTestElector(t, d, "a", nil)
+	b := newTestElector(t, d, "b", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leading := make(chan context.Context, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- a.Run(ctx, func(leaderCtx context.Context) { leading <- leaderCtx })
+	}()
+	leaderCtx := <-leading
+	ft.AssertTrue(t, a.IsLeader())
+	ft.AssertFalse(t, b.tryAcquireOrRenew())
+
+	cancel()
+	ft.AssertNil(t, <-done)
+	<-leaderCtx.Done()
+	ft.AssertFalse(t, a.IsLeader())
+	// the released lease is taken over without waiting for it to expire.
+	ft.AssertTrue(t, b.tryAcquireOrRenew())
+}
+
+// failingLeaseDAO - fails to write the lease once failing is set.
+type failingLeaseDAO struct {
+	*testLeaseDAO
+	failing chan struct{}
+}
+
+func (f failingLeaseDAO) CompareAndSetLease(lease types.Lease, version string) (string, error) {
+	select {
+	case <-f.failing:
+		return "", errors.New("dao unavailable")
+	default:
+		return f.testLeaseDAO.CompareAndSetLease(lease, version)
+	}
+}
+
+func TestLeaderElectionLost(t *testing.T) {
+	d := newTestLeaseDAO()
+	failing := make(chan struct{})
+	a := newTestElector(t, failingLeaseDAO{testLeaseDAO: d, failing: failing}, "a", nil)
+
+	leading := make(chan context.Context, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- a.Run(context.Background(), func(leaderCtx context.Context) { leading <- leaderCtx })
+	}()
+	leaderCtx := <-leading
+	close(failing)
+
+	ft.AssertEqual(t, ErrLeaseLost, <-done)
+	<-leaderCtx.Done()
+	ft.AssertFalse(t, a.IsLeader())
+}
@@ -0,0 +1,177 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/runtime"
+	log "github.com/sirupsen/logrus"
+	kapierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// RetryPolicy - how the work engine retries a job whose work failed with a
+// retryable error.
+type RetryPolicy struct {
+	// MaxAttempts is how many times the work runs at most, 1 or less never
+	// retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each retry
+	// after it.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between two attempts, 0 does not.
+	MaxBackoff time.Duration
+}
+
+// RetryableWork - work overriding the retry policy of the work engine.
+type RetryableWork interface {
+	RetryPolicy(defaults RetryPolicy) RetryPolicy
+}
+
+// retryMetadataKey - the key of the spec and plan metadata overriding the
+// retry policy, for example:
+//
+//	metadata:
+//	  retry:
+//	    max_attempts: 5
+//	    backoff: 30s
+//	    max_backoff: 5m
+const retryMetadataKey = "retry"
+
+// delay - how long to wait after the failed attempt before the next one.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+// overrideRetryPolicy - the policy with the settings found under the retry
+// key of the metadata. Invalid settings are logged and ignored.
+func overrideRetryPolicy(policy RetryPolicy, metadata map[string]interface{}) RetryPolicy {
+	var retry map[string]interface{}
+	switch m := metadata[retryMetadataKey].(type) {
+	case map[string]interface{}:
+		retry = m
+	case map[interface{}]interface{}:
+		// metadata decoded from yaml.
+		retry = map[string]interface{}{}
+		for k, v := range m {
+			if key, ok := k.(string); ok {
+				retry[key] = v
+			}
+		}
+	default:
+		return policy
+	}
+	switch attempts := retry["max_attempts"].(type) {
+	case nil:
+	case int:
+		policy.MaxAttempts = attempts
+	case float64:
+		policy.MaxAttempts = int(attempts)
+	default:
+		log.Warningf("Ignoring retry max_attempts %v, it is not a number", attempts)
+	}
+	policy.Backoff = metadataDuration(retry, "backoff", policy.Backoff)
+	policy.MaxBackoff = metadataDuration(retry, "max_backoff", policy.MaxBackoff)
+	return policy
+}
+
+// metadataDuration - the duration of the key, or d when the key is not set.
+func metadataDuration(retry map[string]interface{}, key string, d time.Duration) time.Duration {
+	value, ok := retry[key]
+	if !ok {
+		return d
+	}
+	s, ok := value.(string)
+	if !ok {
+		log.Warningf("Ignoring retry %s %v, it is not a duration", key, value)
+		return d
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		log.Warningf("Ignoring retry %s - %v", key, err)
+		return d
+	}
+	return parsed
+}
+
+// transientErrors - the messages of the errors from talking to the cluster
+// that the runtime wraps without keeping their type.
+var transientErrors = []string{
+	"i/o timeout",
+	"connection refused",
+	"connection reset",
+	"TLS handshake timeout",
+	"the server was unable to return a response in the time allotted",
+	"the server is currently unable to handle the request",
+}
+
+// isRetryableError - whether the job failed because of a transient problem
+// running the bundle rather than because of the bundle itself. The bundle
+// failing, with a message of its own or not, is not retried.
+func isRetryableError(err error) bool {
+	if err == nil || runtime.IsErrorCustomMsg(err) || err == runtime.ErrorActionNotFound {
+		return false
+	}
+	if err == runtime.ErrorPodPullErr {
+		return true
+	}
+	if kapierrors.IsServerTimeout(err) || kapierrors.IsTimeout(err) ||
+		kapierrors.IsTooManyRequests(err) || kapierrors.IsServiceUnavailable(err) ||
+		kapierrors.IsInternalError(err) {
+		return true
+	}
+	if netErr, ok := err.(net.Error); ok && (netErr.Timeout() || netErr.Temporary()) {
+		return true
+	}
+	for _, transient := range transientErrors {
+		if strings.Contains(err.Error(), transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryMetadata - the metadata of the spec and of the plan of the service
+// instance, in the order they override the retry policy.
+func retryMetadata(si *bundle.ServiceInstance) []map[string]interface{} {
+	if si.Spec == nil {
+		return nil
+	}
+	metadata := []map[string]interface{}{si.Spec.Metadata}
+	if si.Parameters == nil {
+		return metadata
+	}
+	if name, ok := (*si.Parameters)[planParameterKey].(string); ok {
+		if plan, ok := si.Spec.GetPlan(name); ok {
+			metadata = append(metadata, plan.Metadata)
+		}
+	}
+	return metadata
+}
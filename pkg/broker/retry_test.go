@@ -0,0 +1,73 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/runtime"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	ft.AssertEqual(t, time.Second, policy.delay(1))
+	ft.AssertEqual(t, 2*time.Second, policy.delay(2))
+	ft.AssertEqual(t, 4*time.Second, policy.delay(3))
+	ft.AssertEqual(t, 5*time.Second, policy.delay(4))
+	ft.AssertEqual(t, 5*time.Second, policy.delay(40))
+}
+
+func TestIsRetryableError(t *testing.T) {
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{err: runtime.ErrorPodPullErr, retryable: true},
+		{err: errors.New("dial tcp 172.30.0.1:443: i/o timeout"), retryable: true},
+		{err: runtime.ErrorActionNotFound},
+		{err: runtime.ErrorCustomMsg{}},
+		{err: fmt.Errorf("Pod [ bundle-1 ] failed with exit code [2]")},
+	}
+	for _, tc := range cases {
+		ft.AssertEqual(t, tc.retryable, isRetryableError(tc.err), fmt.Sprintf("%v", tc.err))
+	}
+}
+
+func TestApbJobRetryPolicy(t *testing.T) {
+	si := &bundle.ServiceInstance{
+		Spec: &bundle.Spec{
+			Metadata: map[string]interface{}{
+				"retry": map[string]interface{}{"max_attempts": float64(5), "backoff": "1m"},
+			},
+			Plans: []bundle.Plan{{
+				Name: "dev",
+				Metadata: map[string]interface{}{
+					"retry": map[interface{}]interface{}{"max_attempts": 2, "max_backoff": "nope"},
+				},
+			}},
+		},
+		Parameters: &bundle.Parameters{planParameterKey: "dev"},
+	}
+	job := &apbJob{retryMetadata: retryMetadata(si)}
+	policy := job.RetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Second, MaxBackoff: time.Hour})
+	ft.AssertEqual(t, RetryPolicy{MaxAttempts: 2, Backoff: time.Minute, MaxBackoff: time.Hour}, policy)
+}
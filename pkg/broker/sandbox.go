@@ -0,0 +1,109 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/clients"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// sandboxPod - the pod the executor runs the bundle in, recorded on the
+// running job as the executor reports it.
+type sandboxPod struct {
+	lock      sync.Mutex
+	name      string
+	namespace string
+}
+
+// setName - records the name of the pod reported by the executor.
+func (p *sandboxPod) setName(name string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if name != "" && name != p.name {
+		p.name = name
+		p.namespace = ""
+	}
+}
+
+// get - the name and namespace of the pod, empty until they are known.
+func (p *sandboxPod) get() (string, string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.name, p.namespace
+}
+
+// setNamespace - records the namespace of the pod once it is resolved.
+func (p *sandboxPod) setNamespace(name string, namespace string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.name == name {
+		p.namespace = namespace
+	}
+}
+
+// terminateSandboxPod - terminates the pod the executor reported running the
+// action of the bundle for the service instance. The pod runs in the sandbox
+// namespace the executor creates for it, labelled with the name of the pod,
+// or in the namespace of the service instance when the executor does not
+// create one. Deleting the pod fails the action, after which the executor
+// destroys the sandbox as usual.
+func terminateSandboxPod(si *bundle.ServiceInstance) terminateFn {
+	return func(pod *sandboxPod) (bool, error) {
+		name, namespace := pod.get()
+		if name == "" {
+			return false, nil
+		}
+		k8scli, err := clients.Kubernetes()
+		if err != nil {
+			return false, err
+		}
+		if namespace == "" {
+			selector := labels.SelectorFromSet(labels.Set{"bundle-pod-name": name})
+			namespaces, err := k8scli.Client.CoreV1().Namespaces().List(
+				metav1.ListOptions{LabelSelector: selector.String()})
+			if err != nil {
+				return false, err
+			}
+			switch len(namespaces.Items) {
+			case 0:
+				namespace = instanceNamespace(si)
+			case 1:
+				namespace = namespaces.Items[0].Name
+			default:
+				return false, fmt.Errorf("found %d sandbox namespaces of pod %s", len(namespaces.Items), name)
+			}
+			pod.setNamespace(name, namespace)
+		}
+
+		log.Infof("Terminating pod [ %s ] in namespace [ %s ]", name, namespace)
+		err = k8scli.Client.CoreV1().Pods(namespace).Delete(name, &metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			// the pod is not created yet.
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/automationbroker/bundle-lib/bundle"
 	schema "github.com/lestrrat/go-jsschema"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
 	"github.com/pborman/uuid"
 	log "github.com/sirupsen/logrus"
 	authv1 "k8s.io/api/authentication/v1"
@@ -296,6 +297,8 @@ type JobMsg struct {
 	DashboardURL         string                      `json:"dashboard_url"`
 	BindingUUID          string                      `json:"binding_uuid"`
 	Error                string                      `json:"error"`
+	// Retryable is set on a failure the work engine may retry.
+	Retryable bool `json:"retryable,omitempty"`
 }
 
 // Render - Display the job message.
@@ -307,11 +310,19 @@ func (jm JobMsg) Render() string {
 // SubscriberDAO defines the interface subscribers use when persisting state
 type SubscriberDAO interface {
 	SetState(id string, state bundle.JobState) (string, error)
+	GetStateVersion(id string, token string) (bundle.JobState, string, error)
+	CompareAndSetState(id string, state bundle.JobState, version string) (string, error)
 	GetServiceInstance(id string) (*bundle.ServiceInstance, error)
 	DeleteServiceInstance(id string) error
 	GetBindInstance(id string) (*bundle.BindInstance, error)
 	DeleteBinding(bundle.BindInstance, bundle.ServiceInstance) error
 	SetServiceInstance(id string, serviceInstance *bundle.ServiceInstance) error
+	GetServiceInstanceVersion(id string) (*bundle.ServiceInstance, string, error)
+	CompareAndSetServiceInstance(id string, serviceInstance *bundle.ServiceInstance, version string) (string, error)
+	IsConflictError(err error) bool
+	IsNotFoundError(err error) bool
+	Apply(batch types.Batch) error
+	CompleteIntent(id string) error
 }
 
 // WorkSubscriber - Defines how a Subscriber can be notified of changes
@@ -320,6 +331,23 @@ type WorkSubscriber interface {
 	Notify(msg JobMsg)
 }
 
+// ReliableWorkSubscriber - A subscriber reporting whether it handled the
+// message. The work engine delivers the messages through Deliver rather than
+// Notify, and retries the messages it fails to handle.
+type ReliableWorkSubscriber interface {
+	WorkSubscriber
+	Deliver(msg JobMsg) error
+}
+
+// ReplayResponse - The dead letters replayed through the admin route.
+type ReplayResponse struct {
+	Replayed int `json:"replayed"`
+	Left     int `json:"left"`
+	// Superseded counts the dead letters skipped because the job state moved
+	// on past their message.
+	Superseded int `json:"superseded"`
+}
+
 // WorkFactory is a factory for creating Work items
 type WorkFactory interface {
 	NewProvisionJob(si *bundle.ServiceInstance) Work
@@ -580,7 +580,7 @@ func StateToLastOperation(state bundle.State) LastOperationState {
 		return LastOperationStateInProgress
 	case bundle.StateSucceeded:
 		return LastOperationStateSucceeded
-	case bundle.StateFailed:
+	case bundle.StateFailed, StateCancelled:
 		return LastOperationStateFailed
 	default:
 		return LastOperationStateFailed
@@ -0,0 +1,193 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// WebhookSignatureHeader - the header carrying the hex encoded HMAC-SHA256
+	// of the body, keyed by the secret of the webhook, as sha256=<hmac>.
+	WebhookSignatureHeader = "X-Broker-Signature"
+	// webhookIDPrefix - the prefix of the subscriber ID of a webhook,
+	// followed by its name.
+	webhookIDPrefix = "webhook-"
+	// defaultWebhookTimeout - how long a webhook has to answer when its
+	// config does not say.
+	defaultWebhookTimeout = 5 * time.Second
+	// defaultWebhookQueueSize - how many messages wait to be posted when the
+	// config of the webhook does not say.
+	defaultWebhookQueueSize = 100
+)
+
+// WebhookConfig - an endpoint the job messages are posted to.
+type WebhookConfig struct {
+	Name string
+	URL  string
+	// States and Methods filter the messages posted by the state and method
+	// of their job, empty posts them all.
+	States  []bundle.State
+	Methods []bundle.JobMethod
+	// Secret signs the messages posted when set.
+	Secret []byte
+	// Timeout is how long the endpoint has to answer.
+	Timeout time.Duration
+	// MaxAttempts overrides how many times a message is posted before it is
+	// stored as a dead letter, 0 keeps the delivery policy of the engine.
+	MaxAttempts int
+	// QueueSize is how many messages wait to be posted, the messages beyond
+	// it are stored as dead letters right away.
+	QueueSize int
+}
+
+// WebhookSubscriber - posts the job messages, without their credentials, to
+// an endpoint. It is a queued subscriber, the messages are posted by a worker
+// of their own so that the endpoint is never waited on by the jobs.
+type WebhookSubscriber struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+// NewWebhookSubscriber - creates a new webhook subscriber.
+func NewWebhookSubscriber(config WebhookConfig) (*WebhookSubscriber, error) {
+	if config.Name == "" || config.URL == "" {
+		return nil, fmt.Errorf("a webhook needs a name and a url")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultWebhookTimeout
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultWebhookQueueSize
+	}
+	return &WebhookSubscriber{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+// ID is used as an identifier for the type of subscriber
+func (ws *WebhookSubscriber) ID() string {
+	return webhookIDPrefix + ws.config.Name
+}
+
+// Notify posts the message to the endpoint.
+func (ws *WebhookSubscriber) Notify(msg JobMsg) {
+	if err := ws.Deliver(msg); err != nil {
+		log.Errorf("Error webhook %s failed to post the message of job %s : %v", ws.config.Name, msg.JobToken, err)
+	}
+}
+
+// Deliver posts the message to the endpoint once, unless it is filtered out.
+// An endpoint that does not answer with a 2xx status fails the delivery.
+func (ws *WebhookSubscriber) Deliver(msg JobMsg) error {
+	if !ws.accepts(msg) {
+		return nil
+	}
+	body, err := webhookBody(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, ws.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(ws.config.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+WebhookSignature(ws.config.Secret, body))
+	}
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", ws.config.Name, resp.Status)
+	}
+	log.Debugf("Posted the message of job %s to webhook %s", msg.JobToken, ws.config.Name)
+	return nil
+}
+
+// DeliveryPolicy - the policy of the engine with the timeout and the attempts
+// of the webhook. The engine waits a little longer than the request timeout
+// so that a request timing out is reported as such.
+func (ws *WebhookSubscriber) DeliveryPolicy(defaults DeliveryPolicy) DeliveryPolicy {
+	defaults.Timeout = ws.config.Timeout + time.Second
+	if ws.config.MaxAttempts > 0 {
+		defaults.Retry.MaxAttempts = ws.config.MaxAttempts
+	}
+	return defaults
+}
+
+// QueueSize - how many messages wait to be posted.
+func (ws *WebhookSubscriber) QueueSize() int {
+	return ws.config.QueueSize
+}
+
+// accepts - whether the message passes the filters of the webhook.
+func (ws *WebhookSubscriber) accepts(msg JobMsg) bool {
+	if len(ws.config.States) > 0 {
+		found := false
+		for _, state := range ws.config.States {
+			found = found || state == msg.State.State
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(ws.config.Methods) > 0 {
+		found := false
+		for _, method := range ws.config.Methods {
+			found = found || method == msg.State.Method
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// WebhookSignature - the hex encoded HMAC-SHA256 of the body keyed by the
+// secret, which receivers compute to check the signature header.
+func WebhookSignature(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBody - the JSON rendering of the message without its credentials.
+func webhookBody(msg JobMsg) ([]byte, error) {
+	message, err := messageMap(msg)
+	if err != nil {
+		return nil, err
+	}
+	delete(message, "extracted_credentials")
+	return json.Marshal(message)
+}
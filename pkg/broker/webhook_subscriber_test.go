@@ -0,0 +1,114 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package broker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+type webhookRequest struct {
+	body      []byte
+	signature string
+}
+
+func newWebhookServer(t *testing.T, status int) (*httptest.Server, chan webhookRequest) {
+	requests := make(chan webhookRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		requests <- webhookRequest{body: body, signature: r.Header.Get(WebhookSignatureHeader)}
+		w.WriteHeader(status)
+	}))
+	return server, requests
+}
+
+func TestNewWebhookSubscriber(t *testing.T) {
+	_, err := NewWebhookSubscriber(WebhookConfig{Name: "cmdb"})
+	ft.AssertNotNil(t, err)
+	ws, err := NewWebhookSubscriber(WebhookConfig{Name: "cmdb", URL: "http://localhost", MaxAttempts: 5})
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "webhook-cmdb", ws.ID())
+	ft.AssertEqual(t, defaultWebhookQueueSize, ws.QueueSize())
+
+	policy := ws.DeliveryPolicy(DeliveryPolicy{Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Second}})
+	ft.AssertEqual(t, defaultWebhookTimeout+time.Second, policy.Timeout)
+	ft.AssertEqual(t, 5, policy.Retry.MaxAttempts)
+	ft.AssertEqual(t, time.Second, policy.Retry.Backoff)
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	server, requests := newWebhookServer(t, http.StatusOK)
+	defer server.Close()
+	ws, _ := NewWebhookSubscriber(WebhookConfig{Name: "cmdb", URL: server.URL, Secret: []byte("secret")})
+
+	msg := JobMsg{
+		InstanceUUID:         "instance",
+		JobToken:             "token",
+		State:                bundle.JobState{State: bundle.StateSucceeded, Method: bundle.JobMethodBind},
+		ExtractedCredentials: bundle.ExtractedCredentials{Credentials: map[string]interface{}{"password": "hunter2"}},
+	}
+	ft.AssertNil(t, ws.Deliver(msg))
+
+	req := <-requests
+	ft.AssertEqual(t, "sha256="+WebhookSignature([]byte("secret"), req.body), req.signature)
+	posted := map[string]interface{}{}
+	ft.AssertNil(t, json.Unmarshal(req.body, &posted))
+	ft.AssertEqual(t, "token", posted["job_token"])
+	ft.AssertEqual(t, "instance", posted["instance_uuid"])
+	_, ok := posted["extracted_credentials"]
+	ft.AssertFalse(t, ok, "the credentials were posted")
+}
+
+func TestWebhookDeliverFailure(t *testing.T) {
+	server, requests := newWebhookServer(t, http.StatusServiceUnavailable)
+	defer server.Close()
+	ws, _ := NewWebhookSubscriber(WebhookConfig{Name: "cmdb", URL: server.URL})
+
+	ft.AssertNotNil(t, ws.Deliver(JobMsg{State: bundle.JobState{State: bundle.StateFailed}}))
+	req := <-requests
+	ft.AssertEqual(t, "", req.signature)
+}
+
+func TestWebhookFilters(t *testing.T) {
+	server, requests := newWebhookServer(t, http.StatusOK)
+	defer server.Close()
+	ws, _ := NewWebhookSubscriber(WebhookConfig{
+		Name:    "chat",
+		URL:     server.URL,
+		States:  []bundle.State{bundle.StateSucceeded, bundle.StateFailed},
+		Methods: []bundle.JobMethod{bundle.JobMethodProvision},
+	})
+
+	ft.AssertNil(t, ws.Deliver(JobMsg{State: bundle.JobState{State: bundle.StateInProgress, Method: bundle.JobMethodProvision}}))
+	ft.AssertNil(t, ws.Deliver(JobMsg{State: bundle.JobState{State: bundle.StateFailed, Method: bundle.JobMethodBind}}))
+	ft.AssertNil(t, ws.Deliver(JobMsg{JobToken: "posted", State: bundle.JobState{State: bundle.StateFailed, Method: bundle.JobMethodProvision}}))
+
+	ft.AssertEqual(t, 1, len(requests))
+	posted := map[string]interface{}{}
+	ft.AssertNil(t, json.Unmarshal((<-requests).body, &posted))
+	ft.AssertEqual(t, "posted", posted["job_token"])
+}
@@ -19,19 +19,63 @@ package broker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/automationbroker/bundle-lib/bundle"
 	"github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/openshift/ansible-service-broker/pkg/metrics"
 	"github.com/pborman/uuid"
 	log "github.com/sirupsen/logrus"
 	"sync"
 	"time"
 )
 
-// Work - is the interface that wraps the basic run method.
+// QueuedDescription - the description of the job state of a job waiting in
+// the work engine queue for the concurrency limits to let it run.
+const QueuedDescription = "queued"
+
+// StateCancelled - the state of a job cancelled through the work engine. The
+// last operation of a cancelled job is reported as failed.
+const StateCancelled = types.StateCancelled
+
+// drainPollInterval - how often Drain checks whether the running jobs have
+// finished.
+const drainPollInterval = 100 * time.Millisecond
+
+// ErrJobNotFound - the job is neither running nor queued in this work engine.
+var ErrJobNotFound = errors.New("job not found")
+
+// Work - is the interface that wraps the basic run method. Run should stop
+// once the context is done.
 type Work interface {
 	ID() string
 	Method() bundle.JobMethod
-	Run(token string, msgBuffer chan<- JobMsg)
+	Run(ctx context.Context, token string, msgBuffer chan<- JobMsg)
+}
+
+// DurableWork - work stored as a job record from the moment it is accepted
+// until it has run, so that it is started again after a restart.
+type DurableWork interface {
+	// JobRecord - the record of the work, the engine sets its token, topic
+	// and creation time.
+	JobRecord() types.JobRecord
+}
+
+// NamespacedWork - work run for a namespace, it counts against the per
+// namespace concurrency limit.
+type NamespacedWork interface {
+	Namespace() string
+}
+
+// WorkLimits - how many jobs the work engine runs at once. A limit of 0 is
+// unlimited.
+type WorkLimits struct {
+	// Global limits the jobs of every topic together.
+	Global int
+	// Topics limits the jobs of each topic.
+	Topics map[WorkTopic]int
+	// Namespace limits the jobs run for each namespace.
+	Namespace int
 }
 
 // WorkEngine - a new engine for doing work.
@@ -42,6 +86,50 @@ type WorkEngine struct {
 	// the number of seconds given to each subscriber to complete its task
 	subscriberTimeout time.Duration
 	dao               dao.Dao
+	// queue is shared by the copies of the engine, like the maps above.
+	queue *workQueue
+}
+
+// workQueue - the jobs waiting for the concurrency limits to let them run,
+// and the running jobs the limits apply to.
+type workQueue struct {
+	// lock guards the job channels of the engine as well.
+	lock    sync.Mutex
+	limits  WorkLimits
+	jobs    []*queuedJob
+	running jobCounts
+	// cancels holds the function cancelling each running job by token.
+	cancels map[string]context.CancelFunc
+	retry   RetryPolicy
+	// delivery is how the job messages are delivered to the subscribers.
+	delivery DeliveryPolicy
+	// deadLetters holds the ids of the dead letters stored while the broker
+	// runs, by subscriber and job, until a later message of the job is
+	// handled.
+	deadLetters map[string][]string
+	// subscriberQueues holds the queue of each queued subscriber by
+	// subscriber ID.
+	subscriberQueues map[string]chan queuedMsg
+	// draining holds back every job from running once the engine is
+	// draining.
+	draining bool
+}
+
+// queuedJob - a job waiting for the concurrency limits to let it run.
+type queuedJob struct {
+	token     string
+	work      Work
+	topic     WorkTopic
+	namespace string
+	// done is closed once the job has run.
+	done chan struct{}
+}
+
+// jobCounts - the number of running jobs the concurrency limits apply to.
+type jobCounts struct {
+	total      int
+	topics     map[WorkTopic]int
+	namespaces map[string]int
 }
 
 // NewWorkEngine - creates a new work engine
@@ -51,7 +139,52 @@ func NewWorkEngine(bufferSize int, subscriberTimeout time.Duration, dao dao.Dao)
 		subscribers:       map[WorkTopic][]WorkSubscriber{},
 		jobBufferSize:     bufferSize,
 		subscriberTimeout: subscriberTimeout,
-		dao:               dao}
+		dao:               dao,
+		queue: &workQueue{
+			running: jobCounts{
+				topics:     map[WorkTopic]int{},
+				namespaces: map[string]int{},
+			},
+			cancels:          map[string]context.CancelFunc{},
+			deadLetters:      map[string][]string{},
+			subscriberQueues: map[string]chan queuedMsg{},
+		}}
+}
+
+// SetLimits - sets how many jobs the engine runs at once, the jobs beyond the
+// limits wait in a queue in the order they were started.
+func (engine *WorkEngine) SetLimits(limits WorkLimits) {
+	engine.queue.lock.Lock()
+	defer engine.queue.lock.Unlock()
+	engine.queue.limits = limits
+	engine.dispatch()
+}
+
+// SetRetryPolicy - sets how the jobs whose work failed with a retryable error
+// are run again. Work implementing RetryableWork may override it.
+func (engine *WorkEngine) SetRetryPolicy(policy RetryPolicy) {
+	engine.queue.lock.Lock()
+	defer engine.queue.lock.Unlock()
+	engine.queue.retry = policy
+}
+
+// retryPolicy - the retry policy of the work.
+func (engine *WorkEngine) retryPolicy(work Work) RetryPolicy {
+	engine.queue.lock.Lock()
+	policy := engine.queue.retry
+	engine.queue.lock.Unlock()
+	if rw, ok := work.(RetryableWork); ok {
+		return rw.RetryPolicy(policy)
+	}
+	return policy
+}
+
+// QueueDepth - the number of jobs waiting for the concurrency limits to let
+// them run.
+func (engine *WorkEngine) QueueDepth() int {
+	engine.queue.lock.Lock()
+	defer engine.queue.lock.Unlock()
+	return len(engine.queue.jobs)
 }
 
 // StartNewAsyncJob - Starts a job in an new goroutine, reporting to a specific topic.
@@ -66,80 +199,353 @@ func (engine *WorkEngine) StartNewAsyncJob(
 	if token == "" {
 		token = engine.Token()
 	}
-	if err := engine.setupJob(token, work); err != nil {
+	job, started, err := engine.submit(token, work, topic)
+	if err != nil {
 		return token, err
 	}
-	go engine.runJob(token, work, topic)
+	if started {
+		go engine.run(job)
+	}
 
 	return token, nil
 }
 
-func waitForNotify(ctx context.Context, sub WorkSubscriber, msg JobMsg, signal chan<- struct{}) {
-	sub.Notify(msg)
-	// avoid sending on a closed channel if the context is done
-	select {
-	case <-ctx.Done():
-		return
-	default:
-		signal <- struct{}{}
+func (engine *WorkEngine) setupJob(token string, work Work, description string) error {
+	if _, err := engine.dao.SetState(work.ID(), bundle.JobState{Token: token, State: bundle.StateNotYetStarted, Method: work.Method(), Description: description}); err != nil {
+		return err
 	}
+	return nil
 }
 
-func (engine *WorkEngine) setupJob(token string, work Work) error {
-	if _, err := engine.dao.SetState(work.ID(), bundle.JobState{Token: token, State: bundle.StateNotYetStarted, Method: work.Method()}); err != nil {
+// submit - sets up the job and either reserves its place within the
+// concurrency limits, in which case the caller must run it, or queues it.
+func (engine *WorkEngine) submit(token string, work Work, topic WorkTopic) (*queuedJob, bool, error) {
+	job := &queuedJob{token: token, work: work, topic: topic, done: make(chan struct{})}
+	if nw, ok := work.(NamespacedWork); ok {
+		job.namespace = nw.Namespace()
+	}
+
+	// the jobs already queued are never runnable once the queue has been
+	// dispatched, so starting a job that fits keeps them in order.
+	engine.queue.lock.Lock()
+	started := engine.fits(job)
+	if started {
+		engine.acquire(job)
+	}
+	engine.queue.lock.Unlock()
+
+	description := ""
+	if !started {
+		description = QueuedDescription
+	}
+	err := engine.storeJob(job)
+	if err == nil {
+		err = engine.setupJob(token, work, description)
+	}
+	if err != nil {
+		if started {
+			engine.release(job)
+		}
+		engine.forgetJob(job)
+		return nil, false, err
+	}
+	if started {
+		return job, true, nil
+	}
+
+	log.Infof("Concurrency limits reached, queueing %s job %v", work.Method(), token)
+	engine.queue.lock.Lock()
+	engine.queue.jobs = append(engine.queue.jobs, job)
+	engine.dispatch()
+	engine.queue.lock.Unlock()
+	return job, false, nil
+}
+
+// run - runs a job whose place within the limits is reserved, then lets the
+// next queued jobs run.
+func (engine *WorkEngine) run(job *queuedJob) {
+	defer engine.release(job)
+	ctx, cancel := context.WithCancel(context.Background())
+	engine.queue.lock.Lock()
+	engine.queue.cancels[job.token] = cancel
+	engine.queue.lock.Unlock()
+	defer func() {
+		engine.queue.lock.Lock()
+		delete(engine.queue.cancels, job.token)
+		engine.queue.lock.Unlock()
+		cancel()
+	}()
+	engine.runJob(ctx, job.token, job.work, job.topic)
+	engine.forgetJob(job)
+}
+
+// storeJob - stores the record of durable work before the job is accepted.
+func (engine *WorkEngine) storeJob(job *queuedJob) error {
+	dw, ok := job.work.(DurableWork)
+	if !ok {
+		return nil
+	}
+	record := dw.JobRecord()
+	record.Token = job.token
+	record.Topic = string(job.topic)
+	record.Created = time.Now()
+	if err := engine.dao.SetJobRecord(record); err != nil {
+		log.Errorf("Unable to store the record of %s job %v - %v", job.work.Method(), job.token, err)
 		return err
 	}
 	return nil
 }
 
-func (engine *WorkEngine) runJob(token string, work Work, topic WorkTopic) {
+// forgetJob - deletes the record of durable work once the job has run, or
+// will not run.
+func (engine *WorkEngine) forgetJob(job *queuedJob) {
+	if _, ok := job.work.(DurableWork); !ok {
+		return
+	}
+	if err := engine.dao.DeleteJobRecord(job.token); err != nil {
+		log.Errorf("Unable to delete the record of %s job %v, it will run again on restart - %v",
+			job.work.Method(), job.token, err)
+	}
+}
+
+// hasJob - whether the job of the token is running or queued.
+func (engine *WorkEngine) hasJob(token string) bool {
+	engine.queue.lock.Lock()
+	defer engine.queue.lock.Unlock()
+	if _, ok := engine.queue.cancels[token]; ok {
+		return true
+	}
+	for _, job := range engine.queue.jobs {
+		if job.token == token {
+			return true
+		}
+	}
+	return false
+}
+
+// CancelJob - cancels the job of the token. A queued job is removed from the
+// queue and its state set to cancelled right away, a running job is asked to
+// stop and reports its own state once it has. Returns ErrJobNotFound when the
+// job is not running or queued in this engine.
+func (engine *WorkEngine) CancelJob(token string) error {
+	engine.queue.lock.Lock()
+	if cancel, ok := engine.queue.cancels[token]; ok {
+		engine.queue.lock.Unlock()
+		log.Infof("Cancelling running job %v", token)
+		cancel()
+		return nil
+	}
+	var job *queuedJob
+	for i, queued := range engine.queue.jobs {
+		if queued.token == token {
+			job = queued
+			engine.queue.jobs = append(engine.queue.jobs[:i], engine.queue.jobs[i+1:]...)
+			break
+		}
+	}
+	if job == nil {
+		engine.queue.lock.Unlock()
+		return ErrJobNotFound
+	}
+	metrics.WorkQueueDepth(len(engine.queue.jobs))
+	engine.queue.lock.Unlock()
+
+	log.Infof("Cancelling queued %s job %v", job.work.Method(), token)
+	defer close(job.done)
+	defer engine.forgetJob(job)
+	_, err := engine.dao.SetState(job.work.ID(), bundle.JobState{
+		Token:       token,
+		State:       StateCancelled,
+		Method:      job.work.Method(),
+		Description: fmt.Sprintf("%s job cancelled before it started", job.work.Method()),
+	})
+	return err
+}
+
+// Drain - stops starting jobs and waits up to timeout for the running jobs to
+// finish, so that another broker can take over the work. The jobs submitted
+// from then on, and the ones already queued, stay queued for the broker
+// resuming their records. Returns the number of jobs still running.
+func (engine *WorkEngine) Drain(timeout time.Duration) int {
+	engine.queue.lock.Lock()
+	engine.queue.draining = true
+	engine.queue.lock.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		engine.queue.lock.Lock()
+		running := engine.queue.running.total
+		engine.queue.lock.Unlock()
+		if running == 0 || !time.Now().Before(deadline) {
+			return running
+		}
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// fits - whether the job can run within the limits. The caller must hold the
+// lock.
+func (engine *WorkEngine) fits(job *queuedJob) bool {
+	if engine.queue.draining {
+		return false
+	}
+	limits := engine.queue.limits
+	if limits.Global > 0 && engine.queue.running.total >= limits.Global {
+		return false
+	}
+	if limit := limits.Topics[job.topic]; limit > 0 && engine.queue.running.topics[job.topic] >= limit {
+		return false
+	}
+	if limits.Namespace > 0 && job.namespace != "" && engine.queue.running.namespaces[job.namespace] >= limits.Namespace {
+		return false
+	}
+	return true
+}
+
+// acquire - counts the job as running. The caller must hold the lock.
+func (engine *WorkEngine) acquire(job *queuedJob) {
+	engine.queue.running.total++
+	engine.queue.running.topics[job.topic]++
+	if job.namespace != "" {
+		engine.queue.running.namespaces[job.namespace]++
+	}
+}
+
+// release - counts the job as finished and starts the queued jobs that now
+// fit.
+func (engine *WorkEngine) release(job *queuedJob) {
+	engine.queue.lock.Lock()
+	defer engine.queue.lock.Unlock()
+	engine.queue.running.total--
+	engine.queue.running.topics[job.topic]--
+	if job.namespace != "" {
+		engine.queue.running.namespaces[job.namespace]--
+		if engine.queue.running.namespaces[job.namespace] == 0 {
+			delete(engine.queue.running.namespaces, job.namespace)
+		}
+	}
+	close(job.done)
+	engine.dispatch()
+}
+
+// dispatch - starts the queued jobs that fit within the limits in the order
+// they were queued. A job held back by the limit of its topic or namespace
+// does not hold back the jobs behind it that fit. The caller must hold the
+// lock.
+func (engine *WorkEngine) dispatch() {
+	waiting := engine.queue.jobs[:0]
+	for _, job := range engine.queue.jobs {
+		if !engine.fits(job) {
+			waiting = append(waiting, job)
+			continue
+		}
+		engine.acquire(job)
+		log.Debugf("Starting queued %s job %v", job.work.Method(), job.token)
+		go engine.run(job)
+	}
+	for i := len(waiting); i < len(engine.queue.jobs); i++ {
+		engine.queue.jobs[i] = nil
+	}
+	engine.queue.jobs = waiting
+	metrics.WorkQueueDepth(len(engine.queue.jobs))
+}
+
+func (engine *WorkEngine) runJob(ctx context.Context, token string, work Work, topic WorkTopic) {
 	// create a channel specifically for use with this job
 	jobChannel := make(chan JobMsg, engine.jobBufferSize)
+	engine.queue.lock.Lock()
 	engine.jobChannels[token] = jobChannel
+	engine.queue.lock.Unlock()
+	// closed once the subscribers have been notified of every message
+	notified := make(chan struct{})
 	// ensure we always clean up
 	defer func() {
 		log.Debugf("closing channel for job %v", token)
-		close(jobChannel)
+		engine.queue.lock.Lock()
 		delete(engine.jobChannels, token)
+		engine.queue.lock.Unlock()
+		close(jobChannel)
+		<-notified
 	}()
 
 	go func() {
+		defer close(notified)
 		// listen for a new message for the job keyed to this token and hand off to the subscribers async.
 		// Wait for them all to be done before accepting the next message
 		for msg := range jobChannel {
 			wg := &sync.WaitGroup{}
 			// hand off the msg to all subscribers async
 			for _, sub := range engine.subscribers[topic] {
+				// the queued subscribers are handed the msg without waiting
+				if _, ok := sub.(QueuedSubscriber); ok {
+					engine.enqueue(token, topic, sub, msg)
+					continue
+				}
 				wg.Add(1)
 				go func(msg JobMsg, sub WorkSubscriber) {
-					// ensure things don't get locked up.
-					// Each subscriber has up to the configured amount of time to complete its action
-					ctx, cancel := context.WithTimeout(context.Background(), engine.subscriberTimeout*time.Second)
-					// used to tell us when the subscribers notify method is completed
-					notifySignal := make(chan struct{})
-					// If our subscriber times out or returns normally we will always clean up
-					defer func() {
-						wg.Done()
-						close(notifySignal)
-						cancel()
-					}()
-					// notify the subscriber
-					go waitForNotify(ctx, sub, msg, notifySignal)
-					// act on whichever happens first the subscriber's notify method completing or the timeout
-					select {
-					case <-notifySignal:
-						return
-					case <-ctx.Done():
-						log.Errorf("Subscriber %s timeout %v ", sub.ID(), ctx.Err())
-						return
-					}
+					defer wg.Done()
+					// Each subscriber has up to the configured amount of time to
+					// complete its action, for each attempt of the delivery
+					engine.deliver(token, topic, sub, msg)
 				}(msg, sub)
 			}
 			// ensure we wait until all subs are done before taking on the next message
 			wg.Wait()
 		}
 	}()
-	work.Run(token, jobChannel)
+	engine.runAttempts(ctx, token, work, jobChannel)
+}
+
+// runAttempts - runs the work until it does not fail with a retryable error or
+// the attempts of its retry policy are used up. The failures retried are
+// reported in progress, so the job stays in progress under the same token, and
+// the messages of the attempts after the first one carry the attempt count.
+func (engine *WorkEngine) runAttempts(ctx context.Context, token string, work Work, jobChannel chan<- JobMsg) {
+	policy := engine.retryPolicy(work)
+	for attempt := 1; ; attempt++ {
+		attemptChannel := make(chan JobMsg, engine.jobBufferSize)
+		go func() {
+			defer close(attemptChannel)
+			work.Run(ctx, token, attemptChannel)
+		}()
+
+		var failure *JobMsg
+		for msg := range attemptChannel {
+			if msg.State.State == bundle.StateFailed && msg.Retryable && attempt < policy.MaxAttempts {
+				failed := msg
+				failure = &failed
+				continue
+			}
+			if policy.MaxAttempts > 1 && attempt > 1 {
+				msg.State.Description = fmt.Sprintf("%s (attempt %d of %d)", msg.State.Description, attempt, policy.MaxAttempts)
+			}
+			jobChannel <- msg
+		}
+		if failure == nil {
+			return
+		}
+
+		delay := policy.delay(attempt)
+		log.Warningf("Attempt %d of %d of %s job %v failed, retrying in %v - %s",
+			attempt, policy.MaxAttempts, work.Method(), token, delay, failure.State.Error)
+		metrics.JobRetried(string(work.Method()))
+		retrying := *failure
+		retrying.State.State = bundle.StateInProgress
+		retrying.State.Error = ""
+		retrying.Retryable = false
+		retrying.State.Description = fmt.Sprintf("attempt %d of %d failed, retrying in %v: %s",
+			attempt, policy.MaxAttempts, delay, failure.State.Description)
+		jobChannel <- retrying
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			failure.State.State = StateCancelled
+			failure.State.Description = fmt.Sprintf("%s job cancelled by an administrator", work.Method())
+			jobChannel <- *failure
+			return
+		}
+	}
 }
 
 // StartNewSyncJob - Starts a job and waits for it to finish, reporting to a specific topic.
@@ -153,10 +559,14 @@ func (engine *WorkEngine) StartNewSyncJob(
 	if token == "" {
 		token = engine.Token()
 	}
-	if err := engine.setupJob(token, work); err != nil {
+	job, started, err := engine.submit(token, work, topic)
+	if err != nil {
 		return err
 	}
-	engine.runJob(token, work, topic)
+	if started {
+		engine.run(job)
+	}
+	<-job.done
 	return nil
 }
 
@@ -174,6 +584,9 @@ func (engine *WorkEngine) AttachSubscriber(
 		return errors.New("invalid work topic")
 	}
 
+	if qs, ok := subscriber.(QueuedSubscriber); ok {
+		engine.startQueue(subscriber, qs.QueueSize())
+	}
 	engine.subscribers[topic] = append(engine.subscribers[topic], subscriber)
 
 	return nil
@@ -181,7 +594,13 @@ func (engine *WorkEngine) AttachSubscriber(
 
 // GetActiveJobChannels - Get list of active jobs
 func (engine *WorkEngine) GetActiveJobChannels() map[string]chan JobMsg {
-	return engine.jobChannels
+	engine.queue.lock.Lock()
+	defer engine.queue.lock.Unlock()
+	channels := make(map[string]chan JobMsg, len(engine.jobChannels))
+	for token, ch := range engine.jobChannels {
+		channels[token] = ch
+	}
+	return channels
 }
 
 // GetSubscribers - Get list of subscribers to a topic
@@ -17,14 +17,18 @@
 package broker
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/automationbroker/bundle-lib/bundle"
 	"github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
 
 	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	tmock "github.com/stretchr/testify/mock"
 )
 
 var engine *WorkEngine
@@ -58,7 +62,7 @@ type mockWorker struct {
 	wg     *sync.WaitGroup
 }
 
-func (mw *mockWorker) Run(token string, buffer chan<- JobMsg) {
+func (mw *mockWorker) Run(ctx context.Context, token string, buffer chan<- JobMsg) {
 	mw.called = true
 	buffer <- JobMsg{Msg: "hello"}
 	mw.wg.Done()
@@ -92,7 +96,7 @@ type mockWork struct {
 	funcToCall func(msg chan<- JobMsg)
 }
 
-func (mw *mockWork) Run(token string, msgBuffer chan<- JobMsg) {
+func (mw *mockWork) Run(ctx context.Context, token string, msgBuffer chan<- JobMsg) {
 	mw.funcToCall(msgBuffer)
 }
 
@@ -154,6 +158,8 @@ func TestStartNewJob(t *testing.T) {
 			},
 			ConfigureMock: func() {
 				mockDao.On("SetState", "id", bundle.JobState{Token: testToken, State: "not yet started", Podname: "", Method: "bind", Error: "", Description: ""}).Return(testToken, nil)
+				// the message that timed out is stored as a dead letter
+				mockDao.On("SetDeadLetter", tmock.Anything).Return(nil)
 			},
 			Subscribers: func(wg *sync.WaitGroup) []WorkSubscriber {
 				wg.Add(2)
@@ -197,10 +203,256 @@ func TestStartNewJob(t *testing.T) {
 				t.Fatal("test timed out !!")
 			case <-done(wg):
 				// check out channel is gone
-				if _, ok := engine.jobChannels[testToken]; ok {
+				if _, ok := engine.GetActiveJobChannels()[testToken]; ok {
 					t.Fatal("there should be no job channel present")
 				}
 			}
 		})
 	}
 }
+
+type mockNamespacedWork struct {
+	mockWork
+	namespace string
+}
+
+func (mw *mockNamespacedWork) Namespace() string {
+	return mw.namespace
+}
+
+func TestWorkLimits(t *testing.T) {
+	d := &dao.MockDao{}
+	d.On("SetState", tmock.Anything, tmock.Anything).Return("", nil)
+	engine := NewWorkEngine(10, 1, d)
+	engine.SetLimits(WorkLimits{Global: 2, Namespace: 1})
+
+	release := map[string]chan struct{}{}
+	started := make(chan string, 3)
+	work := func(name string, namespace string) Work {
+		release[name] = make(chan struct{})
+		return &mockNamespacedWork{
+			mockWork: mockWork{funcToCall: func(msg chan<- JobMsg) {
+				started <- name
+				<-release[name]
+			}},
+			namespace: namespace,
+		}
+	}
+	first, second, third := work("first", "a"), work("second", "a"), work("third", "b")
+
+	engine.StartNewAsyncJob("1", first, ProvisionTopic)
+	ft.AssertEqual(t, "first", <-started)
+	// the second job waits for the first in the same namespace, the third
+	// one is not held back by it.
+	engine.StartNewAsyncJob("2", second, ProvisionTopic)
+	engine.StartNewAsyncJob("3", third, ProvisionTopic)
+	ft.AssertEqual(t, "third", <-started)
+	ft.AssertEqual(t, 1, engine.QueueDepth())
+	d.AssertCalled(t, "SetState", "id", bundle.JobState{Token: "2", State: bundle.StateNotYetStarted, Method: bundle.JobMethodBind, Description: QueuedDescription})
+
+	close(release["first"])
+	ft.AssertEqual(t, "second", <-started)
+	ft.AssertEqual(t, 0, engine.QueueDepth())
+	close(release["second"])
+	close(release["third"])
+}
+
+func TestWorkLimitsSyncJob(t *testing.T) {
+	d := &dao.MockDao{}
+	d.On("SetState", tmock.Anything, tmock.Anything).Return("", nil)
+	engine := NewWorkEngine(10, 1, d)
+	engine.SetLimits(WorkLimits{Topics: map[WorkTopic]int{ProvisionTopic: 1}})
+
+	release := make(chan struct{})
+	engine.StartNewAsyncJob("1", &mockWork{funcToCall: func(msg chan<- JobMsg) { <-release }}, ProvisionTopic)
+
+	done := make(chan struct{})
+	go func() {
+		engine.StartNewSyncJob("2", &mockWork{funcToCall: func(msg chan<- JobMsg) {}}, ProvisionTopic)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("the sync job should wait for the running job")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the sync job did not run once the running job finished")
+	}
+}
+
+type cancellableWork struct {
+	mockWork
+	started chan struct{}
+}
+
+func (cw *cancellableWork) Run(ctx context.Context, token string, msgBuffer chan<- JobMsg) {
+	close(cw.started)
+	<-ctx.Done()
+}
+
+func TestCancelJob(t *testing.T) {
+	d := &dao.MockDao{}
+	d.On("SetState", tmock.Anything, tmock.Anything).Return("", nil)
+	engine := NewWorkEngine(10, 1, d)
+	engine.SetLimits(WorkLimits{Global: 1})
+
+	running := &cancellableWork{started: make(chan struct{})}
+	engine.StartNewAsyncJob("1", running, ProvisionTopic)
+	<-running.started
+	queued := &cancellableWork{started: make(chan struct{})}
+	engine.StartNewAsyncJob("2", queued, ProvisionTopic)
+	ft.AssertEqual(t, 1, engine.QueueDepth())
+
+	// the queued job is cancelled without ever running.
+	ft.AssertNil(t, engine.CancelJob("2"))
+	ft.AssertEqual(t, 0, engine.QueueDepth())
+	d.AssertCalled(t, "SetState", "id", bundle.JobState{Token: "2", State: StateCancelled, Method: bundle.JobMethodBind, Description: "bind job cancelled before it started"})
+
+	ft.AssertNil(t, engine.CancelJob("1"))
+	done := make(chan struct{})
+	go func() {
+		engine.StartNewSyncJob("3", &mockWork{funcToCall: func(msg chan<- JobMsg) {}}, ProvisionTopic)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the cancelled job did not stop")
+	}
+	select {
+	case <-queued.started:
+		t.Fatal("the cancelled queued job ran")
+	default:
+	}
+
+	ft.AssertEqual(t, ErrJobNotFound, engine.CancelJob("1"))
+}
+
+func TestRetryJob(t *testing.T) {
+	d := &dao.MockDao{}
+	d.On("SetState", tmock.Anything, tmock.Anything).Return("", nil)
+	engine := NewWorkEngine(10, 1, d)
+	engine.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+
+	notified := make(chan JobMsg, 10)
+	sub := &mockSubscriber{funcToCall: func(msg JobMsg) { notified <- msg }}
+	engine.AttachSubscriber(sub, UpdateTopic)
+
+	attempts := 0
+	work := &mockWork{funcToCall: func(msg chan<- JobMsg) {
+		attempts++
+		if attempts < 3 {
+			msg <- JobMsg{Retryable: true, State: bundle.JobState{State: bundle.StateFailed, Description: "failed", Error: "i/o timeout"}}
+			return
+		}
+		msg <- JobMsg{State: bundle.JobState{State: bundle.StateSucceeded, Description: "done"}}
+	}}
+	ft.AssertNil(t, engine.StartNewSyncJob("token", work, UpdateTopic))
+	ft.AssertEqual(t, 3, attempts)
+
+	messages := []JobMsg{}
+	for len(messages) < 3 {
+		select {
+		case msg := <-notified:
+			messages = append(messages, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("expected 3 job messages, got %d", len(messages))
+		}
+	}
+	ft.AssertEqual(t, bundle.StateInProgress, messages[0].State.State)
+	ft.AssertEqual(t, "attempt 1 of 3 failed, retrying in 1ms: failed", messages[0].State.Description)
+	ft.AssertEqual(t, "attempt 2 of 3 failed, retrying in 2ms: failed", messages[1].State.Description)
+	ft.AssertEqual(t, bundle.StateSucceeded, messages[2].State.State)
+	ft.AssertEqual(t, "done (attempt 3 of 3)", messages[2].State.Description)
+}
+
+func TestRetryJobNotRetryable(t *testing.T) {
+	d := &dao.MockDao{}
+	d.On("SetState", tmock.Anything, tmock.Anything).Return("", nil)
+	engine := NewWorkEngine(10, 1, d)
+	engine.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+
+	attempts := 0
+	work := &mockWork{funcToCall: func(msg chan<- JobMsg) {
+		attempts++
+		msg <- JobMsg{State: bundle.JobState{State: bundle.StateFailed}}
+	}}
+	ft.AssertNil(t, engine.StartNewSyncJob("token", work, UpdateTopic))
+	ft.AssertEqual(t, 1, attempts)
+}
+
+type durableWork struct {
+	mockWork
+}
+
+func (dw *durableWork) JobRecord() types.JobRecord {
+	return types.JobRecord{Method: dw.Method()}
+}
+
+func TestDurableJob(t *testing.T) {
+	d := &dao.MockDao{}
+	d.On("SetState", tmock.Anything, tmock.Anything).Return("", nil)
+	d.On("SetJobRecord", tmock.Anything).Return(nil)
+	d.On("DeleteJobRecord", "token").Return(nil)
+	engine := NewWorkEngine(10, 1, d)
+
+	work := &durableWork{mockWork{funcToCall: func(msg chan<- JobMsg) {}}}
+	ft.AssertNil(t, engine.StartNewSyncJob("token", work, ProvisionTopic))
+
+	record := d.Calls[0].Arguments.Get(0).(types.JobRecord)
+	ft.AssertEqual(t, "SetJobRecord", d.Calls[0].Method)
+	ft.AssertEqual(t, "token", record.Token)
+	ft.AssertEqual(t, ProvisionTopic, WorkTopic(record.Topic))
+	ft.AssertEqual(t, bundle.JobMethodBind, record.Method)
+	ft.AssertFalse(t, record.Created.IsZero())
+	d.AssertCalled(t, "DeleteJobRecord", "token")
+	ft.AssertFalse(t, engine.hasJob("token"))
+}
+
+func TestDurableJobNotStored(t *testing.T) {
+	d := &dao.MockDao{}
+	d.On("SetJobRecord", tmock.Anything).Return(fmt.Errorf("unavailable"))
+	d.On("DeleteJobRecord", "token").Return(nil)
+	engine := NewWorkEngine(10, 1, d)
+
+	ran := false
+	work := &durableWork{mockWork{funcToCall: func(msg chan<- JobMsg) { ran = true }}}
+	_, err := engine.StartNewAsyncJob("token", work, ProvisionTopic)
+	ft.AssertNotNil(t, err)
+	ft.AssertFalse(t, ran)
+	d.AssertNotCalled(t, "SetState", tmock.Anything, tmock.Anything)
+}
+
+func TestDrain(t *testing.T) {
+	d := &dao.MockDao{}
+	d.On("SetState", tmock.Anything, tmock.Anything).Return("", nil)
+	engine := NewWorkEngine(10, 1, d)
+
+	release := make(chan struct{})
+	started := make(chan string, 2)
+	work := func(name string) Work {
+		return &mockWork{funcToCall: func(msg chan<- JobMsg) {
+			started <- name
+			<-release
+		}}
+	}
+	engine.StartNewAsyncJob("running", work("running"), ProvisionTopic)
+	ft.AssertEqual(t, "running", <-started)
+
+	// the running job is given the timeout to finish.
+	ft.AssertEqual(t, 1, engine.Drain(10*time.Millisecond))
+
+	// the jobs submitted while draining are left queued.
+	engine.StartNewAsyncJob("queued", work("queued"), ProvisionTopic)
+	ft.AssertEqual(t, 1, engine.QueueDepth())
+
+	close(release)
+	ft.AssertEqual(t, 0, engine.Drain(time.Second))
+	ft.AssertEqual(t, 1, engine.QueueDepth())
+	ft.AssertEqual(t, 0, len(started))
+	ft.AssertTrue(t, engine.hasJob("queued"))
+}
@@ -0,0 +1,135 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package backup exports the broker state to an archive and restores it into
+// any dao backend.
+package backup
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+)
+
+// Version - the archive format written by this broker. Archives written by
+// older brokers are read as well.
+const Version = 1
+
+// Archive - a snapshot of the broker state.
+type Archive struct {
+	Version  int
+	Created  time.Time
+	Contents Contents
+}
+
+// Contents - the records in an archive.
+type Contents struct {
+	Specs                []*bundle.Spec            `json:"specs"`
+	ServiceInstances     []*bundle.ServiceInstance `json:"service_instances"`
+	BindInstances        []*bundle.BindInstance    `json:"bind_instances"`
+	JobStates            []JobState                `json:"job_states"`
+	ExtractedCredentials []Credentials             `json:"extracted_credentials"`
+}
+
+// JobState - a job state along with the id of the service instance or
+// binding it belongs to.
+type JobState struct {
+	ID    string          `json:"id"`
+	State bundle.JobState `json:"state"`
+}
+
+// Credentials - the extracted credentials of a service instance or binding,
+// as they are stored.
+type Credentials struct {
+	ID          string                 `json:"id"`
+	Action      string                 `json:"action"`
+	Credentials map[string]interface{} `json:"credentials"`
+}
+
+// file - how an archive is written. The checksum covers the contents exactly
+// as they were written.
+type file struct {
+	Version  int             `json:"version"`
+	Created  time.Time       `json:"created"`
+	Checksum string          `json:"checksum"`
+	Contents json.RawMessage `json:"contents"`
+}
+
+// ChecksumError - the contents of an archive do not match its checksum.
+type ChecksumError struct {
+	Expected string
+	Actual   string
+}
+
+func (e ChecksumError) Error() string {
+	return fmt.Sprintf("archive checksum is %s, expected %s", e.Actual, e.Expected)
+}
+
+// Write - writes the archive gzip compressed to w.
+func (a *Archive) Write(w io.Writer) error {
+	contents, err := json.Marshal(a.Contents)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	err = json.NewEncoder(gz).Encode(file{
+		Version:  a.Version,
+		Created:  a.Created,
+		Checksum: checksum(contents),
+		Contents: contents,
+	})
+	if err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// Read - reads an archive written by Write, checking its version and
+// checksum.
+func Read(r io.Reader) (*Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	f := file{}
+	if err := json.NewDecoder(gz).Decode(&f); err != nil {
+		return nil, fmt.Errorf("unable to read the archive - %v", err)
+	}
+	if f.Version < 1 || f.Version > Version {
+		return nil, fmt.Errorf("archive version %d is not supported, the latest is %d", f.Version, Version)
+	}
+	if sum := checksum(f.Contents); sum != f.Checksum {
+		return nil, ChecksumError{Expected: f.Checksum, Actual: sum}
+	}
+	a := &Archive{Version: f.Version, Created: f.Created}
+	if err := json.Unmarshal(f.Contents, &a.Contents); err != nil {
+		return nil, fmt.Errorf("unable to read the archive contents - %v", err)
+	}
+	return a, nil
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,204 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backup
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/runtime"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	"github.com/openshift/ansible-service-broker/pkg/dao/migration"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	actionProvision = "provision"
+	actionBind      = "bind"
+)
+
+// Export - reads every record of d, and the extracted credentials of its
+// instances and bindings from creds in namespace ns, into an archive. The
+// credentials are left out when creds is nil.
+func Export(d dao.Dao, creds runtime.ExtractedCredential, ns string) (*Archive, error) {
+	c := Contents{}
+	err := dao.ForEachSpecPage(d, dao.DefaultPageSize, func(specs []*bundle.Spec) error {
+		c.Specs = append(c.Specs, specs...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = dao.ForEachServiceInstancePage(d, dao.DefaultPageSize, func(instances []*bundle.ServiceInstance) error {
+		c.ServiceInstances = append(c.ServiceInstances, instances...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.BindInstances, err = d.BatchGetBindInstances(); err != nil {
+		return nil, err
+	}
+	states, err := d.BatchGetJobStates()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range states {
+		c.JobStates = append(c.JobStates, JobState{ID: s.ID, State: s.State})
+	}
+
+	if creds != nil {
+		export := func(id, action string) error {
+			data, err := creds.GetExtractedCredential(id, ns)
+			if err == runtime.ErrCredentialsNotFound {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			c.ExtractedCredentials = append(c.ExtractedCredentials, Credentials{ID: id, Action: action, Credentials: data})
+			return nil
+		}
+		for _, si := range c.ServiceInstances {
+			if err := export(si.ID.String(), actionProvision); err != nil {
+				return nil, err
+			}
+		}
+		for _, bi := range c.BindInstances {
+			if err := export(bi.ID.String(), actionBind); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	log.Infof("Exported [ %d ] specs, [ %d ] service instances, [ %d ] bind instances, [ %d ] job states and [ %d ] extracted credentials",
+		len(c.Specs), len(c.ServiceInstances), len(c.BindInstances), len(c.JobStates), len(c.ExtractedCredentials))
+	return &Archive{Version: Version, Created: time.Now().UTC(), Contents: c}, nil
+}
+
+// Restore - writes the records of the archive to d, and its extracted
+// credentials to creds in namespace ns unless creds is nil. Records that are
+// different in d are handled as onConflict says, with Fail nothing is written
+// when any of them is.
+func Restore(a *Archive, d dao.Dao, creds runtime.ExtractedCredential, ns string,
+	onConflict migration.ConflictMode) (migration.Report, error) {
+
+	source, err := a.load()
+	if err != nil {
+		return migration.Report{}, err
+	}
+	if creds != nil && onConflict == migration.Fail {
+		if err := a.checkCredentialConflicts(creds, ns); err != nil {
+			return migration.Report{}, err
+		}
+	}
+
+	m := &migration.Migrator{Source: source, Target: d, OnConflict: onConflict}
+	report, err := m.Run()
+	if err != nil || creds == nil {
+		return report, err
+	}
+
+	for _, c := range a.Contents.ExtractedCredentials {
+		current, err := creds.GetExtractedCredential(c.ID, ns)
+		switch {
+		case err == runtime.ErrCredentialsNotFound:
+			err = creds.CreateExtractedCredential(c.ID, ns, c.Credentials, a.credentialLabels(c))
+			if err == nil {
+				report.Created++
+			}
+		case err != nil:
+		case reflect.DeepEqual(current, c.Credentials):
+			report.Skipped++
+		case onConflict == migration.Skip:
+			log.Infof("Skipping extracted credentials %s, they are different", c.ID)
+			report.Skipped++
+		default:
+			err = creds.UpdateExtractedCredential(c.ID, ns, c.Credentials, a.credentialLabels(c))
+			if err == nil {
+				report.Updated++
+			}
+		}
+		if err != nil {
+			log.Errorf("Unable to restore extracted credentials %s - %v", c.ID, err)
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// load - a memory dao holding the records of the archive.
+func (a *Archive) load() (dao.Dao, error) {
+	d, err := memory.NewDao()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range a.Contents.Specs {
+		if err := d.SetSpec(s.ID, s); err != nil {
+			return nil, err
+		}
+	}
+	for _, si := range a.Contents.ServiceInstances {
+		if err := d.SetServiceInstance(si.ID.String(), si); err != nil {
+			return nil, err
+		}
+	}
+	for _, bi := range a.Contents.BindInstances {
+		if err := d.SetBindInstance(bi.ID.String(), bi); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range a.Contents.JobStates {
+		if _, err := d.SetState(s.ID, s.State); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func (a *Archive) checkCredentialConflicts(creds runtime.ExtractedCredential, ns string) error {
+	keys := []string{}
+	for _, c := range a.Contents.ExtractedCredentials {
+		current, err := creds.GetExtractedCredential(c.ID, ns)
+		if err == runtime.ErrCredentialsNotFound {
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(current, c.Credentials) {
+			keys = append(keys, "extracted_credentials/"+c.ID)
+		}
+	}
+	if len(keys) > 0 {
+		return migration.ConflictError{Keys: keys}
+	}
+	return nil
+}
+
+// credentialLabels - the labels the broker puts on the secret of the
+// credentials.
+func (a *Archive) credentialLabels(c Credentials) map[string]string {
+	labels := map[string]string{"apbAction": c.Action}
+	if c.Action != actionProvision {
+		return labels
+	}
+	for _, si := range a.Contents.ServiceInstances {
+		if si.ID.String() == c.ID && si.Spec != nil {
+			labels["apbName"] = si.Spec.FQName
+		}
+	}
+	return labels
+}
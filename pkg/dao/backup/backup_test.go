@@ -0,0 +1,139 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"testing"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/runtime"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	"github.com/openshift/ansible-service-broker/pkg/dao/migration"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+// fakeCredentials - keeps extracted credentials in a map keyed by id.
+type fakeCredentials map[string]map[string]interface{}
+
+func (f fakeCredentials) CreateExtractedCredential(id, ns string, creds map[string]interface{}, labels map[string]string) error {
+	f[id] = creds
+	return nil
+}
+
+func (f fakeCredentials) UpdateExtractedCredential(id, ns string, creds map[string]interface{}, labels map[string]string) error {
+	f[id] = creds
+	return nil
+}
+
+func (f fakeCredentials) GetExtractedCredential(id, ns string) (map[string]interface{}, error) {
+	creds, ok := f[id]
+	if !ok {
+		return nil, runtime.ErrCredentialsNotFound
+	}
+	return creds, nil
+}
+
+func (f fakeCredentials) DeleteExtractedCredential(id, ns string) error {
+	delete(f, id)
+	return nil
+}
+
+func newTestArchive(t *testing.T) (*Archive, string) {
+	d, _ := memory.NewDao()
+	spec := &bundle.Spec{ID: "spec-1", FQName: "test-apb"}
+	d.SetSpec(spec.ID, spec)
+	id := uuid.NewRandom()
+	d.SetServiceInstance(id.String(), &bundle.ServiceInstance{ID: id, Spec: spec})
+	d.SetState(id.String(), bundle.JobState{Token: "token-1", State: bundle.StateSucceeded})
+	creds := fakeCredentials{id.String(): {"password": "secret"}}
+
+	a, err := Export(d, creds, "ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, id.String()
+}
+
+func TestWriteRead(t *testing.T) {
+	a, _ := newTestArchive(t)
+	buf := &bytes.Buffer{}
+	ft.AssertNil(t, a.Write(buf))
+
+	read, err := Read(bytes.NewReader(buf.Bytes()))
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, Version, read.Version)
+	ft.AssertEqual(t, 1, len(read.Contents.Specs))
+	ft.AssertEqual(t, 1, len(read.Contents.ServiceInstances))
+	ft.AssertEqual(t, 1, len(read.Contents.JobStates))
+	ft.AssertEqual(t, "secret", read.Contents.ExtractedCredentials[0].Credentials["password"])
+}
+
+func TestReadTampered(t *testing.T) {
+	a, _ := newTestArchive(t)
+	buf := &bytes.Buffer{}
+	a.Write(buf)
+
+	gz, _ := gzip.NewReader(buf)
+	f := file{}
+	json.NewDecoder(gz).Decode(&f)
+	f.Contents = bytes.Replace(f.Contents, []byte("test-apb"), []byte("evil-apb"), -1)
+	tampered := &bytes.Buffer{}
+	w := gzip.NewWriter(tampered)
+	json.NewEncoder(w).Encode(f)
+	w.Close()
+
+	_, err := Read(tampered)
+	_, ok := err.(ChecksumError)
+	ft.AssertTrue(t, ok)
+}
+
+func TestRestore(t *testing.T) {
+	a, id := newTestArchive(t)
+	d, _ := memory.NewDao()
+	creds := fakeCredentials{}
+
+	report, err := Restore(a, d, creds, "ns", migration.Fail)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, migration.Report{Created: 4}, report)
+	si, err := d.GetServiceInstance(id)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "test-apb", si.Spec.FQName)
+	ft.AssertEqual(t, "secret", creds[id]["password"])
+
+	// the records changed since the export are conflicts.
+	d.SetSpec("spec-1", &bundle.Spec{ID: "spec-1", FQName: "newer-apb"})
+	_, err = Restore(a, d, creds, "ns", migration.Fail)
+	conflict, ok := err.(migration.ConflictError)
+	ft.AssertTrue(t, ok)
+	ft.AssertEqual(t, "spec/spec-1", conflict.Keys[0])
+
+	report, err = Restore(a, d, creds, "ns", migration.Skip)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, migration.Report{Skipped: 4}, report)
+	spec, _ := d.GetSpec("spec-1")
+	ft.AssertEqual(t, "newer-apb", spec.FQName)
+
+	report, err = Restore(a, d, creds, "ns", migration.Overwrite)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, migration.Report{Updated: 1, Skipped: 3}, report)
+	spec, _ = d.GetSpec("spec-1")
+	ft.AssertEqual(t, "test-apb", spec.FQName)
+}
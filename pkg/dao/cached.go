@@ -0,0 +1,364 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/hashicorp/golang-lru"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/openshift/ansible-service-broker/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	cacheKindSpec            = "spec"
+	cacheKindSpecList        = "spec_list"
+	cacheKindServiceInstance = "service_instance"
+
+	defaultCacheTTL = 30 * time.Second
+)
+
+// CachedDao - wraps a Dao and keeps the specs and service instances it reads
+// in LRU caches. Entries are dropped when they expire, when they are written
+// through the CachedDao and, when watching, when anyone else writes them.
+// Reads that are about to be written back, like GetServiceInstanceVersion,
+// always go to the wrapped Dao.
+type CachedDao struct {
+	Dao
+	ttl time.Duration
+	now func() time.Time
+
+	specs     *lru.Cache
+	specLists *lru.Cache
+	instances *lru.Cache
+
+	// the generations are bumped on every invalidation so a read that
+	// raced with a write does not put the old record back in the cache.
+	lock               sync.Mutex
+	specGeneration     uint64
+	instanceGeneration uint64
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+type specPage struct {
+	specs []*bundle.Spec
+	next  string
+}
+
+// NewCachedDao - creates a Dao caching up to size specs, spec listings and
+// service instances each for ttl. A ttl of 0 keeps them until they are
+// evicted or invalidated.
+func NewCachedDao(d Dao, size int, ttl time.Duration) (*CachedDao, error) {
+	specs, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	specLists, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &CachedDao{
+		Dao:       d,
+		ttl:       ttl,
+		now:       time.Now,
+		specs:     specs,
+		specLists: specLists,
+		instances: instances,
+	}, nil
+}
+
+// InvalidateOnWatch - drops the cached records that are changed by anyone,
+// including other brokers sharing the data store, until stop is closed.
+func (c *CachedDao) InvalidateOnWatch(stop <-chan struct{}) error {
+	events, err := c.Dao.Watch(stop)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for e := range events {
+			switch e.Kind {
+			case types.KindSpec:
+				c.invalidateSpecs()
+			case types.KindServiceInstance:
+				c.invalidateInstance(e.ID)
+			}
+		}
+		select {
+		case <-stop:
+		default:
+			// without the watch nothing tells the cache about changes
+			// made elsewhere anymore.
+			log.Warning("The dao watch stopped, the dao cache only expires records from now on")
+			c.invalidateSpecs()
+			c.invalidateInstances()
+		}
+	}()
+	return nil
+}
+
+// GetSpec - Retrieve the spec from the cache or the wrapped Dao.
+func (c *CachedDao) GetSpec(id string) (*bundle.Spec, error) {
+	spec := &bundle.Spec{}
+	if c.get(c.specs, cacheKindSpec, id, spec) {
+		return spec, nil
+	}
+	generation := c.generation(&c.specGeneration)
+	spec, err := c.Dao.GetSpec(id)
+	if err != nil {
+		return nil, err
+	}
+	c.add(c.specs, &c.specGeneration, generation, id, spec)
+	return spec, nil
+}
+
+// BatchGetSpecs - Retrieve all the specs for dir from the cache or the
+// wrapped Dao.
+func (c *CachedDao) BatchGetSpecs(dir string) ([]*bundle.Spec, error) {
+	key := "batch" + dir
+	if page, ok := c.getSpecPage(key); ok {
+		return page.specs, nil
+	}
+	generation := c.generation(&c.specGeneration)
+	specs, err := c.Dao.BatchGetSpecs(dir)
+	if err != nil {
+		return nil, err
+	}
+	c.add(c.specLists, &c.specGeneration, generation, key, specPage{specs: specs})
+	return specs, nil
+}
+
+// GetSpecsPage - Retrieve a page of specs from the cache or the wrapped Dao.
+func (c *CachedDao) GetSpecsPage(continueToken string, limit int) ([]*bundle.Spec, string, error) {
+	key := fmt.Sprintf("page/%d/%s", limit, continueToken)
+	if page, ok := c.getSpecPage(key); ok {
+		return page.specs, page.next, nil
+	}
+	generation := c.generation(&c.specGeneration)
+	specs, next, err := c.Dao.GetSpecsPage(continueToken, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	c.add(c.specLists, &c.specGeneration, generation, key, specPage{specs: specs, next: next})
+	return specs, next, nil
+}
+
+// SetSpec - Set the spec and drop the cached specs.
+func (c *CachedDao) SetSpec(id string, spec *bundle.Spec) error {
+	defer c.invalidateSpecs()
+	return c.Dao.SetSpec(id, spec)
+}
+
+// DeleteSpec - Delete the spec and drop the cached specs.
+func (c *CachedDao) DeleteSpec(id string) error {
+	defer c.invalidateSpecs()
+	return c.Dao.DeleteSpec(id)
+}
+
+// BatchSetSpecs - Set the specs and drop the cached specs.
+func (c *CachedDao) BatchSetSpecs(specs bundle.SpecManifest) error {
+	defer c.invalidateSpecs()
+	return c.Dao.BatchSetSpecs(specs)
+}
+
+// BatchDeleteSpecs - Delete the specs and drop the cached specs.
+func (c *CachedDao) BatchDeleteSpecs(specs []*bundle.Spec) error {
+	defer c.invalidateSpecs()
+	return c.Dao.BatchDeleteSpecs(specs)
+}
+
+// GetServiceInstance - Retrieve the service instance from the cache or the
+// wrapped Dao.
+func (c *CachedDao) GetServiceInstance(id string) (*bundle.ServiceInstance, error) {
+	si := &bundle.ServiceInstance{}
+	if c.get(c.instances, cacheKindServiceInstance, id, si) {
+		return si, nil
+	}
+	generation := c.generation(&c.instanceGeneration)
+	si, err := c.Dao.GetServiceInstance(id)
+	if err != nil {
+		return nil, err
+	}
+	c.add(c.instances, &c.instanceGeneration, generation, id, si)
+	return si, nil
+}
+
+// SetServiceInstance - Set the service instance and drop its cached copy.
+func (c *CachedDao) SetServiceInstance(id string, si *bundle.ServiceInstance) error {
+	defer c.invalidateInstance(id)
+	return c.Dao.SetServiceInstance(id, si)
+}
+
+// CompareAndSetServiceInstance - Set the service instance if it is still at
+// version and drop its cached copy.
+func (c *CachedDao) CompareAndSetServiceInstance(id string, si *bundle.ServiceInstance, version string) (string, error) {
+	defer c.invalidateInstance(id)
+	return c.Dao.CompareAndSetServiceInstance(id, si, version)
+}
+
+// DeleteServiceInstance - Delete the service instance and drop its cached
+// copy.
+func (c *CachedDao) DeleteServiceInstance(id string) error {
+	defer c.invalidateInstance(id)
+	return c.Dao.DeleteServiceInstance(id)
+}
+
+// DeleteBinding - Delete the binding and drop the cached copy of the service
+// instance it is removed from.
+func (c *CachedDao) DeleteBinding(bi bundle.BindInstance, si bundle.ServiceInstance) error {
+	defer c.invalidateInstance(si.ID.String())
+	return c.Dao.DeleteBinding(bi, si)
+}
+
+// Apply - Apply the batch and drop the cached copies of the service instances
+// it writes.
+func (c *CachedDao) Apply(batch types.Batch) error {
+	defer func() {
+		for _, op := range batch.Ops {
+			switch op.Type {
+			case types.OpSetServiceInstance, types.OpDeleteServiceInstance, types.OpRemoveBinding:
+				c.invalidateInstance(op.ID)
+			}
+		}
+	}()
+	return c.Dao.Apply(batch)
+}
+
+// get - copies the unexpired cached value of key into out, the caller gets
+// its own copy to change as it likes.
+func (c *CachedDao) get(cache *lru.Cache, kind string, key string, out interface{}) bool {
+	value, ok := cache.Get(key)
+	if ok {
+		entry := value.(cacheEntry)
+		if c.ttl > 0 && c.now().After(entry.expires) {
+			cache.Remove(key)
+		} else if err := copyRecord(entry.value, out); err == nil {
+			metrics.DAOCacheHit(kind)
+			return true
+		}
+	}
+	metrics.DAOCacheMiss(kind)
+	return false
+}
+
+func (c *CachedDao) getSpecPage(key string) (specPage, bool) {
+	value, ok := c.specLists.Get(key)
+	if ok {
+		entry := value.(cacheEntry)
+		if c.ttl > 0 && c.now().After(entry.expires) {
+			c.specLists.Remove(key)
+		} else if specs, err := copySpecs(entry.value.(specPage).specs); err == nil {
+			metrics.DAOCacheHit(cacheKindSpecList)
+			return specPage{specs: specs, next: entry.value.(specPage).next}, true
+		}
+	}
+	metrics.DAOCacheMiss(cacheKindSpecList)
+	return specPage{}, false
+}
+
+// add - caches a copy of value unless the cache was invalidated since the
+// value was read at generation.
+func (c *CachedDao) add(cache *lru.Cache, current *uint64, generation uint64, key string, value interface{}) {
+	var stored interface{}
+	var err error
+	switch v := value.(type) {
+	case specPage:
+		var specs []*bundle.Spec
+		specs, err = copySpecs(v.specs)
+		stored = specPage{specs: specs, next: v.next}
+	case *bundle.Spec:
+		spec := &bundle.Spec{}
+		err = copyRecord(v, spec)
+		stored = spec
+	case *bundle.ServiceInstance:
+		si := &bundle.ServiceInstance{}
+		err = copyRecord(v, si)
+		stored = si
+	}
+	if err != nil {
+		log.Debugf("Not caching [ %s ] - %v", key, err)
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if *current != generation {
+		return
+	}
+	cache.Add(key, cacheEntry{value: stored, expires: c.now().Add(c.ttl)})
+}
+
+func (c *CachedDao) generation(current *uint64) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return *current
+}
+
+func (c *CachedDao) invalidateSpecs() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.specGeneration++
+	c.specs.Purge()
+	c.specLists.Purge()
+}
+
+func (c *CachedDao) invalidateInstance(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.instanceGeneration++
+	c.instances.Remove(id)
+}
+
+func (c *CachedDao) invalidateInstances() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.instanceGeneration++
+	c.instances.Purge()
+}
+
+// copyRecord - deep copies in into out through json, the way the records
+// are stored.
+func copyRecord(in interface{}, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func copySpecs(specs []*bundle.Spec) ([]*bundle.Spec, error) {
+	copies := make([]*bundle.Spec, len(specs))
+	for i, spec := range specs {
+		copies[i] = &bundle.Spec{}
+		if err := copyRecord(spec, copies[i]); err != nil {
+			return nil, err
+		}
+	}
+	return copies, nil
+}
@@ -0,0 +1,105 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+// countingDao - counts the reads that made it past the cache.
+type countingDao struct {
+	Dao
+	specReads     int
+	instanceReads int
+}
+
+func (d *countingDao) GetSpec(id string) (*bundle.Spec, error) {
+	d.specReads++
+	return d.Dao.GetSpec(id)
+}
+
+func (d *countingDao) GetServiceInstance(id string) (*bundle.ServiceInstance, error) {
+	d.instanceReads++
+	return d.Dao.GetServiceInstance(id)
+}
+
+func newTestCache(t *testing.T, ttl time.Duration) (*CachedDao, *countingDao) {
+	backend, _ := memory.NewDao()
+	counting := &countingDao{Dao: backend}
+	c, err := NewCachedDao(counting, 10, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, counting
+}
+
+func TestCachedDaoSpecs(t *testing.T) {
+	c, counting := newTestCache(t, 0)
+	c.SetSpec("1", &bundle.Spec{ID: "1", FQName: "first"})
+
+	for i := 0; i < 3; i++ {
+		spec, err := c.GetSpec("1")
+		ft.AssertNil(t, err)
+		ft.AssertEqual(t, "first", spec.FQName)
+		// callers get their own copy to change.
+		spec.FQName = "changed"
+	}
+	ft.AssertEqual(t, 1, counting.specReads)
+
+	c.SetSpec("1", &bundle.Spec{ID: "1", FQName: "second"})
+	spec, _ := c.GetSpec("1")
+	ft.AssertEqual(t, "second", spec.FQName)
+	ft.AssertEqual(t, 2, counting.specReads)
+
+	specs, _ := c.BatchGetSpecs("/spec")
+	ft.AssertEqual(t, 1, len(specs))
+	c.SetSpec("2", &bundle.Spec{ID: "2", FQName: "third"})
+	specs, _ = c.BatchGetSpecs("/spec")
+	ft.AssertEqual(t, 2, len(specs))
+}
+
+func TestCachedDaoServiceInstances(t *testing.T) {
+	c, counting := newTestCache(t, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	id := uuid.NewRandom()
+	c.SetServiceInstance(id.String(), &bundle.ServiceInstance{ID: id, DashboardURL: "one"})
+	c.GetServiceInstance(id.String())
+	si, _ := c.GetServiceInstance(id.String())
+	ft.AssertEqual(t, "one", si.DashboardURL)
+	ft.AssertEqual(t, 1, counting.instanceReads)
+
+	// writes that bypass the cache are seen once the entry expired.
+	counting.Dao.SetServiceInstance(id.String(), &bundle.ServiceInstance{ID: id, DashboardURL: "two"})
+	si, _ = c.GetServiceInstance(id.String())
+	ft.AssertEqual(t, "one", si.DashboardURL)
+	now = now.Add(2 * time.Minute)
+	si, _ = c.GetServiceInstance(id.String())
+	ft.AssertEqual(t, "two", si.DashboardURL)
+	ft.AssertEqual(t, 2, counting.instanceReads)
+
+	c.DeleteServiceInstance(id.String())
+	_, err := c.GetServiceInstance(id.String())
+	ft.AssertNotNil(t, err)
+}
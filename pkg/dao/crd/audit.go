@@ -0,0 +1,59 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// auditKind - the kind of the config maps in the broker namespace holding the
+// audit records, one per config map, there is no custom resource for them.
+const auditKind = "audit"
+
+// SetAuditRecord - Store the audit record in a config map of its own.
+func (d *Dao) SetAuditRecord(record types.AuditRecord) error {
+	payload, err := bundle.DumpJSON(record)
+	if err != nil {
+		return err
+	}
+	return d.setRecord(auditKind, record.ID, payload)
+}
+
+// DeleteAuditRecord - Remove the config map of the audit record.
+func (d *Dao) DeleteAuditRecord(id string) error {
+	return d.deleteRecord(auditKind, id)
+}
+
+// BatchGetAuditRecords - Retrieve the audit records from their config maps, oldest
+// first.
+func (d *Dao) BatchGetAuditRecords() ([]types.AuditRecord, error) {
+	payloads, err := d.listRecords(auditKind)
+	if err != nil {
+		return nil, err
+	}
+	records := []types.AuditRecord{}
+	for _, payload := range payloads {
+		record := types.AuditRecord{}
+		if err := bundle.LoadJSON(payload, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	types.SortAuditRecords(records)
+	return records, nil
+}
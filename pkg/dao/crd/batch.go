@@ -0,0 +1,203 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/clients"
+	"github.com/openshift/ansible-service-broker/pkg/dao/journal"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// journalConfigMap - the config map in the broker namespace holding the
+	// journal entries and the pending intents, there is no custom resource
+	// for them.
+	journalConfigMap = "ansible-service-broker-journal"
+	batchDataPrefix  = "batch."
+	intentDataPrefix = "intent."
+)
+
+// Apply - Record the batch in the journal config map, then make its writes
+// one by one. The intents are recorded in the same update that removes the
+// journal entry. A batch interrupted part of the way is finished when
+// PendingIntents replays its entry.
+func (d *Dao) Apply(batch types.Batch) error {
+	if err := journal.Check(d, batch); err != nil {
+		return err
+	}
+	entry := types.JournalEntry{ID: uuid.New(), Batch: batch, Created: time.Now()}
+	payload, err := bundle.DumpJSON(entry)
+	if err != nil {
+		return err
+	}
+	err = d.updateConfigMap(journalConfigMap, func(data map[string]string) error {
+		data[batchDataPrefix+entry.ID] = payload
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return d.finish(entry, false)
+}
+
+// finish - makes the writes of a journaled batch, then replaces its journal
+// entry with its intents.
+func (d *Dao) finish(entry types.JournalEntry, replay bool) error {
+	if err := journal.Apply(d, entry.Batch, replay); err != nil {
+		log.Errorf("Unable to apply batch %v, it will be replayed - %v", entry.ID, err)
+		return err
+	}
+	return d.updateConfigMap(journalConfigMap, func(data map[string]string) error {
+		delete(data, batchDataPrefix+entry.ID)
+		for _, intent := range entry.Batch.Intents {
+			payload, err := bundle.DumpJSON(intent)
+			if err != nil {
+				return err
+			}
+			data[intentDataPrefix+intent.ID] = payload
+		}
+		return nil
+	})
+}
+
+// PendingIntents - Replay the journal entries left behind, then retrieve the
+// pending intents from the journal config map ordered by id.
+func (d *Dao) PendingIntents() ([]types.Intent, error) {
+	data, err := d.readConfigMap(journalConfigMap)
+	if err != nil {
+		return nil, err
+	}
+	replayed := false
+	for key, payload := range data {
+		if !strings.HasPrefix(key, batchDataPrefix) {
+			continue
+		}
+		entry := types.JournalEntry{}
+		if err := bundle.LoadJSON(payload, &entry); err != nil {
+			return nil, err
+		}
+		if !journal.Due(entry) {
+			continue
+		}
+		log.Infof("Replaying the batch %v journaled at %v", entry.ID, entry.Created)
+		if err := d.finish(entry, true); err != nil {
+			return nil, err
+		}
+		replayed = true
+	}
+	if replayed {
+		if data, err = d.readConfigMap(journalConfigMap); err != nil {
+			return nil, err
+		}
+	}
+
+	intents := []types.Intent{}
+	for key, payload := range data {
+		if !strings.HasPrefix(key, intentDataPrefix) {
+			continue
+		}
+		intent := types.Intent{}
+		if err := bundle.LoadJSON(payload, &intent); err != nil {
+			return nil, err
+		}
+		intents = append(intents, intent)
+	}
+	sort.Slice(intents, func(i, j int) bool { return intents[i].ID < intents[j].ID })
+	return intents, nil
+}
+
+// CompleteIntent - Remove the intent from the journal config map.
+func (d *Dao) CompleteIntent(id string) error {
+	data, err := d.readConfigMap(journalConfigMap)
+	if err != nil {
+		return err
+	}
+	if _, ok := data[intentDataPrefix+id]; !ok {
+		return nil
+	}
+	return d.updateConfigMap(journalConfigMap, func(data map[string]string) error {
+		delete(data, intentDataPrefix+id)
+		return nil
+	})
+}
+
+// readConfigMap - the data of the config map of the broker namespace, empty
+// when it does not exist yet.
+func (d *Dao) readConfigMap(name string) (map[string]string, error) {
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		return nil, err
+	}
+	cm, err := k8scli.Client.CoreV1().ConfigMaps(d.namespace).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		log.Errorf("unable to get the %s config map - %v", name, err)
+		return nil, err
+	}
+	return cm.Data, nil
+}
+
+// updateConfigMap - edits the data of the config map of the broker
+// namespace, creating it the first time. The edit is retried when another
+// broker updated it first.
+func (d *Dao) updateConfigMap(name string, edit func(map[string]string) error) error {
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		return err
+	}
+	configMaps := k8scli.Client.CoreV1().ConfigMaps(d.namespace)
+	for i := 0; ; i++ {
+		cm, err := configMaps.Get(name, metav1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		if create {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: d.namespace},
+			}
+		} else if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if err := edit(cm.Data); err != nil {
+			return err
+		}
+		if create {
+			_, err = configMaps.Create(cm)
+		} else {
+			_, err = configMaps.Update(cm)
+		}
+		if !(apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)) || i >= conflictRetries {
+			if err != nil {
+				log.Errorf("unable to update the %s config map - %v", name, err)
+			}
+			return err
+		}
+		log.Debugf("%s config map changed while updating it, retrying", name)
+	}
+}
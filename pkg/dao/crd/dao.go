@@ -19,6 +19,7 @@ package dao
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"sync"
 
 	automationbrokerv1 "github.com/automationbroker/broker-client-go/client/clientset/versioned/typed/automationbroker/v1alpha1"
@@ -26,10 +27,12 @@ import (
 	"github.com/automationbroker/bundle-lib/bundle"
 	"github.com/automationbroker/bundle-lib/clients"
 	"github.com/automationbroker/bundle-lib/crd"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
 	"github.com/pborman/uuid"
 	log "github.com/sirupsen/logrus"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type arrayErrors []error
@@ -38,10 +41,24 @@ func (a arrayErrors) Error() string {
 	return fmt.Sprintf("%#v", a)
 }
 
+// ConflictError - Error returned when a compare and set finds that the
+// resource has been written since the expected version was read.
+type ConflictError struct {
+	Kind string
+	Name string
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("%s %s was modified concurrently", e.Kind, e.Name)
+}
+
 const (
 	// instanceLabel for the job state to track which instance created it.
 	jobStateInstanceLabel string = "instanceId"
 	jobStateLabel         string = "state"
+	// conflictRetries is how many times a job state update is retried when
+	// another writer updated the owning resource first.
+	conflictRetries = 5
 )
 
 // Dao - object to interface with the data store.
@@ -51,6 +68,10 @@ type Dao struct {
 	bundleLock   sync.Mutex
 	bindingLock  sync.Mutex
 	instanceLock sync.Mutex
+	// labelLock guards labelled, set once the resources written before
+	// the query labels were maintained have been labelled.
+	labelLock sync.Mutex
+	labelled  bool
 }
 
 // NewDao - Create a new Dao object
@@ -186,6 +207,83 @@ func (d *Dao) BatchGetBundleInstances() ([]*bundle.ServiceInstance, error) {
 	return bundleInstances, nil
 }
 
+// BatchGetBindInstances - Retrieve all the bundle bindings.
+func (d *Dao) BatchGetBindInstances() ([]*bundle.BindInstance, error) {
+	log.Debugf("Dao::BatchGetBindInstances")
+	bl, err := d.client.BundleBindings(d.namespace).List(metav1.ListOptions{})
+	if err != nil {
+		log.Errorf("unable to get batch bundlebindings - %v", err)
+		return nil, err
+	}
+	bindings := make([]*bundle.BindInstance, len(bl.Items))
+	for index, bundleBinding := range bl.Items {
+		bi, err := crd.ConvertServiceBindingToAPB(bundleBinding, bundleBinding.GetName())
+		if err != nil {
+			log.Errorf("unable to convert bundle binding to bind instance - %v", err)
+			return nil, err
+		}
+		bindings[index] = bi
+	}
+	return bindings, nil
+}
+
+// GetSpecsPage - Retrieve up to limit specs, starting at the continue token
+// returned with the previous page.
+func (d *Dao) GetSpecsPage(continueToken string, limit int) ([]*bundle.Spec, string, error) {
+	log.Debugf("Dao::GetSpecsPage")
+	l, err := d.client.Bundles(d.namespace).List(metav1.ListOptions{
+		Limit:    int64(limit),
+		Continue: continueToken,
+	})
+	if err != nil {
+		log.Errorf("unable to get page of specs - %v", err)
+		return nil, "", err
+	}
+	specs := []*bundle.Spec{}
+	// capture all the errors and still try to return the correct bundles
+	errs := arrayErrors{}
+	for _, b := range l.Items {
+		spec, err := crd.ConvertBundleToSpec(b.Spec, b.GetName())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		specs = append(specs, spec)
+	}
+	if len(errs) > 0 {
+		return specs, l.Continue, errs
+	}
+	return specs, l.Continue, nil
+}
+
+// GetServiceInstancesPage - Retrieve up to limit bundle instances, starting
+// at the continue token returned with the previous page.
+func (d *Dao) GetServiceInstancesPage(continueToken string, limit int) ([]*bundle.ServiceInstance, string, error) {
+	log.Debugf("Dao::GetServiceInstancesPage")
+	bl, err := d.client.BundleInstances(d.namespace).List(metav1.ListOptions{
+		Limit:    int64(limit),
+		Continue: continueToken,
+	})
+	if err != nil {
+		log.Errorf("unable to get page of bundleinstances - %v", err)
+		return nil, "", err
+	}
+	bundleInstances := make([]*bundle.ServiceInstance, len(bl.Items))
+	for index, bundleInstance := range bl.Items {
+		spec, err := d.GetSpec(bundleInstance.Spec.Bundle.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		s, err := crd.ConvertServiceInstanceToAPB(bundleInstance, spec, bundleInstance.GetName())
+		if err != nil {
+			log.Errorf("unable to convert service instance to bundle instance - %v", err)
+			return nil, "", err
+		}
+		bundleInstances[index] = s
+	}
+	return bundleInstances, bl.Continue, nil
+}
+
 // BatchDeleteSpecs - set specs based on SpecManifest in the kvp API.
 func (d *Dao) BatchDeleteSpecs(specs []*bundle.Spec) error {
 	for _, spec := range specs {
@@ -224,6 +322,7 @@ func (d *Dao) SetServiceInstance(id string, serviceInstance *bundle.ServiceInsta
 		log.Debugf("updating service instance: %v", id)
 		si.Spec = spec.Spec
 		si.Status.Bindings = intersectionOfBindings(serviceInstance.BindingIDs, si.Status.Bindings)
+		labelInstance(si)
 		_, err := d.client.BundleInstances(d.namespace).Update(si)
 		if err != nil {
 			log.Errorf("unable to get service instance - %v", err)
@@ -240,6 +339,7 @@ func (d *Dao) SetServiceInstance(id string, serviceInstance *bundle.ServiceInsta
 		Status: spec.Status,
 	}
 
+	labelInstance(&s)
 	_, err = d.client.BundleInstances(d.namespace).Create(&s)
 	if err != nil {
 		log.Errorf("unable to save service instance - %v", err)
@@ -248,6 +348,92 @@ func (d *Dao) SetServiceInstance(id string, serviceInstance *bundle.ServiceInsta
 	return nil
 }
 
+// GetServiceInstanceVersion - Retrieve a service instance and the resource
+// version it was read at from the k8s API.
+func (d *Dao) GetServiceInstanceVersion(id string) (*bundle.ServiceInstance, string, error) {
+	log.Debugf("get service instance: %v", id)
+	servInstance, err := d.client.BundleInstances(d.namespace).Get(id, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	spec, err := d.GetSpec(servInstance.Spec.Bundle.Name)
+	if err != nil {
+		return nil, "", err
+	}
+	si, err := crd.ConvertServiceInstanceToAPB(*servInstance, spec, servInstance.GetName())
+	if err != nil {
+		return nil, "", err
+	}
+	return si, servInstance.GetResourceVersion(), nil
+}
+
+// CompareAndSetServiceInstance - Set service instance for an id in the k8s API
+// if it has not changed since version was read. Unlike SetServiceInstance the
+// bindings are replaced, since the caller has seen the latest set.
+func (d *Dao) CompareAndSetServiceInstance(id string, serviceInstance *bundle.ServiceInstance, version string) (string, error) {
+	log.Debugf("compare and set service instance: %v at version %v", id, version)
+	spec, err := crd.ConvertServiceInstanceToCRD(serviceInstance)
+	if err != nil {
+		return "", err
+	}
+	if version == "" {
+		s := v1.BundleInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      id,
+				Namespace: d.namespace,
+			},
+			Spec:   spec.Spec,
+			Status: spec.Status,
+		}
+		s.Status.Bindings = bindingsFromIDs(serviceInstance.BindingIDs)
+		labelInstance(&s)
+		created, err := d.client.BundleInstances(d.namespace).Create(&s)
+		if err != nil {
+			return "", conflictOrErr(err, "bundleinstance", id)
+		}
+		return created.GetResourceVersion(), nil
+	}
+
+	si, err := d.client.BundleInstances(d.namespace).Get(id, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	si.SetResourceVersion(version)
+	si.Spec = spec.Spec
+	si.Status.Bindings = bindingsFromIDs(serviceInstance.BindingIDs)
+	labelInstance(si)
+	updated, err := d.client.BundleInstances(d.namespace).Update(si)
+	if err != nil {
+		return "", conflictOrErr(err, "bundleinstance", id)
+	}
+	return updated.GetResourceVersion(), nil
+}
+
+// bindingsFromIDs - converts the active bindings of an instance to the
+// references kept in the bundle instance status, in a stable order.
+func bindingsFromIDs(bindings map[string]bool) []v1.LocalObjectReference {
+	ids := []string{}
+	for id, active := range bindings {
+		if active {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	refs := []v1.LocalObjectReference{}
+	for _, id := range ids {
+		refs = append(refs, v1.LocalObjectReference{Name: id})
+	}
+	return refs
+}
+
+// conflictOrErr - translates the k8s API conflict errors to a ConflictError.
+func conflictOrErr(err error, kind string, name string) error {
+	if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) {
+		return ConflictError{Kind: kind, Name: name}
+	}
+	return err
+}
+
 func intersectionOfBindings(bindings map[string]bool, bind []v1.LocalObjectReference) []v1.LocalObjectReference {
 	newBindings := []v1.LocalObjectReference{}
 	alreadyChecked := map[string]bool{}
@@ -311,12 +497,14 @@ func (d *Dao) SetBindInstance(id string, bindInstance *bundle.BindInstance) erro
 		Spec:   b.Spec,
 		Status: b.Status,
 	}
+	labelBinding(&bi)
 	_, err = d.client.BundleBindings(d.namespace).Create(&bi)
 	if err != nil && apierrors.IsAlreadyExists(err) {
 		// looks like we already have this state, probably created by
 		// another goroutine. Let's try to update the existing one instead.
 		if binding, err := d.client.BundleBindings(d.namespace).Get(id, metav1.GetOptions{}); err == nil {
 			binding.Spec = b.Spec
+			labelBinding(binding)
 			_, err := d.client.BundleBindings(d.namespace).Update(binding)
 
 			if err != nil && apierrors.IsConflict(err) {
@@ -335,6 +523,60 @@ func (d *Dao) SetBindInstance(id string, bindInstance *bundle.BindInstance) erro
 	return nil
 }
 
+// GetBindInstanceVersion - Retrieve a bind instance and the resource version
+// it was read at from the k8s API.
+func (d *Dao) GetBindInstanceVersion(id string) (*bundle.BindInstance, string, error) {
+	log.Debugf("get binding instance: %v", id)
+	b, err := d.client.BundleBindings(d.namespace).Get(id, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	bi, err := crd.ConvertServiceBindingToAPB(*b, b.GetName())
+	if err != nil {
+		return nil, "", err
+	}
+	return bi, b.GetResourceVersion(), nil
+}
+
+// CompareAndSetBindInstance - Set the bind instance for id in the k8s API if
+// it has not changed since version was read.
+func (d *Dao) CompareAndSetBindInstance(id string, bindInstance *bundle.BindInstance, version string) (string, error) {
+	log.Debugf("compare and set binding instance: %v at version %v", id, version)
+	b, err := crd.ConvertServiceBindingToCRD(bindInstance)
+	if err != nil {
+		return "", err
+	}
+	if version == "" {
+		bi := v1.BundleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      id,
+				Namespace: d.namespace,
+			},
+			Spec:   b.Spec,
+			Status: b.Status,
+		}
+		labelBinding(&bi)
+		created, err := d.client.BundleBindings(d.namespace).Create(&bi)
+		if err != nil {
+			return "", conflictOrErr(err, "bundlebinding", id)
+		}
+		return created.GetResourceVersion(), nil
+	}
+
+	binding, err := d.client.BundleBindings(d.namespace).Get(id, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	binding.SetResourceVersion(version)
+	binding.Spec = b.Spec
+	labelBinding(binding)
+	updated, err := d.client.BundleBindings(d.namespace).Update(binding)
+	if err != nil {
+		return "", conflictOrErr(err, "bundlebinding", id)
+	}
+	return updated.GetResourceVersion(), nil
+}
+
 // DeleteBindInstance - Delete the binding instance for an id in the kvp API.
 func (d *Dao) DeleteBindInstance(id string) error {
 	log.Debugf("Dao::DeleteBindInstance -> [ %s ]", id)
@@ -342,10 +584,93 @@ func (d *Dao) DeleteBindInstance(id string) error {
 	return err
 }
 
-// SetState - Set the Job State in the kvp API for id.
+// SetState - Set the Job State in the kvp API for id. The job states are kept
+// in the status of the owning binding or instance, so the update is retried
+// when another writer changed the owner in the meantime.
 func (d *Dao) SetState(instanceID string, state bundle.JobState) (string, error) {
 	log.Debugf("set job state for instance: %v token: %v", instanceID, state.Token)
+	var err error
+	for i := 0; i < conflictRetries; i++ {
+		_, err = d.writeJobState(instanceID, state, "", false)
+		if !d.IsConflictError(err) {
+			break
+		}
+		// detect if the error was a conflict or not. Conflicts occur
+		// when two things attempt to update the same resource
+		// simultaneously
+		log.Warningf("detected a conflicting update of job state %v on %v, retrying",
+			state.Token, instanceID)
+	}
+	return state.Token, err
+}
+
+// GetStateVersion - Retrieve a job state and the resource version of the
+// binding or instance that owns it.
+func (d *Dao) GetStateVersion(id string, token string) (bundle.JobState, string, error) {
+	// get the binding based on instance ID //update the job based on the token.
+	var job v1.Job
+	var version string
+	bi, err := d.client.BundleBindings(d.namespace).Get(id, metav1.GetOptions{})
+	if err != nil && !d.IsNotFoundError(err) {
+		log.Debugf("Could not find binding %v associated with job state %v - %v", id, token, err)
+		return bundle.JobState{}, "", fmt.Errorf("Could not find binding %v associated with job state %v",
+			id, token)
+	} else if d.IsNotFoundError(err) {
+		si, err := d.client.BundleInstances(d.namespace).Get(id, metav1.GetOptions{})
+		if err != nil {
+			log.Debugf("Could not find instance %v associated with job state %v - %v",
+				id, token, err)
+
+			return bundle.JobState{}, "", err
+		}
+		j, ok := si.Status.Jobs[token]
+		if !ok {
+			log.Debugf("Unable to get the job state: %v - %v", token, err)
+			return bundle.JobState{}, "", jobNotFound(token)
+		}
+		job = j
+		version = si.GetResourceVersion()
+	} else {
+		j, ok := bi.Status.Jobs[token]
+		if !ok {
+			log.Debugf("binding %v does not have job state: %v - %v", id, token, err)
+			return bundle.JobState{}, "", jobNotFound(token)
+		}
+
+		job = j
+		version = bi.GetResourceVersion()
+	}
+	return bundle.JobState{
+		Description: job.Description,
+		Method:      crd.ConvertJobMethodToAPB(job.Method),
+		Podname:     job.Podname,
+		Token:       token,
+		State:       stateToAPB(job.State),
+		Error:       job.Error,
+	}, version, nil
+}
+
+// CompareAndSetState - Set the Job State in the k8s API for id if the owning
+// binding or instance has not changed since version was read. An empty
+// version only adds a job state that does not exist yet.
+func (d *Dao) CompareAndSetState(instanceID string, state bundle.JobState, version string) (string, error) {
+	log.Debugf("compare and set job state for instance: %v token: %v", instanceID, state.Token)
+	return d.writeJobState(instanceID, state, version, true)
+}
+
+// writeJobState - writes the job state into the status of the binding or
+// instance that owns it, returning the new resource version of the owner.
+// When compare is set the owner must still be at version.
+func (d *Dao) writeJobState(instanceID string, state bundle.JobState, version string, compare bool) (string, error) {
 	n := metav1.Now()
+	job := v1.Job{
+		Description:      state.Description,
+		LastModifiedTime: &n,
+		Method:           crd.ConvertJobMethodToCRD(state.Method),
+		Podname:          state.Podname,
+		State:            stateToCRD(state.State),
+		Error:            state.Error,
+	}
 	switch state.Method {
 	case bundle.JobMethodBind, bundle.JobMethodUnbind:
 		defer d.bindingLock.Unlock()
@@ -356,36 +681,26 @@ func (d *Dao) SetState(instanceID string, state bundle.JobState) (string, error)
 			log.Errorf("Could not find binding %v associated with job state %v - %v",
 				instanceID, state.Token, err)
 
-			return state.Token, err
+			return "", err
+		}
+		if compare && !versionMatches(bi.GetResourceVersion(), bi.Status.Jobs, state.Token, version) {
+			return "", ConflictError{Kind: "bundlebinding", Name: instanceID}
 		}
 		if bi.Status.Jobs == nil {
 			bi.Status.Jobs = map[string]v1.Job{}
 		}
-		bi.Status.Jobs[state.Token] = v1.Job{
-			Description:      state.Description,
-			LastModifiedTime: &n,
-			Method:           crd.ConvertJobMethodToCRD(state.Method),
-			Podname:          state.Podname,
-			State:            crd.ConvertStateToCRD(state.State),
-			Error:            state.Error,
-		}
+		bi.Status.Jobs[state.Token] = job
 		bi.Status.LastDescription = state.Description
-		bi.Status.State = crd.ConvertStateToCRD(state.State)
-		_, err = d.client.BundleBindings(d.namespace).Update(bi)
+		bi.Status.State = stateToCRD(state.State)
+		labelBinding(bi)
+		updated, err := d.client.BundleBindings(d.namespace).Update(bi)
 		if err != nil {
-			if apierrors.IsConflict(err) {
-				// detect if the error was a conflict or not. Conflicts occur
-				// when two things attempt to update the same resource
-				// simultaneously
-				log.Warningf("detected a conflicting update of job state %v on binding %v",
-					state.Token, instanceID)
-			}
-
 			log.Errorf("Unable to update the job state %v on the binding %v. Reason: %v - %v",
 				state.Token, instanceID, apierrors.ReasonForError(err), err)
 
-			return state.Token, err
+			return "", conflictOrErr(err, "bundlebinding", instanceID)
 		}
+		return updated.GetResourceVersion(), nil
 	case bundle.JobMethodUpdate, bundle.JobMethodDeprovision, bundle.JobMethodProvision:
 		defer d.bindingLock.Unlock()
 		d.bindingLock.Lock()
@@ -395,78 +710,143 @@ func (d *Dao) SetState(instanceID string, state bundle.JobState) (string, error)
 			log.Errorf("Could not find instance %v associated with job state %v - %v",
 				instanceID, state.Token, err)
 
-			return state.Token, err
+			return "", err
+		}
+		if compare && !versionMatches(si.GetResourceVersion(), si.Status.Jobs, state.Token, version) {
+			return "", ConflictError{Kind: "bundleinstance", Name: instanceID}
 		}
 		if si.Status.Jobs == nil {
 			si.Status.Jobs = map[string]v1.Job{}
 		}
-		si.Status.Jobs[state.Token] = v1.Job{
-			Description:      state.Description,
-			LastModifiedTime: &n,
-			Method:           crd.ConvertJobMethodToCRD(state.Method),
-			Podname:          state.Podname,
-			State:            crd.ConvertStateToCRD(state.State),
-			Error:            state.Error,
-		}
+		si.Status.Jobs[state.Token] = job
 		si.Status.LastDescription = state.Description
-		si.Status.State = crd.ConvertStateToCRD(state.State)
-		_, err = d.client.BundleInstances(d.namespace).Update(si)
+		si.Status.State = stateToCRD(state.State)
+		labelInstance(si)
+		updated, err := d.client.BundleInstances(d.namespace).Update(si)
 		if err != nil {
 			log.Errorf("Unable to update the job state %v on the instance %v: %v",
 				state.Token, instanceID, err)
 
-			return state.Token, err
+			return "", conflictOrErr(err, "bundleinstance", instanceID)
 		}
+		return updated.GetResourceVersion(), nil
 	}
 
 	// looks like we're good
-	return state.Token, nil
+	return "", nil
 }
 
-// GetState - Retrieve a job state from the kvp API for an ID and Token.
-func (d *Dao) GetState(id string, token string) (bundle.JobState, error) {
-	// get the binding based on instance ID //update the job based on the token.
-	var job v1.Job
-	bi, err := d.client.BundleBindings(d.namespace).Get(id, metav1.GetOptions{})
-	if err != nil && !d.IsNotFoundError(err) {
-		log.Debugf("Could not find binding %v associated with job state %v - %v", id, token, err)
-		return bundle.JobState{}, fmt.Errorf("Could not find binding %v associated with job state %v",
-			id, token)
-	} else if d.IsNotFoundError(err) {
-		si, err := d.client.BundleInstances(d.namespace).Get(id, metav1.GetOptions{})
-		if err != nil || si.Status.Jobs == nil {
-			log.Debugf("Could not find instance %v associated with job state %v - %v",
-				id, token, err)
+// jobNotFound - the not found error of a job state missing from the status of
+// its binding or instance.
+func jobNotFound(token string) error {
+	return apierrors.NewNotFound(schema.GroupResource{Resource: "jobs"}, token)
+}
 
-			return bundle.JobState{}, err
+// versionMatches - checks a job state compare and set against the owner. An
+// empty version expects the job to not exist yet.
+func versionMatches(current string, jobs map[string]v1.Job, token string, version string) bool {
+	if version == "" {
+		_, exists := jobs[token]
+		return !exists
+	}
+	return current == version
+}
+
+// DeleteState - Delete the job state for an id and token from the status of
+// the binding or instance that owns it.
+func (d *Dao) DeleteState(id string, token string) error {
+	log.Debugf("Dao::DeleteState -> [ %s ] token [ %s ]", id, token)
+	var err error
+	for i := 0; i < conflictRetries; i++ {
+		err = d.removeJobState(id, token)
+		if !apierrors.IsConflict(err) {
+			break
 		}
-		j, ok := si.Status.Jobs[token]
-		if !ok {
-			log.Debugf("Unable to get the job state: %v - %v", token, err)
-			return bundle.JobState{}, fmt.Errorf("unable to find job state %v", token)
+		log.Warningf("detected a conflicting update while deleting job state %v on %v, retrying",
+			token, id)
+	}
+	return err
+}
+
+func (d *Dao) removeJobState(id string, token string) error {
+	defer d.bindingLock.Unlock()
+	d.bindingLock.Lock()
+	bi, err := d.client.BundleBindings(d.namespace).Get(id, metav1.GetOptions{})
+	if err == nil {
+		if _, ok := bi.Status.Jobs[token]; !ok {
+			return jobStateNotFound()
 		}
-		job = j
-	} else {
-		if bi.Status.Jobs == nil {
-			log.Debugf("binding %v has no associated job states: %v - %v", id, token, err)
-			return bundle.JobState{}, err
+		delete(bi.Status.Jobs, token)
+		_, err = d.client.BundleBindings(d.namespace).Update(bi)
+		return err
+	} else if !d.IsNotFoundError(err) {
+		return err
+	}
+
+	si, err := d.client.BundleInstances(d.namespace).Get(id, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if _, ok := si.Status.Jobs[token]; !ok {
+		return jobStateNotFound()
+	}
+	delete(si.Status.Jobs, token)
+	_, err = d.client.BundleInstances(d.namespace).Update(si)
+	return err
+}
+
+func jobStateNotFound() error {
+	return &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status: metav1.StatusFailure,
+		Code:   http.StatusNotFound,
+		Reason: metav1.StatusReasonNotFound,
+	}}
+}
+
+// BatchGetJobStates - Retrieve the job states of every bundle instance and
+// bundle binding.
+func (d *Dao) BatchGetJobStates() ([]types.JobStateRecord, error) {
+	log.Debugf("Dao::BatchGetJobStates")
+	sis, err := d.client.BundleInstances(d.namespace).List(metav1.ListOptions{})
+	if err != nil {
+		log.Errorf("unable to get bundle instances for the job states - %v", err)
+		return nil, err
+	}
+	bis, err := d.client.BundleBindings(d.namespace).List(metav1.ListOptions{})
+	if err != nil {
+		log.Errorf("unable to get bundle bindings for the job states - %v", err)
+		return nil, err
+	}
+
+	records := []types.JobStateRecord{}
+	for _, si := range sis.Items {
+		records = append(records, jobStateRecords(si.GetName(), si.Status.Jobs)...)
+	}
+	for _, bi := range bis.Items {
+		records = append(records, jobStateRecords(bi.GetName(), bi.Status.Jobs)...)
+	}
+	return records, nil
+}
+
+func jobStateRecords(id string, jobs map[string]v1.Job) []types.JobStateRecord {
+	records := []types.JobStateRecord{}
+	for token, j := range jobs {
+		record := types.JobStateRecord{
+			ID:    id,
+			State: jobStateFromCRD(token, j),
 		}
-		j, ok := bi.Status.Jobs[token]
-		if !ok {
-			log.Debugf("binding %v does not have job state: %v - %v", id, token, err)
-			return bundle.JobState{}, fmt.Errorf("unable to find job state %v", token)
+		if j.LastModifiedTime != nil {
+			record.LastModified = j.LastModifiedTime.Time
 		}
-
-		job = j
+		records = append(records, record)
 	}
-	return bundle.JobState{
-		Description: job.Description,
-		Method:      crd.ConvertJobMethodToAPB(job.Method),
-		Podname:     job.Podname,
-		Token:       token,
-		State:       crd.ConvertStateToAPB(job.State),
-		Error:       job.Error,
-	}, nil
+	return records
+}
+
+// GetState - Retrieve a job state from the kvp API for an ID and Token.
+func (d *Dao) GetState(id string, token string) (bundle.JobState, error) {
+	state, _, err := d.GetStateVersion(id, token)
+	return state, err
 }
 
 // GetStateByKey - Retrieve a job state from the kvp API for a job key
@@ -486,7 +866,7 @@ func (d *Dao) GetStateByKey(key string) (bundle.JobState, error) {
 				Method:      crd.ConvertJobMethodToAPB(j.Method),
 				Podname:     j.Podname,
 				Token:       token,
-				State:       crd.ConvertStateToAPB(j.State),
+				State:       stateToAPB(j.State),
 				Error:       j.Error,
 			}, nil
 		}
@@ -522,14 +902,14 @@ func (d *Dao) FindJobStateByState(state bundle.State) ([]bundle.RecoverStatus, e
 
 	for _, si := range sis.Items {
 		for token, j := range si.Status.Jobs {
-			if state == crd.ConvertStateToAPB(j.State) {
+			if state == stateToAPB(j.State) {
 				rss = append(rss,
 					bundle.RecoverStatus{InstanceID: uuid.Parse(si.GetName()), State: bundle.JobState{
 						Description: j.Description,
 						Method:      crd.ConvertJobMethodToAPB(j.Method),
 						Podname:     j.Podname,
 						Token:       token,
-						State:       crd.ConvertStateToAPB(j.State),
+						State:       stateToAPB(j.State),
 						Error:       j.Error,
 					}})
 			}
@@ -538,14 +918,14 @@ func (d *Dao) FindJobStateByState(state bundle.State) ([]bundle.RecoverStatus, e
 
 	for _, bi := range bis.Items {
 		for token, j := range bi.Status.Jobs {
-			if state == crd.ConvertStateToAPB(j.State) {
+			if state == stateToAPB(j.State) {
 				rss = append(rss,
 					bundle.RecoverStatus{InstanceID: uuid.Parse(bi.GetName()), State: bundle.JobState{
 						Description: j.Description,
 						Method:      crd.ConvertJobMethodToAPB(j.Method),
 						Podname:     j.Podname,
 						Token:       token,
-						State:       crd.ConvertStateToAPB(j.State),
+						State:       stateToAPB(j.State),
 						Error:       j.Error,
 					}})
 			}
@@ -570,26 +950,26 @@ func (d *Dao) GetSvcInstJobsByState(ID string, state bundle.State) ([]bundle.Job
 			return []bundle.JobState{}, err
 		}
 		for token, job := range si.Status.Jobs {
-			if job.State == crd.ConvertStateToCRD(state) {
+			if job.State == stateToCRD(state) {
 				jobs = append(jobs, bundle.JobState{
 					Description: job.Description,
 					Method:      crd.ConvertJobMethodToAPB(job.Method),
 					Podname:     job.Podname,
 					Token:       token,
-					State:       crd.ConvertStateToAPB(job.State),
+					State:       stateToAPB(job.State),
 					Error:       job.Error,
 				})
 			}
 		}
 	} else {
 		for token, job := range bi.Status.Jobs {
-			if job.State == crd.ConvertStateToCRD(state) {
+			if job.State == stateToCRD(state) {
 				jobs = append(jobs, bundle.JobState{
 					Description: job.Description,
 					Method:      crd.ConvertJobMethodToAPB(job.Method),
 					Podname:     job.Podname,
 					Token:       token,
-					State:       crd.ConvertStateToAPB(job.State),
+					State:       stateToAPB(job.State),
 					Error:       job.Error,
 				})
 			}
@@ -603,11 +983,17 @@ func (d *Dao) IsNotFoundError(err error) bool {
 	return apierrors.IsNotFound(err)
 }
 
-// DeleteBinding - Delete the binding instance and remove the association with the service instance.
-func (d *Dao) DeleteBinding(bindingInstance bundle.BindInstance, serviceInstance bundle.ServiceInstance) error {
-	if err := d.DeleteBindInstance(bindingInstance.ID.String()); err != nil {
-		return err
+// IsConflictError - Will determine if the error is a compare and set conflict.
+func (d *Dao) IsConflictError(err error) bool {
+	if _, ok := err.(ConflictError); ok {
+		return true
 	}
-	serviceInstance.RemoveBinding(bindingInstance.ID)
-	return d.SetServiceInstance(serviceInstance.ID.String(), &serviceInstance)
+	return apierrors.IsConflict(err)
+}
+
+// DeleteBinding - Delete the binding instance and remove the association
+// with the service instance, as a journaled batch so an interrupted delete is
+// finished by a later replay.
+func (d *Dao) DeleteBinding(bindingInstance bundle.BindInstance, serviceInstance bundle.ServiceInstance) error {
+	return d.Apply(types.DeleteBindingBatch(bindingInstance.ID.String(), serviceInstance.ID.String()))
 }
@@ -0,0 +1,60 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// deadLetterKind - the kind of the config maps in the broker namespace holding
+// the job messages the subscribers failed to handle, one per config map, there
+// is no custom resource for them.
+const deadLetterKind = "dead-letter"
+
+// SetDeadLetter - Store the dead letter in a config map of its own.
+func (d *Dao) SetDeadLetter(letter types.DeadLetter) error {
+	payload, err := bundle.DumpJSON(letter)
+	if err != nil {
+		return err
+	}
+	return d.setRecord(deadLetterKind, letter.ID, payload)
+}
+
+// DeleteDeadLetter - Remove the config map of the dead letter.
+func (d *Dao) DeleteDeadLetter(id string) error {
+	return d.deleteRecord(deadLetterKind, id)
+}
+
+// BatchGetDeadLetters - Retrieve the dead letters from their config maps, oldest
+// first.
+func (d *Dao) BatchGetDeadLetters() ([]types.DeadLetter, error) {
+	payloads, err := d.listRecords(deadLetterKind)
+	if err != nil {
+		return nil, err
+	}
+	letters := []types.DeadLetter{}
+	for _, payload := range payloads {
+		letter := types.DeadLetter{}
+		if err := bundle.LoadJSON(payload, &letter); err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	types.SortDeadLetters(letters)
+	return letters, nil
+}
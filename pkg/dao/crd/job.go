@@ -0,0 +1,60 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// jobKind - the kind of the config maps in the broker namespace holding the
+// records of the jobs that have not run yet, one per config map, there is no
+// custom resource for them.
+const jobKind = "job"
+
+// SetJobRecord - Store the job record in a config map of its own.
+func (d *Dao) SetJobRecord(record types.JobRecord) error {
+	payload, err := bundle.DumpJSON(record)
+	if err != nil {
+		return err
+	}
+	return d.setRecord(jobKind, record.Token, payload)
+}
+
+// DeleteJobRecord - Remove the config map of the job record.
+func (d *Dao) DeleteJobRecord(token string) error {
+	return d.deleteRecord(jobKind, token)
+}
+
+// BatchGetJobRecords - Retrieve the job records from their config maps, oldest
+// first.
+func (d *Dao) BatchGetJobRecords() ([]types.JobRecord, error) {
+	payloads, err := d.listRecords(jobKind)
+	if err != nil {
+		return nil, err
+	}
+	records := []types.JobRecord{}
+	for _, payload := range payloads {
+		record := types.JobRecord{}
+		if err := bundle.LoadJSON(payload, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	types.SortJobRecords(records)
+	return records, nil
+}
@@ -0,0 +1,230 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+
+	v1 "github.com/automationbroker/broker-client-go/pkg/apis/automationbroker/v1alpha1"
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/crd"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// The labels the bundle instances and bindings are queried by, kept up to
+// date on every write. The state label is the state of the job written last.
+const (
+	namespaceLabel       = "namespace"
+	specLabel            = "specId"
+	planLabel            = "planId"
+	serviceInstanceLabel = "serviceInstanceId"
+)
+
+// FindServiceInstances - Retrieve the service instances selected by the
+// filter with a label selector.
+func (d *Dao) FindServiceInstances(filter types.InstanceFilter) ([]*bundle.ServiceInstance, error) {
+	log.Debugf("Dao::FindServiceInstances -> %+v", filter)
+	if err := d.ensureLabels(); err != nil {
+		return nil, err
+	}
+	selector := labels.Set{}
+	if filter.Namespace != "" {
+		selector[namespaceLabel] = labelValue(filter.Namespace)
+	}
+	if filter.SpecID != "" {
+		selector[specLabel] = labelValue(filter.SpecID)
+	}
+	if filter.PlanID != "" {
+		selector[planLabel] = labelValue(filter.PlanID)
+	}
+	if filter.State != "" {
+		selector[jobStateLabel] = labelValue(string(stateToCRD(filter.State)))
+	}
+	bl, err := d.client.BundleInstances(d.namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		log.Errorf("unable to find bundleinstances - %v", err)
+		return nil, err
+	}
+
+	instances := []*bundle.ServiceInstance{}
+	for _, bundleInstance := range bl.Items {
+		spec, err := d.GetSpec(bundleInstance.Spec.Bundle.Name)
+		if err != nil {
+			return nil, err
+		}
+		si, err := crd.ConvertServiceInstanceToAPB(bundleInstance, spec, bundleInstance.GetName())
+		if err != nil {
+			log.Errorf("unable to convert service instance to bundle instance - %v", err)
+			return nil, err
+		}
+		// hashed label values could collide.
+		if filter.Matches(si, stateToAPB(bundleInstance.Status.State)) {
+			instances = append(instances, si)
+		}
+	}
+	return instances, nil
+}
+
+// FindBindInstances - Retrieve the bind instances selected by the filter
+// with a label selector.
+func (d *Dao) FindBindInstances(filter types.BindingFilter) ([]*bundle.BindInstance, error) {
+	log.Debugf("Dao::FindBindInstances -> %+v", filter)
+	if err := d.ensureLabels(); err != nil {
+		return nil, err
+	}
+	selector := labels.Set{}
+	if filter.ServiceInstanceID != "" {
+		selector[serviceInstanceLabel] = labelValue(filter.ServiceInstanceID)
+	}
+	if filter.State != "" {
+		selector[jobStateLabel] = labelValue(string(stateToCRD(filter.State)))
+	}
+	bl, err := d.client.BundleBindings(d.namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(selector).String(),
+	})
+	if err != nil {
+		log.Errorf("unable to find bundlebindings - %v", err)
+		return nil, err
+	}
+
+	bindings := []*bundle.BindInstance{}
+	for _, bundleBinding := range bl.Items {
+		bi, err := crd.ConvertServiceBindingToAPB(bundleBinding, bundleBinding.GetName())
+		if err != nil {
+			log.Errorf("unable to convert bundle binding to bind instance - %v", err)
+			return nil, err
+		}
+		if filter.Matches(bi, stateToAPB(bundleBinding.Status.State)) {
+			bindings = append(bindings, bi)
+		}
+	}
+	return bindings, nil
+}
+
+// ensureLabels - labels the bundle instances and bindings written before the
+// labels were maintained, once per broker.
+func (d *Dao) ensureLabels() error {
+	d.labelLock.Lock()
+	defer d.labelLock.Unlock()
+	if d.labelled {
+		return nil
+	}
+
+	sis, err := d.client.BundleInstances(d.namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range sis.Items {
+		si := &sis.Items[i]
+		current := si.GetLabels()
+		labelInstance(si)
+		if reflect.DeepEqual(current, si.GetLabels()) {
+			continue
+		}
+		if _, err := d.client.BundleInstances(d.namespace).Update(si); err != nil && !d.IsConflictError(err) {
+			return err
+		}
+		log.Debugf("labelled bundleinstance %v", si.GetName())
+	}
+
+	bis, err := d.client.BundleBindings(d.namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range bis.Items {
+		bi := &bis.Items[i]
+		current := bi.GetLabels()
+		labelBinding(bi)
+		if reflect.DeepEqual(current, bi.GetLabels()) {
+			continue
+		}
+		if _, err := d.client.BundleBindings(d.namespace).Update(bi); err != nil && !d.IsConflictError(err) {
+			return err
+		}
+		log.Debugf("labelled bundlebinding %v", bi.GetName())
+	}
+	// a conflict means the resource was written, and labelled, since it was
+	// listed.
+	d.labelled = true
+	return nil
+}
+
+// labelInstance - sets the query labels of a bundle instance from its spec
+// and status.
+func labelInstance(si *v1.BundleInstance) {
+	plan := ""
+	if si.Spec.Parameters != "" {
+		params := bundle.Parameters{}
+		if err := json.Unmarshal([]byte(si.Spec.Parameters), &params); err == nil {
+			plan = types.PlanID(&bundle.ServiceInstance{Parameters: &params})
+		}
+	}
+	setLabels(&si.ObjectMeta, map[string]string{
+		namespaceLabel: si.Spec.Context.Namespace,
+		specLabel:      si.Spec.Bundle.Name,
+		planLabel:      plan,
+		jobStateLabel:  string(si.Status.State),
+	})
+}
+
+// labelBinding - sets the query labels of a bundle binding from its spec and
+// status.
+func labelBinding(bi *v1.BundleBinding) {
+	setLabels(&bi.ObjectMeta, map[string]string{
+		serviceInstanceLabel: bi.Spec.BundleInstance.Name,
+		jobStateLabel:        string(bi.Status.State),
+	})
+}
+
+// setLabels - sets the labels with a value and removes the empty ones,
+// keeping any other label.
+func setLabels(meta *metav1.ObjectMeta, values map[string]string) {
+	l := map[string]string{}
+	for k, v := range meta.GetLabels() {
+		l[k] = v
+	}
+	for k, v := range values {
+		if v == "" {
+			delete(l, k)
+			continue
+		}
+		l[k] = labelValue(v)
+	}
+	if len(l) == 0 {
+		l = nil
+	}
+	meta.SetLabels(l)
+}
+
+// labelValue - the value itself when it is a valid label value, otherwise a
+// hash of it.
+func labelValue(v string) string {
+	if len(validation.IsValidLabelValue(v)) == 0 {
+		return v
+	}
+	sum := sha256.Sum256([]byte(v))
+	return "sha256-" + hex.EncodeToString(sum[:])[:48]
+}
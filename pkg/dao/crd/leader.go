@@ -0,0 +1,87 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/clients"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// leaseConfigMapPrefix - the prefix of the config maps in the broker
+	// namespace holding the leases, followed by their name. There is no
+	// custom resource for them.
+	leaseConfigMapPrefix = "ansible-service-broker-lease-"
+	leaseDataKey         = "lease"
+)
+
+// GetLease - Retrieve the lease and the resource version of its config map.
+func (d *Dao) GetLease(name string) (types.Lease, string, error) {
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		return types.Lease{}, "", err
+	}
+	cm, err := k8scli.Client.CoreV1().ConfigMaps(d.namespace).Get(leaseConfigMapPrefix+name, metav1.GetOptions{})
+	if err != nil {
+		return types.Lease{}, "", err
+	}
+	lease := types.Lease{}
+	if err := bundle.LoadJSON(cm.Data[leaseDataKey], &lease); err != nil {
+		return types.Lease{}, "", err
+	}
+	return lease, cm.GetResourceVersion(), nil
+}
+
+// CompareAndSetLease - Set the lease in its config map if the config map has
+// not changed since version was read. An empty version only creates it.
+func (d *Dao) CompareAndSetLease(lease types.Lease, version string) (string, error) {
+	payload, err := bundle.DumpJSON(lease)
+	if err != nil {
+		return "", err
+	}
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		return "", err
+	}
+	configMaps := k8scli.Client.CoreV1().ConfigMaps(d.namespace)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            leaseConfigMapPrefix + lease.Name,
+			Namespace:       d.namespace,
+			ResourceVersion: version,
+		},
+		Data: map[string]string{leaseDataKey: payload},
+	}
+	if version == "" {
+		cm, err = configMaps.Create(cm)
+		if apierrors.IsAlreadyExists(err) {
+			return "", ConflictError{Kind: "lease", Name: lease.Name}
+		}
+	} else {
+		// the update is rejected as a conflict when the resource version
+		// changed.
+		cm, err = configMaps.Update(cm)
+	}
+	if err != nil {
+		return "", err
+	}
+	return cm.GetResourceVersion(), nil
+}
@@ -0,0 +1,114 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/automationbroker/bundle-lib/clients"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// recordKindLabel - the label of the config maps holding the records
+	// there is no custom resource for, one record per config map, set to
+	// the kind of the record.
+	recordKindLabel = "ansible-service-broker-record"
+	// recordDataKey - the key of the record in the data of its config map.
+	recordDataKey = "record"
+)
+
+// recordConfigMapName - the name of the config map holding the record of the
+// kind with the id.
+func recordConfigMapName(kind string, id string) string {
+	name := fmt.Sprintf("ansible-service-broker-%s-%s", kind, id)
+	if len(validation.IsDNS1123Subdomain(name)) == 0 {
+		return name
+	}
+	sum := sha256.Sum256([]byte(id))
+	return fmt.Sprintf("ansible-service-broker-%s-%s", kind, hex.EncodeToString(sum[:])[:48])
+}
+
+// setRecord - stores the record of the kind in a config map of its own in the
+// broker namespace, so that the records are not bound together by the size
+// limit of a single object.
+func (d *Dao) setRecord(kind string, id string, payload string) error {
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		return err
+	}
+	configMaps := k8scli.Client.CoreV1().ConfigMaps(d.namespace)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      recordConfigMapName(kind, id),
+			Namespace: d.namespace,
+			Labels:    map[string]string{recordKindLabel: kind},
+		},
+		Data: map[string]string{recordDataKey: payload},
+	}
+	_, err = configMaps.Create(cm)
+	if apierrors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(cm)
+	}
+	if err != nil {
+		log.Errorf("unable to store the %s record %s - %v", kind, id, err)
+	}
+	return err
+}
+
+// deleteRecord - deletes the config map of the record of the kind, a record
+// that does not exist is not an error.
+func (d *Dao) deleteRecord(kind string, id string) error {
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		return err
+	}
+	err = k8scli.Client.CoreV1().ConfigMaps(d.namespace).Delete(recordConfigMapName(kind, id), &metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Errorf("unable to delete the %s record %s - %v", kind, id, err)
+		return err
+	}
+	return nil
+}
+
+// listRecords - the payloads of the records of the kind.
+func (d *Dao) listRecords(kind string) ([]string, error) {
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.SelectorFromSet(labels.Set{recordKindLabel: kind})
+	cms, err := k8scli.Client.CoreV1().ConfigMaps(d.namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		log.Errorf("unable to list the %s records - %v", kind, err)
+		return nil, err
+	}
+	payloads := make([]string, 0, len(cms.Items))
+	for _, cm := range cms.Items {
+		if payload, ok := cm.Data[recordDataKey]; ok {
+			payloads = append(payloads, payload)
+		}
+	}
+	return payloads, nil
+}
@@ -0,0 +1,45 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	v1 "github.com/automationbroker/broker-client-go/pkg/apis/automationbroker/v1alpha1"
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/crd"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// stateCancelled - the state the cancelled jobs are stored with. The
+// conversions of bundle-lib only know the states of the jobs bundle-lib runs,
+// and would store the cancelled jobs as failed.
+const stateCancelled v1.State = "cancelled"
+
+// stateToCRD - the state of the crd for the job state.
+func stateToCRD(s bundle.State) v1.State {
+	if s == types.StateCancelled {
+		return stateCancelled
+	}
+	return crd.ConvertStateToCRD(s)
+}
+
+// stateToAPB - the job state for the state of the crd.
+func stateToAPB(s v1.State) bundle.State {
+	if s == stateCancelled {
+		return types.StateCancelled
+	}
+	return crd.ConvertStateToAPB(s)
+}
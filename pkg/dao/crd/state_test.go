@@ -0,0 +1,114 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"strconv"
+	"testing"
+
+	automationbrokerv1 "github.com/automationbroker/broker-client-go/client/clientset/versioned/typed/automationbroker/v1alpha1"
+	v1 "github.com/automationbroker/broker-client-go/pkg/apis/automationbroker/v1alpha1"
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// instanceClient - a client holding bundle instances, without bindings.
+type instanceClient struct {
+	automationbrokerv1.AutomationbrokerV1alpha1Interface
+	instances *instances
+}
+
+func (c instanceClient) BundleInstances(namespace string) automationbrokerv1.BundleInstanceInterface {
+	return c.instances
+}
+
+func (c instanceClient) BundleBindings(namespace string) automationbrokerv1.BundleBindingInterface {
+	return bindings{}
+}
+
+type instances struct {
+	automationbrokerv1.BundleInstanceInterface
+	items   map[string]*v1.BundleInstance
+	version int
+}
+
+func (i *instances) Get(name string, options metav1.GetOptions) (*v1.BundleInstance, error) {
+	si, ok := i.items[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "bundleinstances"}, name)
+	}
+	return si.DeepCopy(), nil
+}
+
+func (i *instances) Update(si *v1.BundleInstance) (*v1.BundleInstance, error) {
+	i.version++
+	si = si.DeepCopy()
+	si.ResourceVersion = strconv.Itoa(i.version)
+	i.items[si.GetName()] = si
+	return si.DeepCopy(), nil
+}
+
+type bindings struct {
+	automationbrokerv1.BundleBindingInterface
+}
+
+func (bindings) Get(name string, options metav1.GetOptions) (*v1.BundleBinding, error) {
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "bundlebindings"}, name)
+}
+
+func TestCancelledJobState(t *testing.T) {
+	si := &v1.BundleInstance{ObjectMeta: metav1.ObjectMeta{Name: "instance"}}
+	client := instanceClient{instances: &instances{items: map[string]*v1.BundleInstance{"instance": si}}}
+	d := &Dao{client: client, namespace: "broker"}
+
+	state := bundle.JobState{
+		Token:       "token",
+		Method:      bundle.JobMethodProvision,
+		State:       types.StateCancelled,
+		Description: "provision job cancelled",
+	}
+	_, err := d.SetState("instance", state)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, stateCancelled, client.instances.items["instance"].Status.State)
+
+	stored, _, err := d.GetStateVersion("instance", "token")
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, types.StateCancelled, stored.State)
+	ft.AssertEqual(t, "provision job cancelled", stored.Description)
+
+	// the states bundle-lib knows are converted by bundle-lib.
+	ft.AssertEqual(t, bundle.StateFailed, stateToAPB(stateToCRD(bundle.StateFailed)))
+	ft.AssertEqual(t, v1.StateSucceeded, stateToCRD(bundle.StateSucceeded))
+}
+
+func TestMissingJobStateNotFound(t *testing.T) {
+	si := &v1.BundleInstance{ObjectMeta: metav1.ObjectMeta{Name: "instance"}}
+	client := instanceClient{instances: &instances{items: map[string]*v1.BundleInstance{"instance": si}}}
+	d := &Dao{client: client, namespace: "broker"}
+
+	_, _, err := d.GetStateVersion("instance", "token")
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+
+	_, err = d.SetState("instance", bundle.JobState{Token: "token", Method: bundle.JobMethodProvision, State: bundle.StateInProgress})
+	ft.AssertNil(t, err)
+	_, _, err = d.GetStateVersion("instance", "other")
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+}
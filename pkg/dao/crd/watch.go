@@ -0,0 +1,282 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"reflect"
+	"sync"
+
+	v1 "github.com/automationbroker/broker-client-go/pkg/apis/automationbroker/v1alpha1"
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/crd"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Watch - Report the changes made after the watch started using an informer
+// for bundles, bundle instances and bundle bindings. Job states are reported
+// by comparing the jobs of an instance or binding with its previous copy.
+func (d *Dao) Watch(stop <-chan struct{}) (<-chan types.Event, error) {
+	events := make(chan types.Event)
+	w := &watcher{dao: d, events: events, stop: stop}
+
+	informers := []cache.Controller{
+		newInformer(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return d.client.Bundles(d.namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return d.client.Bundles(d.namespace).Watch(options)
+			},
+		}, &v1.Bundle{}, w.bundleChanged),
+		newInformer(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return d.client.BundleInstances(d.namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return d.client.BundleInstances(d.namespace).Watch(options)
+			},
+		}, &v1.BundleInstance{}, w.instanceChanged),
+		newInformer(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return d.client.BundleBindings(d.namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return d.client.BundleBindings(d.namespace).Watch(options)
+			},
+		}, &v1.BundleBinding{}, w.bindingChanged),
+	}
+
+	wg := sync.WaitGroup{}
+	for _, informer := range informers {
+		wg.Add(1)
+		go func(informer cache.Controller) {
+			defer wg.Done()
+			informer.Run(stop)
+		}(informer)
+	}
+	// the handlers are done once every informer returned.
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+	return events, nil
+}
+
+// newInformer - creates an informer that calls changed for the changes made
+// after it started. The objects returned by the first list are remembered so
+// their add notifications can be dropped, objects that show up in a later
+// relist are reported as added.
+func newInformer(lw *cache.ListWatch, obj runtime.Object,
+	changed func(t types.EventType, old interface{}, new interface{})) cache.Controller {
+
+	lock := sync.Mutex{}
+	initial := map[string]string{}
+	watching := false
+
+	list := lw.ListFunc
+	lw.ListFunc = func(options metav1.ListOptions) (runtime.Object, error) {
+		o, err := list(options)
+		if err != nil {
+			return o, err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if !watching {
+			items, _ := meta.ExtractList(o)
+			for _, item := range items {
+				if m, err := meta.Accessor(item); err == nil {
+					initial[m.GetName()] = m.GetResourceVersion()
+				}
+			}
+		}
+		return o, nil
+	}
+	watchFunc := lw.WatchFunc
+	lw.WatchFunc = func(options metav1.ListOptions) (watch.Interface, error) {
+		lock.Lock()
+		watching = true
+		lock.Unlock()
+		return watchFunc(options)
+	}
+
+	_, controller := cache.NewInformer(lw, obj, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(o interface{}) {
+			m, err := meta.Accessor(o)
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			version, listed := initial[m.GetName()]
+			delete(initial, m.GetName())
+			lock.Unlock()
+			if listed && version == m.GetResourceVersion() {
+				return
+			}
+			changed(types.Added, nil, o)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			o, err := meta.Accessor(old)
+			if err != nil {
+				return
+			}
+			n, err := meta.Accessor(new)
+			if err != nil || o.GetResourceVersion() == n.GetResourceVersion() {
+				return
+			}
+			changed(types.Updated, old, new)
+		},
+		DeleteFunc: func(o interface{}) {
+			if unknown, ok := o.(cache.DeletedFinalStateUnknown); ok {
+				o = unknown.Obj
+			}
+			changed(types.Deleted, o, nil)
+		},
+	})
+	return controller
+}
+
+type watcher struct {
+	dao    *Dao
+	events chan<- types.Event
+	stop   <-chan struct{}
+}
+
+func (w *watcher) send(e types.Event) {
+	select {
+	case w.events <- e:
+	case <-w.stop:
+	}
+}
+
+func (w *watcher) bundleChanged(t types.EventType, old, new interface{}) {
+	b, ok := current(t, old, new).(*v1.Bundle)
+	if !ok {
+		return
+	}
+	e := types.Event{Type: t, Kind: types.KindSpec, ID: b.GetName()}
+	spec, err := crd.ConvertBundleToSpec(b.Spec, b.GetName())
+	if err != nil {
+		log.Warningf("Unable to convert the watched bundle [ %s ] - %v", b.GetName(), err)
+	}
+	e.Spec = spec
+	w.send(e)
+}
+
+func (w *watcher) instanceChanged(t types.EventType, old, new interface{}) {
+	si, ok := current(t, old, new).(*v1.BundleInstance)
+	if !ok {
+		return
+	}
+	var oldJobs, newJobs map[string]v1.Job
+	if o, ok := old.(*v1.BundleInstance); ok {
+		oldJobs = o.Status.Jobs
+	}
+	if n, ok := new.(*v1.BundleInstance); ok {
+		newJobs = n.Status.Jobs
+	}
+
+	// the spec may already be gone when the instance is deleted.
+	spec, err := w.dao.GetSpec(si.Spec.Bundle.Name)
+	if err != nil {
+		log.Debugf("Unable to get the spec of the watched instance [ %s ] - %v", si.GetName(), err)
+		spec = nil
+	}
+	e := types.Event{Type: t, Kind: types.KindServiceInstance, ID: si.GetName()}
+	e.ServiceInstance, err = crd.ConvertServiceInstanceToAPB(*si, spec, si.GetName())
+	if err != nil {
+		log.Warningf("Unable to convert the watched instance [ %s ] - %v", si.GetName(), err)
+	}
+	if t != types.Updated || !reflect.DeepEqual(si.Spec, old.(*v1.BundleInstance).Spec) ||
+		!reflect.DeepEqual(si.Status.Bindings, old.(*v1.BundleInstance).Status.Bindings) {
+		w.send(e)
+	}
+	w.jobsChanged(si.GetName(), oldJobs, newJobs)
+}
+
+func (w *watcher) bindingChanged(t types.EventType, old, new interface{}) {
+	bi, ok := current(t, old, new).(*v1.BundleBinding)
+	if !ok {
+		return
+	}
+	var oldJobs, newJobs map[string]v1.Job
+	if o, ok := old.(*v1.BundleBinding); ok {
+		oldJobs = o.Status.Jobs
+	}
+	if n, ok := new.(*v1.BundleBinding); ok {
+		newJobs = n.Status.Jobs
+	}
+
+	e := types.Event{Type: t, Kind: types.KindBindInstance, ID: bi.GetName()}
+	binding, err := crd.ConvertServiceBindingToAPB(*bi, bi.GetName())
+	if err != nil {
+		log.Warningf("Unable to convert the watched binding [ %s ] - %v", bi.GetName(), err)
+	}
+	e.BindInstance = binding
+	if t != types.Updated || !reflect.DeepEqual(bi.Spec, old.(*v1.BundleBinding).Spec) {
+		w.send(e)
+	}
+	w.jobsChanged(bi.GetName(), oldJobs, newJobs)
+}
+
+// jobsChanged - reports the job states that differ between the jobs of the
+// previous and the current copy of an instance or binding.
+func (w *watcher) jobsChanged(id string, old, new map[string]v1.Job) {
+	for token, j := range new {
+		prev, existed := old[token]
+		if existed && reflect.DeepEqual(prev, j) {
+			continue
+		}
+		t := types.Updated
+		if !existed {
+			t = types.Added
+		}
+		state := jobStateFromCRD(token, j)
+		w.send(types.Event{Type: t, Kind: types.KindJobState, ID: id, JobState: &state})
+	}
+	for token, j := range old {
+		if _, ok := new[token]; ok {
+			continue
+		}
+		state := jobStateFromCRD(token, j)
+		w.send(types.Event{Type: types.Deleted, Kind: types.KindJobState, ID: id, JobState: &state})
+	}
+}
+
+// current - the object an event is about, the previous copy for deletes.
+func current(t types.EventType, old, new interface{}) interface{} {
+	if t == types.Deleted {
+		return old
+	}
+	return new
+}
+
+func jobStateFromCRD(token string, j v1.Job) bundle.JobState {
+	return bundle.JobState{
+		Description: j.Description,
+		Method:      crd.ConvertJobMethodToAPB(j.Method),
+		Podname:     j.Podname,
+		Token:       token,
+		State:       stateToAPB(j.State),
+		Error:       j.Error,
+	}
+}
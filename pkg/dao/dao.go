@@ -17,21 +17,172 @@
 package dao
 
 import (
+	"context"
+	"strings"
+	"time"
+
 	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/clients"
 	"github.com/automationbroker/config"
 	crd "github.com/openshift/ansible-service-broker/pkg/dao/crd"
+	"github.com/openshift/ansible-service-broker/pkg/dao/encryption"
 	etcd "github.com/openshift/ansible-service-broker/pkg/dao/etcd"
+	etcdv3 "github.com/openshift/ansible-service-broker/pkg/dao/etcdv3"
+	file "github.com/openshift/ansible-service-broker/pkg/dao/file"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	log "github.com/sirupsen/logrus"
 )
 
-// NewDao - Create a new Dao object
+// InitFromConfig - Initializes the client the dao of the config connects
+// with, when it shares the etcd v2 client of bundle-lib, and creates the dao
+// with NewDao. The broker and the commands run against its data store all
+// connect this way.
+func InitFromConfig(c *config.Config) (Dao, error) {
+	if BackendType(c) == "etcd" {
+		clients.InitEtcdConfig(etcdConfig(c))
+		etcdClient, err := clients.Etcd()
+		if err != nil {
+			return nil, err
+		}
+		version, err := etcdClient.GetVersion(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Etcd Version [Server: %s, Cluster: %s]", version.Server, version.Cluster)
+	}
+	return NewDao(c)
+}
+
+// NewDao - Create a new Dao object. When dao.metrics is set the latency and
+// errors of the data store calls are recorded. When dao.encryption_key_file
+// is set the parameters of service and bind instances are encrypted with its
+// keys. When dao.cache_size is set the specs and service instances read are
+// cached.
 func NewDao(c *config.Config) (Dao, error) {
-	if c.GetString("dao.type") == "crd" {
+	d, err := newBackend(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.GetBool("dao.metrics") {
+		d = NewInstrumentedDao(d, BackendType(c))
+	}
+	if keyFile := c.GetString("dao.encryption_key_file"); keyFile != "" {
+		keys, err := encryption.LoadKeyFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Encrypting instance parameters with key [ %s ]", keys.PrimaryKeyID())
+		d = NewEncryptedDao(d, keys)
+	}
+	return newCache(c, d)
+}
+
+// newCache - wraps d in a CachedDao configured by the dao.cache_* settings.
+func newCache(c *config.Config, d Dao) (Dao, error) {
+	size := c.GetInt("dao.cache_size")
+	if size <= 0 {
+		return d, nil
+	}
+	ttl := defaultCacheTTL
+	if c.GetString("dao.cache_ttl") != "" {
+		var err error
+		ttl, err = time.ParseDuration(c.GetString("dao.cache_ttl"))
+		if err != nil {
+			log.Errorf("Unable to parse dao.cache_ttl - %v", err)
+			return nil, err
+		}
+	}
+	cached, err := NewCachedDao(d, size, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if c.GetBool("dao.cache_watch") {
+		// the cache lives as long as the broker, so the watch is never stopped.
+		if err := cached.InvalidateOnWatch(nil); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("Caching up to %d specs and service instances for %v", size, ttl)
+	return cached, nil
+}
+
+// RecordUpgrader - A Dao storing its records as versioned JSON, which can
+// rewrite the records stored with an older schema version in the current one.
+type RecordUpgrader interface {
+	// UpgradeRecords - Rewrite the records stored with an older schema version,
+	// or only count them with dryRun. Returns how many records were, or would
+	// be, upgraded.
+	UpgradeRecords(dryRun bool) (int, error)
+}
+
+// Backend - the Dao of the data store itself, without the decorators NewDao
+// may have wrapped it in.
+func Backend(d Dao) Dao {
+	for {
+		switch w := d.(type) {
+		case *CachedDao:
+			d = w.Dao
+		case *EncryptedDao:
+			d = w.Dao
+		case *InstrumentedDao:
+			d = w.Dao
+		default:
+			return d
+		}
+	}
+}
+
+func newBackend(c *config.Config) (Dao, error) {
+	switch BackendType(c) {
+	case "crd":
 		return crd.NewDao(c.GetString("openshift.namespace"))
+	case "file":
+		return file.NewDao(c.GetString("dao.file_path"))
+	case "memory":
+		return memory.NewDao()
+	case "etcd3":
+		return newEtcdV3(c)
 	}
 	return etcd.NewDao()
 
 }
 
+// newEtcdV3 - connects to the etcd v3 API with the dao.etcd_* settings.
+func newEtcdV3(c *config.Config) (Dao, error) {
+	var ttl time.Duration
+	if c.GetString("dao.etcd_job_state_ttl") != "" {
+		var err error
+		ttl, err = time.ParseDuration(c.GetString("dao.etcd_job_state_ttl"))
+		if err != nil {
+			log.Errorf("Unable to parse dao.etcd_job_state_ttl - %v", err)
+			return nil, err
+		}
+	}
+	return etcdv3.NewDao(etcdConfig(c), ttl)
+}
+
+// etcdConfig - the dao.etcd_* settings, used by the etcd and etcd3 daos.
+func etcdConfig(c *config.Config) clients.EtcdConfig {
+	return clients.EtcdConfig{
+		EtcdHost:       c.GetString("dao.etcd_host"),
+		EtcdPort:       c.GetInt("dao.etcd_port"),
+		EtcdCaFile:     c.GetString("dao.etcd_ca_file"),
+		EtcdClientKey:  c.GetString("dao.etcd_client_key"),
+		EtcdClientCert: c.GetString("dao.etcd_client_cert"),
+	}
+}
+
+// BackendType - the dao.type of the config in lower case, etcd unless
+// another known backend is set.
+func BackendType(c *config.Config) string {
+	switch t := strings.ToLower(c.GetString("dao.type")); t {
+	case "crd", "file", "memory", "etcd3":
+		return t
+	}
+	return "etcd"
+}
+
 //go:generate mockery -name=Dao -case=underscore -inpkg -note=Generated
 
 // Dao - object to interface with the data store.
@@ -58,6 +209,14 @@ type Dao interface {
 	// BatchDeleteSpecs - set specs based on SpecManifest in the kvp API.
 	BatchDeleteSpecs([]*bundle.Spec) error
 
+	// GetSpecsPage - Retrieve up to limit specs starting at the continue token. Returns the token
+	// for the next page, which is empty after the last page.
+	GetSpecsPage(string, int) ([]*bundle.Spec, string, error)
+
+	// GetServiceInstancesPage - Retrieve up to limit service instances starting at the continue
+	// token. Returns the token for the next page, which is empty after the last page.
+	GetServiceInstancesPage(string, int) ([]*bundle.ServiceInstance, string, error)
+
 	// FindJobStateByState - Retrieve all the jobs that match the specified state
 	FindJobStateByState(bundle.State) ([]bundle.RecoverStatus, error)
 
@@ -96,4 +255,96 @@ type Dao interface {
 
 	// IsNotFoundError - Will determine if the error is a not found error from the DAO implementation.
 	IsNotFoundError(err error) bool
+
+	// GetServiceInstanceVersion - Retrieve a service instance along with the version it was read at.
+	GetServiceInstanceVersion(string) (*bundle.ServiceInstance, string, error)
+
+	// CompareAndSetServiceInstance - Set the service instance only if it is still at the given
+	// version, an empty version only creates it. Returns the new version.
+	CompareAndSetServiceInstance(string, *bundle.ServiceInstance, string) (string, error)
+
+	// GetBindInstanceVersion - Retrieve a bind instance along with the version it was read at.
+	GetBindInstanceVersion(string) (*bundle.BindInstance, string, error)
+
+	// CompareAndSetBindInstance - Set the bind instance only if it is still at the given
+	// version, an empty version only creates it. Returns the new version.
+	CompareAndSetBindInstance(string, *bundle.BindInstance, string) (string, error)
+
+	// GetStateVersion - Retrieve a job state for an ID and Token along with the version it was read at.
+	GetStateVersion(string, string) (bundle.JobState, string, error)
+
+	// CompareAndSetState - Set the Job State for id only if it is still at the given version,
+	// an empty version only creates it. Returns the new version.
+	CompareAndSetState(string, bundle.JobState, string) (string, error)
+
+	// IsConflictError - Will determine if the error is a compare and set conflict from the DAO implementation.
+	IsConflictError(err error) bool
+
+	// BatchGetJobStates - Retrieve every job state along with the id it belongs to and when it was last modified.
+	BatchGetJobStates() ([]types.JobStateRecord, error)
+
+	// DeleteState - Delete the job state for an ID and Token.
+	DeleteState(string, string) error
+
+	// BatchGetBindInstances - Retrieve all the bind instances.
+	BatchGetBindInstances() ([]*bundle.BindInstance, error)
+
+	// FindServiceInstances - Retrieve the service instances selected by the filter.
+	FindServiceInstances(types.InstanceFilter) ([]*bundle.ServiceInstance, error)
+
+	// FindBindInstances - Retrieve the bind instances selected by the filter.
+	FindBindInstances(types.BindingFilter) ([]*bundle.BindInstance, error)
+
+	// Watch - Report the changes made to specs, instances, bindings and job states after the
+	// watch started, including the ones made by other brokers. The channel is closed once stop is.
+	Watch(<-chan struct{}) (<-chan types.Event, error)
+
+	// Apply - Make the writes of the batch all together or not at all, and record its intents as
+	// pending once they are made.
+	Apply(types.Batch) error
+
+	// PendingIntents - Retrieve the intents of applied batches that have not been completed,
+	// ordered by id. Backends without transactions first replay the batches left unfinished.
+	PendingIntents() ([]types.Intent, error)
+
+	// CompleteIntent - Forget the intent once its action has been carried out. Completing an
+	// intent that is not pending is not an error.
+	CompleteIntent(string) error
+
+	// SetJobRecord - Store the record of a job accepted by the work engine, keyed by its token.
+	SetJobRecord(types.JobRecord) error
+
+	// DeleteJobRecord - Delete the record of the job once it has run. Deleting a record that is
+	// not stored is not an error.
+	DeleteJobRecord(string) error
+
+	// BatchGetJobRecords - Retrieve the records of the jobs that have not run yet, oldest first.
+	BatchGetJobRecords() ([]types.JobRecord, error)
+
+	// SetDeadLetter - Store a job message a subscriber failed to handle, keyed by its id.
+	SetDeadLetter(types.DeadLetter) error
+
+	// DeleteDeadLetter - Delete the dead letter once it is replayed. Deleting a dead letter that
+	// is not stored is not an error.
+	DeleteDeadLetter(string) error
+
+	// BatchGetDeadLetters - Retrieve the dead letters, oldest first.
+	BatchGetDeadLetters() ([]types.DeadLetter, error)
+
+	// SetAuditRecord - Store an audit event, keyed by its id.
+	SetAuditRecord(types.AuditRecord) error
+
+	// DeleteAuditRecord - Delete the audit record once it is no longer kept. Deleting a record
+	// that is not stored is not an error.
+	DeleteAuditRecord(string) error
+
+	// BatchGetAuditRecords - Retrieve the audit records, oldest first.
+	BatchGetAuditRecords() ([]types.AuditRecord, error)
+
+	// GetLease - Retrieve the lease of a name along with the version it was read at.
+	GetLease(string) (types.Lease, string, error)
+
+	// CompareAndSetLease - Set the lease only if it is still at the given version, an empty
+	// version only creates it. Returns the new version.
+	CompareAndSetLease(types.Lease, string) (string, error)
 }
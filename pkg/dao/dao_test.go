@@ -0,0 +1,48 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"testing"
+
+	"github.com/automationbroker/config"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+func TestBackendType(t *testing.T) {
+	for daoType, expected := range map[string]string{
+		"":        "etcd",
+		"etcd":    "etcd",
+		"Etcd":    "etcd",
+		"ETCD3":   "etcd3",
+		"crd":     "crd",
+		"File":    "file",
+		"unknown": "etcd",
+	} {
+		c := config.NewConfigFromMap(map[string]interface{}{"dao": map[string]interface{}{"type": daoType}})
+		ft.AssertEqual(t, expected, BackendType(c), daoType)
+	}
+}
+
+func TestInitFromConfig(t *testing.T) {
+	c := config.NewConfigFromMap(map[string]interface{}{"dao": map[string]interface{}{"type": "Memory"}})
+	d, err := InitFromConfig(c)
+	ft.AssertNil(t, err)
+	_, ok := d.(*memory.Dao)
+	ft.AssertTrue(t, ok)
+}
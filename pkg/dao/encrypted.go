@@ -0,0 +1,538 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/encryption"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// EncryptedDao - wraps a Dao and encrypts the parameters of service and bind
+// instances before they are written, decrypting them again when read. Every
+// other record is passed through untouched.
+type EncryptedDao struct {
+	Dao
+	keys *encryption.KeyRing
+}
+
+// NewEncryptedDao - creates a Dao encrypting the instance parameters written
+// to d with keys.
+func NewEncryptedDao(d Dao, keys *encryption.KeyRing) *EncryptedDao {
+	return &EncryptedDao{Dao: d, keys: keys}
+}
+
+// GetServiceInstance - Retrieve and decrypt the service instance.
+func (d *EncryptedDao) GetServiceInstance(id string) (*bundle.ServiceInstance, error) {
+	si, err := d.Dao.GetServiceInstance(id)
+	if err != nil {
+		return nil, err
+	}
+	return d.decryptServiceInstance(si)
+}
+
+// SetServiceInstance - Encrypt and set the service instance.
+func (d *EncryptedDao) SetServiceInstance(id string, si *bundle.ServiceInstance) error {
+	encrypted, err := d.encryptServiceInstance(si)
+	if err != nil {
+		return err
+	}
+	return d.Dao.SetServiceInstance(id, encrypted)
+}
+
+// GetServiceInstanceVersion - Retrieve and decrypt the service instance along
+// with its version.
+func (d *EncryptedDao) GetServiceInstanceVersion(id string) (*bundle.ServiceInstance, string, error) {
+	si, version, err := d.Dao.GetServiceInstanceVersion(id)
+	if err != nil {
+		return nil, "", err
+	}
+	si, err = d.decryptServiceInstance(si)
+	return si, version, err
+}
+
+// CompareAndSetServiceInstance - Encrypt and set the service instance if it
+// is still at version.
+func (d *EncryptedDao) CompareAndSetServiceInstance(id string, si *bundle.ServiceInstance, version string) (string, error) {
+	encrypted, err := d.encryptServiceInstance(si)
+	if err != nil {
+		return "", err
+	}
+	return d.Dao.CompareAndSetServiceInstance(id, encrypted, version)
+}
+
+// BatchGetBundleInstances - Retrieve and decrypt all the service instances.
+func (d *EncryptedDao) BatchGetBundleInstances() ([]*bundle.ServiceInstance, error) {
+	instances, err := d.Dao.BatchGetBundleInstances()
+	if err != nil {
+		return nil, err
+	}
+	return d.decryptServiceInstances(instances)
+}
+
+// GetServiceInstancesPage - Retrieve and decrypt a page of service instances.
+func (d *EncryptedDao) GetServiceInstancesPage(continueToken string, limit int) ([]*bundle.ServiceInstance, string, error) {
+	instances, next, err := d.Dao.GetServiceInstancesPage(continueToken, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	instances, err = d.decryptServiceInstances(instances)
+	return instances, next, err
+}
+
+// GetBindInstance - Retrieve and decrypt the bind instance.
+func (d *EncryptedDao) GetBindInstance(id string) (*bundle.BindInstance, error) {
+	bi, err := d.Dao.GetBindInstance(id)
+	if err != nil {
+		return nil, err
+	}
+	return d.decryptBindInstance(bi)
+}
+
+// SetBindInstance - Encrypt and set the bind instance.
+func (d *EncryptedDao) SetBindInstance(id string, bi *bundle.BindInstance) error {
+	encrypted, err := d.encryptBindInstance(bi)
+	if err != nil {
+		return err
+	}
+	return d.Dao.SetBindInstance(id, encrypted)
+}
+
+// GetBindInstanceVersion - Retrieve and decrypt the bind instance along with
+// its version.
+func (d *EncryptedDao) GetBindInstanceVersion(id string) (*bundle.BindInstance, string, error) {
+	bi, version, err := d.Dao.GetBindInstanceVersion(id)
+	if err != nil {
+		return nil, "", err
+	}
+	bi, err = d.decryptBindInstance(bi)
+	return bi, version, err
+}
+
+// CompareAndSetBindInstance - Encrypt and set the bind instance if it is
+// still at version.
+func (d *EncryptedDao) CompareAndSetBindInstance(id string, bi *bundle.BindInstance, version string) (string, error) {
+	encrypted, err := d.encryptBindInstance(bi)
+	if err != nil {
+		return "", err
+	}
+	return d.Dao.CompareAndSetBindInstance(id, encrypted, version)
+}
+
+// Apply - Encrypt the instances and bindings the batch writes and apply it.
+func (d *EncryptedDao) Apply(batch types.Batch) error {
+	ops := make([]types.Op, len(batch.Ops))
+	for i, op := range batch.Ops {
+		var err error
+		ops[i] = op
+		if op.ServiceInstance != nil {
+			if ops[i].ServiceInstance, err = d.encryptServiceInstance(op.ServiceInstance); err != nil {
+				return err
+			}
+		}
+		if op.BindInstance != nil {
+			if ops[i].BindInstance, err = d.encryptBindInstance(op.BindInstance); err != nil {
+				return err
+			}
+		}
+	}
+	return d.Dao.Apply(types.Batch{Ops: ops, Intents: batch.Intents})
+}
+
+// SetJobRecord - Encrypt the parameters of the job record and set it.
+func (d *EncryptedDao) SetJobRecord(record types.JobRecord) error {
+	var err error
+	if record.ServiceInstance, err = d.encryptServiceInstance(record.ServiceInstance); err != nil {
+		return err
+	}
+	if record.Parameters != nil {
+		params, err := d.keys.Encrypt(*record.Parameters)
+		if err != nil {
+			return err
+		}
+		p := bundle.Parameters(params)
+		record.Parameters = &p
+	}
+	return d.Dao.SetJobRecord(record)
+}
+
+// BatchGetJobRecords - Retrieve the job records and decrypt their parameters.
+func (d *EncryptedDao) BatchGetJobRecords() ([]types.JobRecord, error) {
+	records, err := d.Dao.BatchGetJobRecords()
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		if records[i], err = d.decryptJobRecord(record); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func (d *EncryptedDao) decryptJobRecord(record types.JobRecord) (types.JobRecord, error) {
+	var err error
+	if record.ServiceInstance, err = d.decryptServiceInstance(record.ServiceInstance); err != nil {
+		return record, err
+	}
+	if record.Parameters != nil {
+		params, err := d.keys.Decrypt(*record.Parameters)
+		if err != nil {
+			return record, err
+		}
+		p := bundle.Parameters(params)
+		record.Parameters = &p
+	}
+	return record, nil
+}
+
+// SetDeadLetter - Encrypt the message of the dead letter and set it.
+func (d *EncryptedDao) SetDeadLetter(letter types.DeadLetter) error {
+	message, err := d.keys.Encrypt(letter.Message)
+	if err != nil {
+		return err
+	}
+	letter.Message = message
+	return d.Dao.SetDeadLetter(letter)
+}
+
+// BatchGetDeadLetters - Retrieve the dead letters and decrypt their messages.
+func (d *EncryptedDao) BatchGetDeadLetters() ([]types.DeadLetter, error) {
+	letters, err := d.Dao.BatchGetDeadLetters()
+	if err != nil {
+		return nil, err
+	}
+	for i, letter := range letters {
+		if letters[i].Message, err = d.keys.Decrypt(letter.Message); err != nil {
+			return nil, err
+		}
+	}
+	return letters, nil
+}
+
+// BatchGetBindInstances - Retrieve and decrypt all the bind instances.
+func (d *EncryptedDao) BatchGetBindInstances() ([]*bundle.BindInstance, error) {
+	bindings, err := d.Dao.BatchGetBindInstances()
+	if err != nil {
+		return nil, err
+	}
+	for i, bi := range bindings {
+		if bindings[i], err = d.decryptBindInstance(bi); err != nil {
+			return nil, err
+		}
+	}
+	return bindings, nil
+}
+
+// FindServiceInstances - Retrieve and decrypt the service instances selected
+// by the filter. The plan id is kept in the encrypted parameters, so the
+// instances are selected by plan once decrypted.
+func (d *EncryptedDao) FindServiceInstances(filter types.InstanceFilter) ([]*bundle.ServiceInstance, error) {
+	backendFilter := filter
+	backendFilter.PlanID = ""
+	instances, err := d.Dao.FindServiceInstances(backendFilter)
+	if err != nil {
+		return nil, err
+	}
+	instances, err = d.decryptServiceInstances(instances)
+	if err != nil || filter.PlanID == "" {
+		return instances, err
+	}
+	found := []*bundle.ServiceInstance{}
+	for _, si := range instances {
+		if types.PlanID(si) == filter.PlanID {
+			found = append(found, si)
+		}
+	}
+	return found, nil
+}
+
+// FindBindInstances - Retrieve and decrypt the bind instances selected by the
+// filter.
+func (d *EncryptedDao) FindBindInstances(filter types.BindingFilter) ([]*bundle.BindInstance, error) {
+	bindings, err := d.Dao.FindBindInstances(filter)
+	if err != nil {
+		return nil, err
+	}
+	for i, bi := range bindings {
+		if bindings[i], err = d.decryptBindInstance(bi); err != nil {
+			return nil, err
+		}
+	}
+	return bindings, nil
+}
+
+// Watch - Report the changes made after the watch started with the instance
+// parameters decrypted. Events that can not be decrypted are reported
+// without the instance.
+func (d *EncryptedDao) Watch(stop <-chan struct{}) (<-chan types.Event, error) {
+	in, err := d.Dao.Watch(stop)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan types.Event)
+	go func() {
+		defer close(out)
+		for e := range in {
+			var err error
+			if e.ServiceInstance != nil {
+				e.ServiceInstance, err = d.decryptServiceInstance(e.ServiceInstance)
+			}
+			if e.BindInstance != nil {
+				e.BindInstance, err = d.decryptBindInstance(e.BindInstance)
+			}
+			if err != nil {
+				log.Warningf("Unable to decrypt the watched %s [ %s ] - %v", e.Kind, e.ID, err)
+			}
+			select {
+			case out <- e:
+			case <-stop:
+			}
+		}
+	}()
+	return out, nil
+}
+
+// ReencryptServiceInstance - rewrites the service instance encrypted with the
+// primary key. Returns false when it already was.
+func (d *EncryptedDao) ReencryptServiceInstance(id string) (bool, error) {
+	rotated := false
+	err := RetryOnConflict("service instance", id, d.IsConflictError, func() error {
+		si, version, err := d.Dao.GetServiceInstanceVersion(id)
+		if err != nil {
+			return err
+		}
+		if si.Parameters == nil || !d.keys.NeedsRotation(*si.Parameters) {
+			return nil
+		}
+		if si, err = d.decryptServiceInstance(si); err != nil {
+			return err
+		}
+		if _, err := d.CompareAndSetServiceInstance(id, si, version); err != nil {
+			return err
+		}
+		rotated = true
+		return nil
+	})
+	return rotated, err
+}
+
+// ReencryptBindInstance - rewrites the bind instance encrypted with the
+// primary key. Returns false when it already was.
+func (d *EncryptedDao) ReencryptBindInstance(id string) (bool, error) {
+	rotated := false
+	err := RetryOnConflict("bind instance", id, d.IsConflictError, func() error {
+		bi, version, err := d.Dao.GetBindInstanceVersion(id)
+		if err != nil {
+			return err
+		}
+		if bi.Parameters == nil || !d.keys.NeedsRotation(*bi.Parameters) {
+			return nil
+		}
+		if bi, err = d.decryptBindInstance(bi); err != nil {
+			return err
+		}
+		if _, err := d.CompareAndSetBindInstance(id, bi, version); err != nil {
+			return err
+		}
+		rotated = true
+		return nil
+	})
+	return rotated, err
+}
+
+// ReencryptJobRecords - rewrites the job records not encrypted with the
+// primary key. The records cannot be compared and set, so they are listed
+// again before they are written and the records of the jobs that finished in
+// the meantime are not written back. Returns how many records were rewritten
+// and how many could not be, which are logged.
+func (d *EncryptedDao) ReencryptJobRecords() (int, int, error) {
+	records, err := d.Dao.BatchGetJobRecords()
+	if err != nil {
+		return 0, 0, err
+	}
+	failed := 0
+	stale := []types.JobRecord{}
+	for _, record := range records {
+		if !d.needsRotation(record.Parameters) &&
+			(record.ServiceInstance == nil || !d.needsRotation(record.ServiceInstance.Parameters)) {
+			continue
+		}
+		decrypted, err := d.decryptJobRecord(record)
+		if err != nil {
+			log.Errorf("unable to decrypt job record %v - %v", record.Token, err)
+			failed++
+			continue
+		}
+		stale = append(stale, decrypted)
+	}
+	if len(stale) == 0 {
+		return 0, failed, nil
+	}
+
+	current, err := d.Dao.BatchGetJobRecords()
+	if err != nil {
+		return 0, failed, err
+	}
+	present := map[string]bool{}
+	for _, record := range current {
+		present[record.Token] = true
+	}
+	rotated := 0
+	for _, record := range stale {
+		if !present[record.Token] {
+			continue
+		}
+		if err := d.SetJobRecord(record); err != nil {
+			log.Errorf("unable to rewrite job record %v - %v", record.Token, err)
+			failed++
+			continue
+		}
+		rotated++
+	}
+	return rotated, failed, nil
+}
+
+// ReencryptDeadLetters - rewrites the dead letters not encrypted with the
+// primary key. Like the job records, the dead letters are listed again
+// before they are written so the letters replayed in the meantime are not
+// written back. Returns how many letters were rewritten and how many could
+// not be, which are logged.
+func (d *EncryptedDao) ReencryptDeadLetters() (int, int, error) {
+	letters, err := d.Dao.BatchGetDeadLetters()
+	if err != nil {
+		return 0, 0, err
+	}
+	failed := 0
+	stale := []types.DeadLetter{}
+	for _, letter := range letters {
+		if !d.keys.NeedsRotation(letter.Message) {
+			continue
+		}
+		if letter.Message, err = d.keys.Decrypt(letter.Message); err != nil {
+			log.Errorf("unable to decrypt dead letter %v - %v", letter.ID, err)
+			failed++
+			continue
+		}
+		stale = append(stale, letter)
+	}
+	if len(stale) == 0 {
+		return 0, failed, nil
+	}
+
+	current, err := d.Dao.BatchGetDeadLetters()
+	if err != nil {
+		return 0, failed, err
+	}
+	present := map[string]bool{}
+	for _, letter := range current {
+		present[letter.ID] = true
+	}
+	rotated := 0
+	for _, letter := range stale {
+		if !present[letter.ID] {
+			continue
+		}
+		if err := d.SetDeadLetter(letter); err != nil {
+			log.Errorf("unable to rewrite dead letter %v - %v", letter.ID, err)
+			failed++
+			continue
+		}
+		rotated++
+	}
+	return rotated, failed, nil
+}
+
+// needsRotation - true when the parameters are set and not encrypted with
+// the primary key.
+func (d *EncryptedDao) needsRotation(params *bundle.Parameters) bool {
+	return params != nil && d.keys.NeedsRotation(*params)
+}
+
+// encryptServiceInstance - returns a copy of si with encrypted parameters so
+// the callers instance keeps the plain ones.
+func (d *EncryptedDao) encryptServiceInstance(si *bundle.ServiceInstance) (*bundle.ServiceInstance, error) {
+	if si == nil || si.Parameters == nil {
+		return si, nil
+	}
+	params, err := d.keys.Encrypt(*si.Parameters)
+	if err != nil {
+		log.Errorf("unable to encrypt parameters of service instance %v - %v", si.ID, err)
+		return nil, err
+	}
+	encrypted := *si
+	p := bundle.Parameters(params)
+	encrypted.Parameters = &p
+	return &encrypted, nil
+}
+
+func (d *EncryptedDao) decryptServiceInstance(si *bundle.ServiceInstance) (*bundle.ServiceInstance, error) {
+	if si == nil || si.Parameters == nil {
+		return si, nil
+	}
+	params, err := d.keys.Decrypt(*si.Parameters)
+	if err != nil {
+		log.Errorf("unable to decrypt parameters of service instance %v - %v", si.ID, err)
+		return nil, err
+	}
+	p := bundle.Parameters(params)
+	si.Parameters = &p
+	return si, nil
+}
+
+func (d *EncryptedDao) decryptServiceInstances(instances []*bundle.ServiceInstance) ([]*bundle.ServiceInstance, error) {
+	for i, si := range instances {
+		decrypted, err := d.decryptServiceInstance(si)
+		if err != nil {
+			return nil, err
+		}
+		instances[i] = decrypted
+	}
+	return instances, nil
+}
+
+// encryptBindInstance - returns a copy of bi with encrypted parameters so the
+// callers instance keeps the plain ones.
+func (d *EncryptedDao) encryptBindInstance(bi *bundle.BindInstance) (*bundle.BindInstance, error) {
+	if bi == nil || bi.Parameters == nil {
+		return bi, nil
+	}
+	params, err := d.keys.Encrypt(*bi.Parameters)
+	if err != nil {
+		log.Errorf("unable to encrypt parameters of bind instance %v - %v", bi.ID, err)
+		return nil, err
+	}
+	encrypted := *bi
+	p := bundle.Parameters(params)
+	encrypted.Parameters = &p
+	return &encrypted, nil
+}
+
+func (d *EncryptedDao) decryptBindInstance(bi *bundle.BindInstance) (*bundle.BindInstance, error) {
+	if bi == nil || bi.Parameters == nil {
+		return bi, nil
+	}
+	params, err := d.keys.Decrypt(*bi.Parameters)
+	if err != nil {
+		log.Errorf("unable to decrypt parameters of bind instance %v - %v", bi.ID, err)
+		return nil, err
+	}
+	p := bundle.Parameters(params)
+	bi.Parameters = &p
+	return bi, nil
+}
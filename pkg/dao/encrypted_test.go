@@ -0,0 +1,158 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/encryption"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+func newTestKeys(t *testing.T, primary string) *encryption.KeyRing {
+	keys, err := encryption.NewKeyRing(primary, map[string][]byte{
+		"old": bytes.Repeat([]byte{1}, 32),
+		"new": bytes.Repeat([]byte{2}, 32),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestEncryptedDaoParameters(t *testing.T) {
+	backend, _ := memory.NewDao()
+	d := NewEncryptedDao(backend, newTestKeys(t, "old"))
+
+	id := uuid.NewRandom()
+	params := bundle.Parameters{"password": "secret"}
+	si := &bundle.ServiceInstance{ID: id, Parameters: &params}
+	if err := d.SetServiceInstance(id.String(), si); err != nil {
+		t.Fatal(err)
+	}
+	// the callers instance keeps its plain parameters.
+	ft.AssertEqual(t, "secret", (*si.Parameters)["password"])
+
+	raw, _ := backend.GetServiceInstance(id.String())
+	ft.AssertTrue(t, encryption.IsEncrypted(*raw.Parameters))
+
+	got, err := d.GetServiceInstance(id.String())
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "secret", (*got.Parameters)["password"])
+
+	bindID := uuid.NewRandom()
+	bindParams := bundle.Parameters{"token": "abc"}
+	if err := d.SetBindInstance(bindID.String(), &bundle.BindInstance{ID: bindID, Parameters: &bindParams}); err != nil {
+		t.Fatal(err)
+	}
+	rawBind, _ := backend.GetBindInstance(bindID.String())
+	ft.AssertTrue(t, encryption.IsEncrypted(*rawBind.Parameters))
+	bi, err := d.GetBindInstance(bindID.String())
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "abc", (*bi.Parameters)["token"])
+}
+
+func TestEncryptedDaoReencrypt(t *testing.T) {
+	backend, _ := memory.NewDao()
+	id := uuid.NewRandom()
+	params := bundle.Parameters{"password": "secret"}
+	NewEncryptedDao(backend, newTestKeys(t, "old")).SetServiceInstance(id.String(),
+		&bundle.ServiceInstance{ID: id, Parameters: &params})
+
+	// a plain record written before encryption was turned on.
+	plainID := uuid.NewRandom()
+	backend.SetServiceInstance(plainID.String(), &bundle.ServiceInstance{ID: plainID, Parameters: &params})
+
+	d := NewEncryptedDao(backend, newTestKeys(t, "new"))
+	for _, i := range []uuid.UUID{id, plainID} {
+		rotated, err := d.ReencryptServiceInstance(i.String())
+		ft.AssertNil(t, err)
+		ft.AssertTrue(t, rotated)
+
+		raw, _ := backend.GetServiceInstance(i.String())
+		keyID, _ := encryption.KeyID(*raw.Parameters)
+		ft.AssertEqual(t, "new", keyID)
+
+		rotated, _ = d.ReencryptServiceInstance(i.String())
+		ft.AssertFalse(t, rotated)
+
+		got, _ := d.GetServiceInstance(i.String())
+		ft.AssertEqual(t, "secret", (*got.Parameters)["password"])
+	}
+}
+
+func TestEncryptedDaoFindByPlan(t *testing.T) {
+	backend, _ := memory.NewDao()
+	d := NewEncryptedDao(backend, newTestKeys(t, "new"))
+
+	dev, prod := uuid.NewRandom(), uuid.NewRandom()
+	for id, plan := range map[string]string{dev.String(): "dev", prod.String(): "prod"} {
+		params := bundle.Parameters{"_apb_plan_id": plan}
+		d.SetServiceInstance(id, &bundle.ServiceInstance{ID: uuid.Parse(id), Parameters: &params})
+	}
+	// the backend only sees the encrypted parameters.
+	found, _ := backend.FindServiceInstances(types.InstanceFilter{PlanID: "dev"})
+	ft.AssertEqual(t, 0, len(found))
+
+	found, err := d.FindServiceInstances(types.InstanceFilter{PlanID: "dev"})
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 1, len(found))
+	ft.AssertEqual(t, dev.String(), found[0].ID.String())
+	ft.AssertEqual(t, "dev", (*found[0].Parameters)["_apb_plan_id"])
+}
+
+func TestEncryptedDaoReencryptRecords(t *testing.T) {
+	backend, _ := memory.NewDao()
+	old := NewEncryptedDao(backend, newTestKeys(t, "old"))
+	params := bundle.Parameters{"password": "secret"}
+	for _, token := range []string{"a", "b"} {
+		ft.AssertNil(t, old.SetJobRecord(types.JobRecord{Token: token, Parameters: &params,
+			ServiceInstance: &bundle.ServiceInstance{ID: uuid.NewRandom(), Parameters: &params}}))
+	}
+	ft.AssertNil(t, old.SetDeadLetter(types.DeadLetter{ID: "letter", Message: map[string]interface{}{"password": "secret"}}))
+
+	d := NewEncryptedDao(backend, newTestKeys(t, "new"))
+	rotated, failed, err := d.ReencryptJobRecords()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 2, rotated)
+	ft.AssertEqual(t, 0, failed)
+	rotated, _, err = d.ReencryptDeadLetters()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 1, rotated)
+
+	records, _ := backend.BatchGetJobRecords()
+	for _, record := range records {
+		keyID, _ := encryption.KeyID(*record.Parameters)
+		ft.AssertEqual(t, "new", keyID)
+		keyID, _ = encryption.KeyID(*record.ServiceInstance.Parameters)
+		ft.AssertEqual(t, "new", keyID)
+	}
+	letters, _ := backend.BatchGetDeadLetters()
+	keyID, _ := encryption.KeyID(letters[0].Message)
+	ft.AssertEqual(t, "new", keyID)
+
+	// nothing is left to rewrite.
+	rotated, _, _ = d.ReencryptJobRecords()
+	ft.AssertEqual(t, 0, rotated)
+	records, _ = d.BatchGetJobRecords()
+	ft.AssertEqual(t, "secret", (*records[0].Parameters)["password"])
+}
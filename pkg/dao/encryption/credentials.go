@@ -0,0 +1,160 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package encryption
+
+import (
+	"github.com/automationbroker/bundle-lib/clients"
+	"github.com/automationbroker/bundle-lib/runtime"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CredentialStore - an ExtractedCredential that encrypts the extracted
+// credentials before handing them to the store it wraps, and decrypts them
+// again when they are read.
+type CredentialStore struct {
+	keys  *KeyRing
+	store runtime.ExtractedCredential
+}
+
+// NewCredentialStore - creates a credential store encrypting with keys. When
+// store is nil the credentials are kept in secrets, like the runtime does by
+// default.
+func NewCredentialStore(keys *KeyRing, store runtime.ExtractedCredential) *CredentialStore {
+	if store == nil {
+		store = SecretCredentials{}
+	}
+	return &CredentialStore{keys: keys, store: store}
+}
+
+// CreateExtractedCredential - encrypts and saves the credentials.
+func (c *CredentialStore) CreateExtractedCredential(id, ns string,
+	creds map[string]interface{}, labels map[string]string) error {
+
+	encrypted, err := c.keys.Encrypt(creds)
+	if err != nil {
+		log.Errorf("unable to encrypt extracted credentials for %v - %v", id, err)
+		return err
+	}
+	return c.store.CreateExtractedCredential(id, ns, encrypted, labels)
+}
+
+// UpdateExtractedCredential - encrypts and updates the credentials.
+func (c *CredentialStore) UpdateExtractedCredential(id, ns string,
+	creds map[string]interface{}, labels map[string]string) error {
+
+	encrypted, err := c.keys.Encrypt(creds)
+	if err != nil {
+		log.Errorf("unable to encrypt extracted credentials for %v - %v", id, err)
+		return err
+	}
+	return c.store.UpdateExtractedCredential(id, ns, encrypted, labels)
+}
+
+// GetExtractedCredential - gets and decrypts the credentials.
+func (c *CredentialStore) GetExtractedCredential(id, ns string) (map[string]interface{}, error) {
+	creds, err := c.store.GetExtractedCredential(id, ns)
+	if err != nil {
+		return nil, err
+	}
+	return c.keys.Decrypt(creds)
+}
+
+// DeleteExtractedCredential - deletes the credentials.
+func (c *CredentialStore) DeleteExtractedCredential(id, ns string) error {
+	return c.store.DeleteExtractedCredential(id, ns)
+}
+
+// Rotate - re-encrypts the credentials of id with the primary key. Returns
+// false when there are no credentials or they already use the primary key.
+func (c *CredentialStore) Rotate(id, ns string) (bool, error) {
+	stored, err := c.store.GetExtractedCredential(id, ns)
+	if err == runtime.ErrCredentialsNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !c.keys.NeedsRotation(stored) {
+		return false, nil
+	}
+	creds, err := c.keys.Decrypt(stored)
+	if err != nil {
+		return false, err
+	}
+	return true, c.UpdateExtractedCredential(id, ns, creds, nil)
+}
+
+// SecretCredentials - keeps the extracted credentials in secrets, the same
+// way the runtime does by default. Updating the credentials keeps the labels
+// of the secret unless new ones are given.
+type SecretCredentials struct{}
+
+// CreateExtractedCredential - saves the credentials in a new secret.
+func (SecretCredentials) CreateExtractedCredential(id, ns string,
+	creds map[string]interface{}, labels map[string]string) error {
+
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		log.Errorf("Unable to get kubernetes client - %v", err)
+		return err
+	}
+	return k8scli.SaveExtractedCredentialSecret(id, ns, creds, labels)
+}
+
+// UpdateExtractedCredential - replaces the credentials in the secret.
+func (SecretCredentials) UpdateExtractedCredential(id, ns string,
+	creds map[string]interface{}, labels map[string]string) error {
+
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		log.Errorf("Unable to get kubernetes client - %v", err)
+		return err
+	}
+	if labels == nil {
+		secret, err := k8scli.Client.CoreV1().Secrets(ns).Get(id, metav1.GetOptions{})
+		if err != nil {
+			log.Errorf("unable to get extracted credentials secret %v - %v", id, err)
+			return err
+		}
+		labels = secret.GetLabels()
+	}
+	return k8scli.UpdateExtractedCredentialSecret(id, ns, creds, labels)
+}
+
+// GetExtractedCredential - gets the credentials from the secret.
+func (SecretCredentials) GetExtractedCredential(id, ns string) (map[string]interface{}, error) {
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		log.Errorf("Unable to get kubernetes client - %v", err)
+		return nil, err
+	}
+	creds, err := k8scli.GetExtractedCredentialSecretData(id, ns)
+	if err == clients.ErrCredentialsNotFound {
+		return nil, runtime.ErrCredentialsNotFound
+	}
+	return creds, err
+}
+
+// DeleteExtractedCredential - deletes the secret.
+func (SecretCredentials) DeleteExtractedCredential(id, ns string) error {
+	k8scli, err := clients.Kubernetes()
+	if err != nil {
+		log.Errorf("Unable to get kubernetes client - %v", err)
+		return err
+	}
+	return k8scli.DeleteExtractedCredentialSecret(id, ns)
+}
@@ -0,0 +1,186 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// EnvelopeField - the only field of a map that holds encrypted values.
+const EnvelopeField = "_encrypted"
+
+// dataKeySize - every record is encrypted with its own AES-256 data key.
+const dataKeySize = 32
+
+// Envelope - the encrypted form of a map of values. The values are sealed
+// with a random data key, which in turn is sealed with the key encryption key
+// named by KeyID.
+type Envelope struct {
+	KeyID      string `json:"key_id"`
+	DataKey    []byte `json:"data_key"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Encrypt - returns a map holding only the envelope of values, encrypted with
+// the primary key. Values that are already encrypted are returned as is.
+func (k *KeyRing) Encrypt(values map[string]interface{}) (map[string]interface{}, error) {
+	if values == nil || IsEncrypted(values) {
+		return values, nil
+	}
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(dataKey, plaintext, nil)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := k.key(k.primary)
+	if err != nil {
+		return nil, err
+	}
+	// the key id is authenticated along with the data key so an envelope
+	// can not be pointed at a different key.
+	wrapped, err := seal(kek, dataKey, []byte(k.primary))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		EnvelopeField: Envelope{KeyID: k.primary, DataKey: wrapped, Ciphertext: ciphertext},
+	}, nil
+}
+
+// Decrypt - returns the values sealed in the envelope. Values that are not
+// encrypted, like records written before encryption was turned on, are
+// returned as is.
+func (k *KeyRing) Decrypt(values map[string]interface{}) (map[string]interface{}, error) {
+	if !IsEncrypted(values) {
+		return values, nil
+	}
+	env, err := envelopeOf(values)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := k.key(env.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(kek, env.DataKey, []byte(env.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap data key with key %s - %v", env.KeyID, err)
+	}
+	plaintext, err := open(dataKey, env.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt record - %v", err)
+	}
+
+	decrypted := map[string]interface{}{}
+	if err := json.Unmarshal(plaintext, &decrypted); err != nil {
+		return nil, err
+	}
+	return decrypted, nil
+}
+
+// NeedsRotation - true when values are not encrypted with the primary key.
+func (k *KeyRing) NeedsRotation(values map[string]interface{}) bool {
+	if values == nil {
+		return false
+	}
+	id, ok := KeyID(values)
+	return !ok || id != k.primary
+}
+
+// IsEncrypted - true when values only hold an envelope.
+func IsEncrypted(values map[string]interface{}) bool {
+	if len(values) != 1 {
+		return false
+	}
+	_, ok := values[EnvelopeField]
+	return ok
+}
+
+// KeyID - the id of the key values were encrypted with, false when values
+// are not encrypted.
+func KeyID(values map[string]interface{}) (string, bool) {
+	if !IsEncrypted(values) {
+		return "", false
+	}
+	env, err := envelopeOf(values)
+	if err != nil {
+		return "", false
+	}
+	return env.KeyID, true
+}
+
+// envelopeOf - the envelope is an Envelope when it was just encrypted and a
+// generic map once it has been read back from a data store.
+func envelopeOf(values map[string]interface{}) (Envelope, error) {
+	env := Envelope{}
+	b, err := json.Marshal(values[EnvelopeField])
+	if err != nil {
+		return env, err
+	}
+	if err := json.Unmarshal(b, &env); err != nil {
+		return env, fmt.Errorf("malformed encrypted record - %v", err)
+	}
+	return env, nil
+}
+
+// seal - encrypts plaintext with AES-GCM, the random nonce is prepended to
+// the returned ciphertext.
+func seal(key []byte, plaintext []byte, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(key []byte, ciphertext []byte, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce := ciphertext[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, ciphertext[gcm.NonceSize():], additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,143 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package encryption
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+func newTestKeyRing(t *testing.T, primary string, ids ...string) *KeyRing {
+	keys := map[string][]byte{}
+	for i, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+	k, err := NewKeyRing(primary, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// stored - round trips values through json like a data store would.
+func stored(t *testing.T, values map[string]interface{}) map[string]interface{} {
+	b, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := newTestKeyRing(t, "a", "a")
+	values := map[string]interface{}{"password": "secret", "size": float64(3)}
+
+	encrypted, err := k.Encrypt(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted = stored(t, encrypted)
+	ft.AssertTrue(t, IsEncrypted(encrypted))
+	b, _ := json.Marshal(encrypted)
+	ft.AssertFalse(t, bytes.Contains(b, []byte("secret")))
+
+	decrypted, err := k.Decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertTrue(t, reflect.DeepEqual(values, decrypted))
+
+	// already encrypted values are not encrypted twice.
+	again, _ := k.Encrypt(encrypted)
+	ft.AssertTrue(t, reflect.DeepEqual(encrypted, again))
+}
+
+func TestDecryptPlainValues(t *testing.T) {
+	k := newTestKeyRing(t, "a", "a")
+	values := map[string]interface{}{"password": "secret"}
+	decrypted, err := k.Decrypt(values)
+	ft.AssertNil(t, err)
+	ft.AssertTrue(t, reflect.DeepEqual(values, decrypted))
+	ft.AssertTrue(t, k.NeedsRotation(values))
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := newTestKeyRing(t, "a", "a")
+	encrypted, _ := old.Encrypt(map[string]interface{}{"password": "secret"})
+	encrypted = stored(t, encrypted)
+
+	rotated := newTestKeyRing(t, "b", "a", "b")
+	ft.AssertTrue(t, rotated.NeedsRotation(encrypted))
+	decrypted, err := rotated.Decrypt(encrypted)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "secret", decrypted["password"])
+
+	reencrypted, _ := rotated.Encrypt(decrypted)
+	id, _ := KeyID(stored(t, reencrypted))
+	ft.AssertEqual(t, "b", id)
+	ft.AssertFalse(t, rotated.NeedsRotation(stored(t, reencrypted)))
+
+	// once the old key is dropped its records can not be read anymore.
+	dropped := newTestKeyRing(t, "b", "b")
+	_, err = dropped.Decrypt(encrypted)
+	_, ok := err.(UnknownKeyError)
+	ft.AssertTrue(t, ok)
+}
+
+func TestTamperedEnvelope(t *testing.T) {
+	k := newTestKeyRing(t, "a", "a")
+	encrypted, _ := k.Encrypt(map[string]interface{}{"password": "secret"})
+	env := encrypted[EnvelopeField].(Envelope)
+	env.Ciphertext[len(env.Ciphertext)-1] ^= 0xff
+	_, err := k.Decrypt(encrypted)
+	ft.AssertNotNil(t, err)
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.yaml")
+	content := "primary: \"2018-06\"\nkeys:\n  \"2018-06\": MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, "2018-06", k.PrimaryKeyID())
+
+	if err := ioutil.WriteFile(path, []byte("primary: missing\nkeys: {}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadKeyFile(path)
+	ft.AssertNotNil(t, err)
+}
@@ -0,0 +1,106 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package encryption
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// KeyRing - the key encryption keys loaded from a key file. Records are always
+// encrypted with the primary key, the other keys are kept so records written
+// before a rotation can still be decrypted.
+type KeyRing struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// keyFile - the format of the key file, every key is a base64 encoded 16, 24
+// or 32 byte AES key.
+//
+//	primary: 2018-06
+//	keys:
+//	  2018-01: <base64 key>
+//	  2018-06: <base64 key>
+type keyFile struct {
+	Primary string            `yaml:"primary"`
+	Keys    map[string]string `yaml:"keys"`
+}
+
+// UnknownKeyError - Error returned when a record was encrypted with a key
+// that is not in the key ring.
+type UnknownKeyError struct {
+	KeyID string
+}
+
+func (e UnknownKeyError) Error() string {
+	return fmt.Sprintf("unable to decrypt record, key %s is not in the key file", e.KeyID)
+}
+
+// LoadKeyFile - reads the key ring from a key file.
+func LoadKeyFile(path string) (*KeyRing, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	kf := keyFile{}
+	if err := yaml.Unmarshal(b, &kf); err != nil {
+		return nil, fmt.Errorf("unable to parse key file %s - %v", path, err)
+	}
+
+	keys := map[string][]byte{}
+	for id, encoded := range kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s in %s is not base64 encoded - %v", id, path, err)
+		}
+		keys[id] = key
+	}
+	return NewKeyRing(kf.Primary, keys)
+}
+
+// NewKeyRing - creates a key ring from keys by id, encrypting new records
+// with the primary key.
+func NewKeyRing(primary string, keys map[string][]byte) (*KeyRing, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not one of the keys", primary)
+	}
+	for id, key := range keys {
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("key %s must be 16, 24 or 32 bytes, got %d", id, len(key))
+		}
+	}
+	return &KeyRing{primary: primary, keys: keys}, nil
+}
+
+// PrimaryKeyID - the id of the key new records are encrypted with.
+func (k *KeyRing) PrimaryKeyID() string {
+	return k.primary
+}
+
+func (k *KeyRing) key(id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, UnknownKeyError{KeyID: id}
+	}
+	return key, nil
+}
@@ -0,0 +1,63 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"context"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// SetAuditRecord - Store the audit record in the kvp API.
+func (d *Dao) SetAuditRecord(record types.AuditRecord) error {
+	payload, err := bundle.DumpJSON(record)
+	if err != nil {
+		return err
+	}
+	return d.SetRaw(auditRecordKey(record.ID), payload)
+}
+
+// DeleteAuditRecord - Delete the audit record from the kvp API.
+func (d *Dao) DeleteAuditRecord(id string) error {
+	_, err := d.kapi.Delete(context.Background(), auditRecordKey(id), nil)
+	if d.IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
+
+// BatchGetAuditRecords - Retrieve the audit records from the kvp API, oldest
+// first.
+func (d *Dao) BatchGetAuditRecords() ([]types.AuditRecord, error) {
+	records := []types.AuditRecord{}
+	payloads, err := d.BatchGetRaw("/audit")
+	if d.IsNotFoundError(err) {
+		return records, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, payload := range *payloads {
+		record := types.AuditRecord{}
+		if err := bundle.LoadJSON(payload, &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	types.SortAuditRecords(records)
+	return records, nil
+}
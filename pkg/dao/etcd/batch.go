@@ -0,0 +1,110 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/journal"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Apply - Record the batch in a journal entry, then make its writes one by
+// one and record its intents before removing the entry. The v2 API has no
+// multi key transactions, a batch interrupted part of the way is finished
+// when PendingIntents replays its entry.
+func (d *Dao) Apply(batch types.Batch) error {
+	if err := journal.Check(d, batch); err != nil {
+		return err
+	}
+	entry := types.JournalEntry{ID: uuid.New(), Batch: batch, Created: time.Now()}
+	if err := d.setObject(journalKey(entry.ID), entry); err != nil {
+		return err
+	}
+	return d.finish(entry, false)
+}
+
+// finish - makes the writes of a journaled batch and records its intents,
+// removing the journal entry once they are.
+func (d *Dao) finish(entry types.JournalEntry, replay bool) error {
+	if err := journal.Apply(d, entry.Batch, replay); err != nil {
+		log.Errorf("Unable to apply batch [ %s ], it will be replayed - %v", entry.ID, err)
+		return err
+	}
+	for _, intent := range entry.Batch.Intents {
+		if err := d.setObject(intentKey(intent.ID), intent); err != nil {
+			return err
+		}
+	}
+	_, err := d.kapi.Delete(context.Background(), journalKey(entry.ID), nil)
+	return err
+}
+
+// PendingIntents - Replay the journal entries left behind, then retrieve the
+// pending intents from the kvp API ordered by id.
+func (d *Dao) PendingIntents() ([]types.Intent, error) {
+	payloads, err := d.BatchGetRaw("/journal")
+	if err != nil && !d.IsNotFoundError(err) {
+		return nil, err
+	}
+	if err == nil {
+		for _, payload := range *payloads {
+			entry := types.JournalEntry{}
+			if err := bundle.LoadJSON(payload, &entry); err != nil {
+				return nil, err
+			}
+			if !journal.Due(entry) {
+				continue
+			}
+			log.Infof("Replaying the batch [ %s ] journaled at %v", entry.ID, entry.Created)
+			if err := d.finish(entry, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	intents := []types.Intent{}
+	payloads, err = d.BatchGetRaw("/intent")
+	if d.IsNotFoundError(err) {
+		return intents, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, payload := range *payloads {
+		intent := types.Intent{}
+		if err := bundle.LoadJSON(payload, &intent); err != nil {
+			return nil, err
+		}
+		intents = append(intents, intent)
+	}
+	sort.Slice(intents, func(i, j int) bool { return intents[i].ID < intents[j].ID })
+	return intents, nil
+}
+
+// CompleteIntent - Delete the intent from the kvp API.
+func (d *Dao) CompleteIntent(id string) error {
+	_, err := d.kapi.Delete(context.Background(), intentKey(id), nil)
+	if d.IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
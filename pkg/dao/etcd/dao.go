@@ -19,26 +19,76 @@ package dao
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
-
-	"encoding/json"
+	"sync"
+	"time"
 
 	"github.com/automationbroker/bundle-lib/bundle"
 	"github.com/automationbroker/bundle-lib/clients"
 	"github.com/coreos/etcd/client"
+	"github.com/openshift/ansible-service-broker/pkg/dao/schema"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
 	"github.com/pborman/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
+type arrayErrors []error
+
+func (a arrayErrors) Error() string {
+	return fmt.Sprintf("%#v", a)
+}
+
+// ConflictError - Error returned when a compare and set finds that the key
+// has been written since the expected version was read.
+type ConflictError struct {
+	Key string
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("key was modified concurrently: %s", e.Key)
+}
+
+// storedJobState - the job state as it is written to etcd, along with when it
+// was written so old job states can be pruned. Readers that only know about
+// bundle.JobState ignore the extra field.
+type storedJobState struct {
+	bundle.JobState
+	LastModified time.Time `json:"last_modified"`
+}
+
+// listingTTL - how long the listing of a directory read for a walk page by
+// page is kept for the next pages.
+const listingTTL = time.Minute
+
+// listing - the children of a directory, read as a walk page by page starts
+// and shared by the walks of the directory.
+type listing struct {
+	nodes client.Nodes
+	read  time.Time
+}
+
 // Dao - object to interface with the data store.
 type Dao struct {
 	client client.Client
 	kapi   client.KeysAPI // Used to interact with kvp API over HTTP
+
+	// indexLock guards indexed, set once the secondary indexes are known
+	// to have been built.
+	indexLock sync.Mutex
+	indexed   bool
+
+	// listingsLock guards listings, the latest listing of each directory
+	// walked page by page, so a single copy of a directory is kept however
+	// many walks are under way.
+	listingsLock sync.Mutex
+	listings     map[string]*listing
 }
 
 // NewDao - Create a new Dao object
 func NewDao() (*Dao, error) {
-	dao := Dao{}
+	dao := Dao{listings: map[string]*listing{}}
 
 	etcdClient, err := clients.Etcd()
 	if err != nil {
@@ -66,6 +116,39 @@ func (d *Dao) GetRaw(key string) (string, error) {
 	return val, nil
 }
 
+// GetRawVersion - gets a specific json string for a key from the kvp API
+// along with the etcd index it was last modified at.
+func (d *Dao) GetRawVersion(key string) (string, string, error) {
+	res, err := d.kapi.Get(context.Background(), key /*opts*/, nil)
+	if err != nil {
+		return "", "", err
+	}
+	return res.Node.Value, strconv.FormatUint(res.Node.ModifiedIndex, 10), nil
+}
+
+// CompareAndSetRaw - sets the value for key only if it has not been modified
+// since version. An empty version only sets the key if it does not exist. The
+// new version is returned.
+func (d *Dao) CompareAndSetRaw(key string, val string, version string) (string, error) {
+	opts := &client.SetOptions{PrevExist: client.PrevNoExist}
+	if version != "" {
+		index, err := strconv.ParseUint(version, 10, 64)
+		if err != nil || index == 0 {
+			return "", fmt.Errorf("invalid version [ %s ] for key [ %s ]", version, key)
+		}
+		opts = &client.SetOptions{PrevIndex: index}
+	}
+	res, err := d.kapi.Set(context.Background(), key, val, opts)
+	if err != nil {
+		if e, ok := err.(client.Error); ok &&
+			(e.Code == client.ErrorCodeTestFailed || e.Code == client.ErrorCodeNodeExist) {
+			return "", ConflictError{Key: key}
+		}
+		return "", err
+	}
+	return strconv.FormatUint(res.Node.ModifiedIndex, 10), nil
+}
+
 // BatchGetRaw - Get multiple  types as individual json strings
 // TODO: Streaming interface? Going to need to optimize all this for
 // a full-load catalog response of 10k
@@ -97,6 +180,64 @@ func (d *Dao) BatchGetRaw(dir string) (*[]string, error) {
 	return &payloads, nil
 }
 
+// getRawPage - Get up to limit direct children of dir as json strings,
+// ordered by key and starting after the child named in continueToken. The
+// token for the next page is empty once the last child was returned. The v2
+// API cannot range over a directory, so the directory is read as a walk
+// starts and the next pages are taken from the latest listing of the
+// directory, which the concurrent walks share. A listing that expired is read
+// again and the walk resumes after the last child returned.
+func (d *Dao) getRawPage(dir string, continueToken string, limit int) ([]string, string, error) {
+	nodes, err := d.listing(dir, continueToken == "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	start := 0
+	if continueToken != "" {
+		afterKey := fmt.Sprintf("%s/%s", dir, continueToken)
+		start = sort.Search(len(nodes), func(i int) bool { return nodes[i].Key > afterKey })
+	}
+	end := len(nodes)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	payloads := make([]string, 0, end-start)
+	for _, node := range nodes[start:end] {
+		payloads = append(payloads, node.Value)
+	}
+	if end == len(nodes) {
+		return payloads, "", nil
+	}
+	return payloads, strings.TrimPrefix(nodes[end-1].Key, dir+"/"), nil
+}
+
+// listing - the children of dir, sorted by key. The directory is read again
+// when fresh is set or its listing expired, and kept for the next pages. The
+// expired listings of the other directories are dropped.
+func (d *Dao) listing(dir string, fresh bool) (client.Nodes, error) {
+	d.listingsLock.Lock()
+	for listingDir, l := range d.listings {
+		if time.Since(l.read) > listingTTL {
+			delete(d.listings, listingDir)
+		}
+	}
+	l, ok := d.listings[dir]
+	d.listingsLock.Unlock()
+	if ok && !fresh {
+		return l.nodes, nil
+	}
+
+	res, err := d.kapi.Get(context.Background(), dir, &client.GetOptions{Sort: true})
+	if err != nil {
+		return nil, err
+	}
+	d.listingsLock.Lock()
+	d.listings[dir] = &listing{nodes: res.Node.Nodes, read: time.Now()}
+	d.listingsLock.Unlock()
+	return res.Node.Nodes, nil
+}
+
 // GetSpec - Retrieve the spec for the kvp API.
 func (d *Dao) GetSpec(id string) (*bundle.Spec, error) {
 	spec := &bundle.Spec{}
@@ -140,15 +281,7 @@ func (d *Dao) BatchGetSpecs(dir string) ([]*bundle.Spec, error) {
 		return []*bundle.Spec{}, err
 	}
 
-	specs := make([]*bundle.Spec, len(*payloads))
-	for i, payload := range *payloads {
-		spec := &bundle.Spec{}
-		bundle.LoadJSON(payload, spec)
-		specs[i] = spec
-		log.Debugf("Batch idx [ %d ] -> [ %s ]", i, spec.ID)
-	}
-
-	return specs, nil
+	return decodeSpecs(*payloads)
 }
 
 // BatchGetBundleInstances - get list of bundleinstances
@@ -162,7 +295,7 @@ func (d *Dao) BatchGetBundleInstances() ([]*bundle.ServiceInstance, error) {
 	if siJSONStrs != nil {
 		for _, str := range *siJSONStrs {
 			si := bundle.ServiceInstance{}
-			err := json.Unmarshal([]byte(str), &si)
+			err := schema.Unmarshal(types.KindServiceInstance, str, &si)
 			if err != nil {
 				log.Errorf("Unable to convert the service instances json unmarshal error - %v", err)
 				return nil, err
@@ -173,6 +306,65 @@ func (d *Dao) BatchGetBundleInstances() ([]*bundle.ServiceInstance, error) {
 	return bundleInstances, nil
 }
 
+// BatchGetBindInstances - Retrieve all the bind instances.
+func (d *Dao) BatchGetBindInstances() ([]*bundle.BindInstance, error) {
+	bindings := []*bundle.BindInstance{}
+	biJSONStrs, err := d.BatchGetRaw("/bind_instance")
+	if err != nil && !d.IsNotFoundError(err) {
+		log.Errorf("Unable to get the bind instances - %v", err)
+		return nil, err
+	}
+	if biJSONStrs != nil {
+		for _, str := range *biJSONStrs {
+			bi := bundle.BindInstance{}
+			if err := schema.Unmarshal(types.KindBindInstance, str, &bi); err != nil {
+				log.Errorf("Unable to convert the bind instances json unmarshal error - %v", err)
+				return nil, err
+			}
+			bindings = append(bindings, &bi)
+		}
+	}
+	return bindings, nil
+}
+
+// GetSpecsPage - Retrieve up to limit specs ordered by id, starting after
+// the continue token.
+func (d *Dao) GetSpecsPage(continueToken string, limit int) ([]*bundle.Spec, string, error) {
+	payloads, next, err := d.getRawPage("/spec", continueToken, limit)
+	if client.IsKeyNotFound(err) {
+		return []*bundle.Spec{}, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	specs, err := decodeSpecs(payloads)
+	log.Debugf("Loaded page of [ %d ] specs, continue [ %s ]", len(specs), next)
+	return specs, next, err
+}
+
+// GetServiceInstancesPage - Retrieve up to limit service instances ordered by
+// id, starting after the continue token.
+func (d *Dao) GetServiceInstancesPage(continueToken string, limit int) ([]*bundle.ServiceInstance, string, error) {
+	payloads, next, err := d.getRawPage("/service_instance", continueToken, limit)
+	if client.IsKeyNotFound(err) {
+		return []*bundle.ServiceInstance{}, "", nil
+	} else if err != nil {
+		log.Errorf("Unable to get the service instances - %v", err)
+		return nil, "", err
+	}
+
+	instances := make([]*bundle.ServiceInstance, len(payloads))
+	for i, payload := range payloads {
+		si := &bundle.ServiceInstance{}
+		if err := schema.Unmarshal(types.KindServiceInstance, payload, si); err != nil {
+			log.Errorf("Unable to convert the service instances json unmarshal error - %v", err)
+			return nil, "", err
+		}
+		instances[i] = si
+	}
+	return instances, next, nil
+}
+
 // BatchDeleteSpecs - set specs based on SpecManifest in the kvp API.
 func (d *Dao) BatchDeleteSpecs(specs []*bundle.Spec) error {
 	for _, spec := range specs {
@@ -216,7 +408,7 @@ func (d *Dao) FindJobStateByState(state bundle.State) ([]bundle.RecoverStatus, e
 		}
 
 		for _, n := range nodes.Node.Nodes {
-			bundle.LoadJSON(n.Value, &jobstate)
+			schema.Unmarshal(types.KindJobState, n.Value, &jobstate)
 			if jobstate.State == state {
 				log.Debug(fmt.Sprintf(
 					"Found! jobstate [%v] matched given state: [%v].", jobstate, state))
@@ -283,7 +475,7 @@ func (d *Dao) getJobsForSvcInst(instanceID string) ([]bundle.JobState, error) {
 	retJobs := []bundle.JobState{}
 	for _, node := range jobNodes {
 		js := bundle.JobState{}
-		err := bundle.LoadJSON(node.Value, &js)
+		err := schema.Unmarshal(types.KindJobState, node.Value, &js)
 		if err != nil {
 			return nil, fmt.Errorf("An error occurred trying to parse job state of [ %s ]\n%s", node.Key, err.Error())
 		}
@@ -303,7 +495,33 @@ func (d *Dao) GetServiceInstance(id string) (*bundle.ServiceInstance, error) {
 
 // SetServiceInstance - Set service instance for an id in the kvp API.
 func (d *Dao) SetServiceInstance(id string, serviceInstance *bundle.ServiceInstance) error {
-	return d.setObject(serviceInstanceKey(id), serviceInstance)
+	if err := d.setObject(serviceInstanceKey(id), serviceInstance); err != nil {
+		return err
+	}
+	d.updateIndex(indexKindInstance, id, instanceIndexKeys(id, serviceInstance))
+	return nil
+}
+
+// GetServiceInstanceVersion - Retrieve a service instance and the version it
+// was read at from the kvp API.
+func (d *Dao) GetServiceInstanceVersion(id string) (*bundle.ServiceInstance, string, error) {
+	si := &bundle.ServiceInstance{}
+	version, err := d.getObjectVersion(serviceInstanceKey(id), si)
+	if err != nil {
+		return nil, "", err
+	}
+	return si, version, nil
+}
+
+// CompareAndSetServiceInstance - Set service instance for an id in the kvp API
+// if it has not changed since version was read.
+func (d *Dao) CompareAndSetServiceInstance(id string, serviceInstance *bundle.ServiceInstance, version string) (string, error) {
+	newVersion, err := d.compareAndSetObject(serviceInstanceKey(id), serviceInstance, version)
+	if err != nil {
+		return "", err
+	}
+	d.updateIndex(indexKindInstance, id, instanceIndexKeys(id, serviceInstance))
+	return newVersion, nil
 }
 
 func removeFalseBindings(bindings map[string]bool) map[string]bool {
@@ -319,8 +537,11 @@ func removeFalseBindings(bindings map[string]bool) map[string]bool {
 // DeleteServiceInstance - Delete the service instance for an service instance id.
 func (d *Dao) DeleteServiceInstance(id string) error {
 	log.Debug(fmt.Sprintf("Dao::DeleteServiceInstance -> [ %s ]", id))
-	_, err := d.kapi.Delete(context.Background(), serviceInstanceKey(id), nil)
-	return err
+	if _, err := d.kapi.Delete(context.Background(), serviceInstanceKey(id), nil); err != nil {
+		return err
+	}
+	d.updateIndex(indexKindInstance, id, nil)
+	return nil
 }
 
 // GetBindInstance - Retrieve a specific bind instance from the kvp API
@@ -334,32 +555,60 @@ func (d *Dao) GetBindInstance(id string) (*bundle.BindInstance, error) {
 
 // SetBindInstance - Set the bind instance for id in the kvp API.
 func (d *Dao) SetBindInstance(id string, bindInstance *bundle.BindInstance) error {
-	return d.setObject(bindInstanceKey(id), bindInstance)
+	if err := d.setObject(bindInstanceKey(id), bindInstance); err != nil {
+		return err
+	}
+	d.updateIndex(indexKindBinding, id, bindingIndexKeys(id, bindInstance))
+	return nil
 }
 
-// DeleteBindInstance - Delete the binding instance for an id in the kvp API.
-func (d *Dao) DeleteBindInstance(id string) error {
-	log.Debug(fmt.Sprintf("Dao::DeleteBindInstance -> [ %s ]", id))
-	_, err := d.kapi.Delete(context.Background(), bindInstanceKey(id), nil)
-	return err
+// GetBindInstanceVersion - Retrieve a bind instance and the version it was
+// read at from the kvp API.
+func (d *Dao) GetBindInstanceVersion(id string) (*bundle.BindInstance, string, error) {
+	bi := &bundle.BindInstance{}
+	version, err := d.getObjectVersion(bindInstanceKey(id), bi)
+	if err != nil {
+		return nil, "", err
+	}
+	return bi, version, nil
 }
 
-// DeleteBinding - Delete the binding instance and remove the association with the service instance.
-func (d *Dao) DeleteBinding(bindingInstance bundle.BindInstance, serviceInstance bundle.ServiceInstance) error {
-	if err := d.DeleteBindInstance(bindingInstance.ID.String()); err != nil {
-		return err
+// CompareAndSetBindInstance - Set the bind instance for id in the kvp API if
+// it has not changed since version was read.
+func (d *Dao) CompareAndSetBindInstance(id string, bindInstance *bundle.BindInstance, version string) (string, error) {
+	newVersion, err := d.compareAndSetObject(bindInstanceKey(id), bindInstance, version)
+	if err != nil {
+		return "", err
 	}
-	serviceInstance.RemoveBinding(bindingInstance.ID)
-	if err := d.SetServiceInstance(serviceInstance.ID.String(), &serviceInstance); err != nil {
+	d.updateIndex(indexKindBinding, id, bindingIndexKeys(id, bindInstance))
+	return newVersion, nil
+}
+
+// DeleteBindInstance - Delete the binding instance for an id in the kvp API.
+func (d *Dao) DeleteBindInstance(id string) error {
+	log.Debug(fmt.Sprintf("Dao::DeleteBindInstance -> [ %s ]", id))
+	if _, err := d.kapi.Delete(context.Background(), bindInstanceKey(id), nil); err != nil {
 		return err
 	}
+	d.updateIndex(indexKindBinding, id, nil)
 	return nil
 }
 
+// DeleteBinding - Delete the binding instance and remove the association
+// with the service instance, as a journaled batch so an interrupted delete is
+// finished by a later replay.
+func (d *Dao) DeleteBinding(bindingInstance bundle.BindInstance, serviceInstance bundle.ServiceInstance) error {
+	return d.Apply(types.DeleteBindingBatch(bindingInstance.ID.String(), serviceInstance.ID.String()))
+}
+
 // SetState - Set the Job State in the kvp API for id.
 func (d *Dao) SetState(id string, state bundle.JobState) (string, error) {
 	key := stateKey(id, state.Token)
-	return key, d.setObject(key, state)
+	if err := d.setObject(key, storedJobState{JobState: state, LastModified: time.Now()}); err != nil {
+		return key, err
+	}
+	d.updateIndex(indexKindJob, id, []string{stateIndexKey(id, state.State)})
+	return key, nil
 }
 
 // GetState - Retrieve a job state from the kvp API for an ID and Token.
@@ -376,28 +625,140 @@ func (d *Dao) GetStateByKey(key string) (bundle.JobState, error) {
 	return state, nil
 }
 
+// GetStateVersion - Retrieve a job state and the version it was read at from
+// the kvp API.
+func (d *Dao) GetStateVersion(id string, token string) (bundle.JobState, string, error) {
+	state := bundle.JobState{}
+	version, err := d.getObjectVersion(stateKey(id, token), &state)
+	if err != nil {
+		return bundle.JobState{State: bundle.StateFailed}, "", err
+	}
+	return state, version, nil
+}
+
+// CompareAndSetState - Set the Job State in the kvp API for id if it has not
+// changed since version was read.
+func (d *Dao) CompareAndSetState(id string, state bundle.JobState, version string) (string, error) {
+	newVersion, err := d.compareAndSetObject(stateKey(id, state.Token),
+		storedJobState{JobState: state, LastModified: time.Now()}, version)
+	if err != nil {
+		return "", err
+	}
+	d.updateIndex(indexKindJob, id, []string{stateIndexKey(id, state.State)})
+	return newVersion, nil
+}
+
+// DeleteState - Delete the job state for an id and token from the kvp API.
+// The job directories of the id are removed once they are empty.
+func (d *Dao) DeleteState(id string, token string) error {
+	log.Debugf("Dao::DeleteState -> [ %s ]", stateKey(id, token))
+	if _, err := d.kapi.Delete(context.Background(), stateKey(id, token), nil); err != nil {
+		return err
+	}
+	// deleting a directory that still has children fails, which is fine.
+	dirOpts := &client.DeleteOptions{Dir: true}
+	if _, err := d.kapi.Delete(context.Background(), fmt.Sprintf("/state/%s/job", id), dirOpts); err == nil {
+		d.kapi.Delete(context.Background(), fmt.Sprintf("/state/%s", id), dirOpts)
+		d.updateIndex(indexKindJob, id, nil)
+	}
+	return nil
+}
+
+// BatchGetJobStates - Retrieve every job state in the kvp API.
+func (d *Dao) BatchGetJobStates() ([]types.JobStateRecord, error) {
+	log.Debug("Dao::BatchGetJobStates")
+	records := []types.JobStateRecord{}
+	opts := &client.GetOptions{Recursive: true, Sort: true}
+	res, err := d.kapi.Get(context.Background(), "/state", opts)
+	if client.IsKeyNotFound(err) {
+		return records, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// the tree is /state/<id>/job/<token>
+	for _, idNode := range res.Node.Nodes {
+		id := stateKeyID(idNode.Key)
+		for _, jobDir := range idNode.Nodes {
+			for _, node := range jobDir.Nodes {
+				stored := storedJobState{}
+				if err := schema.Unmarshal(types.KindJobState, node.Value, &stored); err != nil {
+					log.Warningf("Unable to parse job state [ %s ], skipping - %v", node.Key, err)
+					continue
+				}
+				records = append(records, types.JobStateRecord{
+					ID:           id,
+					State:        stored.JobState,
+					LastModified: stored.LastModified,
+				})
+			}
+		}
+	}
+	log.Debugf("Successfully loaded [ %d ] job states from etcd", len(records))
+	return records, nil
+}
+
 // IsNotFoundError - Will determine if an error is a key is not found error.
 func (d *Dao) IsNotFoundError(err error) bool {
 	return client.IsKeyNotFound(err)
 }
 
+// IsConflictError - Will determine if an error is a compare and set conflict.
+func (d *Dao) IsConflictError(err error) bool {
+	_, ok := err.(ConflictError)
+	return ok
+}
+
 func (d *Dao) getObject(key string, data interface{}) error {
 	raw, err := d.GetRaw(key)
 	if err != nil {
 		return err
 	}
-	bundle.LoadJSON(raw, data)
-	return nil
+	return decode(key, raw, data)
+}
+
+func (d *Dao) getObjectVersion(key string, data interface{}) (string, error) {
+	raw, version, err := d.GetRawVersion(key)
+	if err != nil {
+		return "", err
+	}
+	return version, decode(key, raw, data)
+}
+
+func (d *Dao) compareAndSetObject(key string, data interface{}, version string) (string, error) {
+	payload, err := encode(key, data)
+	if err != nil {
+		return "", err
+	}
+	return d.CompareAndSetRaw(key, payload, version)
 }
 
 func (d *Dao) setObject(key string, data interface{}) error {
-	payload, err := bundle.DumpJSON(data)
+	payload, err := encode(key, data)
 	if err != nil {
 		return err
 	}
 	return d.SetRaw(key, payload)
 }
 
+// encode - the JSON of data to store at key, the records are written with
+// their schema version.
+func encode(key string, data interface{}) (string, error) {
+	if kind := recordKind(key); kind != "" {
+		return schema.Marshal(kind, data)
+	}
+	return bundle.DumpJSON(data)
+}
+
+// decode - decodes the JSON stored at key into data, upgrading the records
+// written with an older schema version.
+func decode(key string, raw string, data interface{}) error {
+	if kind := recordKind(key); kind != "" {
+		return schema.Unmarshal(kind, raw, data)
+	}
+	return bundle.LoadJSON(raw, data)
+}
+
 ////////////////////////////////////////////////////////////
 // Key generators
 ////////////////////////////////////////////////////////////
@@ -412,6 +773,22 @@ func stateKeyID(key string) string {
 	return s
 }
 
+// recordKind - the kind of the record stored at key, empty for the keys that
+// are not versioned records.
+func recordKind(key string) types.Kind {
+	switch {
+	case strings.HasPrefix(key, "/spec/"):
+		return types.KindSpec
+	case strings.HasPrefix(key, "/service_instance/"):
+		return types.KindServiceInstance
+	case strings.HasPrefix(key, "/bind_instance/"):
+		return types.KindBindInstance
+	case strings.HasPrefix(key, "/state/"):
+		return types.KindJobState
+	}
+	return ""
+}
+
 func extractedCredentialsKey(id string) string {
 	return fmt.Sprintf("/extracted_credentials/%s", id)
 }
@@ -428,6 +805,51 @@ func bindInstanceKey(id string) string {
 	return fmt.Sprintf("/bind_instance/%s", id)
 }
 
+func journalKey(id string) string {
+	return fmt.Sprintf("/journal/%s", id)
+}
+
+func intentKey(id string) string {
+	return fmt.Sprintf("/intent/%s", id)
+}
+
+func jobRecordKey(token string) string {
+	return fmt.Sprintf("/job/%s", token)
+}
+
+func deadLetterKey(id string) string {
+	return fmt.Sprintf("/dead_letter/%s", id)
+}
+
+func auditRecordKey(id string) string {
+	return fmt.Sprintf("/audit/%s", id)
+}
+
+func leaseKey(name string) string {
+	return fmt.Sprintf("/lease/%s", name)
+}
+
 func planNameKey(id string) string {
 	return fmt.Sprintf("/plan_name/%s", id)
 }
+
+// decodeSpecs - the specs of the payloads. The payloads that cannot be
+// decoded, or upgraded to the current schema, are left out and their errors
+// returned together, so a broken spec never comes back as an empty one.
+func decodeSpecs(payloads []string) ([]*bundle.Spec, error) {
+	specs := make([]*bundle.Spec, 0, len(payloads))
+	errs := arrayErrors{}
+	for _, payload := range payloads {
+		spec := &bundle.Spec{}
+		if err := schema.Unmarshal(types.KindSpec, payload, spec); err != nil {
+			log.Errorf("Unable to decode a spec - %v", err)
+			errs = append(errs, err)
+			continue
+		}
+		specs = append(specs, spec)
+	}
+	if len(errs) > 0 {
+		return specs, errs
+	}
+	return specs, nil
+}
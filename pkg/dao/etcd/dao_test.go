@@ -0,0 +1,83 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+// listingKeysAPI - a keys API holding a single directory, counting its reads.
+type listingKeysAPI struct {
+	client.KeysAPI
+	nodes client.Nodes
+	reads int
+}
+
+func (k *listingKeysAPI) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	k.reads++
+	return &client.Response{Node: &client.Node{Key: key, Dir: true, Nodes: k.nodes}}, nil
+}
+
+func TestGetRawPage(t *testing.T) {
+	kapi := &listingKeysAPI{}
+	for i := 0; i < 5; i++ {
+		kapi.nodes = append(kapi.nodes, &client.Node{Key: fmt.Sprintf("/spec/%d", i), Value: fmt.Sprintf("%d", i)})
+	}
+	d := &Dao{kapi: kapi, listings: map[string]*listing{}}
+
+	payloads := []string{}
+	token := ""
+	for {
+		page, next, err := d.getRawPage("/spec", token, 2)
+		ft.AssertNil(t, err)
+		payloads = append(payloads, page...)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	ft.AssertEqual(t, "[0 1 2 3 4]", fmt.Sprintf("%v", payloads))
+	// the directory is read once for the whole walk
+	ft.AssertEqual(t, 1, kapi.reads)
+
+	// the walks under way share a single listing of the directory, which a
+	// walk starting reads again
+	first, next, err := d.getRawPage("/spec", "", 2)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "[0 1]", fmt.Sprintf("%v", first))
+	_, _, err = d.getRawPage("/spec", "", 2)
+	ft.AssertNil(t, err)
+	page, _, err := d.getRawPage("/spec", next, 2)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "[2 3]", fmt.Sprintf("%v", page))
+	ft.AssertEqual(t, 3, kapi.reads)
+	ft.AssertEqual(t, 1, len(d.listings))
+
+	// a walk whose listing expired resumes after the last child returned
+	d.listings["/spec"].read = time.Now().Add(-2 * listingTTL)
+	page, next, err = d.getRawPage("/spec", "1", 2)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "[2 3]", fmt.Sprintf("%v", page))
+	ft.AssertEqual(t, "3", next)
+	ft.AssertEqual(t, 4, kapi.reads)
+}
@@ -0,0 +1,333 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/coreos/etcd/client"
+	"github.com/openshift/ansible-service-broker/pkg/dao/schema"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// The secondary indexes are empty keys named
+// /index/<kind>/<field>/<value>/<id>, so the ids with a value are the children
+// of a single directory. The index keys written for a record are kept in
+// /index/records/<kind>/<id> to remove them once the record changes. The
+// indexes are written after the record, so the queries check the records
+// they find against the filter again.
+const (
+	indexKindInstance = "service_instance"
+	indexKindBinding  = "bind_instance"
+	// indexKindJob - the state of the last job of an instance or binding.
+	indexKindJob = "job"
+
+	// indexVersionKey - set once the indexes of the records written before
+	// they were maintained have been built.
+	indexVersionKey = "/index/version"
+	indexVersion    = "1"
+)
+
+// FindServiceInstances - Retrieve the service instances selected by the
+// filter, ordered by id, using the secondary indexes.
+func (d *Dao) FindServiceInstances(filter types.InstanceFilter) ([]*bundle.ServiceInstance, error) {
+	dirs := []string{}
+	if filter.Namespace != "" {
+		dirs = append(dirs, indexDir(indexKindInstance, "namespace", filter.Namespace))
+	}
+	if filter.SpecID != "" {
+		dirs = append(dirs, indexDir(indexKindInstance, "spec", filter.SpecID))
+	}
+	if filter.PlanID != "" {
+		dirs = append(dirs, indexDir(indexKindInstance, "plan", filter.PlanID))
+	}
+	if filter.State != "" {
+		dirs = append(dirs, indexDir(indexKindJob, "state", string(filter.State)))
+	}
+	if len(dirs) == 0 {
+		return d.BatchGetBundleInstances()
+	}
+	ids, err := d.indexedIDs(dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []*bundle.ServiceInstance{}
+	for _, id := range ids {
+		si, err := d.GetServiceInstance(id)
+		if d.IsNotFoundError(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		state, err := d.latestState(id, filter.State)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Matches(si, state) {
+			found = append(found, si)
+		}
+	}
+	return found, nil
+}
+
+// FindBindInstances - Retrieve the bind instances selected by the filter,
+// ordered by id, using the secondary indexes.
+func (d *Dao) FindBindInstances(filter types.BindingFilter) ([]*bundle.BindInstance, error) {
+	dirs := []string{}
+	if filter.ServiceInstanceID != "" {
+		dirs = append(dirs, indexDir(indexKindBinding, "service_instance", filter.ServiceInstanceID))
+	}
+	if filter.State != "" {
+		dirs = append(dirs, indexDir(indexKindJob, "state", string(filter.State)))
+	}
+	if len(dirs) == 0 {
+		return d.BatchGetBindInstances()
+	}
+	ids, err := d.indexedIDs(dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []*bundle.BindInstance{}
+	for _, id := range ids {
+		bi, err := d.GetBindInstance(id)
+		if d.IsNotFoundError(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		state, err := d.latestState(id, filter.State)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Matches(bi, state) {
+			found = append(found, bi)
+		}
+	}
+	return found, nil
+}
+
+// indexedIDs - the sorted ids found in every one of the index directories.
+func (d *Dao) indexedIDs(dirs []string) ([]string, error) {
+	if err := d.ensureIndex(); err != nil {
+		return nil, err
+	}
+	var ids map[string]bool
+	for _, dir := range dirs {
+		res, err := d.kapi.Get(context.Background(), dir, nil)
+		if client.IsKeyNotFound(err) {
+			return []string{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		found := map[string]bool{}
+		for _, node := range res.Node.Nodes {
+			id := strings.TrimPrefix(node.Key, dir+"/")
+			if ids == nil || ids[id] {
+				found[id] = true
+			}
+		}
+		ids = found
+	}
+
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// latestState - the state of the job state of id written last, only read
+// when the filter selects on it.
+func (d *Dao) latestState(id string, filter bundle.State) (bundle.State, error) {
+	if filter == "" {
+		return "", nil
+	}
+	res, err := d.kapi.Get(context.Background(), fmt.Sprintf("/state/%s/job", id), nil)
+	if client.IsKeyNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	var latest *client.Node
+	for _, node := range res.Node.Nodes {
+		if latest == nil || node.ModifiedIndex > latest.ModifiedIndex {
+			latest = node
+		}
+	}
+	if latest == nil {
+		return "", nil
+	}
+	js := bundle.JobState{}
+	if err := schema.Unmarshal(types.KindJobState, latest.Value, &js); err != nil {
+		return "", err
+	}
+	return js.State, nil
+}
+
+// ensureIndex - builds the indexes of the records written before they were
+// maintained, once per data store.
+func (d *Dao) ensureIndex() error {
+	d.indexLock.Lock()
+	defer d.indexLock.Unlock()
+	if d.indexed {
+		return nil
+	}
+	_, err := d.GetRaw(indexVersionKey)
+	if err == nil {
+		d.indexed = true
+		return nil
+	} else if !d.IsNotFoundError(err) {
+		return err
+	}
+
+	log.Info("Building the etcd secondary indexes")
+	instances, err := d.BatchGetBundleInstances()
+	if err != nil {
+		return err
+	}
+	for _, si := range instances {
+		if err := d.reindex(indexKindInstance, si.ID.String(), instanceIndexKeys(si.ID.String(), si)); err != nil {
+			return err
+		}
+	}
+	bindings, err := d.BatchGetBindInstances()
+	if err != nil {
+		return err
+	}
+	for _, bi := range bindings {
+		if err := d.reindex(indexKindBinding, bi.ID.String(), bindingIndexKeys(bi.ID.String(), bi)); err != nil {
+			return err
+		}
+	}
+	states, err := d.BatchGetJobStates()
+	if err != nil {
+		return err
+	}
+	for id, state := range types.LatestStates(states) {
+		if err := d.reindex(indexKindJob, id, []string{stateIndexKey(id, state)}); err != nil {
+			return err
+		}
+	}
+	if err := d.SetRaw(indexVersionKey, indexVersion); err != nil {
+		return err
+	}
+	log.Infof("Indexed [ %d ] service instances, [ %d ] bind instances and [ %d ] job states",
+		len(instances), len(bindings), len(states))
+	d.indexed = true
+	return nil
+}
+
+// updateIndex - replaces the index keys of a record that was just written or
+// deleted. A failure is only logged, the record itself was written.
+func (d *Dao) updateIndex(kind string, id string, keys []string) {
+	if err := d.reindex(kind, id, keys); err != nil {
+		log.Errorf("Unable to index %s [ %s ] - %v", kind, id, err)
+	}
+}
+
+// reindex - writes the index keys of a record, removing the ones it had
+// before that are no longer valid.
+func (d *Dao) reindex(kind string, id string, keys []string) error {
+	recordKey := indexRecordKey(kind, id)
+	old := []string{}
+	raw, err := d.GetRaw(recordKey)
+	if err == nil {
+		if err := json.Unmarshal([]byte(raw), &old); err != nil {
+			log.Warningf("Unable to parse the index keys of %s [ %s ], rewriting them - %v", kind, id, err)
+		}
+	} else if !d.IsNotFoundError(err) {
+		return err
+	}
+
+	current := map[string]bool{}
+	for _, key := range keys {
+		current[key] = true
+	}
+	for _, key := range old {
+		if current[key] {
+			continue
+		}
+		_, err := d.kapi.Delete(context.Background(), key, nil)
+		if err != nil && !d.IsNotFoundError(err) {
+			return err
+		}
+	}
+	for _, key := range keys {
+		if err := d.SetRaw(key, ""); err != nil {
+			return err
+		}
+	}
+
+	if len(keys) == 0 {
+		_, err := d.kapi.Delete(context.Background(), recordKey, nil)
+		if err != nil && !d.IsNotFoundError(err) {
+			return err
+		}
+		return nil
+	}
+	return d.setObject(recordKey, keys)
+}
+
+// instanceIndexKeys - the index keys of a service instance.
+func instanceIndexKeys(id string, si *bundle.ServiceInstance) []string {
+	keys := []string{}
+	if si.Context != nil && si.Context.Namespace != "" {
+		keys = append(keys, indexKey(indexKindInstance, "namespace", si.Context.Namespace, id))
+	}
+	if si.Spec != nil && si.Spec.ID != "" {
+		keys = append(keys, indexKey(indexKindInstance, "spec", si.Spec.ID, id))
+	}
+	if plan := types.PlanID(si); plan != "" {
+		keys = append(keys, indexKey(indexKindInstance, "plan", plan, id))
+	}
+	return keys
+}
+
+// bindingIndexKeys - the index keys of a bind instance.
+func bindingIndexKeys(id string, bi *bundle.BindInstance) []string {
+	if bi.ServiceID == nil {
+		return []string{}
+	}
+	return []string{indexKey(indexKindBinding, "service_instance", bi.ServiceID.String(), id)}
+}
+
+// stateIndexKey - the index key of the state of the last job of id.
+func stateIndexKey(id string, state bundle.State) string {
+	return indexKey(indexKindJob, "state", string(state), id)
+}
+
+func indexDir(kind string, field string, value string) string {
+	return fmt.Sprintf("/index/%s/%s/%s", kind, field, url.PathEscape(value))
+}
+
+func indexKey(kind string, field string, value string, id string) string {
+	return fmt.Sprintf("%s/%s", indexDir(kind, field, value), id)
+}
+
+func indexRecordKey(kind string, id string) string {
+	return fmt.Sprintf("/index/records/%s/%s", kind, id)
+}
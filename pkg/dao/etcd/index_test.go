@@ -0,0 +1,49 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+func TestIndexKeys(t *testing.T) {
+	params := bundle.Parameters{"_apb_plan_id": "dev/small"}
+	si := &bundle.ServiceInstance{
+		Spec:       &bundle.Spec{ID: "spec-1"},
+		Context:    &bundle.Context{Namespace: "project"},
+		Parameters: &params,
+	}
+	keys := instanceIndexKeys("si-1", si)
+	ft.AssertTrue(t, reflect.DeepEqual([]string{
+		"/index/service_instance/namespace/project/si-1",
+		"/index/service_instance/spec/spec-1/si-1",
+		"/index/service_instance/plan/dev%2Fsmall/si-1",
+	}, keys))
+
+	ft.AssertEqual(t, 0, len(instanceIndexKeys("si-2", &bundle.ServiceInstance{})))
+	ft.AssertEqual(t, 0, len(bindingIndexKeys("b-1", &bundle.BindInstance{})))
+
+	siID := uuid.NewRandom()
+	keys = bindingIndexKeys("b-1", &bundle.BindInstance{ServiceID: siID})
+	ft.AssertEqual(t, "/index/bind_instance/service_instance/"+siID.String()+"/b-1", keys[0])
+	ft.AssertEqual(t, "/index/job/state/in%20progress/si-1", stateIndexKey("si-1", bundle.StateInProgress))
+}
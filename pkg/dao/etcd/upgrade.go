@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/coreos/etcd/client"
+	"github.com/openshift/ansible-service-broker/pkg/dao/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+// UpgradeRecords - Rewrites the records stored with an older schema version,
+// or none, in the current version. A record written since it was read is
+// left alone, its writer stored the current version. With dryRun the records
+// are only counted. Returns how many records were, or would be, upgraded.
+func (d *Dao) UpgradeRecords(dryRun bool) (int, error) {
+	upgraded := 0
+	for _, dir := range []string{"/spec", "/service_instance", "/bind_instance", "/state"} {
+		res, err := d.kapi.Get(context.Background(), dir, &client.GetOptions{Recursive: true, Sort: true})
+		if client.IsKeyNotFound(err) {
+			continue
+		} else if err != nil {
+			return upgraded, err
+		}
+		n, err := d.upgradeNodes(res.Node.Nodes, dryRun)
+		upgraded += n
+		if err != nil {
+			return upgraded, err
+		}
+	}
+	return upgraded, nil
+}
+
+// upgradeNodes - upgrades the records in the nodes and their children.
+func (d *Dao) upgradeNodes(nodes client.Nodes, dryRun bool) (int, error) {
+	upgraded := 0
+	for _, node := range nodes {
+		if node.Dir {
+			n, err := d.upgradeNodes(node.Nodes, dryRun)
+			upgraded += n
+			if err != nil {
+				return upgraded, err
+			}
+			continue
+		}
+		kind := recordKind(node.Key)
+		if kind == "" {
+			continue
+		}
+		stale, err := schema.NeedsRewrite(kind, node.Value)
+		if err != nil {
+			log.Warningf("Unable to parse record [ %s ], skipping - %v", node.Key, err)
+			continue
+		}
+		if !stale {
+			continue
+		}
+		if dryRun {
+			upgraded++
+			continue
+		}
+		raw, err := schema.Rewrite(kind, node.Value)
+		if err != nil {
+			return upgraded, err
+		}
+		_, err = d.CompareAndSetRaw(node.Key, raw, strconv.FormatUint(node.ModifiedIndex, 10))
+		if d.IsConflictError(err) {
+			log.Debugf("Record [ %s ] was written while upgrading it, skipping", node.Key)
+			continue
+		} else if err != nil {
+			return upgraded, err
+		}
+		log.Debugf("Upgraded record [ %s ]", node.Key)
+		upgraded++
+	}
+	return upgraded, nil
+}
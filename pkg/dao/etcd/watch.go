@@ -0,0 +1,159 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/coreos/etcd/client"
+	"github.com/openshift/ansible-service-broker/pkg/dao/schema"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchRetryDelay - how long to wait before watching again after the watch
+// failed.
+var watchRetryDelay = time.Second
+
+// Watch - Report the changes made after the watch started using an etcd
+// watch on the whole key space.
+func (d *Dao) Watch(stop <-chan struct{}) (<-chan types.Event, error) {
+	res, err := d.kapi.Get(context.Background(), "/", nil)
+	if err != nil {
+		log.Errorf("Unable to get the etcd index to watch from - %v", err)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	events := make(chan types.Event)
+	go func() {
+		defer close(events)
+		after := res.Index
+		for {
+			w := d.kapi.Watcher("/", &client.WatcherOptions{AfterIndex: after, Recursive: true})
+			for {
+				resp, err := w.Next(ctx)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeEventIndexCleared {
+						// etcd only keeps the last 1000 events, the ones
+						// in between are lost.
+						log.Warningf("Missed etcd events after index %d, watching from %d", after, etcdErr.Index)
+						after = etcdErr.Index
+					} else {
+						log.Errorf("Unable to watch etcd - %v", err)
+						select {
+						case <-time.After(watchRetryDelay):
+						case <-ctx.Done():
+							return
+						}
+					}
+					break
+				}
+
+				after = resp.Index
+				if resp.Node != nil {
+					after = resp.Node.ModifiedIndex
+				}
+				e, ok := eventFromResponse(resp)
+				if !ok {
+					continue
+				}
+				select {
+				case events <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// eventFromResponse - converts an etcd watch response into an event, false
+// when the key is not a record that is watched.
+func eventFromResponse(resp *client.Response) (types.Event, bool) {
+	if resp.Node == nil || resp.Node.Dir {
+		return types.Event{}, false
+	}
+	e := types.Event{}
+	node := resp.Node
+	switch resp.Action {
+	case "delete", "compareAndDelete", "expire":
+		e.Type = types.Deleted
+		node = resp.PrevNode
+	case "create":
+		e.Type = types.Added
+	default:
+		e.Type = types.Updated
+		if resp.PrevNode == nil {
+			e.Type = types.Added
+		}
+	}
+
+	key := resp.Node.Key
+	parts := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "spec":
+		e.Kind, e.ID = types.KindSpec, parts[1]
+	case len(parts) == 2 && parts[0] == "service_instance":
+		e.Kind, e.ID = types.KindServiceInstance, parts[1]
+	case len(parts) == 2 && parts[0] == "bind_instance":
+		e.Kind, e.ID = types.KindBindInstance, parts[1]
+	case len(parts) == 4 && parts[0] == "state" && parts[2] == "job":
+		e.Kind, e.ID = types.KindJobState, parts[1]
+	default:
+		return types.Event{}, false
+	}
+
+	if node == nil || node.Value == "" {
+		if e.Kind == types.KindJobState {
+			e.JobState = &bundle.JobState{Token: parts[3]}
+		}
+		return e, true
+	}
+	var err error
+	switch e.Kind {
+	case types.KindSpec:
+		e.Spec = &bundle.Spec{}
+		err = schema.Unmarshal(e.Kind, node.Value, e.Spec)
+	case types.KindServiceInstance:
+		e.ServiceInstance = &bundle.ServiceInstance{}
+		err = schema.Unmarshal(e.Kind, node.Value, e.ServiceInstance)
+	case types.KindBindInstance:
+		e.BindInstance = &bundle.BindInstance{}
+		err = schema.Unmarshal(e.Kind, node.Value, e.BindInstance)
+	case types.KindJobState:
+		state := storedJobState{}
+		err = schema.Unmarshal(e.Kind, node.Value, &state)
+		e.JobState = &state.JobState
+	}
+	if err != nil {
+		log.Warningf("Unable to decode the watched record [ %s ] - %v", key, err)
+	}
+	return e, true
+}
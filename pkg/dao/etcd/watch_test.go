@@ -0,0 +1,62 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"testing"
+
+	"github.com/coreos/etcd/client"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+func TestEventFromResponse(t *testing.T) {
+	e, ok := eventFromResponse(&client.Response{
+		Action: "set",
+		Node:   &client.Node{Key: "/spec/abc", Value: `{"id":"abc","name":"mediawiki"}`},
+	})
+	ft.AssertTrue(t, ok)
+	ft.AssertEqual(t, types.Added, e.Type)
+	ft.AssertEqual(t, types.KindSpec, e.Kind)
+	ft.AssertEqual(t, "mediawiki", e.Spec.FQName)
+
+	e, ok = eventFromResponse(&client.Response{
+		Action:   "compareAndSwap",
+		Node:     &client.Node{Key: "/state/abc/job/tok", Value: `{"token":"tok","state":"succeeded","last_modified":"2018-06-01T00:00:00Z"}`},
+		PrevNode: &client.Node{Key: "/state/abc/job/tok", Value: `{"token":"tok","state":"in progress"}`},
+	})
+	ft.AssertTrue(t, ok)
+	ft.AssertEqual(t, types.Updated, e.Type)
+	ft.AssertEqual(t, types.KindJobState, e.Kind)
+	ft.AssertEqual(t, "abc", e.ID)
+	ft.AssertEqual(t, "tok", e.JobState.Token)
+
+	e, ok = eventFromResponse(&client.Response{
+		Action:   "delete",
+		Node:     &client.Node{Key: "/bind_instance/def"},
+		PrevNode: &client.Node{Key: "/bind_instance/def", Value: `{"id":"4e7f7b4c-5a3e-4d4e-9f22-1b0a2c3d4e5f"}`},
+	})
+	ft.AssertTrue(t, ok)
+	ft.AssertEqual(t, types.Deleted, e.Type)
+	ft.AssertEqual(t, "def", e.ID)
+	ft.AssertNotNil(t, e.BindInstance)
+
+	_, ok = eventFromResponse(&client.Response{Action: "set", Node: &client.Node{Key: "/extracted_credentials/abc"}})
+	ft.AssertFalse(t, ok)
+	_, ok = eventFromResponse(&client.Response{Action: "delete", Node: &client.Node{Key: "/state/abc", Dir: true}})
+	ft.AssertFalse(t, ok)
+}
@@ -0,0 +1,57 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// SetAuditRecord - Store the audit record in etcd.
+func (d *Dao) SetAuditRecord(record types.AuditRecord) error {
+	payload, err := bundle.DumpJSON(record)
+	if err != nil {
+		return err
+	}
+	return d.SetRaw(auditRecordKey(record.ID), payload)
+}
+
+// DeleteAuditRecord - Delete the audit record from etcd.
+func (d *Dao) DeleteAuditRecord(id string) error {
+	err := d.DeleteRaw(auditRecordKey(id))
+	if d.IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
+
+// BatchGetAuditRecords - Retrieve the audit records stored in etcd, oldest
+// first.
+func (d *Dao) BatchGetAuditRecords() ([]types.AuditRecord, error) {
+	payloads, err := d.BatchGetRaw("/audit")
+	if err != nil {
+		return nil, err
+	}
+	records := make([]types.AuditRecord, len(*payloads))
+	for i, payload := range *payloads {
+		if err := bundle.LoadJSON(payload, &records[i]); err != nil {
+			return nil, err
+		}
+	}
+	types.SortAuditRecords(records)
+	return records, nil
+}
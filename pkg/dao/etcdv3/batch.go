@@ -0,0 +1,217 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// stagedBatch - the writes of a batch staged before they are committed in a
+// transaction, along with the revisions of the keys they were based on.
+type stagedBatch struct {
+	d *Dao
+	// revisions holds the mod revision each key was read at, 0 when it did
+	// not exist.
+	revisions map[string]int64
+	// values holds the current value of each key read or written, nil when
+	// it does not exist.
+	values  map[string]*string
+	written map[string]bool
+}
+
+func newStagedBatch(d *Dao) *stagedBatch {
+	return &stagedBatch{
+		d:         d,
+		revisions: map[string]int64{},
+		values:    map[string]*string{},
+		written:   map[string]bool{},
+	}
+}
+
+// value - the value of key as the batch sees it, read from etcd the first
+// time.
+func (s *stagedBatch) value(key string) (*string, error) {
+	if val, ok := s.values[key]; ok {
+		return val, nil
+	}
+	res, err := s.d.get(key)
+	if err != nil {
+		return nil, err
+	}
+	s.revisions[key] = 0
+	s.values[key] = nil
+	if len(res.Kvs) > 0 {
+		val := string(res.Kvs[0].Value)
+		s.revisions[key] = res.Kvs[0].ModRevision
+		s.values[key] = &val
+	}
+	return s.values[key], nil
+}
+
+// set - stages writing val to key, a nil val deletes it.
+func (s *stagedBatch) set(key string, val *string) {
+	s.values[key] = val
+	s.written[key] = true
+}
+
+// apply - stages a single write of a batch.
+func (s *stagedBatch) apply(op types.Op) error {
+	var key string
+	var data interface{}
+	switch op.Type {
+	case types.OpSetServiceInstance:
+		si := *op.ServiceInstance
+		si.BindingIDs = removeFalseBindings(op.ServiceInstance.BindingIDs)
+		key, data = serviceInstanceKey(op.ID), &si
+	case types.OpSetBindInstance:
+		key, data = bindInstanceKey(op.ID), op.BindInstance
+	case types.OpDeleteServiceInstance, types.OpDeleteBindInstance:
+		key = serviceInstanceKey(op.ID)
+		if op.Type == types.OpDeleteBindInstance {
+			key = bindInstanceKey(op.ID)
+		}
+		val, err := s.value(key)
+		if err != nil {
+			return err
+		}
+		if val == nil {
+			return NotFoundError{Key: key}
+		}
+		s.set(key, nil)
+		return nil
+	case types.OpRemoveBinding:
+		key = serviceInstanceKey(op.ID)
+		val, err := s.value(key)
+		if err != nil || val == nil {
+			return err
+		}
+		si := &bundle.ServiceInstance{}
+		if err := decode(key, *val, si); err != nil {
+			return err
+		}
+		delete(si.BindingIDs, op.BindingID)
+		si.BindingIDs = removeFalseBindings(si.BindingIDs)
+		data = si
+	default:
+		return fmt.Errorf("unknown batch op type: %s", op.Type)
+	}
+	payload, err := encode(key, data)
+	if err != nil {
+		return err
+	}
+	s.set(key, &payload)
+	return nil
+}
+
+// txn - the comparisons that the keys read are unchanged and the operations
+// making the writes, a single one per key since etcd rejects transactions
+// writing a key twice.
+func (s *stagedBatch) txn() ([]clientv3.Cmp, []clientv3.Op) {
+	keys := []string{}
+	for key := range s.revisions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	cmps := make([]clientv3.Cmp, len(keys))
+	for i, key := range keys {
+		if s.revisions[key] == 0 {
+			cmps[i] = clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+		} else {
+			cmps[i] = clientv3.Compare(clientv3.ModRevision(key), "=", s.revisions[key])
+		}
+	}
+
+	keys = keys[:0]
+	for key := range s.written {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ops := make([]clientv3.Op, len(keys))
+	for i, key := range keys {
+		if val := s.values[key]; val != nil {
+			ops[i] = clientv3.OpPut(key, *val)
+		} else {
+			ops[i] = clientv3.OpDelete(key)
+		}
+	}
+	return cmps, ops
+}
+
+// Apply - Make the writes of the batch and record its intents in a single
+// transaction. The records the batch is based on are compared with the
+// revisions they were read at, and the batch is staged again when one of
+// them changed in between.
+func (d *Dao) Apply(batch types.Batch) error {
+	for {
+		s := newStagedBatch(d)
+		for _, op := range batch.Ops {
+			if err := s.apply(op); err != nil {
+				return err
+			}
+		}
+		for _, intent := range batch.Intents {
+			payload, err := bundle.DumpJSON(intent)
+			if err != nil {
+				return err
+			}
+			s.set(intentKey(intent.ID), &payload)
+		}
+
+		cmps, ops := s.txn()
+		if len(ops) > maxTxnOps || len(cmps) > maxTxnOps {
+			return fmt.Errorf("batch writes %d keys, etcd accepts up to %d in a transaction", len(ops), maxTxnOps)
+		}
+		res, err := d.commit(cmps, ops, nil)
+		if err != nil {
+			return err
+		}
+		if res.Succeeded {
+			return nil
+		}
+		log.Debugf("records changed while applying a batch, retrying")
+	}
+}
+
+// PendingIntents - Retrieve the pending intents stored in etcd, ordered by id.
+func (d *Dao) PendingIntents() ([]types.Intent, error) {
+	payloads, err := d.BatchGetRaw("/intent")
+	if err != nil {
+		return nil, err
+	}
+	intents := make([]types.Intent, len(*payloads))
+	for i, payload := range *payloads {
+		if err := bundle.LoadJSON(payload, &intents[i]); err != nil {
+			return nil, err
+		}
+	}
+	return intents, nil
+}
+
+// CompleteIntent - Delete the intent from etcd.
+func (d *Dao) CompleteIntent(id string) error {
+	err := d.DeleteRaw(intentKey(id))
+	if d.IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
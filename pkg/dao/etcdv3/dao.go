@@ -0,0 +1,892 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/automationbroker/bundle-lib/clients"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/pkg/transport"
+	"github.com/openshift/ansible-service-broker/pkg/dao/schema"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// dialTimeout - how long connecting to etcd may take.
+	dialTimeout = 5 * time.Second
+	// requestTimeout - how long a single etcd request may take.
+	requestTimeout = 10 * time.Second
+	// maxTxnOps - the number of operations etcd accepts in a transaction by
+	// default, larger batches are split into several transactions.
+	maxTxnOps = 128
+	// maxTxnBytes - how many bytes of keys and values a transaction carries at
+	// most, below the 1.5 MiB etcd accepts in a request by default.
+	maxTxnBytes = 1024 * 1024
+)
+
+type arrayErrors []error
+
+func (a arrayErrors) Error() string {
+	return fmt.Sprintf("%#v", a)
+}
+
+// NotFoundError - Error returned when a key does not exist in etcd.
+type NotFoundError struct {
+	Key string
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("key not found: %s", e.Key)
+}
+
+// ConflictError - Error returned when a compare and set finds that the key
+// has been written since the expected version was read.
+type ConflictError struct {
+	Key string
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("key was modified concurrently: %s", e.Key)
+}
+
+// storedJobState - the job state as it is written to etcd, along with when it
+// was written so old job states can be pruned. Readers that only know about
+// bundle.JobState ignore the extra field.
+type storedJobState struct {
+	bundle.JobState
+	LastModified time.Time `json:"last_modified"`
+}
+
+// Dao - object to interface with the data store through the etcd v3 API. The
+// keys are the ones the v2 dao uses, so the records can be copied from one to
+// the other as they are. Versions are the mod revisions of the keys.
+type Dao struct {
+	client *clientv3.Client
+
+	// jobStateTTL - how long finished job states are kept before etcd
+	// expires them, forever when 0.
+	jobStateTTL time.Duration
+	// leaseLock guards lease, the lease finished job states are attached to.
+	leaseLock sync.Mutex
+	lease     *jobStateLease
+}
+
+// NewDao - Create a new Dao object connected to the etcd v3 API described by
+// config. With a jobStateTTL the finished job states are attached to a lease
+// and expire after it.
+func NewDao(config clients.EtcdConfig, jobStateTTL time.Duration) (*Dao, error) {
+	var tlsConfig *tls.Config
+	scheme := "http"
+	if config.EtcdCaFile != "" || config.EtcdClientCert != "" || config.EtcdClientKey != "" {
+		info := transport.TLSInfo{
+			CertFile: config.EtcdClientCert,
+			KeyFile:  config.EtcdClientKey,
+			CAFile:   config.EtcdCaFile,
+		}
+		var err error
+		if tlsConfig, err = info.ClientConfig(); err != nil {
+			return nil, err
+		}
+	}
+	if config.EtcdCaFile != "" {
+		scheme = "https"
+	}
+	endpoint := fmt.Sprintf("%s://%s:%v", scheme, config.EtcdHost, config.EtcdPort)
+
+	log.Info("== ETCD V3 CX ==")
+	log.Infof("Endpoint: %s", endpoint)
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: dialTimeout,
+		TLS:         tlsConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	status, err := client.Status(ctx, endpoint)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	log.Infof("Etcd Version [Server: %s]", status.Version)
+	if jobStateTTL > 0 {
+		log.Infof("Finished job states expire after %v", jobStateTTL)
+	}
+	return &Dao{client: client, jobStateTTL: jobStateTTL}, nil
+}
+
+// SetRaw - Allows the setting of the value json string to the key in etcd.
+func (d *Dao) SetRaw(key string, val string) error {
+	_, err := d.put(key, val)
+	return err
+}
+
+// GetRaw - gets a specific json string for a key from etcd.
+func (d *Dao) GetRaw(key string) (string, error) {
+	val, _, err := d.GetRawVersion(key)
+	return val, err
+}
+
+// GetRawVersion - gets a specific json string for a key from etcd along with
+// the revision it was last modified at.
+func (d *Dao) GetRawVersion(key string) (string, string, error) {
+	res, err := d.get(key)
+	if err != nil {
+		return "", "", err
+	}
+	if len(res.Kvs) == 0 {
+		return "", "", NotFoundError{Key: key}
+	}
+	kv := res.Kvs[0]
+	return string(kv.Value), strconv.FormatInt(kv.ModRevision, 10), nil
+}
+
+// CompareAndSetRaw - sets the value for key only if it has not been modified
+// since version. An empty version only sets the key if it does not exist. The
+// new version is returned.
+func (d *Dao) CompareAndSetRaw(key string, val string, version string) (string, error) {
+	return d.compareAndSetRaw(key, val, version)
+}
+
+// compareAndSetRaw - CompareAndSetRaw with the options of the put, which
+// attach the key to a lease or keep the one it has.
+func (d *Dao) compareAndSetRaw(key string, val string, version string, opts ...clientv3.OpOption) (string, error) {
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	if version != "" {
+		rev, err := strconv.ParseInt(version, 10, 64)
+		if err != nil || rev <= 0 {
+			return "", fmt.Errorf("invalid version [ %s ] for key [ %s ]", version, key)
+		}
+		cmp = clientv3.Compare(clientv3.ModRevision(key), "=", rev)
+	}
+	res, err := d.commit([]clientv3.Cmp{cmp},
+		[]clientv3.Op{clientv3.OpPut(key, val, opts...)},
+		[]clientv3.Op{clientv3.OpGet(key, clientv3.WithCountOnly())})
+	if err != nil {
+		return "", err
+	}
+	if !res.Succeeded {
+		if version != "" && res.Responses[0].GetResponseRange().Count == 0 {
+			return "", NotFoundError{Key: key}
+		}
+		return "", ConflictError{Key: key}
+	}
+	return strconv.FormatInt(res.Header.Revision, 10), nil
+}
+
+// DeleteRaw - Deletes the key from etcd.
+func (d *Dao) DeleteRaw(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	res, err := d.client.Delete(ctx, key)
+	if err != nil {
+		return err
+	}
+	if res.Deleted == 0 {
+		return NotFoundError{Key: key}
+	}
+	return nil
+}
+
+// BatchGetRaw - Get every key under dir as individual json strings, ordered
+// by key.
+func (d *Dao) BatchGetRaw(dir string) (*[]string, error) {
+	res, err := d.get(dirPrefix(dir), clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Successfully loaded [ %d ] objects from etcd prefix [ %s ]", len(res.Kvs), dirPrefix(dir))
+
+	payloads := make([]string, len(res.Kvs))
+	for i, kv := range res.Kvs {
+		payloads[i] = string(kv.Value)
+	}
+	return &payloads, nil
+}
+
+// getRawPage - Get up to limit keys under dir as json strings, ordered by key
+// and starting after the key named continueToken. The token for the next page
+// is empty once the last key was returned. Only the page is read from etcd.
+func (d *Dao) getRawPage(dir string, continueToken string, limit int) ([]string, string, error) {
+	start, end := pageRange(dir, continueToken)
+	opts := []clientv3.OpOption{
+		clientv3.WithRange(end),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+	}
+	if limit > 0 {
+		opts = append(opts, clientv3.WithLimit(int64(limit)))
+	}
+	res, err := d.get(start, opts...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	payloads := make([]string, len(res.Kvs))
+	for i, kv := range res.Kvs {
+		payloads[i] = string(kv.Value)
+	}
+	next := ""
+	if res.More && len(res.Kvs) > 0 {
+		next = strings.TrimPrefix(string(res.Kvs[len(res.Kvs)-1].Key), dirPrefix(dir))
+	}
+	return payloads, next, nil
+}
+
+// GetSpec - Retrieve the spec from etcd.
+func (d *Dao) GetSpec(id string) (*bundle.Spec, error) {
+	spec := &bundle.Spec{}
+	if err := d.getObject(specKey(id), spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// SetSpec - set spec for an id in etcd.
+func (d *Dao) SetSpec(id string, spec *bundle.Spec) error {
+	return d.setObject(specKey(id), spec)
+}
+
+// DeleteSpec - Delete the spec for a given spec id.
+func (d *Dao) DeleteSpec(specID string) error {
+	log.Debugf("Dao::DeleteSpec-> [ %s ]", specID)
+	return d.DeleteRaw(specKey(specID))
+}
+
+// BatchSetSpecs - set specs based on SpecManifest in etcd. The specs are
+// written in transactions of up to maxTxnOps specs and maxTxnBytes, so a
+// failure leaves whole transactions written.
+func (d *Dao) BatchSetSpecs(specs bundle.SpecManifest) error {
+	ops := []clientv3.Op{}
+	for id, spec := range specs {
+		payload, err := encode(specKey(id), spec)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, clientv3.OpPut(specKey(id), payload))
+	}
+	return d.commitBatch(ops)
+}
+
+// BatchGetSpecs - Retrieve all the specs for dir.
+func (d *Dao) BatchGetSpecs(dir string) ([]*bundle.Spec, error) {
+	payloads, err := d.BatchGetRaw(dir)
+	if err != nil {
+		return []*bundle.Spec{}, err
+	}
+
+	return decodeSpecs(*payloads)
+}
+
+// BatchGetBundleInstances - get list of bundleinstances
+func (d *Dao) BatchGetBundleInstances() ([]*bundle.ServiceInstance, error) {
+	payloads, err := d.BatchGetRaw("/service_instance")
+	if err != nil {
+		log.Errorf("Unable to get the service instances - %v", err)
+		return nil, err
+	}
+	instances := make([]*bundle.ServiceInstance, len(*payloads))
+	for i, payload := range *payloads {
+		si := &bundle.ServiceInstance{}
+		if err := schema.Unmarshal(types.KindServiceInstance, payload, si); err != nil {
+			log.Errorf("Unable to convert the service instances json unmarshal error - %v", err)
+			return nil, err
+		}
+		instances[i] = si
+	}
+	return instances, nil
+}
+
+// BatchGetBindInstances - Retrieve all the bind instances.
+func (d *Dao) BatchGetBindInstances() ([]*bundle.BindInstance, error) {
+	payloads, err := d.BatchGetRaw("/bind_instance")
+	if err != nil {
+		log.Errorf("Unable to get the bind instances - %v", err)
+		return nil, err
+	}
+	bindings := make([]*bundle.BindInstance, len(*payloads))
+	for i, payload := range *payloads {
+		bi := &bundle.BindInstance{}
+		if err := schema.Unmarshal(types.KindBindInstance, payload, bi); err != nil {
+			log.Errorf("Unable to convert the bind instances json unmarshal error - %v", err)
+			return nil, err
+		}
+		bindings[i] = bi
+	}
+	return bindings, nil
+}
+
+// FindServiceInstances - Retrieve the service instances selected by the
+// filter, ordered by id.
+func (d *Dao) FindServiceInstances(filter types.InstanceFilter) ([]*bundle.ServiceInstance, error) {
+	instances, err := d.BatchGetBundleInstances()
+	if err != nil {
+		return nil, err
+	}
+	states, err := d.latestStates(filter.State)
+	if err != nil {
+		return nil, err
+	}
+	found := []*bundle.ServiceInstance{}
+	for _, si := range instances {
+		if filter.Matches(si, states[si.ID.String()]) {
+			found = append(found, si)
+		}
+	}
+	return found, nil
+}
+
+// FindBindInstances - Retrieve the bind instances selected by the filter,
+// ordered by id.
+func (d *Dao) FindBindInstances(filter types.BindingFilter) ([]*bundle.BindInstance, error) {
+	bindings, err := d.BatchGetBindInstances()
+	if err != nil {
+		return nil, err
+	}
+	states, err := d.latestStates(filter.State)
+	if err != nil {
+		return nil, err
+	}
+	found := []*bundle.BindInstance{}
+	for _, bi := range bindings {
+		if filter.Matches(bi, states[bi.ID.String()]) {
+			found = append(found, bi)
+		}
+	}
+	return found, nil
+}
+
+// latestStates - the state of the last job of every id, only read when the
+// filter selects on it.
+func (d *Dao) latestStates(state bundle.State) (map[string]bundle.State, error) {
+	if state == "" {
+		return nil, nil
+	}
+	records, err := d.BatchGetJobStates()
+	if err != nil {
+		return nil, err
+	}
+	return types.LatestStates(records), nil
+}
+
+// GetSpecsPage - Retrieve up to limit specs ordered by id, starting after
+// the continue token.
+func (d *Dao) GetSpecsPage(continueToken string, limit int) ([]*bundle.Spec, string, error) {
+	payloads, next, err := d.getRawPage("/spec", continueToken, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	specs, err := decodeSpecs(payloads)
+	log.Debugf("Loaded page of [ %d ] specs, continue [ %s ]", len(specs), next)
+	return specs, next, err
+}
+
+// GetServiceInstancesPage - Retrieve up to limit service instances ordered by
+// id, starting after the continue token.
+func (d *Dao) GetServiceInstancesPage(continueToken string, limit int) ([]*bundle.ServiceInstance, string, error) {
+	payloads, next, err := d.getRawPage("/service_instance", continueToken, limit)
+	if err != nil {
+		log.Errorf("Unable to get the service instances - %v", err)
+		return nil, "", err
+	}
+
+	instances := make([]*bundle.ServiceInstance, len(payloads))
+	for i, payload := range payloads {
+		si := &bundle.ServiceInstance{}
+		if err := schema.Unmarshal(types.KindServiceInstance, payload, si); err != nil {
+			log.Errorf("Unable to convert the service instances json unmarshal error - %v", err)
+			return nil, "", err
+		}
+		instances[i] = si
+	}
+	return instances, next, nil
+}
+
+// BatchDeleteSpecs - delete the specs in transactions of up to maxTxnOps
+// specs.
+func (d *Dao) BatchDeleteSpecs(specs []*bundle.Spec) error {
+	ops := make([]clientv3.Op, len(specs))
+	for i, spec := range specs {
+		ops[i] = clientv3.OpDelete(specKey(spec.ID))
+	}
+	return d.commitBatch(ops)
+}
+
+// FindJobStateByState - Retrieve all the jobs that match the specified state
+func (d *Dao) FindJobStateByState(state bundle.State) ([]bundle.RecoverStatus, error) {
+	log.Debug("Dao::FindJobStateByState")
+	records, err := d.BatchGetJobStates()
+	if err != nil {
+		return nil, err
+	}
+
+	recoverstatus := []bundle.RecoverStatus{}
+	for _, record := range records {
+		if record.State.State == state {
+			recoverstatus = append(recoverstatus, bundle.RecoverStatus{
+				InstanceID: uuid.Parse(record.ID),
+				State:      record.State,
+			})
+		}
+	}
+	return recoverstatus, nil
+}
+
+// GetSvcInstJobsByState - Lookup all jobs of a given state for a specific instance
+func (d *Dao) GetSvcInstJobsByState(
+	instanceID string, reqState bundle.State,
+) ([]bundle.JobState, error) {
+	log.Debug("Dao::GetSvcInstJobsByState")
+	res, err := d.get(stateKey(instanceID, ""), clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []bundle.JobState{}
+	for _, kv := range res.Kvs {
+		js := bundle.JobState{}
+		if err := schema.Unmarshal(types.KindJobState, string(kv.Value), &js); err != nil {
+			return nil, fmt.Errorf("An error occurred trying to parse job state of [ %s ]\n%s", kv.Key, err.Error())
+		}
+		if js.State == reqState {
+			jobs = append(jobs, js)
+		}
+	}
+	log.Debugf("Filtered on state: [ %v ], returning %d jobs", reqState, len(jobs))
+	return jobs, nil
+}
+
+// GetServiceInstance - Retrieve specific service instance from etcd.
+func (d *Dao) GetServiceInstance(id string) (*bundle.ServiceInstance, error) {
+	si := &bundle.ServiceInstance{}
+	if err := d.getObject(serviceInstanceKey(id), si); err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+// SetServiceInstance - Set service instance for an id in etcd.
+func (d *Dao) SetServiceInstance(id string, serviceInstance *bundle.ServiceInstance) error {
+	return d.setObject(serviceInstanceKey(id), serviceInstance)
+}
+
+// GetServiceInstanceVersion - Retrieve a service instance and the version it
+// was read at from etcd.
+func (d *Dao) GetServiceInstanceVersion(id string) (*bundle.ServiceInstance, string, error) {
+	si := &bundle.ServiceInstance{}
+	version, err := d.getObjectVersion(serviceInstanceKey(id), si)
+	if err != nil {
+		return nil, "", err
+	}
+	return si, version, nil
+}
+
+// CompareAndSetServiceInstance - Set service instance for an id in etcd if it
+// has not changed since version was read.
+func (d *Dao) CompareAndSetServiceInstance(id string, serviceInstance *bundle.ServiceInstance, version string) (string, error) {
+	return d.compareAndSetObject(serviceInstanceKey(id), serviceInstance, version)
+}
+
+// DeleteServiceInstance - Delete the service instance for an service instance id.
+func (d *Dao) DeleteServiceInstance(id string) error {
+	log.Debugf("Dao::DeleteServiceInstance -> [ %s ]", id)
+	return d.DeleteRaw(serviceInstanceKey(id))
+}
+
+// GetBindInstance - Retrieve a specific bind instance from etcd.
+func (d *Dao) GetBindInstance(id string) (*bundle.BindInstance, error) {
+	bi := &bundle.BindInstance{}
+	if err := d.getObject(bindInstanceKey(id), bi); err != nil {
+		return nil, err
+	}
+	return bi, nil
+}
+
+// SetBindInstance - Set the bind instance for id in etcd.
+func (d *Dao) SetBindInstance(id string, bindInstance *bundle.BindInstance) error {
+	return d.setObject(bindInstanceKey(id), bindInstance)
+}
+
+// GetBindInstanceVersion - Retrieve a bind instance and the version it was
+// read at from etcd.
+func (d *Dao) GetBindInstanceVersion(id string) (*bundle.BindInstance, string, error) {
+	bi := &bundle.BindInstance{}
+	version, err := d.getObjectVersion(bindInstanceKey(id), bi)
+	if err != nil {
+		return nil, "", err
+	}
+	return bi, version, nil
+}
+
+// CompareAndSetBindInstance - Set the bind instance for id in etcd if it has
+// not changed since version was read.
+func (d *Dao) CompareAndSetBindInstance(id string, bindInstance *bundle.BindInstance, version string) (string, error) {
+	return d.compareAndSetObject(bindInstanceKey(id), bindInstance, version)
+}
+
+// DeleteBindInstance - Delete the binding instance for an id in etcd.
+func (d *Dao) DeleteBindInstance(id string) error {
+	log.Debugf("Dao::DeleteBindInstance -> [ %s ]", id)
+	return d.DeleteRaw(bindInstanceKey(id))
+}
+
+// DeleteBinding - Delete the binding instance and remove the association
+// with the service instance in a single transaction. The binding is removed
+// from the stored instance rather than the callers copy, so bindings added
+// since it was read are kept.
+func (d *Dao) DeleteBinding(bindingInstance bundle.BindInstance, serviceInstance bundle.ServiceInstance) error {
+	return d.Apply(types.DeleteBindingBatch(bindingInstance.ID.String(), serviceInstance.ID.String()))
+}
+
+// SetState - Set the Job State in etcd for id. Finished job states are
+// attached to the job state lease when a TTL is configured.
+func (d *Dao) SetState(id string, state bundle.JobState) (string, error) {
+	key := stateKey(id, state.Token)
+	payload, err := encode(key, storedJobState{JobState: state, LastModified: time.Now()})
+	if err != nil {
+		return key, err
+	}
+	err = d.withJobStateLease(state, func(opts ...clientv3.OpOption) error {
+		_, err := d.put(key, payload, opts...)
+		return err
+	})
+	return key, err
+}
+
+// GetState - Retrieve a job state from etcd for an ID and Token.
+func (d *Dao) GetState(id string, token string) (bundle.JobState, error) {
+	return d.GetStateByKey(stateKey(id, token))
+}
+
+// GetStateByKey - Retrieve a job state from etcd for a job key
+func (d *Dao) GetStateByKey(key string) (bundle.JobState, error) {
+	state := bundle.JobState{}
+	if err := d.getObject(key, &state); err != nil {
+		return bundle.JobState{State: bundle.StateFailed}, err
+	}
+	return state, nil
+}
+
+// GetStateVersion - Retrieve a job state and the version it was read at from
+// etcd.
+func (d *Dao) GetStateVersion(id string, token string) (bundle.JobState, string, error) {
+	state := bundle.JobState{}
+	version, err := d.getObjectVersion(stateKey(id, token), &state)
+	if err != nil {
+		return bundle.JobState{State: bundle.StateFailed}, "", err
+	}
+	return state, version, nil
+}
+
+// CompareAndSetState - Set the Job State in etcd for id if it has not changed
+// since version was read.
+func (d *Dao) CompareAndSetState(id string, state bundle.JobState, version string) (string, error) {
+	key := stateKey(id, state.Token)
+	payload, err := encode(key, storedJobState{JobState: state, LastModified: time.Now()})
+	if err != nil {
+		return "", err
+	}
+	newVersion := ""
+	err = d.withJobStateLease(state, func(opts ...clientv3.OpOption) error {
+		var err error
+		newVersion, err = d.compareAndSetRaw(key, payload, version, opts...)
+		return err
+	})
+	return newVersion, err
+}
+
+// DeleteState - Delete the job state for an id and token from etcd.
+func (d *Dao) DeleteState(id string, token string) error {
+	log.Debugf("Dao::DeleteState -> [ %s ]", stateKey(id, token))
+	return d.DeleteRaw(stateKey(id, token))
+}
+
+// BatchGetJobStates - Retrieve every job state in etcd.
+func (d *Dao) BatchGetJobStates() ([]types.JobStateRecord, error) {
+	log.Debug("Dao::BatchGetJobStates")
+	res, err := d.get("/state/", clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	records := []types.JobStateRecord{}
+	for _, kv := range res.Kvs {
+		id, _, ok := parseStateKey(string(kv.Key))
+		if !ok {
+			continue
+		}
+		stored := storedJobState{}
+		if err := schema.Unmarshal(types.KindJobState, string(kv.Value), &stored); err != nil {
+			log.Warningf("Unable to parse job state [ %s ], skipping - %v", kv.Key, err)
+			continue
+		}
+		records = append(records, types.JobStateRecord{
+			ID:           id,
+			State:        stored.JobState,
+			LastModified: stored.LastModified,
+		})
+	}
+	log.Debugf("Successfully loaded [ %d ] job states from etcd", len(records))
+	return records, nil
+}
+
+// IsNotFoundError - Will determine if an error is a key is not found error.
+func (d *Dao) IsNotFoundError(err error) bool {
+	_, ok := err.(NotFoundError)
+	return ok
+}
+
+// IsConflictError - Will determine if an error is a compare and set conflict.
+func (d *Dao) IsConflictError(err error) bool {
+	_, ok := err.(ConflictError)
+	return ok
+}
+
+func removeFalseBindings(bindings map[string]bool) map[string]bool {
+	newBindings := map[string]bool{}
+	for k, v := range bindings {
+		if v {
+			newBindings[k] = v
+		}
+	}
+	return newBindings
+}
+
+func (d *Dao) get(key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return d.client.Get(ctx, key, opts...)
+}
+
+func (d *Dao) put(key string, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return d.client.Put(ctx, key, val, opts...)
+}
+
+// commit - runs the then operations in a transaction when every comparison
+// holds, the else ones otherwise.
+func (d *Dao) commit(cmps []clientv3.Cmp, then []clientv3.Op, els []clientv3.Op) (*clientv3.TxnResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return d.client.Txn(ctx).If(cmps...).Then(then...).Else(els...).Commit()
+}
+
+// commitBatch - runs the operations in as few transactions as etcd accepts.
+func (d *Dao) commitBatch(ops []clientv3.Op) error {
+	for _, batch := range batches(ops, maxTxnOps, maxTxnBytes) {
+		if _, err := d.commit(nil, batch, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dao) getObject(key string, data interface{}) error {
+	raw, err := d.GetRaw(key)
+	if err != nil {
+		return err
+	}
+	return decode(key, raw, data)
+}
+
+func (d *Dao) getObjectVersion(key string, data interface{}) (string, error) {
+	raw, version, err := d.GetRawVersion(key)
+	if err != nil {
+		return "", err
+	}
+	return version, decode(key, raw, data)
+}
+
+func (d *Dao) compareAndSetObject(key string, data interface{}, version string) (string, error) {
+	payload, err := encode(key, data)
+	if err != nil {
+		return "", err
+	}
+	return d.CompareAndSetRaw(key, payload, version)
+}
+
+func (d *Dao) setObject(key string, data interface{}) error {
+	payload, err := encode(key, data)
+	if err != nil {
+		return err
+	}
+	return d.SetRaw(key, payload)
+}
+
+// encode - the JSON of data to store at key, the records are written with
+// their schema version.
+func encode(key string, data interface{}) (string, error) {
+	if kind := recordKind(key); kind != "" {
+		return schema.Marshal(kind, data)
+	}
+	return bundle.DumpJSON(data)
+}
+
+// decode - decodes the JSON stored at key into data, upgrading the records
+// written with an older schema version.
+func decode(key string, raw string, data interface{}) error {
+	if kind := recordKind(key); kind != "" {
+		return schema.Unmarshal(kind, raw, data)
+	}
+	return bundle.LoadJSON(raw, data)
+}
+
+// revisionIs - the comparison of the mod revision of key with a version read
+// from it.
+func revisionIs(key string, version string) clientv3.Cmp {
+	rev, _ := strconv.ParseInt(version, 10, 64)
+	return clientv3.Compare(clientv3.ModRevision(key), "=", rev)
+}
+
+// batches - splits ops into batches of at most size operations and at most
+// maxBytes bytes of keys and values. An operation larger than maxBytes makes
+// a batch of its own.
+func batches(ops []clientv3.Op, size int, maxBytes int) [][]clientv3.Op {
+	split := [][]clientv3.Op{}
+	start, bytes := 0, 0
+	for i, op := range ops {
+		opBytes := len(op.KeyBytes()) + len(op.ValueBytes())
+		if i > start && (i-start == size || bytes+opBytes > maxBytes) {
+			split = append(split, ops[start:i])
+			start, bytes = i, 0
+		}
+		bytes += opBytes
+	}
+	if start < len(ops) {
+		split = append(split, ops[start:])
+	}
+	return split
+}
+
+// pageRange - the range of keys under dir after the key named continueToken.
+func pageRange(dir string, continueToken string) (string, string) {
+	prefix := dirPrefix(dir)
+	start := prefix
+	if continueToken != "" {
+		// the smallest key after the token.
+		start = prefix + continueToken + "\x00"
+	}
+	return start, clientv3.GetPrefixRangeEnd(prefix)
+}
+
+////////////////////////////////////////////////////////////
+// Key generators
+////////////////////////////////////////////////////////////
+
+func dirPrefix(dir string) string {
+	return strings.TrimSuffix(dir, "/") + "/"
+}
+
+func stateKey(id string, jobid string) string {
+	return fmt.Sprintf("/state/%s/job/%s", id, jobid)
+}
+
+// parseStateKey - the id and token of a job state key, false for any other
+// key.
+func parseStateKey(key string) (string, string, bool) {
+	parts := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	if len(parts) != 4 || parts[0] != "state" || parts[2] != "job" {
+		return "", "", false
+	}
+	return parts[1], parts[3], true
+}
+
+// recordKind - the kind of the record stored at key, empty for the keys that
+// are not versioned records.
+func recordKind(key string) types.Kind {
+	switch {
+	case strings.HasPrefix(key, "/spec/"):
+		return types.KindSpec
+	case strings.HasPrefix(key, "/service_instance/"):
+		return types.KindServiceInstance
+	case strings.HasPrefix(key, "/bind_instance/"):
+		return types.KindBindInstance
+	case strings.HasPrefix(key, "/state/"):
+		return types.KindJobState
+	}
+	return ""
+}
+
+func specKey(id string) string {
+	return fmt.Sprintf("/spec/%s", id)
+}
+
+func serviceInstanceKey(id string) string {
+	return fmt.Sprintf("/service_instance/%s", id)
+}
+
+func bindInstanceKey(id string) string {
+	return fmt.Sprintf("/bind_instance/%s", id)
+}
+
+func intentKey(id string) string {
+	return fmt.Sprintf("/intent/%s", id)
+}
+
+func jobRecordKey(token string) string {
+	return fmt.Sprintf("/job/%s", token)
+}
+
+func deadLetterKey(id string) string {
+	return fmt.Sprintf("/dead_letter/%s", id)
+}
+
+func auditRecordKey(id string) string {
+	return fmt.Sprintf("/audit/%s", id)
+}
+
+func leaseKey(name string) string {
+	return fmt.Sprintf("/lease/%s", name)
+}
+
+// decodeSpecs - the specs of the payloads. The payloads that cannot be
+// decoded, or upgraded to the current schema, are left out and their errors
+// returned together, so a broken spec never comes back as an empty one.
+func decodeSpecs(payloads []string) ([]*bundle.Spec, error) {
+	specs := make([]*bundle.Spec, 0, len(payloads))
+	errs := arrayErrors{}
+	for _, payload := range payloads {
+		spec := &bundle.Spec{}
+		if err := schema.Unmarshal(types.KindSpec, payload, spec); err != nil {
+			log.Errorf("Unable to decode a spec - %v", err)
+			errs = append(errs, err)
+			continue
+		}
+		specs = append(specs, spec)
+	}
+	if len(errs) > 0 {
+		return specs, errs
+	}
+	return specs, nil
+}
@@ -0,0 +1,97 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+func TestPageRange(t *testing.T) {
+	start, end := pageRange("/spec", "")
+	ft.AssertEqual(t, "/spec/", start)
+	ft.AssertEqual(t, "/spec0", end)
+
+	start, end = pageRange("/spec", "abc")
+	ft.AssertEqual(t, "/spec/abc\x00", start)
+	ft.AssertEqual(t, "/spec0", end)
+	// ids sharing the token as a prefix are on the next page.
+	ft.AssertTrue(t, "/spec/abcd" > start)
+}
+
+func TestBatches(t *testing.T) {
+	ops := make([]clientv3.Op, 5)
+	for i := range ops {
+		ops[i] = clientv3.OpDelete(specKey(strconv.Itoa(i)))
+	}
+	split := batches(ops, 2, maxTxnBytes)
+	ft.AssertEqual(t, 3, len(split))
+	ft.AssertEqual(t, 2, len(split[0]))
+	ft.AssertEqual(t, 1, len(split[2]))
+	ft.AssertEqual(t, 0, len(batches(nil, 2, maxTxnBytes)))
+	ft.AssertEqual(t, 1, len(batches(ops, 5, maxTxnBytes)))
+
+	// large specs are split by their size as well.
+	value := strings.Repeat("x", 400*1024)
+	puts := make([]clientv3.Op, 5)
+	for i := range puts {
+		puts[i] = clientv3.OpPut(specKey(strconv.Itoa(i)), value)
+	}
+	split = batches(puts, maxTxnOps, maxTxnBytes)
+	ft.AssertEqual(t, 3, len(split))
+	ft.AssertEqual(t, 2, len(split[0]))
+	ft.AssertEqual(t, 1, len(split[2]))
+	// an operation over the size makes a batch of its own.
+	ft.AssertEqual(t, 5, len(batches(puts, maxTxnOps, 1024)))
+}
+
+func TestDecodeSpecs(t *testing.T) {
+	payloads := []string{`{"id": "a", "name": "a-apb"}`, `{"id": `}
+	specs, err := decodeSpecs(payloads)
+	ft.AssertNotNil(t, err)
+	ft.AssertEqual(t, 1, len(specs))
+	ft.AssertEqual(t, "a", specs[0].ID)
+}
+
+func TestParseStateKey(t *testing.T) {
+	id, token, ok := parseStateKey(stateKey("abc", "tok"))
+	ft.AssertTrue(t, ok)
+	ft.AssertEqual(t, "abc", id)
+	ft.AssertEqual(t, "tok", token)
+
+	_, _, ok = parseStateKey("/state/abc")
+	ft.AssertFalse(t, ok)
+	_, _, ok = parseStateKey("/spec/abc")
+	ft.AssertFalse(t, ok)
+	ft.AssertEqual(t, types.KindJobState, recordKind(stateKey("abc", "tok")))
+	ft.AssertEqual(t, types.Kind(""), recordKind("/index/version"))
+}
+
+func TestLeaseTTL(t *testing.T) {
+	ft.AssertEqual(t, time.Second, leaseWindow(5*time.Second))
+	ft.AssertEqual(t, 72*time.Hour, leaseWindow(720*time.Hour))
+	// the last job state attached is still kept for the whole ttl.
+	ft.AssertEqual(t, int64(6), leaseTTL(5*time.Second))
+	ft.AssertEqual(t, int64(2), leaseTTL(time.Millisecond))
+	ft.AssertEqual(t, int64((720+72)*3600), leaseTTL(720*time.Hour))
+}
@@ -0,0 +1,57 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// SetDeadLetter - Store the dead letter in etcd.
+func (d *Dao) SetDeadLetter(letter types.DeadLetter) error {
+	payload, err := bundle.DumpJSON(letter)
+	if err != nil {
+		return err
+	}
+	return d.SetRaw(deadLetterKey(letter.ID), payload)
+}
+
+// DeleteDeadLetter - Delete the dead letter from etcd.
+func (d *Dao) DeleteDeadLetter(id string) error {
+	err := d.DeleteRaw(deadLetterKey(id))
+	if d.IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
+
+// BatchGetDeadLetters - Retrieve the dead letters stored in etcd, oldest
+// first.
+func (d *Dao) BatchGetDeadLetters() ([]types.DeadLetter, error) {
+	payloads, err := d.BatchGetRaw("/dead_letter")
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]types.DeadLetter, len(*payloads))
+	for i, payload := range *payloads {
+		if err := bundle.LoadJSON(payload, &letters[i]); err != nil {
+			return nil, err
+		}
+	}
+	types.SortDeadLetters(letters)
+	return letters, nil
+}
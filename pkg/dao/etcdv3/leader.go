@@ -0,0 +1,38 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// GetLease - Retrieve the lease and the version it was read at from
+// etcd.
+func (d *Dao) GetLease(name string) (types.Lease, string, error) {
+	lease := types.Lease{}
+	version, err := d.getObjectVersion(leaseKey(name), &lease)
+	if err != nil {
+		return types.Lease{}, "", err
+	}
+	return lease, version, nil
+}
+
+// CompareAndSetLease - Set the lease in etcd if it has not changed since
+// version was read.
+func (d *Dao) CompareAndSetLease(lease types.Lease, version string) (string, error) {
+	return d.compareAndSetObject(leaseKey(lease.Name), lease, version)
+}
@@ -0,0 +1,101 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"context"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobStateLease - a lease finished job states are attached to. A lease is
+// shared by the job states finished within its reuse window, so they expire
+// between the TTL and the TTL plus the window after they finished instead of
+// every job state needing a lease of its own.
+type jobStateLease struct {
+	id      clientv3.LeaseID
+	granted time.Time
+}
+
+// leaseWindow - how long a job state lease is reused for a TTL.
+func leaseWindow(ttl time.Duration) time.Duration {
+	window := ttl / 10
+	if window < time.Second {
+		window = time.Second
+	}
+	return window
+}
+
+// leaseTTL - the TTL in seconds a job state lease is granted with, long enough
+// for the last job state attached to it to be kept for ttl.
+func leaseTTL(ttl time.Duration) int64 {
+	seconds := int64((ttl + leaseWindow(ttl) + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// withJobStateLease - calls write with the options attaching the job state to
+// a lease when it is finished and a TTL is configured. Job states still in
+// progress are never attached, the broker needs them to recover the jobs. A
+// lease that is gone is replaced once.
+func (d *Dao) withJobStateLease(state bundle.JobState, write func(...clientv3.OpOption) error) error {
+	if d.jobStateTTL <= 0 || state.State == bundle.StateInProgress {
+		return write()
+	}
+	for attempt := 0; ; attempt++ {
+		id, err := d.jobStateLeaseID()
+		if err != nil {
+			return err
+		}
+		err = write(clientv3.WithLease(id))
+		if err != rpctypes.ErrLeaseNotFound || attempt > 0 {
+			return err
+		}
+		log.Debugf("Job state lease %x expired, granting a new one", id)
+		d.leaseLock.Lock()
+		if d.lease != nil && d.lease.id == id {
+			d.lease = nil
+		}
+		d.leaseLock.Unlock()
+	}
+}
+
+// jobStateLeaseID - the current job state lease, granting a new one once its
+// reuse window is over.
+func (d *Dao) jobStateLeaseID() (clientv3.LeaseID, error) {
+	d.leaseLock.Lock()
+	defer d.leaseLock.Unlock()
+	if d.lease != nil && time.Since(d.lease.granted) < leaseWindow(d.jobStateTTL) {
+		return d.lease.id, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	res, err := d.client.Grant(ctx, leaseTTL(d.jobStateTTL))
+	if err != nil {
+		log.Errorf("Unable to grant a job state lease - %v", err)
+		return 0, err
+	}
+	d.lease = &jobStateLease{id: res.ID, granted: time.Now()}
+	return res.ID, nil
+}
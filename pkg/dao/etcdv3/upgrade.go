@@ -0,0 +1,71 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"strconv"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/openshift/ansible-service-broker/pkg/dao/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+// UpgradeRecords - Rewrites the records stored with an older schema version,
+// or none, in the current version, keeping the lease of the job states. A
+// record written or deleted since it was read is left alone. With dryRun the
+// records are only counted. Returns how many records were, or would be,
+// upgraded.
+func (d *Dao) UpgradeRecords(dryRun bool) (int, error) {
+	upgraded := 0
+	for _, dir := range []string{"/spec", "/service_instance", "/bind_instance", "/state"} {
+		res, err := d.get(dirPrefix(dir), clientv3.WithPrefix(),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+		if err != nil {
+			return upgraded, err
+		}
+		for _, kv := range res.Kvs {
+			key := string(kv.Key)
+			kind := recordKind(key)
+			stale, err := schema.NeedsRewrite(kind, string(kv.Value))
+			if err != nil {
+				log.Warningf("Unable to parse record [ %s ], skipping - %v", key, err)
+				continue
+			}
+			if !stale {
+				continue
+			}
+			if dryRun {
+				upgraded++
+				continue
+			}
+			raw, err := schema.Rewrite(kind, string(kv.Value))
+			if err != nil {
+				return upgraded, err
+			}
+			_, err = d.compareAndSetRaw(key, raw, strconv.FormatInt(kv.ModRevision, 10), clientv3.WithIgnoreLease())
+			if d.IsConflictError(err) || d.IsNotFoundError(err) {
+				log.Debugf("Record [ %s ] was written while upgrading it, skipping", key)
+				continue
+			} else if err != nil {
+				return upgraded, err
+			}
+			log.Debugf("Upgraded record [ %s ]", key)
+			upgraded++
+		}
+	}
+	return upgraded, nil
+}
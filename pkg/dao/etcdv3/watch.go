@@ -0,0 +1,157 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/openshift/ansible-service-broker/pkg/dao/schema"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchRetryDelay - how long to wait before watching again after the watch
+// failed.
+var watchRetryDelay = time.Second
+
+// Watch - Report the changes made after the watch started using an etcd
+// watch on the whole key space. Deleted records carry their last value.
+func (d *Dao) Watch(stop <-chan struct{}) (<-chan types.Event, error) {
+	res, err := d.get("/", clientv3.WithCountOnly())
+	if err != nil {
+		log.Errorf("Unable to get the etcd revision to watch from - %v", err)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	events := make(chan types.Event)
+	go func() {
+		defer close(events)
+		next := res.Header.Revision + 1
+		for {
+			next = d.watch(ctx, next, events)
+			select {
+			case <-time.After(watchRetryDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// watch - sends the events from revision next on until the watch fails,
+// returning the revision to watch from again.
+func (d *Dao) watch(ctx context.Context, next int64, events chan<- types.Event) int64 {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := d.client.Watch(clientv3.WithRequireLeader(ctx), "/",
+		clientv3.WithPrefix(), clientv3.WithPrevKV(), clientv3.WithRev(next))
+	for resp := range w {
+		if resp.CompactRevision != 0 {
+			// the revisions in between were compacted away.
+			log.Warningf("Missed etcd events after revision %d, watching from %d", next-1, resp.CompactRevision)
+			return resp.CompactRevision
+		}
+		if err := resp.Err(); err != nil {
+			log.Errorf("Unable to watch etcd - %v", err)
+			return next
+		}
+		for _, ev := range resp.Events {
+			next = ev.Kv.ModRevision + 1
+			e, ok := eventFromWatch(ev)
+			if !ok {
+				continue
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return next
+			}
+		}
+	}
+	return next
+}
+
+// eventFromWatch - converts an etcd watch event into an event, false when the
+// key is not a record that is watched.
+func eventFromWatch(ev *clientv3.Event) (types.Event, bool) {
+	e := types.Event{}
+	kv := ev.Kv
+	switch {
+	case ev.Type == mvccpb.DELETE:
+		e.Type = types.Deleted
+		kv = ev.PrevKv
+	case ev.IsCreate():
+		e.Type = types.Added
+	default:
+		e.Type = types.Updated
+	}
+
+	key := string(ev.Kv.Key)
+	parts := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "spec":
+		e.Kind, e.ID = types.KindSpec, parts[1]
+	case len(parts) == 2 && parts[0] == "service_instance":
+		e.Kind, e.ID = types.KindServiceInstance, parts[1]
+	case len(parts) == 2 && parts[0] == "bind_instance":
+		e.Kind, e.ID = types.KindBindInstance, parts[1]
+	case len(parts) == 4 && parts[0] == "state" && parts[2] == "job":
+		e.Kind, e.ID = types.KindJobState, parts[1]
+	default:
+		return types.Event{}, false
+	}
+
+	if kv == nil || len(kv.Value) == 0 {
+		if e.Kind == types.KindJobState {
+			e.JobState = &bundle.JobState{Token: parts[3]}
+		}
+		return e, true
+	}
+	var err error
+	value := string(kv.Value)
+	switch e.Kind {
+	case types.KindSpec:
+		e.Spec = &bundle.Spec{}
+		err = schema.Unmarshal(e.Kind, value, e.Spec)
+	case types.KindServiceInstance:
+		e.ServiceInstance = &bundle.ServiceInstance{}
+		err = schema.Unmarshal(e.Kind, value, e.ServiceInstance)
+	case types.KindBindInstance:
+		e.BindInstance = &bundle.BindInstance{}
+		err = schema.Unmarshal(e.Kind, value, e.BindInstance)
+	case types.KindJobState:
+		state := storedJobState{}
+		err = schema.Unmarshal(e.Kind, value, &state)
+		e.JobState = &state.JobState
+	}
+	if err != nil {
+		log.Warningf("Unable to decode the watched record [ %s ] - %v", key, err)
+	}
+	return e, true
+}
@@ -0,0 +1,70 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"testing"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+func TestEventFromWatch(t *testing.T) {
+	e, ok := eventFromWatch(&clientv3.Event{
+		Type: mvccpb.PUT,
+		Kv:   &mvccpb.KeyValue{Key: []byte("/spec/abc"), Value: []byte(`{"id":"abc","name":"mediawiki"}`), CreateRevision: 5, ModRevision: 5},
+	})
+	ft.AssertTrue(t, ok)
+	ft.AssertEqual(t, types.Added, e.Type)
+	ft.AssertEqual(t, types.KindSpec, e.Kind)
+	ft.AssertEqual(t, "mediawiki", e.Spec.FQName)
+
+	e, ok = eventFromWatch(&clientv3.Event{
+		Type: mvccpb.PUT,
+		Kv: &mvccpb.KeyValue{Key: []byte("/state/abc/job/tok"), CreateRevision: 5, ModRevision: 7,
+			Value: []byte(`{"token":"tok","state":"succeeded","last_modified":"2018-06-01T00:00:00Z"}`)},
+	})
+	ft.AssertTrue(t, ok)
+	ft.AssertEqual(t, types.Updated, e.Type)
+	ft.AssertEqual(t, types.KindJobState, e.Kind)
+	ft.AssertEqual(t, "abc", e.ID)
+	ft.AssertEqual(t, "tok", e.JobState.Token)
+
+	e, ok = eventFromWatch(&clientv3.Event{
+		Type:   mvccpb.DELETE,
+		Kv:     &mvccpb.KeyValue{Key: []byte("/bind_instance/def"), ModRevision: 9},
+		PrevKv: &mvccpb.KeyValue{Key: []byte("/bind_instance/def"), Value: []byte(`{"id":"4e7f7b4c-5a3e-4d4e-9f22-1b0a2c3d4e5f"}`)},
+	})
+	ft.AssertTrue(t, ok)
+	ft.AssertEqual(t, types.Deleted, e.Type)
+	ft.AssertEqual(t, "def", e.ID)
+	ft.AssertNotNil(t, e.BindInstance)
+
+	// a job state that expired with its lease, without the previous value.
+	e, ok = eventFromWatch(&clientv3.Event{
+		Type: mvccpb.DELETE,
+		Kv:   &mvccpb.KeyValue{Key: []byte("/state/abc/job/tok"), ModRevision: 10},
+	})
+	ft.AssertTrue(t, ok)
+	ft.AssertEqual(t, types.Deleted, e.Type)
+	ft.AssertEqual(t, "tok", e.JobState.Token)
+
+	_, ok = eventFromWatch(&clientv3.Event{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{Key: []byte("/extracted_credentials/abc")}})
+	ft.AssertFalse(t, ok)
+}
@@ -0,0 +1,57 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// SetAuditRecord - Store the audit record in the file.
+func (d *Dao) SetAuditRecord(record types.AuditRecord) error {
+	payload, err := bundle.DumpJSON(record)
+	if err != nil {
+		return err
+	}
+	return d.SetRaw(auditRecordKey(record.ID), payload)
+}
+
+// DeleteAuditRecord - Remove the audit record from the file.
+func (d *Dao) DeleteAuditRecord(id string) error {
+	err := d.DeleteRaw(auditRecordKey(id))
+	if d.IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
+
+// BatchGetAuditRecords - Retrieve the audit records stored in the file, oldest
+// first.
+func (d *Dao) BatchGetAuditRecords() ([]types.AuditRecord, error) {
+	d.rlock()
+	defer d.lock.RUnlock()
+	records := []types.AuditRecord{}
+	for _, key := range d.childKeys("/audit") {
+		record := types.AuditRecord{}
+		if err := bundle.LoadJSON(d.store[key], &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	types.SortAuditRecords(records)
+	return records, nil
+}
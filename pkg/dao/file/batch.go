@@ -0,0 +1,146 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"fmt"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// Apply - Make the writes of the batch under the write lock and flush them
+// to the file at once. The previous values are put back when a write or the
+// flush fails, so the file and the in memory view are left untouched.
+func (d *Dao) Apply(batch types.Batch) error {
+	unlock, err := d.writeLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// previous holds the value of each key written before the batch, nil
+	// when the key did not exist.
+	previous := map[string]*string{}
+	set := func(key string, val *string) {
+		if _, ok := previous[key]; !ok {
+			if prev, existed := d.store[key]; existed {
+				previous[key] = &prev
+			} else {
+				previous[key] = nil
+			}
+		}
+		if val == nil {
+			delete(d.store, key)
+		} else {
+			d.store[key] = *val
+		}
+	}
+	undo := func() {
+		d.restoreRaws(previous)
+	}
+
+	for _, op := range batch.Ops {
+		if err := d.applyOp(op, set); err != nil {
+			undo()
+			return err
+		}
+	}
+	for _, intent := range batch.Intents {
+		payload, err := bundle.DumpJSON(intent)
+		if err != nil {
+			undo()
+			return err
+		}
+		set(intentKey(intent.ID), &payload)
+	}
+	if err := d.flush(); err != nil {
+		undo()
+		return err
+	}
+	return nil
+}
+
+// applyOp - makes a single write of a batch through set. The caller must hold
+// the write lock.
+func (d *Dao) applyOp(op types.Op, set func(string, *string)) error {
+	var key string
+	var data interface{}
+	switch op.Type {
+	case types.OpSetServiceInstance:
+		si := *op.ServiceInstance
+		si.BindingIDs = removeFalseBindings(op.ServiceInstance.BindingIDs)
+		key, data = serviceInstanceKey(op.ID), &si
+	case types.OpSetBindInstance:
+		key, data = bindInstanceKey(op.ID), op.BindInstance
+	case types.OpDeleteServiceInstance, types.OpDeleteBindInstance:
+		key = serviceInstanceKey(op.ID)
+		if op.Type == types.OpDeleteBindInstance {
+			key = bindInstanceKey(op.ID)
+		}
+		if _, ok := d.store[key]; !ok {
+			return NotFoundError{Key: key}
+		}
+		set(key, nil)
+		return nil
+	case types.OpRemoveBinding:
+		key = serviceInstanceKey(op.ID)
+		raw, ok := d.store[key]
+		if !ok {
+			return nil
+		}
+		si := &bundle.ServiceInstance{}
+		if err := decode(key, raw, si); err != nil {
+			return err
+		}
+		delete(si.BindingIDs, op.BindingID)
+		si.BindingIDs = removeFalseBindings(si.BindingIDs)
+		data = si
+	default:
+		return fmt.Errorf("unknown batch op type: %s", op.Type)
+	}
+	payload, err := encode(key, data)
+	if err != nil {
+		return err
+	}
+	set(key, &payload)
+	return nil
+}
+
+// PendingIntents - Retrieve the pending intents stored in the file, ordered
+// by id.
+func (d *Dao) PendingIntents() ([]types.Intent, error) {
+	d.rlock()
+	defer d.lock.RUnlock()
+	intents := []types.Intent{}
+	for _, key := range d.childKeys("/intent") {
+		intent := types.Intent{}
+		if err := bundle.LoadJSON(d.store[key], &intent); err != nil {
+			return nil, err
+		}
+		intents = append(intents, intent)
+	}
+	return intents, nil
+}
+
+// CompleteIntent - Remove the intent from the file.
+func (d *Dao) CompleteIntent(id string) error {
+	err := d.DeleteRaw(intentKey(id))
+	if d.IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,843 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/schema"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/openshift/ansible-service-broker/pkg/dao/watch"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// NotFoundError - Error returned when a key does not exist in the file.
+type NotFoundError struct {
+	Key string
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("key not found: %s", e.Key)
+}
+
+// ConflictError - Error returned when a compare and set finds that the key
+// has been written since the expected version was read.
+type ConflictError struct {
+	Key string
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("key was modified concurrently: %s", e.Key)
+}
+
+// storedJobState - the job state as it is written to the file, along with
+// when it was written so old job states can be pruned. Readers that only
+// know about bundle.JobState ignore the extra field.
+type storedJobState struct {
+	bundle.JobState
+	LastModified time.Time `json:"last_modified"`
+}
+
+// Dao - object to interface with the data store. All of the records are
+// kept in memory and flushed to a single file on every write, which makes it
+// suitable for small installations that can mount a volume but do not want
+// to run etcd. The writes lock the file, so the commands run against the file
+// of a running broker do not overwrite its records, and the file is read
+// again whenever another process has replaced it.
+type Dao struct {
+	path  string
+	lock  sync.RWMutex
+	store map[string]string
+	// loaded is the file the store was last read from or written to.
+	loaded os.FileInfo
+}
+
+// NewDao - Create a new Dao object backed by the file at path. The file is
+// created on the first write if it does not exist yet.
+func NewDao(path string) (*Dao, error) {
+	if path == "" {
+		return nil, fmt.Errorf("a file path is required for the file dao")
+	}
+	dao := Dao{path: path, store: map[string]string{}}
+	if err := dao.reload(); err != nil {
+		return nil, err
+	}
+	log.Infof("Loaded [ %d ] keys from dao file [ %s ]", len(dao.store), path)
+	return &dao, nil
+}
+
+// SetRaw - Allows the setting of the value json string to the key in the file.
+func (d *Dao) SetRaw(key string, val string) error {
+	return d.writeRaws(map[string]*string{key: &val})
+}
+
+// writeRaws - sets the values of the keys, deleting the keys with a nil value,
+// and flushes them to the file at once. Deleting a key that does not exist is
+// a NotFoundError and leaves the file untouched, as does a failed flush.
+func (d *Dao) writeRaws(vals map[string]*string) error {
+	unlock, err := d.writeLock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	previous := map[string]*string{}
+	for key, val := range vals {
+		prev, existed := d.store[key]
+		if val == nil && !existed {
+			d.restoreRaws(previous)
+			return NotFoundError{Key: key}
+		}
+		if existed {
+			previous[key] = &prev
+		} else {
+			previous[key] = nil
+		}
+		if val == nil {
+			delete(d.store, key)
+		} else {
+			d.store[key] = *val
+		}
+	}
+	if err := d.flush(); err != nil {
+		// keep the in memory view consistent with what is on disk.
+		d.restoreRaws(previous)
+		return err
+	}
+	return nil
+}
+
+// restoreRaws - puts back the previous values of the keys, deleting the ones
+// that did not exist. The caller must hold the write lock.
+func (d *Dao) restoreRaws(previous map[string]*string) {
+	for key, prev := range previous {
+		if prev == nil {
+			delete(d.store, key)
+		} else {
+			d.store[key] = *prev
+		}
+	}
+}
+
+// GetRaw - gets a specific json string for a key from the file.
+func (d *Dao) GetRaw(key string) (string, error) {
+	d.rlock()
+	defer d.lock.RUnlock()
+	val, ok := d.store[key]
+	if !ok {
+		return "", NotFoundError{Key: key}
+	}
+	return val, nil
+}
+
+// GetRawVersion - gets a specific json string for a key from the file along
+// with the version of the value.
+func (d *Dao) GetRawVersion(key string) (string, string, error) {
+	d.rlock()
+	defer d.lock.RUnlock()
+	val, ok := d.store[key]
+	if !ok {
+		return "", "", NotFoundError{Key: key}
+	}
+	return val, valueVersion(val), nil
+}
+
+// CompareAndSetRaw - sets the value for key only if the stored value is still
+// at version. An empty version only sets the key if it does not exist. The
+// new version is returned.
+func (d *Dao) CompareAndSetRaw(key string, val string, ver string) (string, error) {
+	unlock, err := d.writeLock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	prev, existed := d.store[key]
+	switch {
+	case ver == "" && existed:
+		return "", ConflictError{Key: key}
+	case ver != "" && !existed:
+		return "", NotFoundError{Key: key}
+	case ver != "" && valueVersion(prev) != ver:
+		return "", ConflictError{Key: key}
+	}
+	d.store[key] = val
+	if err := d.flush(); err != nil {
+		if existed {
+			d.store[key] = prev
+		} else {
+			delete(d.store, key)
+		}
+		return "", err
+	}
+	return valueVersion(val), nil
+}
+
+// DeleteRaw - removes a key from the file.
+func (d *Dao) DeleteRaw(key string) error {
+	return d.writeRaws(map[string]*string{key: nil})
+}
+
+// BatchGetRaw - Get the direct children of dir as individual json strings,
+// ordered by key.
+func (d *Dao) BatchGetRaw(dir string) (*[]string, error) {
+	d.rlock()
+	defer d.lock.RUnlock()
+
+	keys := d.childKeys(dir)
+	if len(keys) == 0 {
+		return nil, NotFoundError{Key: dir}
+	}
+	payloads := make([]string, len(keys))
+	for i, k := range keys {
+		payloads[i] = d.store[k]
+	}
+	log.Debugf("Successfully loaded [ %d ] objects from dao file dir [ %s ]", len(payloads), dir)
+	return &payloads, nil
+}
+
+// GetSpec - Retrieve the spec from the file.
+func (d *Dao) GetSpec(id string) (*bundle.Spec, error) {
+	spec := &bundle.Spec{}
+	if err := d.getObject(specKey(id), spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// SetSpec - set spec for an id in the file.
+func (d *Dao) SetSpec(id string, spec *bundle.Spec) error {
+	return d.setObject(specKey(id), spec)
+}
+
+// DeleteSpec - Delete the spec for a given spec id.
+func (d *Dao) DeleteSpec(specID string) error {
+	log.Debugf("Dao::DeleteSpec-> [ %s ]", specID)
+	return d.DeleteRaw(specKey(specID))
+}
+
+// BatchSetSpecs - set specs based on SpecManifest in the file, which is
+// written once.
+func (d *Dao) BatchSetSpecs(specs bundle.SpecManifest) error {
+	vals := map[string]*string{}
+	for id, spec := range specs {
+		payload, err := encode(specKey(id), spec)
+		if err != nil {
+			return err
+		}
+		vals[specKey(id)] = &payload
+	}
+	return d.writeRaws(vals)
+}
+
+// BatchGetSpecs - Retrieve all the specs for dir.
+func (d *Dao) BatchGetSpecs(dir string) ([]*bundle.Spec, error) {
+	payloads, err := d.BatchGetRaw(dir)
+	if d.IsNotFoundError(err) {
+		return []*bundle.Spec{}, nil
+	} else if err != nil {
+		return []*bundle.Spec{}, err
+	}
+
+	specs := make([]*bundle.Spec, len(*payloads))
+	for i, payload := range *payloads {
+		spec := &bundle.Spec{}
+		if err := schema.Unmarshal(types.KindSpec, payload, spec); err != nil {
+			return nil, err
+		}
+		specs[i] = spec
+	}
+	return specs, nil
+}
+
+// BatchGetBundleInstances - get list of bundleinstances
+func (d *Dao) BatchGetBundleInstances() ([]*bundle.ServiceInstance, error) {
+	bundleInstances := []*bundle.ServiceInstance{}
+	payloads, err := d.BatchGetRaw("/service_instance")
+	if d.IsNotFoundError(err) {
+		return bundleInstances, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, payload := range *payloads {
+		si := &bundle.ServiceInstance{}
+		if err := schema.Unmarshal(types.KindServiceInstance, payload, si); err != nil {
+			log.Errorf("Unable to convert the service instances json unmarshal error - %v", err)
+			return nil, err
+		}
+		bundleInstances = append(bundleInstances, si)
+	}
+	return bundleInstances, nil
+}
+
+// BatchGetBindInstances - Retrieve all the bind instances.
+func (d *Dao) BatchGetBindInstances() ([]*bundle.BindInstance, error) {
+	bindings := []*bundle.BindInstance{}
+	payloads, err := d.BatchGetRaw("/bind_instance")
+	if d.IsNotFoundError(err) {
+		return bindings, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, payload := range *payloads {
+		bi := &bundle.BindInstance{}
+		if err := schema.Unmarshal(types.KindBindInstance, payload, bi); err != nil {
+			log.Errorf("Unable to convert the bind instances json unmarshal error - %v", err)
+			return nil, err
+		}
+		bindings = append(bindings, bi)
+	}
+	return bindings, nil
+}
+
+// FindServiceInstances - Retrieve the service instances selected by the
+// filter, ordered by id.
+func (d *Dao) FindServiceInstances(filter types.InstanceFilter) ([]*bundle.ServiceInstance, error) {
+	instances, err := d.BatchGetBundleInstances()
+	if err != nil {
+		return nil, err
+	}
+	states, err := d.latestStates(filter.State)
+	if err != nil {
+		return nil, err
+	}
+	found := []*bundle.ServiceInstance{}
+	for _, si := range instances {
+		if filter.Matches(si, states[si.ID.String()]) {
+			found = append(found, si)
+		}
+	}
+	return found, nil
+}
+
+// FindBindInstances - Retrieve the bind instances selected by the filter,
+// ordered by id.
+func (d *Dao) FindBindInstances(filter types.BindingFilter) ([]*bundle.BindInstance, error) {
+	bindings, err := d.BatchGetBindInstances()
+	if err != nil {
+		return nil, err
+	}
+	states, err := d.latestStates(filter.State)
+	if err != nil {
+		return nil, err
+	}
+	found := []*bundle.BindInstance{}
+	for _, bi := range bindings {
+		if filter.Matches(bi, states[bi.ID.String()]) {
+			found = append(found, bi)
+		}
+	}
+	return found, nil
+}
+
+// latestStates - the state of the last job of every id, only read when the
+// filter selects on it.
+func (d *Dao) latestStates(state bundle.State) (map[string]bundle.State, error) {
+	if state == "" {
+		return nil, nil
+	}
+	records, err := d.BatchGetJobStates()
+	if err != nil {
+		return nil, err
+	}
+	return types.LatestStates(records), nil
+}
+
+// Watch - Report the changes made after the watch started. The file dao is
+// polled for them since it cannot report its own changes.
+func (d *Dao) Watch(stop <-chan struct{}) (<-chan types.Event, error) {
+	return watch.Poll(d, watch.DefaultPollInterval, stop), nil
+}
+
+// GetSpecsPage - Retrieve up to limit specs ordered by id, starting after
+// the continue token.
+func (d *Dao) GetSpecsPage(continueToken string, limit int) ([]*bundle.Spec, string, error) {
+	payloads, next := d.rawPage("/spec", continueToken, limit)
+	specs := make([]*bundle.Spec, len(payloads))
+	for i, payload := range payloads {
+		spec := &bundle.Spec{}
+		if err := schema.Unmarshal(types.KindSpec, payload, spec); err != nil {
+			return nil, "", err
+		}
+		specs[i] = spec
+	}
+	return specs, next, nil
+}
+
+// GetServiceInstancesPage - Retrieve up to limit service instances ordered by
+// id, starting after the continue token.
+func (d *Dao) GetServiceInstancesPage(continueToken string, limit int) ([]*bundle.ServiceInstance, string, error) {
+	payloads, next := d.rawPage("/service_instance", continueToken, limit)
+	instances := make([]*bundle.ServiceInstance, len(payloads))
+	for i, payload := range payloads {
+		si := &bundle.ServiceInstance{}
+		if err := schema.Unmarshal(types.KindServiceInstance, payload, si); err != nil {
+			return nil, "", err
+		}
+		instances[i] = si
+	}
+	return instances, next, nil
+}
+
+// rawPage - returns up to limit direct children of dir ordered by key,
+// starting after the child named continueToken, and the token for the next
+// page which is empty after the last child.
+func (d *Dao) rawPage(dir string, continueToken string, limit int) ([]string, string) {
+	d.rlock()
+	defer d.lock.RUnlock()
+	after := fmt.Sprintf("%s/%s", dir, continueToken)
+	payloads := []string{}
+	lastKey := ""
+	for _, k := range d.childKeys(dir) {
+		if continueToken != "" && k <= after {
+			continue
+		}
+		if limit > 0 && len(payloads) == limit {
+			return payloads, strings.TrimPrefix(lastKey, dir+"/")
+		}
+		payloads = append(payloads, d.store[k])
+		lastKey = k
+	}
+	return payloads, ""
+}
+
+// BatchDeleteSpecs - delete the specs in the file, which is written once.
+func (d *Dao) BatchDeleteSpecs(specs []*bundle.Spec) error {
+	vals := map[string]*string{}
+	for _, spec := range specs {
+		vals[specKey(spec.ID)] = nil
+	}
+	return d.writeRaws(vals)
+}
+
+// FindJobStateByState - Retrieve all the jobs that match the specified state
+func (d *Dao) FindJobStateByState(state bundle.State) ([]bundle.RecoverStatus, error) {
+	log.Debug("Dao::FindJobStateByState")
+	d.rlock()
+	defer d.lock.RUnlock()
+
+	recoverstatus := []bundle.RecoverStatus{}
+	for _, key := range d.sortedKeys("/state/") {
+		id := stateKeyID(key)
+		if id == "" {
+			continue
+		}
+		jobstate := bundle.JobState{}
+		if err := schema.Unmarshal(types.KindJobState, d.store[key], &jobstate); err != nil {
+			log.Warningf("Error processing jobstate record %s, moving on to next. %v", key, err)
+			continue
+		}
+		if jobstate.State == state {
+			recoverstatus = append(recoverstatus, bundle.RecoverStatus{
+				InstanceID: uuid.Parse(id),
+				State:      jobstate,
+			})
+		}
+	}
+	return recoverstatus, nil
+}
+
+// GetSvcInstJobsByState - Lookup all jobs of a given state for a specific instance
+func (d *Dao) GetSvcInstJobsByState(
+	instanceID string, reqState bundle.State,
+) ([]bundle.JobState, error) {
+	log.Debug("Dao::GetSvcInstJobsByState")
+	d.rlock()
+	defer d.lock.RUnlock()
+
+	jobs := []bundle.JobState{}
+	for _, key := range d.childKeys(fmt.Sprintf("/state/%s/job", instanceID)) {
+		js := bundle.JobState{}
+		if err := schema.Unmarshal(types.KindJobState, d.store[key], &js); err != nil {
+			return nil, fmt.Errorf("An error occurred trying to parse job state of [ %s ]\n%s", key, err.Error())
+		}
+		if js.State == reqState {
+			jobs = append(jobs, js)
+		}
+	}
+	return jobs, nil
+}
+
+// GetServiceInstance - Retrieve specific service instance from the file.
+func (d *Dao) GetServiceInstance(id string) (*bundle.ServiceInstance, error) {
+	si := &bundle.ServiceInstance{}
+	if err := d.getObject(serviceInstanceKey(id), si); err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+// SetServiceInstance - Set service instance for an id in the file.
+func (d *Dao) SetServiceInstance(id string, serviceInstance *bundle.ServiceInstance) error {
+	si := *serviceInstance
+	si.BindingIDs = removeFalseBindings(serviceInstance.BindingIDs)
+	return d.setObject(serviceInstanceKey(id), &si)
+}
+
+// GetServiceInstanceVersion - Retrieve a service instance and the version it
+// was read at from the file.
+func (d *Dao) GetServiceInstanceVersion(id string) (*bundle.ServiceInstance, string, error) {
+	si := &bundle.ServiceInstance{}
+	ver, err := d.getObjectVersion(serviceInstanceKey(id), si)
+	if err != nil {
+		return nil, "", err
+	}
+	return si, ver, nil
+}
+
+// CompareAndSetServiceInstance - Set service instance for an id in the file
+// if it has not changed since version was read.
+func (d *Dao) CompareAndSetServiceInstance(id string, serviceInstance *bundle.ServiceInstance, version string) (string, error) {
+	si := *serviceInstance
+	si.BindingIDs = removeFalseBindings(serviceInstance.BindingIDs)
+	return d.compareAndSetObject(serviceInstanceKey(id), &si, version)
+}
+
+// removeFalseBindings - drops the bindings that have been removed from the
+// instance, so a deleted binding does not block a deprovision.
+func removeFalseBindings(bindings map[string]bool) map[string]bool {
+	newBindings := map[string]bool{}
+	for k, v := range bindings {
+		if v {
+			newBindings[k] = v
+		}
+	}
+	return newBindings
+}
+
+// DeleteServiceInstance - Delete the service instance for an service instance id.
+func (d *Dao) DeleteServiceInstance(id string) error {
+	log.Debugf("Dao::DeleteServiceInstance -> [ %s ]", id)
+	return d.DeleteRaw(serviceInstanceKey(id))
+}
+
+// GetBindInstance - Retrieve a specific bind instance from the file.
+func (d *Dao) GetBindInstance(id string) (*bundle.BindInstance, error) {
+	bi := &bundle.BindInstance{}
+	if err := d.getObject(bindInstanceKey(id), bi); err != nil {
+		return nil, err
+	}
+	return bi, nil
+}
+
+// SetBindInstance - Set the bind instance for id in the file.
+func (d *Dao) SetBindInstance(id string, bindInstance *bundle.BindInstance) error {
+	return d.setObject(bindInstanceKey(id), bindInstance)
+}
+
+// GetBindInstanceVersion - Retrieve a bind instance and the version it was
+// read at from the file.
+func (d *Dao) GetBindInstanceVersion(id string) (*bundle.BindInstance, string, error) {
+	bi := &bundle.BindInstance{}
+	ver, err := d.getObjectVersion(bindInstanceKey(id), bi)
+	if err != nil {
+		return nil, "", err
+	}
+	return bi, ver, nil
+}
+
+// CompareAndSetBindInstance - Set the bind instance for id in the file if it
+// has not changed since version was read.
+func (d *Dao) CompareAndSetBindInstance(id string, bindInstance *bundle.BindInstance, version string) (string, error) {
+	return d.compareAndSetObject(bindInstanceKey(id), bindInstance, version)
+}
+
+// DeleteBindInstance - Delete the binding instance for an id in the file.
+func (d *Dao) DeleteBindInstance(id string) error {
+	log.Debugf("Dao::DeleteBindInstance -> [ %s ]", id)
+	return d.DeleteRaw(bindInstanceKey(id))
+}
+
+// DeleteBinding - Delete the binding instance and remove the association with
+// the service instance, in one batch so the file never holds one without the
+// other.
+func (d *Dao) DeleteBinding(bindingInstance bundle.BindInstance, serviceInstance bundle.ServiceInstance) error {
+	return d.Apply(types.DeleteBindingBatch(bindingInstance.ID.String(), serviceInstance.ID.String()))
+}
+
+// SetState - Set the Job State in the file for id.
+func (d *Dao) SetState(id string, state bundle.JobState) (string, error) {
+	key := stateKey(id, state.Token)
+	return key, d.setObject(key, storedJobState{JobState: state, LastModified: time.Now()})
+}
+
+// GetState - Retrieve a job state from the file for an ID and Token.
+func (d *Dao) GetState(id string, token string) (bundle.JobState, error) {
+	return d.GetStateByKey(stateKey(id, token))
+}
+
+// GetStateByKey - Retrieve a job state from the file for a job key
+func (d *Dao) GetStateByKey(key string) (bundle.JobState, error) {
+	state := bundle.JobState{}
+	if err := d.getObject(key, &state); err != nil {
+		return bundle.JobState{State: bundle.StateFailed}, err
+	}
+	return state, nil
+}
+
+// GetStateVersion - Retrieve a job state and the version it was read at from
+// the file.
+func (d *Dao) GetStateVersion(id string, token string) (bundle.JobState, string, error) {
+	state := bundle.JobState{}
+	ver, err := d.getObjectVersion(stateKey(id, token), &state)
+	if err != nil {
+		return bundle.JobState{State: bundle.StateFailed}, "", err
+	}
+	return state, ver, nil
+}
+
+// CompareAndSetState - Set the Job State in the file for id if it has not
+// changed since version was read.
+func (d *Dao) CompareAndSetState(id string, state bundle.JobState, version string) (string, error) {
+	return d.compareAndSetObject(stateKey(id, state.Token),
+		storedJobState{JobState: state, LastModified: time.Now()}, version)
+}
+
+// DeleteState - Delete the job state for an id and token from the file.
+func (d *Dao) DeleteState(id string, token string) error {
+	log.Debugf("Dao::DeleteState -> [ %s ]", stateKey(id, token))
+	return d.DeleteRaw(stateKey(id, token))
+}
+
+// BatchGetJobStates - Retrieve every job state in the file, ordered by key.
+func (d *Dao) BatchGetJobStates() ([]types.JobStateRecord, error) {
+	d.rlock()
+	defer d.lock.RUnlock()
+	records := []types.JobStateRecord{}
+	for _, key := range d.sortedKeys("/state/") {
+		id := stateKeyID(key)
+		if id == "" {
+			continue
+		}
+		stored := storedJobState{}
+		if err := schema.Unmarshal(types.KindJobState, d.store[key], &stored); err != nil {
+			return nil, fmt.Errorf("unable to parse job state [ %s ] - %v", key, err)
+		}
+		records = append(records, types.JobStateRecord{
+			ID:           id,
+			State:        stored.JobState,
+			LastModified: stored.LastModified,
+		})
+	}
+	return records, nil
+}
+
+// IsNotFoundError - Will determine if an error is a key is not found error.
+func (d *Dao) IsNotFoundError(err error) bool {
+	_, ok := err.(NotFoundError)
+	return ok
+}
+
+func (d *Dao) getObject(key string, data interface{}) error {
+	raw, err := d.GetRaw(key)
+	if err != nil {
+		return err
+	}
+	return decode(key, raw, data)
+}
+
+// IsConflictError - Will determine if an error is a compare and set conflict.
+func (d *Dao) IsConflictError(err error) bool {
+	_, ok := err.(ConflictError)
+	return ok
+}
+
+func (d *Dao) getObjectVersion(key string, data interface{}) (string, error) {
+	raw, ver, err := d.GetRawVersion(key)
+	if err != nil {
+		return "", err
+	}
+	return ver, decode(key, raw, data)
+}
+
+func (d *Dao) compareAndSetObject(key string, data interface{}, version string) (string, error) {
+	payload, err := encode(key, data)
+	if err != nil {
+		return "", err
+	}
+	return d.CompareAndSetRaw(key, payload, version)
+}
+
+func (d *Dao) setObject(key string, data interface{}) error {
+	payload, err := encode(key, data)
+	if err != nil {
+		return err
+	}
+	return d.SetRaw(key, payload)
+}
+
+// sortedKeys - returns all the keys starting with prefix in order. The caller
+// must hold the lock.
+func (d *Dao) sortedKeys(prefix string) []string {
+	keys := []string{}
+	for k := range d.store {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// childKeys - returns the keys that are direct children of dir, the way an
+// etcd directory listing would. The caller must hold the lock.
+func (d *Dao) childKeys(dir string) []string {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	keys := []string{}
+	for _, k := range d.sortedKeys(prefix) {
+		if !strings.Contains(strings.TrimPrefix(k, prefix), "/") {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// flush - atomically replaces the file with the current contents of the
+// store. The caller must hold the write lock.
+func (d *Dao) flush() error {
+	payload, err := json.Marshal(d.store)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(d.path), filepath.Base(d.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(payload); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), d.path); err != nil {
+		return err
+	}
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	d.loaded = info
+	return nil
+}
+
+// valueVersion - the file does not keep revisions, so the version of a value
+// is derived from its content.
+func valueVersion(val string) string {
+	sum := sha256.Sum256([]byte(val))
+	return hex.EncodeToString(sum[:8])
+}
+
+// encode - the JSON of data to store at key, the records are written with
+// their schema version.
+func encode(key string, data interface{}) (string, error) {
+	if kind := recordKind(key); kind != "" {
+		return schema.Marshal(kind, data)
+	}
+	return bundle.DumpJSON(data)
+}
+
+// decode - decodes the JSON stored at key into data, upgrading the records
+// written with an older schema version.
+func decode(key string, raw string, data interface{}) error {
+	if kind := recordKind(key); kind != "" {
+		return schema.Unmarshal(kind, raw, data)
+	}
+	return bundle.LoadJSON(raw, data)
+}
+
+////////////////////////////////////////////////////////////
+// Key generators
+////////////////////////////////////////////////////////////
+
+func stateKey(id string, jobid string) string {
+	return fmt.Sprintf("/state/%s/job/%s", id, jobid)
+}
+
+// stateKeyID - returns the instance id of a job state key, or an empty string
+// if the key is not a job state key.
+func stateKeyID(key string) string {
+	parts := strings.Split(strings.TrimPrefix(key, "/state/"), "/")
+	if len(parts) != 3 || parts[1] != "job" {
+		return ""
+	}
+	return parts[0]
+}
+
+// recordKind - the kind of the record stored at key, empty for the keys that
+// are not versioned records.
+func recordKind(key string) types.Kind {
+	switch {
+	case strings.HasPrefix(key, "/spec/"):
+		return types.KindSpec
+	case strings.HasPrefix(key, "/service_instance/"):
+		return types.KindServiceInstance
+	case strings.HasPrefix(key, "/bind_instance/"):
+		return types.KindBindInstance
+	case stateKeyID(key) != "":
+		return types.KindJobState
+	}
+	return ""
+}
+
+func specKey(id string) string {
+	return fmt.Sprintf("/spec/%s", id)
+}
+
+func serviceInstanceKey(id string) string {
+	return fmt.Sprintf("/service_instance/%s", id)
+}
+
+func bindInstanceKey(id string) string {
+	return fmt.Sprintf("/bind_instance/%s", id)
+}
+
+func intentKey(id string) string {
+	return fmt.Sprintf("/intent/%s", id)
+}
+
+func jobRecordKey(token string) string {
+	return fmt.Sprintf("/job/%s", token)
+}
+
+func deadLetterKey(id string) string {
+	return fmt.Sprintf("/dead_letter/%s", id)
+}
+
+func auditRecordKey(id string) string {
+	return fmt.Sprintf("/audit/%s", id)
+}
@@ -0,0 +1,490 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+func newTestDao(t *testing.T) (*Dao, string) {
+	dir, err := ioutil.TempDir("", "file-dao")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "broker.db")
+	d, err := NewDao(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, dir
+}
+
+func TestNewDaoRequiresPath(t *testing.T) {
+	_, err := NewDao("")
+	ft.AssertNotNil(t, err)
+}
+
+func TestServiceInstancePersists(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	id := uuid.NewRandom()
+	si := &bundle.ServiceInstance{
+		ID:      id,
+		Spec:    &bundle.Spec{ID: "spec-id"},
+		Context: &bundle.Context{Namespace: "ns"},
+	}
+	if err := d.SetServiceInstance(id.String(), si); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewDao(d.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reloaded.GetServiceInstance(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, "spec-id", got.Spec.ID)
+	ft.AssertEqual(t, "ns", got.Context.Namespace)
+
+	instances, err := reloaded.BatchGetBundleInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 1, len(instances))
+
+	if err := reloaded.DeleteServiceInstance(id.String()); err != nil {
+		t.Fatal(err)
+	}
+	_, err = reloaded.GetServiceInstance(id.String())
+	ft.AssertTrue(t, reloaded.IsNotFoundError(err))
+}
+
+func TestBatchSpecs(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	specs, err := d.BatchGetSpecs("/spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 0, len(specs))
+
+	manifest := bundle.SpecManifest{
+		"a": &bundle.Spec{ID: "a"},
+		"b": &bundle.Spec{ID: "b"},
+	}
+	if err := d.BatchSetSpecs(manifest); err != nil {
+		t.Fatal(err)
+	}
+	specs, err = d.BatchGetSpecs("/spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 2, len(specs))
+	ft.AssertEqual(t, "a", specs[0].ID)
+
+	if err := d.BatchDeleteSpecs(specs); err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.GetSpec("a")
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+}
+
+func TestSpecsPage(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	manifest := bundle.SpecManifest{}
+	for _, id := range []string{"e", "a", "d", "b", "c"} {
+		manifest[id] = &bundle.Spec{ID: id}
+	}
+	if err := d.BatchSetSpecs(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	token := ""
+	pages := 0
+	for {
+		specs, next, err := d.GetSpecsPage(token, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, s := range specs {
+			ids = append(ids, s.ID)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	ft.AssertEqual(t, 3, pages)
+	ft.AssertEqual(t, "a,b,c,d,e", strings.Join(ids, ","))
+
+	instances, next, err := d.GetServiceInstancesPage("", 10)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 0, len(instances))
+	ft.AssertEqual(t, "", next)
+}
+
+func TestJobStates(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	id := uuid.New()
+	key, err := d.SetState(id, bundle.JobState{Token: "t1", State: bundle.StateInProgress, Method: bundle.JobMethodProvision})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.SetState(id, bundle.JobState{Token: "t2", State: bundle.StateSucceeded, Method: bundle.JobMethodUpdate}); err != nil {
+		t.Fatal(err)
+	}
+
+	js, err := d.GetStateByKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, bundle.StateInProgress, js.State)
+
+	jobs, err := d.GetSvcInstJobsByState(id, bundle.StateInProgress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 1, len(jobs))
+	ft.AssertEqual(t, "t1", jobs[0].Token)
+
+	rs, err := d.FindJobStateByState(bundle.StateSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 1, len(rs))
+	ft.AssertEqual(t, id, rs[0].InstanceID.String())
+
+	_, err = d.GetState(id, "missing")
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+}
+
+func TestDeleteBinding(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	si := bundle.ServiceInstance{ID: uuid.NewRandom()}
+	bi := bundle.BindInstance{ID: uuid.NewRandom(), ServiceID: si.ID}
+	si.AddBinding(bi.ID)
+	if err := d.SetServiceInstance(si.ID.String(), &si); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetBindInstance(bi.ID.String(), &bi); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.DeleteBinding(bi, si); err != nil {
+		t.Fatal(err)
+	}
+	_, err := d.GetBindInstance(bi.ID.String())
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+	got, err := d.GetServiceInstance(si.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok := got.BindingIDs[bi.ID.String()]
+	ft.AssertFalse(t, ok)
+}
+
+func TestApply(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	si := bundle.ServiceInstance{ID: uuid.NewRandom()}
+	bi := bundle.BindInstance{ID: uuid.NewRandom(), ServiceID: si.ID}
+	si.AddBinding(bi.ID)
+	d.SetServiceInstance(si.ID.String(), &si)
+	d.SetBindInstance(bi.ID.String(), &bi)
+
+	// deleting the binding twice fails the batch, nothing must be written.
+	batch := types.DeleteBindingBatch(bi.ID.String(), si.ID.String())
+	batch.Ops = append(batch.Ops, types.Op{Type: types.OpDeleteBindInstance, ID: bi.ID.String()})
+	ft.AssertTrue(t, d.IsNotFoundError(d.Apply(batch)))
+	_, err := d.GetBindInstance(bi.ID.String())
+	ft.AssertNil(t, err)
+
+	batch = types.DeleteBindingBatch(bi.ID.String(), si.ID.String())
+	batch.Intents = []types.Intent{{ID: "1", Action: types.IntentDeleteExtractedCredentials, Target: bi.ID.String()}}
+	if err := d.Apply(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// the batch and its intent must have been flushed to the file.
+	reloaded, err := NewDao(d.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = reloaded.GetBindInstance(bi.ID.String())
+	ft.AssertTrue(t, reloaded.IsNotFoundError(err))
+	got, _ := reloaded.GetServiceInstance(si.ID.String())
+	ft.AssertEqual(t, 0, len(got.BindingIDs))
+	intents, _ := reloaded.PendingIntents()
+	ft.AssertEqual(t, 1, len(intents))
+	ft.AssertEqual(t, bi.ID.String(), intents[0].Target)
+
+	ft.AssertNil(t, reloaded.CompleteIntent("1"))
+	intents, _ = reloaded.PendingIntents()
+	ft.AssertEqual(t, 0, len(intents))
+}
+
+func TestCompareAndSetBindInstance(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	bi := &bundle.BindInstance{ID: uuid.NewRandom(), ServiceID: uuid.NewRandom()}
+	version, err := d.CompareAndSetBindInstance(bi.ID.String(), bi, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.CompareAndSetBindInstance(bi.ID.String(), bi, "")
+	ft.AssertTrue(t, d.IsConflictError(err))
+
+	// versions survive a reload of the file.
+	reloaded, err := NewDao(d.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, readVersion, err := reloaded.GetBindInstanceVersion(bi.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, version, readVersion)
+
+	got.CreateJobKey = "/state/id/job/token"
+	if _, err := reloaded.CompareAndSetBindInstance(bi.ID.String(), got, readVersion); err != nil {
+		t.Fatal(err)
+	}
+	_, err = reloaded.CompareAndSetBindInstance(bi.ID.String(), got, readVersion)
+	ft.AssertTrue(t, reloaded.IsConflictError(err))
+	ft.AssertFalse(t, reloaded.IsNotFoundError(err))
+}
+
+func TestBatchGetJobStates(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	id := uuid.New()
+	before := time.Now()
+	d.SetState(id, bundle.JobState{Token: "t1", State: bundle.StateSucceeded, Method: bundle.JobMethodProvision})
+	d.SetState(id, bundle.JobState{Token: "t2", State: bundle.StateInProgress, Method: bundle.JobMethodUpdate})
+
+	records, err := d.BatchGetJobStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 2, len(records))
+	ft.AssertEqual(t, id, records[0].ID)
+	ft.AssertEqual(t, "t1", records[0].State.Token)
+	ft.AssertEqual(t, bundle.StateSucceeded, records[0].State.State)
+	ft.AssertFalse(t, records[0].LastModified.Before(before))
+
+	if err := d.DeleteState(id, "t1"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.GetState(id, "t1")
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+	ft.AssertTrue(t, d.IsNotFoundError(d.DeleteState(id, "t1")))
+}
+
+func TestUpgradeRecords(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	// a spec written before the records were versioned.
+	if err := d.SetRaw("/spec/old", `{"id":"old","name":"old-apb"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetSpec("new", &bundle.Spec{ID: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetRaw("/unversioned", `{"id":"other"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := d.UpgradeRecords(true)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, count, 1)
+	raw, _ := d.GetRaw("/spec/old")
+	ft.AssertFalse(t, strings.Contains(raw, "schema_version"))
+
+	count, err = d.UpgradeRecords(false)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, count, 1)
+
+	reloaded, err := NewDao(filepath.Join(dir, "broker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ = reloaded.GetRaw("/spec/old")
+	ft.AssertTrue(t, strings.Contains(raw, `"schema_version":1`))
+	raw, _ = reloaded.GetRaw("/unversioned")
+	ft.AssertEqual(t, raw, `{"id":"other"}`)
+	spec, err := reloaded.GetSpec("old")
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, spec.FQName, "old-apb")
+
+	count, err = reloaded.UpgradeRecords(false)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, count, 0)
+}
+
+func TestJobRecords(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	si := &bundle.ServiceInstance{
+		ID:      uuid.NewRandom(),
+		Spec:    &bundle.Spec{ID: "spec-id"},
+		Context: &bundle.Context{Namespace: "ns"},
+	}
+	ft.AssertNil(t, d.SetJobRecord(types.JobRecord{Token: "t2", Method: bundle.JobMethodUpdate, ServiceInstance: si, Created: now}))
+	ft.AssertNil(t, d.SetJobRecord(types.JobRecord{Token: "t1", Method: bundle.JobMethodProvision, ServiceInstance: si, Created: now.Add(-time.Minute)}))
+
+	reloaded, err := NewDao(d.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := reloaded.BatchGetJobRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 2, len(records))
+	ft.AssertEqual(t, "t1", records[0].Token)
+	ft.AssertEqual(t, bundle.JobMethodUpdate, records[1].Method)
+	ft.AssertEqual(t, "ns", records[1].ServiceInstance.Context.Namespace)
+
+	ft.AssertNil(t, reloaded.DeleteJobRecord("t1"))
+	ft.AssertNil(t, reloaded.DeleteJobRecord("missing"))
+	records, _ = reloaded.BatchGetJobRecords()
+	ft.AssertEqual(t, 1, len(records))
+}
+
+func TestDeadLetters(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	ft.AssertNil(t, d.SetDeadLetter(types.DeadLetter{ID: "l2", Subscriber: "jobstate", Token: "t", Attempts: 3, Created: now}))
+	ft.AssertNil(t, d.SetDeadLetter(types.DeadLetter{ID: "l1", Subscriber: "jobstate", Token: "t", Created: now.Add(-time.Minute)}))
+
+	reloaded, err := NewDao(d.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	letters, err := reloaded.BatchGetDeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 2, len(letters))
+	ft.AssertEqual(t, "l1", letters[0].ID)
+	ft.AssertEqual(t, 3, letters[1].Attempts)
+
+	ft.AssertNil(t, reloaded.DeleteDeadLetter("l1"))
+	ft.AssertNil(t, reloaded.DeleteDeadLetter("missing"))
+	letters, _ = reloaded.BatchGetDeadLetters()
+	ft.AssertEqual(t, 1, len(letters))
+}
+
+func TestAuditRecords(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	ft.AssertNil(t, d.SetAuditRecord(types.AuditRecord{ID: "a2", Time: now, Event: map[string]interface{}{"action": "bind"}}))
+	ft.AssertNil(t, d.SetAuditRecord(types.AuditRecord{ID: "a1", Time: now.Add(-time.Minute)}))
+
+	reloaded, err := NewDao(d.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := reloaded.BatchGetAuditRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 2, len(records))
+	ft.AssertEqual(t, "a1", records[0].ID)
+	ft.AssertEqual(t, "bind", records[1].Event["action"])
+
+	ft.AssertNil(t, reloaded.DeleteAuditRecord("a1"))
+	ft.AssertNil(t, reloaded.DeleteAuditRecord("missing"))
+	records, _ = reloaded.BatchGetAuditRecords()
+	ft.AssertEqual(t, 1, len(records))
+}
+
+func TestLeases(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+	_, _, err := d.GetLease("broker")
+	ft.AssertEqual(t, types.ErrLeasesUnsupported, err)
+	_, err = d.CompareAndSetLease(types.Lease{Name: "broker", Holder: "a", Duration: time.Second}, "")
+	ft.AssertEqual(t, types.ErrLeasesUnsupported, err)
+}
+
+func TestSharedFile(t *testing.T) {
+	d, dir := newTestDao(t)
+	defer os.RemoveAll(dir)
+	// another process, such as a command, opening the same file.
+	other, err := NewDao(filepath.Join(dir, "broker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ft.AssertNil(t, d.SetRaw("/a", "1"))
+	ft.AssertNil(t, other.SetRaw("/b", "2"))
+	ft.AssertNil(t, d.BatchSetSpecs(bundle.SpecManifest{"c": &bundle.Spec{ID: "c"}}))
+
+	// the writes of either are kept, and seen by the other.
+	val, err := d.GetRaw("/b")
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "2", val)
+	_, err = other.GetSpec("c")
+	ft.AssertNil(t, err)
+	reloaded, err := NewDao(filepath.Join(dir, "broker.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"/a", "/b", "/spec/c"} {
+		_, err := reloaded.GetRaw(key)
+		ft.AssertNil(t, err)
+	}
+
+	// a batch deleting a missing spec leaves the file untouched.
+	err = d.BatchDeleteSpecs([]*bundle.Spec{{ID: "c"}, {ID: "missing"}})
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+	_, err = other.GetSpec("c")
+	ft.AssertNil(t, err)
+}
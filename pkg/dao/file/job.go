@@ -0,0 +1,57 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// SetJobRecord - Store the job record in the file.
+func (d *Dao) SetJobRecord(record types.JobRecord) error {
+	payload, err := bundle.DumpJSON(record)
+	if err != nil {
+		return err
+	}
+	return d.SetRaw(jobRecordKey(record.Token), payload)
+}
+
+// DeleteJobRecord - Remove the job record from the file.
+func (d *Dao) DeleteJobRecord(token string) error {
+	err := d.DeleteRaw(jobRecordKey(token))
+	if d.IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
+
+// BatchGetJobRecords - Retrieve the job records stored in the file, oldest
+// first.
+func (d *Dao) BatchGetJobRecords() ([]types.JobRecord, error) {
+	d.rlock()
+	defer d.lock.RUnlock()
+	records := []types.JobRecord{}
+	for _, key := range d.childKeys("/job") {
+		record := types.JobRecord{}
+		if err := bundle.LoadJSON(d.store[key], &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	types.SortJobRecords(records)
+	return records, nil
+}
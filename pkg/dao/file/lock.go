@@ -0,0 +1,103 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// lockSuffix - the suffix of the file locked by the writers of the store. The
+// data file itself is replaced on every write, so it cannot hold the lock.
+const lockSuffix = ".lock"
+
+// writeLock - takes the write lock and the file lock, shared with the other
+// processes using the file, and reloads the store when another process has
+// replaced the file since it was read. The returned func releases both.
+func (d *Dao) writeLock() (func(), error) {
+	d.lock.Lock()
+	if err := os.MkdirAll(filepath.Dir(d.path), 0700); err != nil {
+		d.lock.Unlock()
+		return nil, err
+	}
+	f, err := os.OpenFile(d.path+lockSuffix, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		d.lock.Unlock()
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		d.lock.Unlock()
+		return nil, fmt.Errorf("unable to lock dao file %s - %v", d.path, err)
+	}
+	unlock := func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+		d.lock.Unlock()
+	}
+	if err := d.reload(); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// rlock - takes the read lock, reloading the store first when another process
+// has replaced the file. A store that cannot be reloaded is read as it is.
+func (d *Dao) rlock() {
+	d.lock.Lock()
+	if err := d.reload(); err != nil {
+		log.Warningf("Unable to reload dao file [ %s ], reading the records loaded before - %v", d.path, err)
+	}
+	d.lock.Unlock()
+	d.lock.RLock()
+}
+
+// reload - reads the file again when it is not the one last read or written.
+// The caller must hold the write lock.
+func (d *Dao) reload() error {
+	info, err := os.Stat(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if d.loaded != nil && os.SameFile(d.loaded, info) &&
+		d.loaded.ModTime().Equal(info.ModTime()) && d.loaded.Size() == info.Size() {
+		return nil
+	}
+	raw, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	store := map[string]string{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &store); err != nil {
+			return fmt.Errorf("unable to load dao file %s - %v", d.path, err)
+		}
+	}
+	d.store = store
+	d.loaded = info
+	return nil
+}
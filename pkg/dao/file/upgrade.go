@@ -0,0 +1,73 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/openshift/ansible-service-broker/pkg/dao/schema"
+	log "github.com/sirupsen/logrus"
+)
+
+// UpgradeRecords - Rewrites the records stored with an older schema version,
+// or none, in the current version and writes the file once. With dryRun the
+// records are only counted. Returns how many records were, or would be,
+// upgraded.
+func (d *Dao) UpgradeRecords(dryRun bool) (int, error) {
+	unlock, err := d.writeLock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	previous := map[string]string{}
+	for _, key := range d.sortedKeys("/") {
+		kind := recordKind(key)
+		if kind == "" {
+			continue
+		}
+		stale, err := schema.NeedsRewrite(kind, d.store[key])
+		if err != nil {
+			log.Warningf("Unable to parse record [ %s ], skipping - %v", key, err)
+			continue
+		}
+		if !stale {
+			continue
+		}
+		raw, err := schema.Rewrite(kind, d.store[key])
+		if err != nil {
+			d.restore(previous)
+			return 0, err
+		}
+		previous[key] = d.store[key]
+		d.store[key] = raw
+	}
+	if dryRun || len(previous) == 0 {
+		d.restore(previous)
+		return len(previous), nil
+	}
+	if err := d.flush(); err != nil {
+		d.restore(previous)
+		return 0, err
+	}
+	return len(previous), nil
+}
+
+// restore - puts back the values of the keys. The caller must hold the write
+// lock.
+func (d *Dao) restore(values map[string]string) {
+	for key, val := range values {
+		d.store[key] = val
+	}
+}
@@ -0,0 +1,228 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package fsck finds the records of the broker state that refer to records
+// that no longer exist, and repairs the ones that can be safely repaired.
+package fsck
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
+	"github.com/openshift/ansible-service-broker/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// The kinds of problems the checker finds.
+const (
+	// OrphanedBinding - a bind instance whose service instance is gone.
+	OrphanedBinding = "orphaned_binding"
+	// UnlistedBinding - a bind instance missing from the BindingIDs of its
+	// service instance. Async binds are in this state until they finish.
+	UnlistedBinding = "unlisted_binding"
+	// DanglingBindingID - a service instance BindingIDs entry without a bind
+	// instance. Repaired by dropping the entry.
+	DanglingBindingID = "dangling_binding_id"
+	// MissingSpec - a service instance whose spec is gone.
+	MissingSpec = "missing_spec"
+	// AbandonedJob - an unfinished job state whose service instance or
+	// binding is gone. Repaired by marking the job failed.
+	AbandonedJob = "abandoned_job"
+)
+
+// abandonedJobError - the error recorded on the abandoned jobs marked failed.
+const abandonedJobError = "job abandoned, its service instance or binding no longer exists"
+
+// Problem - an inconsistent record.
+type Problem struct {
+	Kind   string
+	ID     string
+	Detail string
+	// Repaired - whether the problem was fixed.
+	Repaired bool
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s [ %s ] %s", p.Kind, p.ID, p.Detail)
+}
+
+// Checker - checks the references between the records of a dao.
+type Checker struct {
+	dao dao.Dao
+	// gracePeriod - how long an unfinished job state of a missing instance
+	// or binding is left alone, a new async bind saves its binding late.
+	gracePeriod time.Duration
+	now         func() time.Time
+}
+
+// NewChecker - creates a consistency checker for d. Unfinished jobs are only
+// considered abandoned once they have not been written for gracePeriod.
+func NewChecker(d dao.Dao, gracePeriod time.Duration) *Checker {
+	return &Checker{dao: d, gracePeriod: gracePeriod, now: time.Now}
+}
+
+// Run - walks every record and returns the problems found, sorted by kind
+// and id. With repair the safe fixes are applied as well.
+func (c *Checker) Run(repair bool) ([]Problem, error) {
+	specs := map[string]bool{}
+	err := dao.ForEachSpecPage(c.dao, dao.DefaultPageSize, func(page []*bundle.Spec) error {
+		for _, s := range page {
+			specs[s.ID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the specs - %v", err)
+	}
+	instances := map[string]*bundle.ServiceInstance{}
+	err = dao.ForEachServiceInstancePage(c.dao, dao.DefaultPageSize, func(page []*bundle.ServiceInstance) error {
+		for _, si := range page {
+			instances[si.ID.String()] = si
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the service instances - %v", err)
+	}
+	bindingList, err := c.dao.BatchGetBindInstances()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the bind instances - %v", err)
+	}
+	bindings := map[string]*bundle.BindInstance{}
+	for _, bi := range bindingList {
+		bindings[bi.ID.String()] = bi
+	}
+	states, err := c.dao.BatchGetJobStates()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the job states - %v", err)
+	}
+
+	problems := []Problem{}
+	for id, si := range instances {
+		if si.Spec != nil && !specs[si.Spec.ID] {
+			problems = append(problems, Problem{Kind: MissingSpec, ID: id, Detail: "spec " + si.Spec.ID})
+		}
+		dangling := []string{}
+		for bindingID, bound := range si.BindingIDs {
+			if _, ok := bindings[bindingID]; !ok || !bound {
+				dangling = append(dangling, bindingID)
+			}
+		}
+		sort.Strings(dangling)
+		repaired := repair && len(dangling) > 0 && c.dropBindingIDs(id, dangling)
+		for _, bindingID := range dangling {
+			problems = append(problems, Problem{
+				Kind: DanglingBindingID, ID: id, Detail: "binding " + bindingID, Repaired: repaired,
+			})
+		}
+	}
+
+	for id, bi := range bindings {
+		si, ok := instances[bi.ServiceID.String()]
+		switch {
+		case !ok:
+			problems = append(problems, Problem{Kind: OrphanedBinding, ID: id, Detail: "service instance " + bi.ServiceID.String()})
+		case !si.BindingIDs[id]:
+			problems = append(problems, Problem{Kind: UnlistedBinding, ID: id, Detail: "service instance " + bi.ServiceID.String()})
+		}
+	}
+
+	now := c.now()
+	for _, s := range states {
+		if s.State.State != bundle.StateInProgress && s.State.State != bundle.StateNotYetStarted {
+			continue
+		}
+		_, isInstance := instances[s.ID]
+		_, isBinding := bindings[s.ID]
+		if isInstance || isBinding || now.Sub(s.LastModified) < c.gracePeriod {
+			continue
+		}
+		p := Problem{Kind: AbandonedJob, ID: s.ID, Detail: fmt.Sprintf("%s job %s", s.State.Method, s.State.Token)}
+		if repair {
+			p.Repaired = c.failJob(s.ID, s.State.Token)
+		}
+		problems = append(problems, p)
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Kind != problems[j].Kind {
+			return problems[i].Kind < problems[j].Kind
+		}
+		return problems[i].ID < problems[j].ID
+	})
+	counts := map[string]int{
+		OrphanedBinding: 0, UnlistedBinding: 0, DanglingBindingID: 0, MissingSpec: 0, AbandonedJob: 0,
+	}
+	for _, p := range problems {
+		if !p.Repaired {
+			counts[p.Kind]++
+		}
+	}
+	metrics.DAOInconsistencies(counts)
+	return problems, nil
+}
+
+// dropBindingIDs - removes the binding ids from the service instance when
+// their bind instances still do not exist.
+func (c *Checker) dropBindingIDs(id string, bindingIDs []string) bool {
+	_, err := dao.UpdateServiceInstance(c.dao, id, func(si *bundle.ServiceInstance) error {
+		for _, bindingID := range bindingIDs {
+			if si.BindingIDs[bindingID] {
+				if _, err := c.dao.GetBindInstance(bindingID); err == nil {
+					continue
+				} else if !c.dao.IsNotFoundError(err) {
+					return err
+				}
+			}
+			delete(si.BindingIDs, bindingID)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Unable to drop the dangling bindings of service instance [ %s ] - %v", id, err)
+		return false
+	}
+	log.Infof("Dropped the dangling bindings %v of service instance [ %s ]", bindingIDs, id)
+	return true
+}
+
+// failJob - marks the job failed unless it was written since it was read, or
+// its service instance or binding showed up meanwhile.
+func (c *Checker) failJob(id, token string) bool {
+	state, version, err := c.dao.GetStateVersion(id, token)
+	if err != nil {
+		log.Errorf("Unable to read job [ %s ] of [ %s ] - %v", token, id, err)
+		return false
+	}
+	if _, err := c.dao.GetServiceInstance(id); !c.dao.IsNotFoundError(err) {
+		return false
+	}
+	if _, err := c.dao.GetBindInstance(id); !c.dao.IsNotFoundError(err) {
+		return false
+	}
+	state.State = bundle.StateFailed
+	state.Error = abandonedJobError
+	state.Description = abandonedJobError
+	if _, err := c.dao.CompareAndSetState(id, state, version); err != nil {
+		log.Errorf("Unable to mark job [ %s ] of [ %s ] failed - %v", token, id, err)
+		return false
+	}
+	log.Infof("Marked abandoned job [ %s ] of [ %s ] failed", token, id)
+	return true
+}
@@ -0,0 +1,77 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package fsck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+func TestCheck(t *testing.T) {
+	d, _ := memory.NewDao()
+	spec := &bundle.Spec{ID: "spec-1"}
+	d.SetSpec(spec.ID, spec)
+
+	siID, goneID := uuid.NewRandom(), uuid.NewRandom()
+	bound, dangling, unlisted, orphaned := uuid.NewRandom(), uuid.NewRandom(), uuid.NewRandom(), uuid.NewRandom()
+	d.SetServiceInstance(siID.String(), &bundle.ServiceInstance{
+		ID:         siID,
+		Spec:       spec,
+		BindingIDs: map[string]bool{bound.String(): true, dangling.String(): true},
+	})
+	d.SetBindInstance(bound.String(), &bundle.BindInstance{ID: bound, ServiceID: siID})
+	d.SetBindInstance(unlisted.String(), &bundle.BindInstance{ID: unlisted, ServiceID: siID})
+	d.SetBindInstance(orphaned.String(), &bundle.BindInstance{ID: orphaned, ServiceID: goneID})
+	d.SetState(goneID.String(), bundle.JobState{Token: "t1", State: bundle.StateInProgress, Method: bundle.JobMethodProvision})
+	d.SetState(siID.String(), bundle.JobState{Token: "t2", State: bundle.StateInProgress, Method: bundle.JobMethodUpdate})
+
+	c := NewChecker(d, time.Minute)
+	problems, err := c.Run(false)
+	ft.AssertNil(t, err)
+	// the abandoned job is still within its grace period.
+	ft.AssertEqual(t, 3, len(problems))
+	ft.AssertEqual(t, DanglingBindingID, problems[0].Kind)
+	ft.AssertEqual(t, OrphanedBinding, problems[1].Kind)
+	ft.AssertEqual(t, orphaned.String(), problems[1].ID)
+	ft.AssertEqual(t, UnlistedBinding, problems[2].Kind)
+
+	c.now = func() time.Time { return time.Now().Add(time.Hour) }
+	problems, err = c.Run(true)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 4, len(problems))
+	ft.AssertEqual(t, AbandonedJob, problems[0].Kind)
+	ft.AssertTrue(t, problems[0].Repaired)
+	ft.AssertTrue(t, problems[1].Repaired)
+	ft.AssertFalse(t, problems[2].Repaired)
+
+	si, _ := d.GetServiceInstance(siID.String())
+	ft.AssertTrue(t, si.BindingIDs[bound.String()])
+	_, ok := si.BindingIDs[dangling.String()]
+	ft.AssertFalse(t, ok)
+	state, _ := d.GetState(goneID.String(), "t1")
+	ft.AssertEqual(t, bundle.StateFailed, state.State)
+	state, _ = d.GetState(siID.String(), "t2")
+	ft.AssertEqual(t, bundle.StateInProgress, state.State)
+
+	problems, _ = c.Run(true)
+	ft.AssertEqual(t, 2, len(problems))
+}
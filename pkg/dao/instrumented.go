@@ -0,0 +1,344 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/openshift/ansible-service-broker/pkg/metrics"
+)
+
+// The reasons a failed dao call is counted under.
+const (
+	errorReasonNotFound = "not_found"
+	errorReasonConflict = "conflict"
+	errorReasonError    = "error"
+)
+
+// InstrumentedDao - Dao that records the latency of every call to the
+// wrapped Dao and counts its errors, by method and backend type. Not found
+// errors and compare and set conflicts are expected and counted apart from
+// the failures of the data store.
+type InstrumentedDao struct {
+	Dao
+	backend string
+}
+
+// NewInstrumentedDao - Creates a Dao recording metrics for the calls to d,
+// labelled with the backend type.
+func NewInstrumentedDao(d Dao, backend string) *InstrumentedDao {
+	return &InstrumentedDao{Dao: d, backend: backend}
+}
+
+// observe - records a call of method that started at start and returned
+// *err.
+func (d *InstrumentedDao) observe(method string, start time.Time, err *error) {
+	metrics.DAORequest(method, d.backend, time.Since(start))
+	switch {
+	case *err == nil:
+	case d.Dao.IsNotFoundError(*err):
+		metrics.DAOError(method, d.backend, errorReasonNotFound)
+	case d.Dao.IsConflictError(*err):
+		metrics.DAOError(method, d.backend, errorReasonConflict)
+	default:
+		metrics.DAOError(method, d.backend, errorReasonError)
+	}
+}
+
+// GetSpec - Times GetSpec of the wrapped dao.
+func (d *InstrumentedDao) GetSpec(id string) (_ *bundle.Spec, err error) {
+	defer d.observe("GetSpec", time.Now(), &err)
+	return d.Dao.GetSpec(id)
+}
+
+// SetSpec - Times SetSpec of the wrapped dao.
+func (d *InstrumentedDao) SetSpec(id string, spec *bundle.Spec) (err error) {
+	defer d.observe("SetSpec", time.Now(), &err)
+	return d.Dao.SetSpec(id, spec)
+}
+
+// DeleteSpec - Times DeleteSpec of the wrapped dao.
+func (d *InstrumentedDao) DeleteSpec(id string) (err error) {
+	defer d.observe("DeleteSpec", time.Now(), &err)
+	return d.Dao.DeleteSpec(id)
+}
+
+// BatchSetSpecs - Times BatchSetSpecs of the wrapped dao.
+func (d *InstrumentedDao) BatchSetSpecs(specs bundle.SpecManifest) (err error) {
+	defer d.observe("BatchSetSpecs", time.Now(), &err)
+	return d.Dao.BatchSetSpecs(specs)
+}
+
+// BatchGetSpecs - Times BatchGetSpecs of the wrapped dao.
+func (d *InstrumentedDao) BatchGetSpecs(dir string) (_ []*bundle.Spec, err error) {
+	defer d.observe("BatchGetSpecs", time.Now(), &err)
+	return d.Dao.BatchGetSpecs(dir)
+}
+
+// BatchGetBundleInstances - Times BatchGetBundleInstances of the wrapped dao.
+func (d *InstrumentedDao) BatchGetBundleInstances() (_ []*bundle.ServiceInstance, err error) {
+	defer d.observe("BatchGetBundleInstances", time.Now(), &err)
+	return d.Dao.BatchGetBundleInstances()
+}
+
+// BatchDeleteSpecs - Times BatchDeleteSpecs of the wrapped dao.
+func (d *InstrumentedDao) BatchDeleteSpecs(specs []*bundle.Spec) (err error) {
+	defer d.observe("BatchDeleteSpecs", time.Now(), &err)
+	return d.Dao.BatchDeleteSpecs(specs)
+}
+
+// GetSpecsPage - Times GetSpecsPage of the wrapped dao.
+func (d *InstrumentedDao) GetSpecsPage(continueToken string, limit int) (_ []*bundle.Spec, _ string, err error) {
+	defer d.observe("GetSpecsPage", time.Now(), &err)
+	return d.Dao.GetSpecsPage(continueToken, limit)
+}
+
+// GetServiceInstancesPage - Times GetServiceInstancesPage of the wrapped dao.
+func (d *InstrumentedDao) GetServiceInstancesPage(continueToken string, limit int) (_ []*bundle.ServiceInstance, _ string, err error) {
+	defer d.observe("GetServiceInstancesPage", time.Now(), &err)
+	return d.Dao.GetServiceInstancesPage(continueToken, limit)
+}
+
+// FindJobStateByState - Times FindJobStateByState of the wrapped dao.
+func (d *InstrumentedDao) FindJobStateByState(state bundle.State) (_ []bundle.RecoverStatus, err error) {
+	defer d.observe("FindJobStateByState", time.Now(), &err)
+	return d.Dao.FindJobStateByState(state)
+}
+
+// GetSvcInstJobsByState - Times GetSvcInstJobsByState of the wrapped dao.
+func (d *InstrumentedDao) GetSvcInstJobsByState(id string, state bundle.State) (_ []bundle.JobState, err error) {
+	defer d.observe("GetSvcInstJobsByState", time.Now(), &err)
+	return d.Dao.GetSvcInstJobsByState(id, state)
+}
+
+// GetServiceInstance - Times GetServiceInstance of the wrapped dao.
+func (d *InstrumentedDao) GetServiceInstance(id string) (_ *bundle.ServiceInstance, err error) {
+	defer d.observe("GetServiceInstance", time.Now(), &err)
+	return d.Dao.GetServiceInstance(id)
+}
+
+// SetServiceInstance - Times SetServiceInstance of the wrapped dao.
+func (d *InstrumentedDao) SetServiceInstance(id string, si *bundle.ServiceInstance) (err error) {
+	defer d.observe("SetServiceInstance", time.Now(), &err)
+	return d.Dao.SetServiceInstance(id, si)
+}
+
+// DeleteServiceInstance - Times DeleteServiceInstance of the wrapped dao.
+func (d *InstrumentedDao) DeleteServiceInstance(id string) (err error) {
+	defer d.observe("DeleteServiceInstance", time.Now(), &err)
+	return d.Dao.DeleteServiceInstance(id)
+}
+
+// GetBindInstance - Times GetBindInstance of the wrapped dao.
+func (d *InstrumentedDao) GetBindInstance(id string) (_ *bundle.BindInstance, err error) {
+	defer d.observe("GetBindInstance", time.Now(), &err)
+	return d.Dao.GetBindInstance(id)
+}
+
+// SetBindInstance - Times SetBindInstance of the wrapped dao.
+func (d *InstrumentedDao) SetBindInstance(id string, bi *bundle.BindInstance) (err error) {
+	defer d.observe("SetBindInstance", time.Now(), &err)
+	return d.Dao.SetBindInstance(id, bi)
+}
+
+// DeleteBindInstance - Times DeleteBindInstance of the wrapped dao.
+func (d *InstrumentedDao) DeleteBindInstance(id string) (err error) {
+	defer d.observe("DeleteBindInstance", time.Now(), &err)
+	return d.Dao.DeleteBindInstance(id)
+}
+
+// DeleteBinding - Times DeleteBinding of the wrapped dao.
+func (d *InstrumentedDao) DeleteBinding(bi bundle.BindInstance, si bundle.ServiceInstance) (err error) {
+	defer d.observe("DeleteBinding", time.Now(), &err)
+	return d.Dao.DeleteBinding(bi, si)
+}
+
+// Apply - Times Apply of the wrapped dao.
+func (d *InstrumentedDao) Apply(batch types.Batch) (err error) {
+	defer d.observe("Apply", time.Now(), &err)
+	return d.Dao.Apply(batch)
+}
+
+// PendingIntents - Times PendingIntents of the wrapped dao.
+func (d *InstrumentedDao) PendingIntents() (_ []types.Intent, err error) {
+	defer d.observe("PendingIntents", time.Now(), &err)
+	return d.Dao.PendingIntents()
+}
+
+// CompleteIntent - Times CompleteIntent of the wrapped dao.
+func (d *InstrumentedDao) CompleteIntent(id string) (err error) {
+	defer d.observe("CompleteIntent", time.Now(), &err)
+	return d.Dao.CompleteIntent(id)
+}
+
+// SetJobRecord - Times SetJobRecord of the wrapped dao.
+func (d *InstrumentedDao) SetJobRecord(record types.JobRecord) (err error) {
+	defer d.observe("SetJobRecord", time.Now(), &err)
+	return d.Dao.SetJobRecord(record)
+}
+
+// DeleteJobRecord - Times DeleteJobRecord of the wrapped dao.
+func (d *InstrumentedDao) DeleteJobRecord(token string) (err error) {
+	defer d.observe("DeleteJobRecord", time.Now(), &err)
+	return d.Dao.DeleteJobRecord(token)
+}
+
+// BatchGetJobRecords - Times BatchGetJobRecords of the wrapped dao.
+func (d *InstrumentedDao) BatchGetJobRecords() (_ []types.JobRecord, err error) {
+	defer d.observe("BatchGetJobRecords", time.Now(), &err)
+	return d.Dao.BatchGetJobRecords()
+}
+
+// SetDeadLetter - Times SetDeadLetter of the wrapped dao.
+func (d *InstrumentedDao) SetDeadLetter(letter types.DeadLetter) (err error) {
+	defer d.observe("SetDeadLetter", time.Now(), &err)
+	return d.Dao.SetDeadLetter(letter)
+}
+
+// DeleteDeadLetter - Times DeleteDeadLetter of the wrapped dao.
+func (d *InstrumentedDao) DeleteDeadLetter(id string) (err error) {
+	defer d.observe("DeleteDeadLetter", time.Now(), &err)
+	return d.Dao.DeleteDeadLetter(id)
+}
+
+// BatchGetDeadLetters - Times BatchGetDeadLetters of the wrapped dao.
+func (d *InstrumentedDao) BatchGetDeadLetters() (_ []types.DeadLetter, err error) {
+	defer d.observe("BatchGetDeadLetters", time.Now(), &err)
+	return d.Dao.BatchGetDeadLetters()
+}
+
+// SetAuditRecord - Times SetAuditRecord of the wrapped dao.
+func (d *InstrumentedDao) SetAuditRecord(record types.AuditRecord) (err error) {
+	defer d.observe("SetAuditRecord", time.Now(), &err)
+	return d.Dao.SetAuditRecord(record)
+}
+
+// DeleteAuditRecord - Times DeleteAuditRecord of the wrapped dao.
+func (d *InstrumentedDao) DeleteAuditRecord(id string) (err error) {
+	defer d.observe("DeleteAuditRecord", time.Now(), &err)
+	return d.Dao.DeleteAuditRecord(id)
+}
+
+// BatchGetAuditRecords - Times BatchGetAuditRecords of the wrapped dao.
+func (d *InstrumentedDao) BatchGetAuditRecords() (_ []types.AuditRecord, err error) {
+	defer d.observe("BatchGetAuditRecords", time.Now(), &err)
+	return d.Dao.BatchGetAuditRecords()
+}
+
+// GetLease - Times GetLease of the wrapped dao.
+func (d *InstrumentedDao) GetLease(name string) (_ types.Lease, _ string, err error) {
+	defer d.observe("GetLease", time.Now(), &err)
+	return d.Dao.GetLease(name)
+}
+
+// CompareAndSetLease - Times CompareAndSetLease of the wrapped dao.
+func (d *InstrumentedDao) CompareAndSetLease(lease types.Lease, version string) (_ string, err error) {
+	defer d.observe("CompareAndSetLease", time.Now(), &err)
+	return d.Dao.CompareAndSetLease(lease, version)
+}
+
+// SetState - Times SetState of the wrapped dao.
+func (d *InstrumentedDao) SetState(id string, state bundle.JobState) (_ string, err error) {
+	defer d.observe("SetState", time.Now(), &err)
+	return d.Dao.SetState(id, state)
+}
+
+// GetState - Times GetState of the wrapped dao.
+func (d *InstrumentedDao) GetState(id string, token string) (_ bundle.JobState, err error) {
+	defer d.observe("GetState", time.Now(), &err)
+	return d.Dao.GetState(id, token)
+}
+
+// GetStateByKey - Times GetStateByKey of the wrapped dao.
+func (d *InstrumentedDao) GetStateByKey(key string) (_ bundle.JobState, err error) {
+	defer d.observe("GetStateByKey", time.Now(), &err)
+	return d.Dao.GetStateByKey(key)
+}
+
+// GetServiceInstanceVersion - Times GetServiceInstanceVersion of the wrapped dao.
+func (d *InstrumentedDao) GetServiceInstanceVersion(id string) (_ *bundle.ServiceInstance, _ string, err error) {
+	defer d.observe("GetServiceInstanceVersion", time.Now(), &err)
+	return d.Dao.GetServiceInstanceVersion(id)
+}
+
+// CompareAndSetServiceInstance - Times CompareAndSetServiceInstance of the wrapped dao.
+func (d *InstrumentedDao) CompareAndSetServiceInstance(id string, si *bundle.ServiceInstance, version string) (_ string, err error) {
+	defer d.observe("CompareAndSetServiceInstance", time.Now(), &err)
+	return d.Dao.CompareAndSetServiceInstance(id, si, version)
+}
+
+// GetBindInstanceVersion - Times GetBindInstanceVersion of the wrapped dao.
+func (d *InstrumentedDao) GetBindInstanceVersion(id string) (_ *bundle.BindInstance, _ string, err error) {
+	defer d.observe("GetBindInstanceVersion", time.Now(), &err)
+	return d.Dao.GetBindInstanceVersion(id)
+}
+
+// CompareAndSetBindInstance - Times CompareAndSetBindInstance of the wrapped dao.
+func (d *InstrumentedDao) CompareAndSetBindInstance(id string, bi *bundle.BindInstance, version string) (_ string, err error) {
+	defer d.observe("CompareAndSetBindInstance", time.Now(), &err)
+	return d.Dao.CompareAndSetBindInstance(id, bi, version)
+}
+
+// GetStateVersion - Times GetStateVersion of the wrapped dao.
+func (d *InstrumentedDao) GetStateVersion(id string, token string) (_ bundle.JobState, _ string, err error) {
+	defer d.observe("GetStateVersion", time.Now(), &err)
+	return d.Dao.GetStateVersion(id, token)
+}
+
+// CompareAndSetState - Times CompareAndSetState of the wrapped dao.
+func (d *InstrumentedDao) CompareAndSetState(id string, state bundle.JobState, version string) (_ string, err error) {
+	defer d.observe("CompareAndSetState", time.Now(), &err)
+	return d.Dao.CompareAndSetState(id, state, version)
+}
+
+// BatchGetJobStates - Times BatchGetJobStates of the wrapped dao.
+func (d *InstrumentedDao) BatchGetJobStates() (_ []types.JobStateRecord, err error) {
+	defer d.observe("BatchGetJobStates", time.Now(), &err)
+	return d.Dao.BatchGetJobStates()
+}
+
+// DeleteState - Times DeleteState of the wrapped dao.
+func (d *InstrumentedDao) DeleteState(id string, token string) (err error) {
+	defer d.observe("DeleteState", time.Now(), &err)
+	return d.Dao.DeleteState(id, token)
+}
+
+// BatchGetBindInstances - Times BatchGetBindInstances of the wrapped dao.
+func (d *InstrumentedDao) BatchGetBindInstances() (_ []*bundle.BindInstance, err error) {
+	defer d.observe("BatchGetBindInstances", time.Now(), &err)
+	return d.Dao.BatchGetBindInstances()
+}
+
+// FindServiceInstances - Times FindServiceInstances of the wrapped dao.
+func (d *InstrumentedDao) FindServiceInstances(filter types.InstanceFilter) (_ []*bundle.ServiceInstance, err error) {
+	defer d.observe("FindServiceInstances", time.Now(), &err)
+	return d.Dao.FindServiceInstances(filter)
+}
+
+// FindBindInstances - Times FindBindInstances of the wrapped dao.
+func (d *InstrumentedDao) FindBindInstances(filter types.BindingFilter) (_ []*bundle.BindInstance, err error) {
+	defer d.observe("FindBindInstances", time.Now(), &err)
+	return d.Dao.FindBindInstances(filter)
+}
+
+// Watch - Times Watch of the wrapped dao.
+func (d *InstrumentedDao) Watch(stop <-chan struct{}) (_ <-chan types.Event, err error) {
+	defer d.observe("Watch", time.Now(), &err)
+	return d.Dao.Watch(stop)
+}
@@ -0,0 +1,79 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"testing"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// daoMetric - the value of the dao metric name with the labels, the sample
+// count for histograms.
+func daoMetric(t *testing.T, name string, labels map[string]string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v != l.GetValue() {
+					continue metrics
+				}
+			}
+			if m.GetHistogram() != nil {
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestInstrumentedDao(t *testing.T) {
+	backend, _ := memory.NewDao()
+	d := NewInstrumentedDao(backend, "test")
+	spec := &bundle.Spec{ID: "spec-1"}
+	ft.AssertNil(t, d.SetSpec(spec.ID, spec))
+	got, err := d.GetSpec(spec.ID)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, "spec-1", got.ID)
+	_, err = d.GetSpec("missing")
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+	d.SetServiceInstance("si-1", &bundle.ServiceInstance{})
+	_, err = d.CompareAndSetServiceInstance("si-1", &bundle.ServiceInstance{}, "")
+	ft.AssertTrue(t, d.IsConflictError(err))
+
+	get := map[string]string{"method": "GetSpec", "backend": "test"}
+	ft.AssertEqual(t, float64(2), daoMetric(t, "asb_dao_request_duration_seconds", get))
+	ft.AssertEqual(t, float64(1), daoMetric(t, "asb_dao_errors",
+		map[string]string{"method": "GetSpec", "backend": "test", "reason": "not_found"}))
+	ft.AssertEqual(t, float64(0), daoMetric(t, "asb_dao_errors",
+		map[string]string{"method": "GetSpec", "backend": "test", "reason": "error"}))
+	ft.AssertEqual(t, float64(1), daoMetric(t, "asb_dao_errors",
+		map[string]string{"method": "CompareAndSetServiceInstance", "backend": "test", "reason": "conflict"}))
+	ft.AssertEqual(t, float64(0), daoMetric(t, "asb_dao_errors",
+		map[string]string{"method": "SetSpec", "backend": "test"}))
+}
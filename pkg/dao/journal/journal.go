@@ -0,0 +1,145 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package journal makes the writes of a batch one by one, for the dao
+// backends that cannot apply them in a transaction. The backends record the
+// batch in a journal entry first and replay the entries left behind by a
+// broker that stopped part of the way, so a batch is eventually applied in
+// full.
+package journal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Store - the records a journaled batch writes, implemented by the backends.
+type Store interface {
+	GetServiceInstance(string) (*bundle.ServiceInstance, error)
+	SetServiceInstance(string, *bundle.ServiceInstance) error
+	DeleteServiceInstance(string) error
+	GetServiceInstanceVersion(string) (*bundle.ServiceInstance, string, error)
+	CompareAndSetServiceInstance(string, *bundle.ServiceInstance, string) (string, error)
+	GetBindInstance(string) (*bundle.BindInstance, error)
+	SetBindInstance(string, *bundle.BindInstance) error
+	DeleteBindInstance(string) error
+	IsNotFoundError(error) bool
+	IsConflictError(error) bool
+}
+
+// Check - Verify the records the batch deletes exist, taking the writes of
+// the batch before the delete into account. It is called before the batch
+// is journaled so a batch that would fail is never started.
+func Check(s Store, batch types.Batch) error {
+	// exists holds whether the records written earlier in the batch still
+	// exist, keyed by the op type deleting them and their id.
+	exists := map[string]bool{}
+	for _, op := range batch.Ops {
+		switch op.Type {
+		case types.OpSetServiceInstance:
+			exists[recordKey(types.OpDeleteServiceInstance, op.ID)] = true
+		case types.OpSetBindInstance:
+			exists[recordKey(types.OpDeleteBindInstance, op.ID)] = true
+		case types.OpDeleteServiceInstance, types.OpDeleteBindInstance:
+			key := recordKey(op.Type, op.ID)
+			if found, ok := exists[key]; ok && !found {
+				return fmt.Errorf("%s: record not found: %s", op.Type, op.ID)
+			} else if !ok {
+				// the not found error of the backend is returned as is.
+				if err := get(s, op); err != nil {
+					return err
+				}
+			}
+			exists[key] = false
+		case types.OpRemoveBinding:
+		default:
+			return fmt.Errorf("unknown batch op type: %s", op.Type)
+		}
+	}
+	return nil
+}
+
+// Apply - Make the writes of the batch one by one. When an entry is replayed
+// the batch may have been applied in part before, so the deletes of records
+// that are already gone are skipped.
+func Apply(s Store, batch types.Batch, replay bool) error {
+	for _, op := range batch.Ops {
+		var err error
+		switch op.Type {
+		case types.OpSetServiceInstance:
+			err = s.SetServiceInstance(op.ID, op.ServiceInstance)
+		case types.OpDeleteServiceInstance:
+			err = s.DeleteServiceInstance(op.ID)
+		case types.OpSetBindInstance:
+			err = s.SetBindInstance(op.ID, op.BindInstance)
+		case types.OpDeleteBindInstance:
+			err = s.DeleteBindInstance(op.ID)
+		case types.OpRemoveBinding:
+			err = removeBinding(s, op.ID, op.BindingID)
+		default:
+			err = fmt.Errorf("unknown batch op type: %s", op.Type)
+		}
+		if err != nil && !(replay && s.IsNotFoundError(err)) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Due - Whether the journal entry is old enough to be replayed.
+func Due(entry types.JournalEntry) bool {
+	return time.Since(entry.Created) >= types.JournalReplayAge
+}
+
+// removeBinding - removes the binding from the stored instance rather than a
+// copy read earlier, so the bindings added in between are kept.
+func removeBinding(s Store, instanceID string, bindingID string) error {
+	for {
+		si, version, err := s.GetServiceInstanceVersion(instanceID)
+		if s.IsNotFoundError(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, ok := si.BindingIDs[bindingID]; !ok {
+			return nil
+		}
+		delete(si.BindingIDs, bindingID)
+		_, err = s.CompareAndSetServiceInstance(instanceID, si, version)
+		if !s.IsConflictError(err) {
+			return err
+		}
+		log.Debugf("service instance [ %s ] changed while removing binding, retrying", instanceID)
+	}
+}
+
+// get - reads the record an op deletes.
+func get(s Store, op types.Op) error {
+	if op.Type == types.OpDeleteServiceInstance {
+		_, err := s.GetServiceInstance(op.ID)
+		return err
+	}
+	_, err := s.GetBindInstance(op.ID)
+	return err
+}
+
+func recordKey(opType types.OpType, id string) string {
+	return fmt.Sprintf("%s/%s", opType, id)
+}
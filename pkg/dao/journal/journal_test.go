@@ -0,0 +1,78 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package journal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+func TestCheck(t *testing.T) {
+	d, _ := memory.NewDao()
+	si := bundle.ServiceInstance{ID: uuid.NewRandom()}
+	d.SetServiceInstance(si.ID.String(), &si)
+
+	ok := types.Batch{Ops: []types.Op{
+		{Type: types.OpSetBindInstance, ID: "b", BindInstance: &bundle.BindInstance{}},
+		{Type: types.OpDeleteBindInstance, ID: "b"},
+		{Type: types.OpDeleteServiceInstance, ID: si.ID.String()},
+	}}
+	ft.AssertNil(t, Check(d, ok))
+
+	missing := types.Batch{Ops: []types.Op{{Type: types.OpDeleteBindInstance, ID: "b"}}}
+	ft.AssertTrue(t, d.IsNotFoundError(Check(d, missing)))
+
+	twice := types.Batch{Ops: []types.Op{
+		{Type: types.OpDeleteServiceInstance, ID: si.ID.String()},
+		{Type: types.OpDeleteServiceInstance, ID: si.ID.String()},
+	}}
+	ft.AssertNotNil(t, Check(d, twice))
+}
+
+func TestApplyReplay(t *testing.T) {
+	d, _ := memory.NewDao()
+	si := bundle.ServiceInstance{ID: uuid.NewRandom()}
+	bi := bundle.BindInstance{ID: uuid.NewRandom(), ServiceID: si.ID}
+	other := uuid.NewRandom()
+	si.AddBinding(bi.ID)
+	si.AddBinding(other)
+	d.SetServiceInstance(si.ID.String(), &si)
+	d.SetBindInstance(bi.ID.String(), &bi)
+
+	batch := types.DeleteBindingBatch(bi.ID.String(), si.ID.String())
+	if err := Apply(d, batch, false); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := d.GetServiceInstance(si.ID.String())
+	ft.AssertEqual(t, 1, len(got.BindingIDs))
+	ft.AssertTrue(t, got.BindingIDs[other.String()])
+
+	// the binding is already gone when the batch is applied again.
+	ft.AssertTrue(t, d.IsNotFoundError(Apply(d, batch, false)))
+	ft.AssertNil(t, Apply(d, batch, true))
+}
+
+func TestDue(t *testing.T) {
+	ft.AssertFalse(t, Due(types.JournalEntry{Created: time.Now()}))
+	ft.AssertTrue(t, Due(types.JournalEntry{Created: time.Now().Add(-2 * types.JournalReplayAge)}))
+}
@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// SetAuditRecord - Store a copy of the audit record in memory.
+func (d *Dao) SetAuditRecord(record types.AuditRecord) error {
+	stored := types.AuditRecord{}
+	if err := clone(record, &stored); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.auditRecords[record.ID] = stored
+	return nil
+}
+
+// DeleteAuditRecord - Forget the audit record held in memory.
+func (d *Dao) DeleteAuditRecord(id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.auditRecords, id)
+	return nil
+}
+
+// BatchGetAuditRecords - Retrieve the audit records held in memory, oldest
+// first.
+func (d *Dao) BatchGetAuditRecords() ([]types.AuditRecord, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	records := make([]types.AuditRecord, 0, len(d.auditRecords))
+	for _, record := range d.auditRecords {
+		copied := types.AuditRecord{}
+		if err := clone(record, &copied); err != nil {
+			return nil, err
+		}
+		records = append(records, copied)
+	}
+	types.SortAuditRecords(records)
+	return records, nil
+}
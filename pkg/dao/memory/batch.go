@@ -0,0 +1,123 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// Apply - Make the writes of the batch under the write lock. They are made on
+// copies of the instance and binding maps that only replace the stored ones
+// once every write succeeded.
+func (d *Dao) Apply(batch types.Batch) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	instances := make(map[string]*bundle.ServiceInstance, len(d.instances))
+	for id, si := range d.instances {
+		instances[id] = si
+	}
+	bindings := make(map[string]*bundle.BindInstance, len(d.bindings))
+	for id, bi := range d.bindings {
+		bindings[id] = bi
+	}
+
+	// written holds whether each key written still exists after the batch.
+	written := map[string]bool{}
+	for _, op := range batch.Ops {
+		switch op.Type {
+		case types.OpSetServiceInstance:
+			si := &bundle.ServiceInstance{}
+			if err := clone(op.ServiceInstance, si); err != nil {
+				return err
+			}
+			si.BindingIDs = removeFalseBindings(si.BindingIDs)
+			instances[op.ID] = si
+			written[serviceInstanceKey(op.ID)] = true
+		case types.OpDeleteServiceInstance:
+			if _, ok := instances[op.ID]; !ok {
+				return NotFoundError{Kind: "service instance", ID: op.ID}
+			}
+			delete(instances, op.ID)
+			written[serviceInstanceKey(op.ID)] = false
+		case types.OpSetBindInstance:
+			bi := &bundle.BindInstance{}
+			if err := clone(op.BindInstance, bi); err != nil {
+				return err
+			}
+			bindings[op.ID] = bi
+			written[bindInstanceKey(op.ID)] = true
+		case types.OpDeleteBindInstance:
+			if _, ok := bindings[op.ID]; !ok {
+				return NotFoundError{Kind: "bind instance", ID: op.ID}
+			}
+			delete(bindings, op.ID)
+			written[bindInstanceKey(op.ID)] = false
+		case types.OpRemoveBinding:
+			stored, ok := instances[op.ID]
+			if !ok {
+				continue
+			}
+			si := &bundle.ServiceInstance{}
+			if err := clone(stored, si); err != nil {
+				return err
+			}
+			delete(si.BindingIDs, op.BindingID)
+			instances[op.ID] = si
+			written[serviceInstanceKey(op.ID)] = true
+		default:
+			return fmt.Errorf("unknown batch op type: %s", op.Type)
+		}
+	}
+
+	d.instances = instances
+	d.bindings = bindings
+	for key, exists := range written {
+		if exists {
+			d.bump(key)
+		} else {
+			delete(d.versions, key)
+		}
+	}
+	for _, intent := range batch.Intents {
+		d.intents[intent.ID] = intent
+	}
+	return nil
+}
+
+// PendingIntents - Retrieve the pending intents held in memory, ordered by id.
+func (d *Dao) PendingIntents() ([]types.Intent, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	intents := make([]types.Intent, 0, len(d.intents))
+	for _, intent := range d.intents {
+		intents = append(intents, intent)
+	}
+	sort.Slice(intents, func(i, j int) bool { return intents[i].ID < intents[j].ID })
+	return intents, nil
+}
+
+// CompleteIntent - Forget the intent held in memory.
+func (d *Dao) CompleteIntent(id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.intents, id)
+	return nil
+}
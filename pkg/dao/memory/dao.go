@@ -0,0 +1,697 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	"github.com/openshift/ansible-service-broker/pkg/dao/watch"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// NotFoundError - Error returned when a record does not exist.
+type NotFoundError struct {
+	Kind string
+	ID   string
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("%s not found: %s", e.Kind, e.ID)
+}
+
+// ConflictError - Error returned when a compare and set finds that the record
+// has been written since the expected version was read.
+type ConflictError struct {
+	Kind string
+	ID   string
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("%s was modified concurrently: %s", e.Kind, e.ID)
+}
+
+// Dao - object to interface with the data store. Everything is held in
+// memory and lost when the process exits, it is intended for the dev broker
+// and for tests that need a working data store.
+type Dao struct {
+	lock      sync.RWMutex
+	specs     map[string]*bundle.Spec
+	instances map[string]*bundle.ServiceInstance
+	bindings  map[string]*bundle.BindInstance
+	// states is keyed by instance or binding id, then by job token.
+	states map[string]map[string]bundle.JobState
+	// stateTimes holds when each job state was last written, keyed by the
+	// job state key.
+	stateTimes map[string]time.Time
+	// versions holds the revision each instance, binding and job state was
+	// last written at, keyed by the record key.
+	versions map[string]string
+	revision uint64
+	// intents holds the pending intents of applied batches, keyed by id.
+	intents map[string]types.Intent
+	// jobs holds the records of the jobs that have not run yet, keyed by token.
+	jobs map[string]types.JobRecord
+	// deadLetters holds the undelivered job messages, keyed by id.
+	deadLetters map[string]types.DeadLetter
+	// auditRecords holds the audit events, keyed by id.
+	auditRecords map[string]types.AuditRecord
+}
+
+// NewDao - Create a new, empty, Dao object
+func NewDao() (*Dao, error) {
+	return &Dao{
+		specs:        map[string]*bundle.Spec{},
+		instances:    map[string]*bundle.ServiceInstance{},
+		bindings:     map[string]*bundle.BindInstance{},
+		states:       map[string]map[string]bundle.JobState{},
+		stateTimes:   map[string]time.Time{},
+		versions:     map[string]string{},
+		intents:      map[string]types.Intent{},
+		jobs:         map[string]types.JobRecord{},
+		deadLetters:  map[string]types.DeadLetter{},
+		auditRecords: map[string]types.AuditRecord{},
+	}, nil
+}
+
+// GetSpec - Retrieve the spec from memory.
+func (d *Dao) GetSpec(id string) (*bundle.Spec, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	s, ok := d.specs[id]
+	if !ok {
+		return nil, NotFoundError{Kind: "spec", ID: id}
+	}
+	spec := &bundle.Spec{}
+	return spec, clone(s, spec)
+}
+
+// SetSpec - set spec for an id in memory.
+func (d *Dao) SetSpec(id string, spec *bundle.Spec) error {
+	s := &bundle.Spec{}
+	if err := clone(spec, s); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.specs[id] = s
+	return nil
+}
+
+// DeleteSpec - Delete the spec for a given spec id.
+func (d *Dao) DeleteSpec(specID string) error {
+	log.Debugf("Dao::DeleteSpec-> [ %s ]", specID)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.specs[specID]; !ok {
+		return NotFoundError{Kind: "spec", ID: specID}
+	}
+	delete(d.specs, specID)
+	return nil
+}
+
+// BatchSetSpecs - set specs based on SpecManifest in memory.
+func (d *Dao) BatchSetSpecs(specs bundle.SpecManifest) error {
+	for id, spec := range specs {
+		if err := d.SetSpec(id, spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchGetSpecs - Retrieve all the specs, ordered by id. The dir is ignored
+// since specs are the only thing kept in the spec store.
+func (d *Dao) BatchGetSpecs(dir string) ([]*bundle.Spec, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	ids := make([]string, 0, len(d.specs))
+	for id := range d.specs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	specs := make([]*bundle.Spec, len(ids))
+	for i, id := range ids {
+		spec := &bundle.Spec{}
+		if err := clone(d.specs[id], spec); err != nil {
+			return nil, err
+		}
+		specs[i] = spec
+	}
+	return specs, nil
+}
+
+// BatchGetBundleInstances - get list of bundleinstances, ordered by id.
+func (d *Dao) BatchGetBundleInstances() ([]*bundle.ServiceInstance, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	ids := make([]string, 0, len(d.instances))
+	for id := range d.instances {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	instances := make([]*bundle.ServiceInstance, len(ids))
+	for i, id := range ids {
+		si := &bundle.ServiceInstance{}
+		if err := clone(d.instances[id], si); err != nil {
+			return nil, err
+		}
+		instances[i] = si
+	}
+	return instances, nil
+}
+
+// BatchGetBindInstances - Retrieve all the bind instances ordered by id.
+func (d *Dao) BatchGetBindInstances() ([]*bundle.BindInstance, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	ids := make([]string, 0, len(d.bindings))
+	for id := range d.bindings {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	bindings := make([]*bundle.BindInstance, len(ids))
+	for i, id := range ids {
+		bi := &bundle.BindInstance{}
+		if err := clone(d.bindings[id], bi); err != nil {
+			return nil, err
+		}
+		bindings[i] = bi
+	}
+	return bindings, nil
+}
+
+// FindServiceInstances - Retrieve the service instances selected by the
+// filter, ordered by id.
+func (d *Dao) FindServiceInstances(filter types.InstanceFilter) ([]*bundle.ServiceInstance, error) {
+	instances, err := d.BatchGetBundleInstances()
+	if err != nil {
+		return nil, err
+	}
+	states, err := d.latestStates(filter.State)
+	if err != nil {
+		return nil, err
+	}
+	found := []*bundle.ServiceInstance{}
+	for _, si := range instances {
+		if filter.Matches(si, states[si.ID.String()]) {
+			found = append(found, si)
+		}
+	}
+	return found, nil
+}
+
+// FindBindInstances - Retrieve the bind instances selected by the filter,
+// ordered by id.
+func (d *Dao) FindBindInstances(filter types.BindingFilter) ([]*bundle.BindInstance, error) {
+	bindings, err := d.BatchGetBindInstances()
+	if err != nil {
+		return nil, err
+	}
+	states, err := d.latestStates(filter.State)
+	if err != nil {
+		return nil, err
+	}
+	found := []*bundle.BindInstance{}
+	for _, bi := range bindings {
+		if filter.Matches(bi, states[bi.ID.String()]) {
+			found = append(found, bi)
+		}
+	}
+	return found, nil
+}
+
+// latestStates - the state of the last job of every id, only read when the
+// filter selects on it.
+func (d *Dao) latestStates(state bundle.State) (map[string]bundle.State, error) {
+	if state == "" {
+		return nil, nil
+	}
+	records, err := d.BatchGetJobStates()
+	if err != nil {
+		return nil, err
+	}
+	return types.LatestStates(records), nil
+}
+
+// Watch - Report the changes made after the watch started. The memory dao
+// is polled for them, like the other backends without a native watch.
+func (d *Dao) Watch(stop <-chan struct{}) (<-chan types.Event, error) {
+	return watch.Poll(d, watch.DefaultPollInterval, stop), nil
+}
+
+// GetSpecsPage - Retrieve up to limit specs ordered by id, starting after
+// the continue token.
+func (d *Dao) GetSpecsPage(continueToken string, limit int) ([]*bundle.Spec, string, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	ids := make([]string, 0, len(d.specs))
+	for id := range d.specs {
+		ids = append(ids, id)
+	}
+	ids, next := page(ids, continueToken, limit)
+
+	specs := make([]*bundle.Spec, len(ids))
+	for i, id := range ids {
+		spec := &bundle.Spec{}
+		if err := clone(d.specs[id], spec); err != nil {
+			return nil, "", err
+		}
+		specs[i] = spec
+	}
+	return specs, next, nil
+}
+
+// GetServiceInstancesPage - Retrieve up to limit service instances ordered by
+// id, starting after the continue token.
+func (d *Dao) GetServiceInstancesPage(continueToken string, limit int) ([]*bundle.ServiceInstance, string, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	ids := make([]string, 0, len(d.instances))
+	for id := range d.instances {
+		ids = append(ids, id)
+	}
+	ids, next := page(ids, continueToken, limit)
+
+	instances := make([]*bundle.ServiceInstance, len(ids))
+	for i, id := range ids {
+		si := &bundle.ServiceInstance{}
+		if err := clone(d.instances[id], si); err != nil {
+			return nil, "", err
+		}
+		instances[i] = si
+	}
+	return instances, next, nil
+}
+
+// page - sorts ids and returns up to limit of them that come after
+// continueToken, along with the token for the next page which is empty after
+// the last id.
+func page(ids []string, continueToken string, limit int) ([]string, string) {
+	sort.Strings(ids)
+	start := sort.SearchStrings(ids, continueToken)
+	if start < len(ids) && continueToken != "" && ids[start] == continueToken {
+		start++
+	}
+	ids = ids[start:]
+	if limit > 0 && len(ids) > limit {
+		return ids[:limit], ids[limit-1]
+	}
+	return ids, ""
+}
+
+// BatchDeleteSpecs - delete the specs from memory.
+func (d *Dao) BatchDeleteSpecs(specs []*bundle.Spec) error {
+	for _, spec := range specs {
+		if err := d.DeleteSpec(spec.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindJobStateByState - Retrieve all the jobs that match the specified state
+func (d *Dao) FindJobStateByState(state bundle.State) ([]bundle.RecoverStatus, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	rss := []bundle.RecoverStatus{}
+	for id, jobs := range d.states {
+		for _, js := range jobs {
+			if js.State == state {
+				rss = append(rss, bundle.RecoverStatus{InstanceID: uuid.Parse(id), State: js})
+			}
+		}
+	}
+	return rss, nil
+}
+
+// GetSvcInstJobsByState - Lookup all jobs of a given state for a specific instance
+func (d *Dao) GetSvcInstJobsByState(instanceID string, state bundle.State) ([]bundle.JobState, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	jobs := []bundle.JobState{}
+	for _, js := range d.states[instanceID] {
+		if js.State == state {
+			jobs = append(jobs, js)
+		}
+	}
+	return jobs, nil
+}
+
+// GetServiceInstance - Retrieve specific service instance from memory.
+func (d *Dao) GetServiceInstance(id string) (*bundle.ServiceInstance, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	s, ok := d.instances[id]
+	if !ok {
+		return nil, NotFoundError{Kind: "service instance", ID: id}
+	}
+	si := &bundle.ServiceInstance{}
+	return si, clone(s, si)
+}
+
+// SetServiceInstance - Set service instance for an id in memory.
+func (d *Dao) SetServiceInstance(id string, serviceInstance *bundle.ServiceInstance) error {
+	si := &bundle.ServiceInstance{}
+	if err := clone(serviceInstance, si); err != nil {
+		return err
+	}
+	si.BindingIDs = removeFalseBindings(si.BindingIDs)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.instances[id] = si
+	d.bump(serviceInstanceKey(id))
+	return nil
+}
+
+// GetServiceInstanceVersion - Retrieve a service instance and the version it
+// was read at from memory.
+func (d *Dao) GetServiceInstanceVersion(id string) (*bundle.ServiceInstance, string, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	s, ok := d.instances[id]
+	if !ok {
+		return nil, "", NotFoundError{Kind: "service instance", ID: id}
+	}
+	si := &bundle.ServiceInstance{}
+	if err := clone(s, si); err != nil {
+		return nil, "", err
+	}
+	return si, d.versions[serviceInstanceKey(id)], nil
+}
+
+// CompareAndSetServiceInstance - Set service instance for an id in memory if
+// it has not changed since version was read.
+func (d *Dao) CompareAndSetServiceInstance(id string, serviceInstance *bundle.ServiceInstance, version string) (string, error) {
+	si := &bundle.ServiceInstance{}
+	if err := clone(serviceInstance, si); err != nil {
+		return "", err
+	}
+	si.BindingIDs = removeFalseBindings(si.BindingIDs)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, exists := d.instances[id]
+	if err := d.checkVersion(serviceInstanceKey(id), exists, version, "service instance", id); err != nil {
+		return "", err
+	}
+	d.instances[id] = si
+	return d.bump(serviceInstanceKey(id)), nil
+}
+
+// removeFalseBindings - drops the bindings that have been removed from the
+// instance, so a deleted binding does not block a deprovision.
+func removeFalseBindings(bindings map[string]bool) map[string]bool {
+	newBindings := map[string]bool{}
+	for k, v := range bindings {
+		if v {
+			newBindings[k] = v
+		}
+	}
+	return newBindings
+}
+
+// DeleteServiceInstance - Delete the service instance for an service instance id.
+func (d *Dao) DeleteServiceInstance(id string) error {
+	log.Debugf("Dao::DeleteServiceInstance -> [ %s ]", id)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.instances[id]; !ok {
+		return NotFoundError{Kind: "service instance", ID: id}
+	}
+	delete(d.instances, id)
+	delete(d.versions, serviceInstanceKey(id))
+	return nil
+}
+
+// GetBindInstance - Retrieve a specific bind instance from memory.
+func (d *Dao) GetBindInstance(id string) (*bundle.BindInstance, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	b, ok := d.bindings[id]
+	if !ok {
+		return nil, NotFoundError{Kind: "bind instance", ID: id}
+	}
+	bi := &bundle.BindInstance{}
+	return bi, clone(b, bi)
+}
+
+// SetBindInstance - Set the bind instance for id in memory.
+func (d *Dao) SetBindInstance(id string, bindInstance *bundle.BindInstance) error {
+	bi := &bundle.BindInstance{}
+	if err := clone(bindInstance, bi); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.bindings[id] = bi
+	d.bump(bindInstanceKey(id))
+	return nil
+}
+
+// GetBindInstanceVersion - Retrieve a bind instance and the version it was
+// read at from memory.
+func (d *Dao) GetBindInstanceVersion(id string) (*bundle.BindInstance, string, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	b, ok := d.bindings[id]
+	if !ok {
+		return nil, "", NotFoundError{Kind: "bind instance", ID: id}
+	}
+	bi := &bundle.BindInstance{}
+	if err := clone(b, bi); err != nil {
+		return nil, "", err
+	}
+	return bi, d.versions[bindInstanceKey(id)], nil
+}
+
+// CompareAndSetBindInstance - Set the bind instance for id in memory if it has
+// not changed since version was read.
+func (d *Dao) CompareAndSetBindInstance(id string, bindInstance *bundle.BindInstance, version string) (string, error) {
+	bi := &bundle.BindInstance{}
+	if err := clone(bindInstance, bi); err != nil {
+		return "", err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, exists := d.bindings[id]
+	if err := d.checkVersion(bindInstanceKey(id), exists, version, "bind instance", id); err != nil {
+		return "", err
+	}
+	d.bindings[id] = bi
+	return d.bump(bindInstanceKey(id)), nil
+}
+
+// DeleteBindInstance - Delete the binding instance for an id in memory.
+func (d *Dao) DeleteBindInstance(id string) error {
+	log.Debugf("Dao::DeleteBindInstance -> [ %s ]", id)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.bindings[id]; !ok {
+		return NotFoundError{Kind: "bind instance", ID: id}
+	}
+	delete(d.bindings, id)
+	delete(d.versions, bindInstanceKey(id))
+	return nil
+}
+
+// DeleteBinding - Delete the binding instance and remove the association with
+// the service instance. Both are applied as one batch, and the binding is
+// removed from the stored instance so concurrent changes to it are kept.
+func (d *Dao) DeleteBinding(bindingInstance bundle.BindInstance, serviceInstance bundle.ServiceInstance) error {
+	return d.Apply(types.DeleteBindingBatch(bindingInstance.ID.String(), serviceInstance.ID.String()))
+}
+
+// SetState - Set the Job State in memory for id.
+func (d *Dao) SetState(id string, state bundle.JobState) (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.states[id] == nil {
+		d.states[id] = map[string]bundle.JobState{}
+	}
+	d.states[id][state.Token] = state
+	d.stateTimes[stateKey(id, state.Token)] = time.Now()
+	d.bump(stateKey(id, state.Token))
+	return stateKey(id, state.Token), nil
+}
+
+// GetStateVersion - Retrieve a job state and the version it was read at from
+// memory.
+func (d *Dao) GetStateVersion(id string, token string) (bundle.JobState, string, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	js, ok := d.states[id][token]
+	if !ok {
+		return bundle.JobState{State: bundle.StateFailed}, "", NotFoundError{Kind: "job state", ID: stateKey(id, token)}
+	}
+	return js, d.versions[stateKey(id, token)], nil
+}
+
+// CompareAndSetState - Set the Job State in memory for id if it has not
+// changed since version was read.
+func (d *Dao) CompareAndSetState(id string, state bundle.JobState, version string) (string, error) {
+	key := stateKey(id, state.Token)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, exists := d.states[id][state.Token]
+	if err := d.checkVersion(key, exists, version, "job state", key); err != nil {
+		return "", err
+	}
+	if d.states[id] == nil {
+		d.states[id] = map[string]bundle.JobState{}
+	}
+	d.states[id][state.Token] = state
+	d.stateTimes[key] = time.Now()
+	return d.bump(key), nil
+}
+
+// DeleteState - Delete the job state for an id and token from memory.
+func (d *Dao) DeleteState(id string, token string) error {
+	key := stateKey(id, token)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.states[id][token]; !ok {
+		return NotFoundError{Kind: "job state", ID: key}
+	}
+	delete(d.states[id], token)
+	if len(d.states[id]) == 0 {
+		delete(d.states, id)
+	}
+	delete(d.stateTimes, key)
+	delete(d.versions, key)
+	return nil
+}
+
+// BatchGetJobStates - Retrieve every job state held in memory, ordered by
+// id and token.
+func (d *Dao) BatchGetJobStates() ([]types.JobStateRecord, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	keys := []string{}
+	for id, jobs := range d.states {
+		for token := range jobs {
+			keys = append(keys, stateKey(id, token))
+		}
+	}
+	sort.Strings(keys)
+
+	records := make([]types.JobStateRecord, len(keys))
+	for i, key := range keys {
+		id, token, _ := parseStateKey(key)
+		records[i] = types.JobStateRecord{
+			ID:           id,
+			State:        d.states[id][token],
+			LastModified: d.stateTimes[key],
+		}
+	}
+	return records, nil
+}
+
+// GetState - Retrieve a job state from memory for an ID and Token.
+func (d *Dao) GetState(id string, token string) (bundle.JobState, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	js, ok := d.states[id][token]
+	if !ok {
+		return bundle.JobState{State: bundle.StateFailed}, NotFoundError{Kind: "job state", ID: stateKey(id, token)}
+	}
+	return js, nil
+}
+
+// GetStateByKey - Retrieve a job state from memory for a job key
+func (d *Dao) GetStateByKey(key string) (bundle.JobState, error) {
+	id, token, ok := parseStateKey(key)
+	if !ok {
+		return bundle.JobState{State: bundle.StateFailed}, NotFoundError{Kind: "job state", ID: key}
+	}
+	return d.GetState(id, token)
+}
+
+// IsNotFoundError - Will determine if an error is a record not found error.
+func (d *Dao) IsNotFoundError(err error) bool {
+	_, ok := err.(NotFoundError)
+	return ok
+}
+
+// IsConflictError - Will determine if an error is a compare and set conflict.
+func (d *Dao) IsConflictError(err error) bool {
+	_, ok := err.(ConflictError)
+	return ok
+}
+
+// checkVersion - verifies a compare and set against the stored version of
+// key. An empty version means the record must not exist yet. The caller must
+// hold the write lock.
+func (d *Dao) checkVersion(key string, exists bool, version string, kind string, id string) error {
+	switch {
+	case version == "" && exists:
+		return ConflictError{Kind: kind, ID: id}
+	case version != "" && !exists:
+		return NotFoundError{Kind: kind, ID: id}
+	case version != "" && d.versions[key] != version:
+		return ConflictError{Kind: kind, ID: id}
+	}
+	return nil
+}
+
+// bump - records a new revision for key and returns it. The caller must hold
+// the write lock.
+func (d *Dao) bump(key string) string {
+	d.revision++
+	d.versions[key] = strconv.FormatUint(d.revision, 10)
+	return d.versions[key]
+}
+
+// clone - deep copies from into to, so that callers can never mutate the
+// records held by the dao.
+func clone(from interface{}, to interface{}) error {
+	payload, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, to)
+}
+
+func stateKey(id string, jobid string) string {
+	return fmt.Sprintf("/state/%s/job/%s", id, jobid)
+}
+
+func serviceInstanceKey(id string) string {
+	return fmt.Sprintf("/service_instance/%s", id)
+}
+
+func bindInstanceKey(id string) string {
+	return fmt.Sprintf("/bind_instance/%s", id)
+}
+
+func parseStateKey(key string) (string, string, bool) {
+	parts := strings.Split(strings.TrimPrefix(key, "/state/"), "/")
+	if len(parts) != 3 || parts[1] != "job" {
+		return "", "", false
+	}
+	return parts[0], parts[2], true
+}
@@ -0,0 +1,367 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+func TestNotFound(t *testing.T) {
+	d, _ := NewDao()
+	_, err := d.GetSpec("missing")
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+	_, err = d.GetServiceInstance("missing")
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+	_, err = d.GetBindInstance("missing")
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+	_, err = d.GetState("missing", "token")
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+	ft.AssertTrue(t, d.IsNotFoundError(d.DeleteServiceInstance("missing")))
+	ft.AssertFalse(t, d.IsNotFoundError(fmt.Errorf("other")))
+}
+
+func TestRecordsAreCopied(t *testing.T) {
+	d, _ := NewDao()
+	id := uuid.NewRandom()
+	params := bundle.Parameters{"a": "b"}
+	si := &bundle.ServiceInstance{ID: id, Parameters: &params}
+	if err := d.SetServiceInstance(id.String(), si); err != nil {
+		t.Fatal(err)
+	}
+	// mutating the callers copy must not change what is stored.
+	(*si.Parameters)["a"] = "changed"
+
+	got, err := d.GetServiceInstance(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, "b", (*got.Parameters)["a"])
+	got.AddBinding(uuid.NewRandom())
+
+	again, _ := d.GetServiceInstance(id.String())
+	ft.AssertEqual(t, 0, len(again.BindingIDs))
+}
+
+func TestJobStates(t *testing.T) {
+	d, _ := NewDao()
+	id := uuid.New()
+	key, err := d.SetState(id, bundle.JobState{Token: "t1", State: bundle.StateInProgress, Method: bundle.JobMethodProvision})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetState(id, bundle.JobState{Token: "t2", State: bundle.StateFailed, Method: bundle.JobMethodUpdate})
+
+	js, err := d.GetStateByKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, "t1", js.Token)
+
+	jobs, _ := d.GetSvcInstJobsByState(id, bundle.StateFailed)
+	ft.AssertEqual(t, 1, len(jobs))
+	ft.AssertEqual(t, "t2", jobs[0].Token)
+
+	rs, _ := d.FindJobStateByState(bundle.StateInProgress)
+	ft.AssertEqual(t, 1, len(rs))
+	ft.AssertEqual(t, id, rs[0].InstanceID.String())
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	d, _ := NewDao()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("spec-%d", i)
+			d.SetSpec(id, &bundle.Spec{ID: id})
+			d.SetState(id, bundle.JobState{Token: id, State: bundle.StateInProgress})
+			d.GetSpec(id)
+			d.BatchGetSpecs("/spec")
+		}(i)
+	}
+	wg.Wait()
+
+	specs, err := d.BatchGetSpecs("/spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 50, len(specs))
+	rs, _ := d.FindJobStateByState(bundle.StateInProgress)
+	ft.AssertEqual(t, 50, len(rs))
+}
+
+func TestCompareAndSet(t *testing.T) {
+	d, _ := NewDao()
+	id := uuid.NewRandom()
+	si := &bundle.ServiceInstance{ID: id}
+
+	version, err := d.CompareAndSetServiceInstance(id.String(), si, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an empty version only creates the record.
+	_, err = d.CompareAndSetServiceInstance(id.String(), si, "")
+	ft.AssertTrue(t, d.IsConflictError(err))
+
+	got, readVersion, err := d.GetServiceInstanceVersion(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, version, readVersion)
+
+	// a plain write moves the version on, so the read copy is now stale.
+	if err := d.SetServiceInstance(id.String(), si); err != nil {
+		t.Fatal(err)
+	}
+	got.DashboardURL = "https://example.com"
+	_, err = d.CompareAndSetServiceInstance(id.String(), got, readVersion)
+	ft.AssertTrue(t, d.IsConflictError(err))
+	ft.AssertFalse(t, d.IsNotFoundError(err))
+
+	_, err = d.CompareAndSetServiceInstance("missing", got, readVersion)
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+
+	key, err := d.SetState(id.String(), bundle.JobState{Token: "t1", State: bundle.StateInProgress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, stateVersion, err := d.GetStateVersion(id.String(), "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	js.State = bundle.StateSucceeded
+	if _, err := d.CompareAndSetState(id.String(), js, stateVersion); err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.CompareAndSetState(id.String(), js, stateVersion)
+	ft.AssertTrue(t, d.IsConflictError(err))
+	js, _ = d.GetStateByKey(key)
+	ft.AssertEqual(t, bundle.StateSucceeded, js.State)
+}
+
+func TestDeleteBindingKeepsOtherBindings(t *testing.T) {
+	d, _ := NewDao()
+	si := bundle.ServiceInstance{ID: uuid.NewRandom()}
+	bi := bundle.BindInstance{ID: uuid.NewRandom(), ServiceID: si.ID}
+	si.AddBinding(bi.ID)
+	d.SetServiceInstance(si.ID.String(), &si)
+	d.SetBindInstance(bi.ID.String(), &bi)
+
+	// another binding is added after the caller read the instance.
+	other := uuid.NewRandom()
+	latest, _ := d.GetServiceInstance(si.ID.String())
+	latest.AddBinding(other)
+	d.SetServiceInstance(si.ID.String(), latest)
+
+	if err := d.DeleteBinding(bi, si); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := d.GetServiceInstance(si.ID.String())
+	ft.AssertEqual(t, 1, len(got.BindingIDs))
+	ft.AssertTrue(t, got.BindingIDs[other.String()])
+}
+
+func TestServiceInstancesPage(t *testing.T) {
+	d, _ := NewDao()
+	ids := []string{}
+	for i := 0; i < 5; i++ {
+		id := uuid.NewRandom()
+		ids = append(ids, id.String())
+		d.SetServiceInstance(id.String(), &bundle.ServiceInstance{ID: id})
+	}
+	sort.Strings(ids)
+
+	page, next, err := d.GetServiceInstancesPage("", 3)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 3, len(page))
+	ft.AssertEqual(t, ids[2], next)
+	ft.AssertEqual(t, ids[0], page[0].ID.String())
+
+	page, next, err = d.GetServiceInstancesPage(next, 3)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 2, len(page))
+	ft.AssertEqual(t, "", next)
+	ft.AssertEqual(t, ids[3], page[0].ID.String())
+}
+
+func TestFind(t *testing.T) {
+	d, _ := NewDao()
+	spec := &bundle.Spec{ID: "spec-1"}
+	ids := []uuid.UUID{uuid.NewRandom(), uuid.NewRandom(), uuid.NewRandom()}
+	for i, id := range ids {
+		params := bundle.Parameters{"_apb_plan_id": "dev"}
+		if i == 2 {
+			params["_apb_plan_id"] = "prod"
+		}
+		ns := "project-a"
+		if i == 1 {
+			ns = "project-b"
+		}
+		d.SetServiceInstance(id.String(), &bundle.ServiceInstance{
+			ID: id, Spec: spec, Context: &bundle.Context{Namespace: ns}, Parameters: &params,
+		})
+	}
+	d.SetState(ids[0].String(), bundle.JobState{Token: "t1", State: bundle.StateInProgress})
+	d.SetState(ids[0].String(), bundle.JobState{Token: "t2", State: bundle.StateSucceeded})
+	d.SetState(ids[2].String(), bundle.JobState{Token: "t3", State: bundle.StateInProgress})
+
+	found, err := d.FindServiceInstances(types.InstanceFilter{SpecID: "spec-1"})
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 3, len(found))
+	found, _ = d.FindServiceInstances(types.InstanceFilter{Namespace: "project-a", PlanID: "dev"})
+	ft.AssertEqual(t, 1, len(found))
+	ft.AssertEqual(t, ids[0].String(), found[0].ID.String())
+	// the state is the one of the job written last.
+	found, _ = d.FindServiceInstances(types.InstanceFilter{State: bundle.StateInProgress})
+	ft.AssertEqual(t, 1, len(found))
+	ft.AssertEqual(t, ids[2].String(), found[0].ID.String())
+	found, _ = d.FindServiceInstances(types.InstanceFilter{SpecID: "spec-2"})
+	ft.AssertEqual(t, 0, len(found))
+
+	bindID := uuid.NewRandom()
+	d.SetBindInstance(bindID.String(), &bundle.BindInstance{ID: bindID, ServiceID: ids[1]})
+	otherID := uuid.NewRandom()
+	d.SetBindInstance(otherID.String(), &bundle.BindInstance{ID: otherID, ServiceID: ids[2]})
+	bindings, err := d.FindBindInstances(types.BindingFilter{ServiceInstanceID: ids[1].String()})
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 1, len(bindings))
+	ft.AssertEqual(t, bindID.String(), bindings[0].ID.String())
+	bindings, _ = d.FindBindInstances(types.BindingFilter{State: bundle.StateFailed})
+	ft.AssertEqual(t, 0, len(bindings))
+}
+
+func TestApply(t *testing.T) {
+	d, _ := NewDao()
+	si := bundle.ServiceInstance{ID: uuid.NewRandom()}
+	d.SetServiceInstance(si.ID.String(), &si)
+
+	// the missing binding fails the batch, the instance must be kept.
+	err := d.Apply(types.Batch{
+		Ops: []types.Op{
+			{Type: types.OpDeleteServiceInstance, ID: si.ID.String()},
+			{Type: types.OpDeleteBindInstance, ID: "missing"},
+		},
+		Intents: []types.Intent{{ID: "1", Action: types.IntentDeleteExtractedCredentials, Target: si.ID.String()}},
+	})
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+	_, err = d.GetServiceInstance(si.ID.String())
+	ft.AssertNil(t, err)
+	intents, _ := d.PendingIntents()
+	ft.AssertEqual(t, 0, len(intents))
+
+	err = d.Apply(types.Batch{
+		Ops:     []types.Op{{Type: types.OpDeleteServiceInstance, ID: si.ID.String()}},
+		Intents: []types.Intent{{ID: "1", Action: types.IntentDeleteExtractedCredentials, Target: si.ID.String()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.GetServiceInstance(si.ID.String())
+	ft.AssertTrue(t, d.IsNotFoundError(err))
+	intents, _ = d.PendingIntents()
+	ft.AssertEqual(t, 1, len(intents))
+	ft.AssertEqual(t, si.ID.String(), intents[0].Target)
+
+	ft.AssertNil(t, d.CompleteIntent("1"))
+	ft.AssertNil(t, d.CompleteIntent("1"))
+	intents, _ = d.PendingIntents()
+	ft.AssertEqual(t, 0, len(intents))
+}
+
+func TestJobRecords(t *testing.T) {
+	d, _ := NewDao()
+	now := time.Now()
+	params := bundle.Parameters{"a": "b"}
+	newer := types.JobRecord{Token: "t2", Method: bundle.JobMethodBind, Parameters: &params, Created: now}
+	older := types.JobRecord{Token: "t1", Method: bundle.JobMethodProvision, Created: now.Add(-time.Minute)}
+	ft.AssertNil(t, d.SetJobRecord(newer))
+	ft.AssertNil(t, d.SetJobRecord(older))
+	// mutating the callers copy must not change what is stored.
+	params["a"] = "changed"
+
+	records, err := d.BatchGetJobRecords()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 2, len(records))
+	ft.AssertEqual(t, "t1", records[0].Token)
+	ft.AssertEqual(t, "t2", records[1].Token)
+	ft.AssertEqual(t, "b", (*records[1].Parameters)["a"])
+
+	ft.AssertNil(t, d.DeleteJobRecord("t1"))
+	ft.AssertNil(t, d.DeleteJobRecord("missing"))
+	records, _ = d.BatchGetJobRecords()
+	ft.AssertEqual(t, 1, len(records))
+}
+
+func TestDeadLetters(t *testing.T) {
+	d, _ := NewDao()
+	now := time.Now()
+	message := map[string]interface{}{"msg": "in progress"}
+	ft.AssertNil(t, d.SetDeadLetter(types.DeadLetter{ID: "l2", Subscriber: "jobstate", Message: message, Created: now}))
+	ft.AssertNil(t, d.SetDeadLetter(types.DeadLetter{ID: "l1", Subscriber: "jobstate", Created: now.Add(-time.Minute)}))
+	// mutating the callers copy must not change what is stored.
+	message["msg"] = "changed"
+
+	letters, err := d.BatchGetDeadLetters()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 2, len(letters))
+	ft.AssertEqual(t, "l1", letters[0].ID)
+	ft.AssertEqual(t, "in progress", letters[1].Message["msg"])
+
+	ft.AssertNil(t, d.DeleteDeadLetter("l1"))
+	ft.AssertNil(t, d.DeleteDeadLetter("missing"))
+	letters, _ = d.BatchGetDeadLetters()
+	ft.AssertEqual(t, 1, len(letters))
+}
+
+func TestAuditRecords(t *testing.T) {
+	d, _ := NewDao()
+	now := time.Now()
+	event := map[string]interface{}{"action": "provision"}
+	ft.AssertNil(t, d.SetAuditRecord(types.AuditRecord{ID: "a2", Time: now, Event: event}))
+	ft.AssertNil(t, d.SetAuditRecord(types.AuditRecord{ID: "a1", Time: now.Add(-time.Minute)}))
+	// mutating the callers copy must not change what is stored.
+	event["action"] = "changed"
+
+	records, err := d.BatchGetAuditRecords()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 2, len(records))
+	ft.AssertEqual(t, "a1", records[0].ID)
+	ft.AssertEqual(t, "provision", records[1].Event["action"])
+
+	ft.AssertNil(t, d.DeleteAuditRecord("a1"))
+	ft.AssertNil(t, d.DeleteAuditRecord("missing"))
+	records, _ = d.BatchGetAuditRecords()
+	ft.AssertEqual(t, 1, len(records))
+}
+
+func TestLeases(t *testing.T) {
+	d, _ := NewDao()
+	_, _, err := d.GetLease("broker")
+	ft.AssertEqual(t, types.ErrLeasesUnsupported, err)
+	_, err = d.CompareAndSetLease(types.Lease{Name: "broker", Holder: "a", Duration: time.Second}, "")
+	ft.AssertEqual(t, types.ErrLeasesUnsupported, err)
+}
@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// SetDeadLetter - Store a copy of the dead letter in memory.
+func (d *Dao) SetDeadLetter(letter types.DeadLetter) error {
+	stored := types.DeadLetter{}
+	if err := clone(letter, &stored); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.deadLetters[letter.ID] = stored
+	return nil
+}
+
+// DeleteDeadLetter - Forget the dead letter held in memory.
+func (d *Dao) DeleteDeadLetter(id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.deadLetters, id)
+	return nil
+}
+
+// BatchGetDeadLetters - Retrieve the dead letters held in memory, oldest
+// first.
+func (d *Dao) BatchGetDeadLetters() ([]types.DeadLetter, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	letters := make([]types.DeadLetter, 0, len(d.deadLetters))
+	for _, letter := range d.deadLetters {
+		copied := types.DeadLetter{}
+		if err := clone(letter, &copied); err != nil {
+			return nil, err
+		}
+		letters = append(letters, copied)
+	}
+	types.SortDeadLetters(letters)
+	return letters, nil
+}
@@ -0,0 +1,53 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+)
+
+// SetJobRecord - Store a copy of the job record in memory.
+func (d *Dao) SetJobRecord(record types.JobRecord) error {
+	stored := types.JobRecord{}
+	if err := clone(record, &stored); err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.jobs[record.Token] = stored
+	return nil
+}
+
+// DeleteJobRecord - Forget the job record held in memory.
+func (d *Dao) DeleteJobRecord(token string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.jobs, token)
+	return nil
+}
+
+// BatchGetJobRecords - Retrieve the job records held in memory, oldest first.
+func (d *Dao) BatchGetJobRecords() ([]types.JobRecord, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	records := make([]types.JobRecord, 0, len(d.jobs))
+	for _, record := range d.jobs {
+		records = append(records, record)
+	}
+	types.SortJobRecords(records)
+	return records, nil
+}
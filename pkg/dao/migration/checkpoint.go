@@ -0,0 +1,108 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint - the records a migration already copied, kept in a file so an
+// interrupted migration can resume where it stopped. A nil Checkpoint
+// remembers nothing.
+type Checkpoint struct {
+	From     string          `json:"from"`
+	To       string          `json:"to"`
+	Migrated map[string]bool `json:"migrated"`
+
+	path string
+}
+
+// LoadCheckpoint - reads the checkpoint at path for a migration from one
+// backend to another, or starts a new one when there is no file yet. A
+// checkpoint written for other backends is an error.
+func LoadCheckpoint(path, from, to string) (*Checkpoint, error) {
+	c := &Checkpoint{From: from, To: to, Migrated: map[string]bool{}, path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("unable to read checkpoint %s - %v", path, err)
+	}
+	if c.From != from || c.To != to {
+		return nil, fmt.Errorf("checkpoint %s is for a migration from %s to %s", path, c.From, c.To)
+	}
+	if c.Migrated == nil {
+		c.Migrated = map[string]bool{}
+	}
+	return c, nil
+}
+
+// Done - whether the record with key was already migrated.
+func (c *Checkpoint) Done(key string) bool {
+	return c != nil && c.Migrated[key]
+}
+
+// Mark - remembers that the record with key was migrated.
+func (c *Checkpoint) Mark(key string) {
+	if c != nil {
+		c.Migrated[key] = true
+	}
+}
+
+// Save - writes the checkpoint. The file is replaced in one step so an
+// interrupted save leaves the previous checkpoint behind.
+func (c *Checkpoint) Save() error {
+	if c == nil {
+		return nil
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// Remove - deletes the checkpoint file once the migration is complete.
+func (c *Checkpoint) Remove() error {
+	if c == nil {
+		return nil
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
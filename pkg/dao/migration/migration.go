@@ -0,0 +1,329 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package migration copies the broker state from one dao backend to another.
+package migration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao"
+	log "github.com/sirupsen/logrus"
+)
+
+// checkpointInterval - how many records are migrated between checkpoint saves.
+const checkpointInterval = 50
+
+// ConflictMode - what to do with a record that is different in the target.
+type ConflictMode string
+
+const (
+	// Overwrite - replace the target record, the default.
+	Overwrite ConflictMode = "overwrite"
+	// Skip - keep the target record.
+	Skip ConflictMode = "skip"
+	// Fail - write nothing when any record is different in the target.
+	Fail ConflictMode = "fail"
+)
+
+// ParseConflictMode - the ConflictMode named s.
+func ParseConflictMode(s string) (ConflictMode, error) {
+	switch m := ConflictMode(s); m {
+	case Overwrite, Skip, Fail:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown conflict mode %s, use overwrite, skip or fail", s)
+}
+
+// ConflictError - the records that are different in the target when
+// migrating with the Fail ConflictMode.
+type ConflictError struct {
+	Keys []string
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("%d records are different in the target: %s", len(e.Keys), strings.Join(e.Keys, ", "))
+}
+
+// Migrator - copies specs, service instances, bind instances and job states
+// from Source to Target, in that order so the records a record refers to are
+// there before it. Records that are already the same in Target are skipped,
+// so a migration can be run again until it succeeds.
+type Migrator struct {
+	Source dao.Dao
+	Target dao.Dao
+	// DryRun - only report what would be written.
+	DryRun bool
+	// Checkpoint - remembers the migrated records, may be nil.
+	Checkpoint *Checkpoint
+	// OnConflict - what to do with records that are different in Target,
+	// they are overwritten when it is empty.
+	OnConflict ConflictMode
+}
+
+// Report - the number of records by what the migration did with them.
+type Report struct {
+	Created int
+	Updated int
+	Skipped int
+	Failed  int
+}
+
+// Difference - a source record that is missing or different in the target.
+type Difference struct {
+	Key    string
+	Reason string
+}
+
+// Missing - the Reason of a Difference for a record the target does not have.
+const Missing = "missing"
+
+// record - a source record and how to read and write it in a backend.
+type record struct {
+	key   string
+	value interface{}
+	read  func(d dao.Dao) (interface{}, error)
+	write func(d dao.Dao) error
+}
+
+// Run - migrates every source record. Records that can not be written are
+// logged and counted as failed, listing the source stops the migration.
+func (m *Migrator) Run() (Report, error) {
+	report := Report{}
+	if m.OnConflict == Fail {
+		if err := m.checkConflicts(); err != nil {
+			return report, err
+		}
+	}
+	pending := 0
+	err := m.forEachRecord(func(r record) error {
+		if m.Checkpoint.Done(r.key) {
+			report.Skipped++
+			return nil
+		}
+		current, err := r.read(m.Target)
+		exists := err == nil
+		if err != nil && !m.Target.IsNotFoundError(err) {
+			log.Errorf("Unable to read %s from the target - %v", r.key, err)
+			report.Failed++
+			return nil
+		}
+		if exists && same(current, r.value) {
+			log.Debugf("Skipping %s, it is already migrated", r.key)
+			report.Skipped++
+			m.Checkpoint.Mark(r.key)
+			return nil
+		}
+		if exists && m.OnConflict == Skip {
+			log.Infof("Skipping %s, it is different in the target", r.key)
+			report.Skipped++
+			return nil
+		}
+
+		if m.DryRun {
+			log.Infof("Would write %s", r.key)
+		} else if err := r.write(m.Target); err != nil {
+			log.Errorf("Unable to write %s to the target - %v", r.key, err)
+			report.Failed++
+			return nil
+		} else {
+			log.Debugf("Wrote %s", r.key)
+			m.Checkpoint.Mark(r.key)
+			pending++
+		}
+		if exists {
+			report.Updated++
+		} else {
+			report.Created++
+		}
+
+		if pending >= checkpointInterval {
+			pending = 0
+			return m.saveCheckpoint()
+		}
+		return nil
+	})
+	if err != nil {
+		if saveErr := m.saveCheckpoint(); saveErr != nil {
+			log.Errorf("Unable to save the migration checkpoint - %v", saveErr)
+		}
+		return report, err
+	}
+	return report, m.saveCheckpoint()
+}
+
+// Verify - compares every source record with the target.
+func (m *Migrator) Verify() ([]Difference, error) {
+	diffs := []Difference{}
+	err := m.forEachRecord(func(r record) error {
+		current, err := r.read(m.Target)
+		switch {
+		case m.Target.IsNotFoundError(err):
+			diffs = append(diffs, Difference{Key: r.key, Reason: Missing})
+		case err != nil:
+			diffs = append(diffs, Difference{Key: r.key, Reason: fmt.Sprintf("unreadable - %v", err)})
+		case !same(current, r.value):
+			diffs = append(diffs, Difference{Key: r.key, Reason: "different"})
+		}
+		return nil
+	})
+	return diffs, err
+}
+
+// checkConflicts - returns a ConflictError when any record is different in
+// the target.
+func (m *Migrator) checkConflicts() error {
+	diffs, err := m.Verify()
+	if err != nil {
+		return err
+	}
+	keys := []string{}
+	for _, d := range diffs {
+		if d.Reason != Missing {
+			keys = append(keys, d.Key)
+		}
+	}
+	if len(keys) > 0 {
+		return ConflictError{Keys: keys}
+	}
+	return nil
+}
+
+func (m *Migrator) saveCheckpoint() error {
+	if m.DryRun {
+		return nil
+	}
+	return m.Checkpoint.Save()
+}
+
+// forEachRecord - calls fn with every source record.
+func (m *Migrator) forEachRecord(fn func(record) error) error {
+	err := dao.ForEachSpecPage(m.Source, dao.DefaultPageSize, func(specs []*bundle.Spec) error {
+		for _, s := range specs {
+			if err := fn(specRecord(s)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list the specs - %v", err)
+	}
+
+	err = dao.ForEachServiceInstancePage(m.Source, dao.DefaultPageSize, func(instances []*bundle.ServiceInstance) error {
+		for _, si := range instances {
+			if err := fn(serviceInstanceRecord(si)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list the service instances - %v", err)
+	}
+
+	bindings, err := m.Source.BatchGetBindInstances()
+	if err != nil {
+		return fmt.Errorf("unable to list the bind instances - %v", err)
+	}
+	for _, bi := range bindings {
+		if err := fn(bindInstanceRecord(bi)); err != nil {
+			return err
+		}
+	}
+
+	states, err := m.Source.BatchGetJobStates()
+	if err != nil {
+		return fmt.Errorf("unable to list the job states - %v", err)
+	}
+	for _, s := range states {
+		if err := fn(jobStateRecord(s.ID, s.State)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func specRecord(s *bundle.Spec) record {
+	return record{
+		key:   "spec/" + s.ID,
+		value: s,
+		read: func(d dao.Dao) (interface{}, error) {
+			return d.GetSpec(s.ID)
+		},
+		write: func(d dao.Dao) error {
+			return d.SetSpec(s.ID, s)
+		},
+	}
+}
+
+func serviceInstanceRecord(si *bundle.ServiceInstance) record {
+	id := si.ID.String()
+	return record{
+		key:   "service_instance/" + id,
+		value: si,
+		read: func(d dao.Dao) (interface{}, error) {
+			return d.GetServiceInstance(id)
+		},
+		write: func(d dao.Dao) error {
+			return d.SetServiceInstance(id, si)
+		},
+	}
+}
+
+func bindInstanceRecord(bi *bundle.BindInstance) record {
+	id := bi.ID.String()
+	return record{
+		key:   "bind_instance/" + id,
+		value: bi,
+		read: func(d dao.Dao) (interface{}, error) {
+			return d.GetBindInstance(id)
+		},
+		write: func(d dao.Dao) error {
+			return d.SetBindInstance(id, bi)
+		},
+	}
+}
+
+func jobStateRecord(id string, state bundle.JobState) record {
+	return record{
+		key:   "state/" + id + "/" + state.Token,
+		value: state,
+		read: func(d dao.Dao) (interface{}, error) {
+			return d.GetState(id, state.Token)
+		},
+		write: func(d dao.Dao) error {
+			_, err := d.SetState(id, state)
+			return err
+		},
+	}
+}
+
+// same - whether two records are stored the same way.
+func same(a, b interface{}) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(x) == string(y)
+}
@@ -0,0 +1,119 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package migration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+func newSource(t *testing.T) *memory.Dao {
+	d, _ := memory.NewDao()
+	spec := &bundle.Spec{ID: "spec-1", FQName: "test-apb"}
+	d.SetSpec(spec.ID, spec)
+	siID := uuid.NewRandom()
+	biID := uuid.NewRandom()
+	d.SetServiceInstance(siID.String(), &bundle.ServiceInstance{
+		ID: siID, Spec: spec, BindingIDs: map[string]bool{biID.String(): true},
+	})
+	d.SetBindInstance(biID.String(), &bundle.BindInstance{ID: biID, ServiceID: siID})
+	d.SetState(siID.String(), bundle.JobState{Token: "token-1", State: bundle.StateSucceeded, Method: bundle.JobMethodProvision})
+	return d
+}
+
+func TestMigrate(t *testing.T) {
+	source := newSource(t)
+	target, _ := memory.NewDao()
+	m := &Migrator{Source: source, Target: target}
+
+	report, err := m.Run()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, Report{Created: 4}, report)
+	diffs, err := m.Verify()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 0, len(diffs))
+
+	// a second run finds everything migrated.
+	report, err = m.Run()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, Report{Skipped: 4}, report)
+
+	// and the migration can go back the other way.
+	source.SetSpec("spec-1", &bundle.Spec{ID: "spec-1", FQName: "changed-apb"})
+	back := &Migrator{Source: target, Target: source}
+	report, err = back.Run()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, Report{Updated: 1, Skipped: 3}, report)
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	target, _ := memory.NewDao()
+	m := &Migrator{Source: newSource(t), Target: target, DryRun: true}
+
+	report, err := m.Run()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, Report{Created: 4}, report)
+	specs, _ := target.BatchGetSpecs("/spec")
+	ft.AssertEqual(t, 0, len(specs))
+
+	diffs, err := m.Verify()
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 4, len(diffs))
+	ft.AssertEqual(t, Difference{Key: "spec/spec-1", Reason: "missing"}, diffs[0])
+}
+
+func TestMigrateCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	c, err := LoadCheckpoint(path, "etcd", "crd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := memory.NewDao()
+	m := &Migrator{Source: newSource(t), Target: target, Checkpoint: c}
+	if _, err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a resumed migration does not look at the records it already wrote.
+	c, err = LoadCheckpoint(path, "etcd", "crd")
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, 4, len(c.Migrated))
+	target.DeleteSpec("spec-1")
+	m.Checkpoint = c
+	report, _ := m.Run()
+	ft.AssertEqual(t, Report{Skipped: 4}, report)
+
+	_, err = LoadCheckpoint(path, "crd", "etcd")
+	ft.AssertNotNil(t, err)
+
+	ft.AssertNil(t, c.Remove())
+	_, err = os.Stat(path)
+	ft.AssertTrue(t, os.IsNotExist(err))
+}
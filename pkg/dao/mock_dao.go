@@ -6,12 +6,27 @@ package dao
 
 import apb "github.com/automationbroker/bundle-lib/bundle"
 import mock "github.com/stretchr/testify/mock"
+import types "github.com/openshift/ansible-service-broker/pkg/dao/types"
 
 // MockDao is an autogenerated mock type for the Dao type
 type MockDao struct {
 	mock.Mock
 }
 
+// Apply provides a mock function with given fields: _a0
+func (_m *MockDao) Apply(_a0 types.Batch) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(types.Batch) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // BatchDeleteSpecs provides a mock function with given fields: _a0
 func (_m *MockDao) BatchDeleteSpecs(_a0 []*apb.Spec) error {
 	ret := _m.Called(_a0)
@@ -26,6 +41,52 @@ func (_m *MockDao) BatchDeleteSpecs(_a0 []*apb.Spec) error {
 	return r0
 }
 
+// BatchGetAuditRecords provides a mock function with given fields:
+func (_m *MockDao) BatchGetAuditRecords() ([]types.AuditRecord, error) {
+	ret := _m.Called()
+
+	var r0 []types.AuditRecord
+	if rf, ok := ret.Get(0).(func() []types.AuditRecord); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.AuditRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BatchGetBindInstances provides a mock function with given fields:
+func (_m *MockDao) BatchGetBindInstances() ([]*apb.BindInstance, error) {
+	ret := _m.Called()
+
+	var r0 []*apb.BindInstance
+	if rf, ok := ret.Get(0).(func() []*apb.BindInstance); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*apb.BindInstance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // BatchGetBundleInstances provides a mock function with given fields:
 func (_m *MockDao) BatchGetBundleInstances() ([]*apb.ServiceInstance, error) {
 	ret := _m.Called()
@@ -49,6 +110,75 @@ func (_m *MockDao) BatchGetBundleInstances() ([]*apb.ServiceInstance, error) {
 	return r0, r1
 }
 
+// BatchGetDeadLetters provides a mock function with given fields:
+func (_m *MockDao) BatchGetDeadLetters() ([]types.DeadLetter, error) {
+	ret := _m.Called()
+
+	var r0 []types.DeadLetter
+	if rf, ok := ret.Get(0).(func() []types.DeadLetter); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.DeadLetter)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BatchGetJobRecords provides a mock function with given fields:
+func (_m *MockDao) BatchGetJobRecords() ([]types.JobRecord, error) {
+	ret := _m.Called()
+
+	var r0 []types.JobRecord
+	if rf, ok := ret.Get(0).(func() []types.JobRecord); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.JobRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BatchGetJobStates provides a mock function with given fields:
+func (_m *MockDao) BatchGetJobStates() ([]types.JobStateRecord, error) {
+	ret := _m.Called()
+
+	var r0 []types.JobStateRecord
+	if rf, ok := ret.Get(0).(func() []types.JobStateRecord); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.JobStateRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // BatchGetSpecs provides a mock function with given fields: _a0
 func (_m *MockDao) BatchGetSpecs(_a0 string) ([]*apb.Spec, error) {
 	ret := _m.Called(_a0)
@@ -86,6 +216,118 @@ func (_m *MockDao) BatchSetSpecs(_a0 apb.SpecManifest) error {
 	return r0
 }
 
+// CompareAndSetBindInstance provides a mock function with given fields: _a0, _a1, _a2
+func (_m *MockDao) CompareAndSetBindInstance(_a0 string, _a1 *apb.BindInstance, _a2 string) (string, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, *apb.BindInstance, string) string); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, *apb.BindInstance, string) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CompareAndSetLease provides a mock function with given fields: _a0, _a1
+func (_m *MockDao) CompareAndSetLease(_a0 types.Lease, _a1 string) (string, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(types.Lease, string) string); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(types.Lease, string) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CompareAndSetServiceInstance provides a mock function with given fields: _a0, _a1, _a2
+func (_m *MockDao) CompareAndSetServiceInstance(_a0 string, _a1 *apb.ServiceInstance, _a2 string) (string, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, *apb.ServiceInstance, string) string); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, *apb.ServiceInstance, string) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CompareAndSetState provides a mock function with given fields: _a0, _a1, _a2
+func (_m *MockDao) CompareAndSetState(_a0 string, _a1 apb.JobState, _a2 string) (string, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, apb.JobState, string) string); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, apb.JobState, string) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CompleteIntent provides a mock function with given fields: _a0
+func (_m *MockDao) CompleteIntent(_a0 string) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteAuditRecord provides a mock function with given fields: _a0
+func (_m *MockDao) DeleteAuditRecord(_a0 string) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteBindInstance provides a mock function with given fields: _a0
 func (_m *MockDao) DeleteBindInstance(_a0 string) error {
 	ret := _m.Called(_a0)
@@ -114,6 +356,34 @@ func (_m *MockDao) DeleteBinding(_a0 apb.BindInstance, _a1 apb.ServiceInstance)
 	return r0
 }
 
+// DeleteDeadLetter provides a mock function with given fields: _a0
+func (_m *MockDao) DeleteDeadLetter(_a0 string) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteJobRecord provides a mock function with given fields: _a0
+func (_m *MockDao) DeleteJobRecord(_a0 string) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteServiceInstance provides a mock function with given fields: _a0
 func (_m *MockDao) DeleteServiceInstance(_a0 string) error {
 	ret := _m.Called(_a0)
@@ -142,6 +412,43 @@ func (_m *MockDao) DeleteSpec(_a0 string) error {
 	return r0
 }
 
+// DeleteState provides a mock function with given fields: _a0, _a1
+func (_m *MockDao) DeleteState(_a0 string, _a1 string) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FindBindInstances provides a mock function with given fields: _a0
+func (_m *MockDao) FindBindInstances(_a0 types.BindingFilter) ([]*apb.BindInstance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 []*apb.BindInstance
+	if rf, ok := ret.Get(0).(func(types.BindingFilter) []*apb.BindInstance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*apb.BindInstance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(types.BindingFilter) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FindJobStateByState provides a mock function with given fields: _a0
 func (_m *MockDao) FindJobStateByState(_a0 apb.State) ([]apb.RecoverStatus, error) {
 	ret := _m.Called(_a0)
@@ -165,6 +472,29 @@ func (_m *MockDao) FindJobStateByState(_a0 apb.State) ([]apb.RecoverStatus, erro
 	return r0, r1
 }
 
+// FindServiceInstances provides a mock function with given fields: _a0
+func (_m *MockDao) FindServiceInstances(_a0 types.InstanceFilter) ([]*apb.ServiceInstance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 []*apb.ServiceInstance
+	if rf, ok := ret.Get(0).(func(types.InstanceFilter) []*apb.ServiceInstance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*apb.ServiceInstance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(types.InstanceFilter) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetBindInstance provides a mock function with given fields: _a0
 func (_m *MockDao) GetBindInstance(_a0 string) (*apb.BindInstance, error) {
 	ret := _m.Called(_a0)
@@ -188,6 +518,64 @@ func (_m *MockDao) GetBindInstance(_a0 string) (*apb.BindInstance, error) {
 	return r0, r1
 }
 
+// GetBindInstanceVersion provides a mock function with given fields: _a0
+func (_m *MockDao) GetBindInstanceVersion(_a0 string) (*apb.BindInstance, string, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *apb.BindInstance
+	if rf, ok := ret.Get(0).(func(string) *apb.BindInstance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*apb.BindInstance)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string) string); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string) error); ok {
+		r2 = rf(_a0)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetLease provides a mock function with given fields: _a0
+func (_m *MockDao) GetLease(_a0 string) (types.Lease, string, error) {
+	ret := _m.Called(_a0)
+
+	var r0 types.Lease
+	if rf, ok := ret.Get(0).(func(string) types.Lease); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(types.Lease)
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string) string); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string) error); ok {
+		r2 = rf(_a0)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetServiceInstance provides a mock function with given fields: _a0
 func (_m *MockDao) GetServiceInstance(_a0 string) (*apb.ServiceInstance, error) {
 	ret := _m.Called(_a0)
@@ -211,6 +599,66 @@ func (_m *MockDao) GetServiceInstance(_a0 string) (*apb.ServiceInstance, error)
 	return r0, r1
 }
 
+// GetServiceInstanceVersion provides a mock function with given fields: _a0
+func (_m *MockDao) GetServiceInstanceVersion(_a0 string) (*apb.ServiceInstance, string, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *apb.ServiceInstance
+	if rf, ok := ret.Get(0).(func(string) *apb.ServiceInstance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*apb.ServiceInstance)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string) string); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string) error); ok {
+		r2 = rf(_a0)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetServiceInstancesPage provides a mock function with given fields: _a0, _a1
+func (_m *MockDao) GetServiceInstancesPage(_a0 string, _a1 int) ([]*apb.ServiceInstance, string, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 []*apb.ServiceInstance
+	if rf, ok := ret.Get(0).(func(string, int) []*apb.ServiceInstance); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*apb.ServiceInstance)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string, int) string); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string, int) error); ok {
+		r2 = rf(_a0, _a1)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetSpec provides a mock function with given fields: _a0
 func (_m *MockDao) GetSpec(_a0 string) (*apb.Spec, error) {
 	ret := _m.Called(_a0)
@@ -234,6 +682,36 @@ func (_m *MockDao) GetSpec(_a0 string) (*apb.Spec, error) {
 	return r0, r1
 }
 
+// GetSpecsPage provides a mock function with given fields: _a0, _a1
+func (_m *MockDao) GetSpecsPage(_a0 string, _a1 int) ([]*apb.Spec, string, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 []*apb.Spec
+	if rf, ok := ret.Get(0).(func(string, int) []*apb.Spec); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*apb.Spec)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string, int) string); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string, int) error); ok {
+		r2 = rf(_a0, _a1)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetState provides a mock function with given fields: _a0, _a1
 func (_m *MockDao) GetState(_a0 string, _a1 string) (apb.JobState, error) {
 	ret := _m.Called(_a0, _a1)
@@ -276,6 +754,34 @@ func (_m *MockDao) GetStateByKey(key string) (apb.JobState, error) {
 	return r0, r1
 }
 
+// GetStateVersion provides a mock function with given fields: _a0, _a1
+func (_m *MockDao) GetStateVersion(_a0 string, _a1 string) (apb.JobState, string, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 apb.JobState
+	if rf, ok := ret.Get(0).(func(string, string) apb.JobState); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Get(0).(apb.JobState)
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string, string) string); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string, string) error); ok {
+		r2 = rf(_a0, _a1)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetSvcInstJobsByState provides a mock function with given fields: _a0, _a1
 func (_m *MockDao) GetSvcInstJobsByState(_a0 string, _a1 apb.State) ([]apb.JobState, error) {
 	ret := _m.Called(_a0, _a1)
@@ -299,6 +805,20 @@ func (_m *MockDao) GetSvcInstJobsByState(_a0 string, _a1 apb.State) ([]apb.JobSt
 	return r0, r1
 }
 
+// IsConflictError provides a mock function with given fields: err
+func (_m *MockDao) IsConflictError(err error) bool {
+	ret := _m.Called(err)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(error) bool); ok {
+		r0 = rf(err)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
 // IsNotFoundError provides a mock function with given fields: err
 func (_m *MockDao) IsNotFoundError(err error) bool {
 	ret := _m.Called(err)
@@ -313,6 +833,43 @@ func (_m *MockDao) IsNotFoundError(err error) bool {
 	return r0
 }
 
+// PendingIntents provides a mock function with given fields:
+func (_m *MockDao) PendingIntents() ([]types.Intent, error) {
+	ret := _m.Called()
+
+	var r0 []types.Intent
+	if rf, ok := ret.Get(0).(func() []types.Intent); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.Intent)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetAuditRecord provides a mock function with given fields: _a0
+func (_m *MockDao) SetAuditRecord(_a0 types.AuditRecord) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(types.AuditRecord) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SetBindInstance provides a mock function with given fields: _a0, _a1
 func (_m *MockDao) SetBindInstance(_a0 string, _a1 *apb.BindInstance) error {
 	ret := _m.Called(_a0, _a1)
@@ -327,6 +884,34 @@ func (_m *MockDao) SetBindInstance(_a0 string, _a1 *apb.BindInstance) error {
 	return r0
 }
 
+// SetDeadLetter provides a mock function with given fields: _a0
+func (_m *MockDao) SetDeadLetter(_a0 types.DeadLetter) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(types.DeadLetter) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetJobRecord provides a mock function with given fields: _a0
+func (_m *MockDao) SetJobRecord(_a0 types.JobRecord) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(types.JobRecord) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SetServiceInstance provides a mock function with given fields: _a0, _a1
 func (_m *MockDao) SetServiceInstance(_a0 string, _a1 *apb.ServiceInstance) error {
 	ret := _m.Called(_a0, _a1)
@@ -375,3 +960,26 @@ func (_m *MockDao) SetState(_a0 string, _a1 apb.JobState) (string, error) {
 
 	return r0, r1
 }
+
+// Watch provides a mock function with given fields: _a0
+func (_m *MockDao) Watch(_a0 <-chan struct{}) (<-chan types.Event, error) {
+	ret := _m.Called(_a0)
+
+	var r0 <-chan types.Event
+	if rf, ok := ret.Get(0).(func(<-chan struct{}) <-chan types.Event); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan types.Event)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(<-chan struct{}) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
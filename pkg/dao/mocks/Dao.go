@@ -5,11 +5,27 @@ import bundle "github.com/automationbroker/bundle-lib/bundle"
 
 import mock "github.com/stretchr/testify/mock"
 
+import types "github.com/openshift/ansible-service-broker/pkg/dao/types"
+
 // Dao is an autogenerated mock type for the Dao type
 type Dao struct {
 	mock.Mock
 }
 
+// Apply provides a mock function with given fields: _a0
+func (_m *Dao) Apply(_a0 types.Batch) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(types.Batch) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // BatchDeleteSpecs provides a mock function with given fields: _a0
 func (_m *Dao) BatchDeleteSpecs(_a0 []*bundle.Spec) error {
 	ret := _m.Called(_a0)
@@ -24,6 +40,52 @@ func (_m *Dao) BatchDeleteSpecs(_a0 []*bundle.Spec) error {
 	return r0
 }
 
+// BatchGetAuditRecords provides a mock function with given fields:
+func (_m *Dao) BatchGetAuditRecords() ([]types.AuditRecord, error) {
+	ret := _m.Called()
+
+	var r0 []types.AuditRecord
+	if rf, ok := ret.Get(0).(func() []types.AuditRecord); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.AuditRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BatchGetBindInstances provides a mock function with given fields:
+func (_m *Dao) BatchGetBindInstances() ([]*bundle.BindInstance, error) {
+	ret := _m.Called()
+
+	var r0 []*bundle.BindInstance
+	if rf, ok := ret.Get(0).(func() []*bundle.BindInstance); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*bundle.BindInstance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // BatchGetBundleInstances provides a mock function with given fields:
 func (_m *Dao) BatchGetBundleInstances() ([]*bundle.ServiceInstance, error) {
 	ret := _m.Called()
@@ -47,6 +109,75 @@ func (_m *Dao) BatchGetBundleInstances() ([]*bundle.ServiceInstance, error) {
 	return r0, r1
 }
 
+// BatchGetDeadLetters provides a mock function with given fields:
+func (_m *Dao) BatchGetDeadLetters() ([]types.DeadLetter, error) {
+	ret := _m.Called()
+
+	var r0 []types.DeadLetter
+	if rf, ok := ret.Get(0).(func() []types.DeadLetter); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.DeadLetter)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BatchGetJobRecords provides a mock function with given fields:
+func (_m *Dao) BatchGetJobRecords() ([]types.JobRecord, error) {
+	ret := _m.Called()
+
+	var r0 []types.JobRecord
+	if rf, ok := ret.Get(0).(func() []types.JobRecord); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.JobRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BatchGetJobStates provides a mock function with given fields:
+func (_m *Dao) BatchGetJobStates() ([]types.JobStateRecord, error) {
+	ret := _m.Called()
+
+	var r0 []types.JobStateRecord
+	if rf, ok := ret.Get(0).(func() []types.JobStateRecord); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.JobStateRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // BatchGetSpecs provides a mock function with given fields: _a0
 func (_m *Dao) BatchGetSpecs(_a0 string) ([]*bundle.Spec, error) {
 	ret := _m.Called(_a0)
@@ -84,6 +215,118 @@ func (_m *Dao) BatchSetSpecs(_a0 bundle.SpecManifest) error {
 	return r0
 }
 
+// CompareAndSetBindInstance provides a mock function with given fields: _a0, _a1, _a2
+func (_m *Dao) CompareAndSetBindInstance(_a0 string, _a1 *bundle.BindInstance, _a2 string) (string, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, *bundle.BindInstance, string) string); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, *bundle.BindInstance, string) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CompareAndSetLease provides a mock function with given fields: _a0, _a1
+func (_m *Dao) CompareAndSetLease(_a0 types.Lease, _a1 string) (string, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(types.Lease, string) string); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(types.Lease, string) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CompareAndSetServiceInstance provides a mock function with given fields: _a0, _a1, _a2
+func (_m *Dao) CompareAndSetServiceInstance(_a0 string, _a1 *bundle.ServiceInstance, _a2 string) (string, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, *bundle.ServiceInstance, string) string); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, *bundle.ServiceInstance, string) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CompareAndSetState provides a mock function with given fields: _a0, _a1, _a2
+func (_m *Dao) CompareAndSetState(_a0 string, _a1 bundle.JobState, _a2 string) (string, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, bundle.JobState, string) string); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, bundle.JobState, string) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CompleteIntent provides a mock function with given fields: _a0
+func (_m *Dao) CompleteIntent(_a0 string) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteAuditRecord provides a mock function with given fields: _a0
+func (_m *Dao) DeleteAuditRecord(_a0 string) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteBindInstance provides a mock function with given fields: _a0
 func (_m *Dao) DeleteBindInstance(_a0 string) error {
 	ret := _m.Called(_a0)
@@ -112,6 +355,34 @@ func (_m *Dao) DeleteBinding(_a0 bundle.BindInstance, _a1 bundle.ServiceInstance
 	return r0
 }
 
+// DeleteDeadLetter provides a mock function with given fields: _a0
+func (_m *Dao) DeleteDeadLetter(_a0 string) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteJobRecord provides a mock function with given fields: _a0
+func (_m *Dao) DeleteJobRecord(_a0 string) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteServiceInstance provides a mock function with given fields: _a0
 func (_m *Dao) DeleteServiceInstance(_a0 string) error {
 	ret := _m.Called(_a0)
@@ -140,6 +411,43 @@ func (_m *Dao) DeleteSpec(_a0 string) error {
 	return r0
 }
 
+// DeleteState provides a mock function with given fields: _a0, _a1
+func (_m *Dao) DeleteState(_a0 string, _a1 string) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FindBindInstances provides a mock function with given fields: _a0
+func (_m *Dao) FindBindInstances(_a0 types.BindingFilter) ([]*bundle.BindInstance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 []*bundle.BindInstance
+	if rf, ok := ret.Get(0).(func(types.BindingFilter) []*bundle.BindInstance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*bundle.BindInstance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(types.BindingFilter) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FindJobStateByState provides a mock function with given fields: _a0
 func (_m *Dao) FindJobStateByState(_a0 bundle.State) ([]bundle.RecoverStatus, error) {
 	ret := _m.Called(_a0)
@@ -163,6 +471,29 @@ func (_m *Dao) FindJobStateByState(_a0 bundle.State) ([]bundle.RecoverStatus, er
 	return r0, r1
 }
 
+// FindServiceInstances provides a mock function with given fields: _a0
+func (_m *Dao) FindServiceInstances(_a0 types.InstanceFilter) ([]*bundle.ServiceInstance, error) {
+	ret := _m.Called(_a0)
+
+	var r0 []*bundle.ServiceInstance
+	if rf, ok := ret.Get(0).(func(types.InstanceFilter) []*bundle.ServiceInstance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*bundle.ServiceInstance)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(types.InstanceFilter) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetBindInstance provides a mock function with given fields: _a0
 func (_m *Dao) GetBindInstance(_a0 string) (*bundle.BindInstance, error) {
 	ret := _m.Called(_a0)
@@ -186,6 +517,64 @@ func (_m *Dao) GetBindInstance(_a0 string) (*bundle.BindInstance, error) {
 	return r0, r1
 }
 
+// GetBindInstanceVersion provides a mock function with given fields: _a0
+func (_m *Dao) GetBindInstanceVersion(_a0 string) (*bundle.BindInstance, string, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *bundle.BindInstance
+	if rf, ok := ret.Get(0).(func(string) *bundle.BindInstance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*bundle.BindInstance)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string) string); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string) error); ok {
+		r2 = rf(_a0)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetLease provides a mock function with given fields: _a0
+func (_m *Dao) GetLease(_a0 string) (types.Lease, string, error) {
+	ret := _m.Called(_a0)
+
+	var r0 types.Lease
+	if rf, ok := ret.Get(0).(func(string) types.Lease); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(types.Lease)
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string) string); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string) error); ok {
+		r2 = rf(_a0)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetServiceInstance provides a mock function with given fields: _a0
 func (_m *Dao) GetServiceInstance(_a0 string) (*bundle.ServiceInstance, error) {
 	ret := _m.Called(_a0)
@@ -209,6 +598,66 @@ func (_m *Dao) GetServiceInstance(_a0 string) (*bundle.ServiceInstance, error) {
 	return r0, r1
 }
 
+// GetServiceInstanceVersion provides a mock function with given fields: _a0
+func (_m *Dao) GetServiceInstanceVersion(_a0 string) (*bundle.ServiceInstance, string, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *bundle.ServiceInstance
+	if rf, ok := ret.Get(0).(func(string) *bundle.ServiceInstance); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*bundle.ServiceInstance)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string) string); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string) error); ok {
+		r2 = rf(_a0)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// GetServiceInstancesPage provides a mock function with given fields: _a0, _a1
+func (_m *Dao) GetServiceInstancesPage(_a0 string, _a1 int) ([]*bundle.ServiceInstance, string, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 []*bundle.ServiceInstance
+	if rf, ok := ret.Get(0).(func(string, int) []*bundle.ServiceInstance); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*bundle.ServiceInstance)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string, int) string); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string, int) error); ok {
+		r2 = rf(_a0, _a1)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetSpec provides a mock function with given fields: _a0
 func (_m *Dao) GetSpec(_a0 string) (*bundle.Spec, error) {
 	ret := _m.Called(_a0)
@@ -232,6 +681,36 @@ func (_m *Dao) GetSpec(_a0 string) (*bundle.Spec, error) {
 	return r0, r1
 }
 
+// GetSpecsPage provides a mock function with given fields: _a0, _a1
+func (_m *Dao) GetSpecsPage(_a0 string, _a1 int) ([]*bundle.Spec, string, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 []*bundle.Spec
+	if rf, ok := ret.Get(0).(func(string, int) []*bundle.Spec); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*bundle.Spec)
+		}
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string, int) string); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string, int) error); ok {
+		r2 = rf(_a0, _a1)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetState provides a mock function with given fields: _a0, _a1
 func (_m *Dao) GetState(_a0 string, _a1 string) (bundle.JobState, error) {
 	ret := _m.Called(_a0, _a1)
@@ -274,6 +753,34 @@ func (_m *Dao) GetStateByKey(key string) (bundle.JobState, error) {
 	return r0, r1
 }
 
+// GetStateVersion provides a mock function with given fields: _a0, _a1
+func (_m *Dao) GetStateVersion(_a0 string, _a1 string) (bundle.JobState, string, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 bundle.JobState
+	if rf, ok := ret.Get(0).(func(string, string) bundle.JobState); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Get(0).(bundle.JobState)
+	}
+
+	var r1 string
+	if rf, ok := ret.Get(1).(func(string, string) string); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(string, string) error); ok {
+		r2 = rf(_a0, _a1)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetSvcInstJobsByState provides a mock function with given fields: _a0, _a1
 func (_m *Dao) GetSvcInstJobsByState(_a0 string, _a1 bundle.State) ([]bundle.JobState, error) {
 	ret := _m.Called(_a0, _a1)
@@ -297,6 +804,20 @@ func (_m *Dao) GetSvcInstJobsByState(_a0 string, _a1 bundle.State) ([]bundle.Job
 	return r0, r1
 }
 
+// IsConflictError provides a mock function with given fields: err
+func (_m *Dao) IsConflictError(err error) bool {
+	ret := _m.Called(err)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(error) bool); ok {
+		r0 = rf(err)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
 // IsNotFoundError provides a mock function with given fields: err
 func (_m *Dao) IsNotFoundError(err error) bool {
 	ret := _m.Called(err)
@@ -311,6 +832,43 @@ func (_m *Dao) IsNotFoundError(err error) bool {
 	return r0
 }
 
+// PendingIntents provides a mock function with given fields:
+func (_m *Dao) PendingIntents() ([]types.Intent, error) {
+	ret := _m.Called()
+
+	var r0 []types.Intent
+	if rf, ok := ret.Get(0).(func() []types.Intent); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.Intent)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetAuditRecord provides a mock function with given fields: _a0
+func (_m *Dao) SetAuditRecord(_a0 types.AuditRecord) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(types.AuditRecord) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SetBindInstance provides a mock function with given fields: _a0, _a1
 func (_m *Dao) SetBindInstance(_a0 string, _a1 *bundle.BindInstance) error {
 	ret := _m.Called(_a0, _a1)
@@ -325,6 +883,34 @@ func (_m *Dao) SetBindInstance(_a0 string, _a1 *bundle.BindInstance) error {
 	return r0
 }
 
+// SetDeadLetter provides a mock function with given fields: _a0
+func (_m *Dao) SetDeadLetter(_a0 types.DeadLetter) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(types.DeadLetter) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetJobRecord provides a mock function with given fields: _a0
+func (_m *Dao) SetJobRecord(_a0 types.JobRecord) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(types.JobRecord) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SetServiceInstance provides a mock function with given fields: _a0, _a1
 func (_m *Dao) SetServiceInstance(_a0 string, _a1 *bundle.ServiceInstance) error {
 	ret := _m.Called(_a0, _a1)
@@ -373,3 +959,26 @@ func (_m *Dao) SetState(_a0 string, _a1 bundle.JobState) (string, error) {
 
 	return r0, r1
 }
+
+// Watch provides a mock function with given fields: _a0
+func (_m *Dao) Watch(_a0 <-chan struct{}) (<-chan types.Event, error) {
+	ret := _m.Called(_a0)
+
+	var r0 <-chan types.Event
+	if rf, ok := ret.Get(0).(func(<-chan struct{}) <-chan types.Event); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan types.Event)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(<-chan struct{}) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,74 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+)
+
+// DefaultPageSize - the number of records read at once when walking a
+// collection page by page.
+const DefaultPageSize = 500
+
+// SpecPager - the part of the Dao needed to read the specs page by page.
+type SpecPager interface {
+	GetSpecsPage(string, int) ([]*bundle.Spec, string, error)
+}
+
+// ServiceInstancePager - the part of the Dao needed to read the service
+// instances page by page.
+type ServiceInstancePager interface {
+	GetServiceInstancesPage(string, int) ([]*bundle.ServiceInstance, string, error)
+}
+
+// ForEachSpecPage - calls fn with every page of at most limit specs until
+// the last page was handled or fn returns an error.
+func ForEachSpecPage(d SpecPager, limit int, fn func([]*bundle.Spec) error) error {
+	continueToken := ""
+	for {
+		specs, next, err := d.GetSpecsPage(continueToken, limit)
+		if err != nil {
+			return err
+		}
+		if err := fn(specs); err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		continueToken = next
+	}
+}
+
+// ForEachServiceInstancePage - calls fn with every page of at most limit
+// service instances until the last page was handled or fn returns an error.
+func ForEachServiceInstancePage(d ServiceInstancePager, limit int, fn func([]*bundle.ServiceInstance) error) error {
+	continueToken := ""
+	for {
+		instances, next, err := d.GetServiceInstancesPage(continueToken, limit)
+		if err != nil {
+			return err
+		}
+		if err := fn(instances); err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		continueToken = next
+	}
+}
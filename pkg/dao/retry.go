@@ -0,0 +1,134 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConflictRetries - the number of times a conflicting write is attempted
+// before giving up.
+const ConflictRetries = 5
+
+// conflictBackoff - the base delay between conflicting writes, it grows with
+// every attempt.
+var conflictBackoff = 10 * time.Millisecond
+
+// ConflictError - Error returned by the retry helpers when a record kept
+// changing underneath every attempt to update it.
+type ConflictError struct {
+	Kind     string
+	ID       string
+	Attempts int
+}
+
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("unable to update %s %s, it was modified concurrently %d times",
+		e.Kind, e.ID, e.Attempts)
+}
+
+// IsConflictError - Will determine if the error is a ConflictError returned
+// by the retry helpers.
+func IsConflictError(err error) bool {
+	_, ok := err.(ConflictError)
+	return ok
+}
+
+// ServiceInstanceUpdater - the part of the Dao needed to safely update a
+// service instance.
+type ServiceInstanceUpdater interface {
+	GetServiceInstanceVersion(string) (*bundle.ServiceInstance, string, error)
+	CompareAndSetServiceInstance(string, *bundle.ServiceInstance, string) (string, error)
+	IsConflictError(error) bool
+}
+
+// BindInstanceUpdater - the part of the Dao needed to safely update a bind
+// instance.
+type BindInstanceUpdater interface {
+	GetBindInstanceVersion(string) (*bundle.BindInstance, string, error)
+	CompareAndSetBindInstance(string, *bundle.BindInstance, string) (string, error)
+	IsConflictError(error) bool
+}
+
+// RetryOnConflict - calls fn until it returns something other than a conflict
+// according to isConflict, waiting a little longer after each attempt. A
+// ConflictError is returned when every attempt conflicted.
+func RetryOnConflict(kind string, id string, isConflict func(error) bool, fn func() error) error {
+	for attempt := 1; attempt <= ConflictRetries; attempt++ {
+		err := fn()
+		if err == nil || !isConflict(err) {
+			return err
+		}
+		log.Debugf("conflicting update of %s [ %s ], attempt %d of %d",
+			kind, id, attempt, ConflictRetries)
+		time.Sleep(time.Duration(attempt) * conflictBackoff)
+	}
+	log.Warningf("giving up updating %s [ %s ] after %d conflicting attempts", kind, id, ConflictRetries)
+	return ConflictError{Kind: kind, ID: id, Attempts: ConflictRetries}
+}
+
+// UpdateServiceInstance - reads the latest copy of the service instance,
+// applies mutate and writes it back only if nobody else wrote it in the
+// meantime. On a conflict the read and mutate are repeated, so mutate must
+// only make the change the caller is interested in.
+func UpdateServiceInstance(d ServiceInstanceUpdater, id string,
+	mutate func(*bundle.ServiceInstance) error) (*bundle.ServiceInstance, error) {
+
+	var instance *bundle.ServiceInstance
+	err := RetryOnConflict("service instance", id, d.IsConflictError, func() error {
+		si, version, err := d.GetServiceInstanceVersion(id)
+		if err != nil {
+			return err
+		}
+		if err := mutate(si); err != nil {
+			return err
+		}
+		if _, err := d.CompareAndSetServiceInstance(id, si, version); err != nil {
+			return err
+		}
+		instance = si
+		return nil
+	})
+	return instance, err
+}
+
+// UpdateBindInstance - reads the latest copy of the bind instance, applies
+// mutate and writes it back only if nobody else wrote it in the meantime.
+func UpdateBindInstance(d BindInstanceUpdater, id string,
+	mutate func(*bundle.BindInstance) error) (*bundle.BindInstance, error) {
+
+	var binding *bundle.BindInstance
+	err := RetryOnConflict("bind instance", id, d.IsConflictError, func() error {
+		bi, version, err := d.GetBindInstanceVersion(id)
+		if err != nil {
+			return err
+		}
+		if err := mutate(bi); err != nil {
+			return err
+		}
+		if _, err := d.CompareAndSetBindInstance(id, bi, version); err != nil {
+			return err
+		}
+		binding = bi
+		return nil
+	})
+	return binding, err
+}
@@ -0,0 +1,90 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package dao
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	memory "github.com/openshift/ansible-service-broker/pkg/dao/memory"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+func TestUpdateServiceInstanceKeepsConcurrentBindings(t *testing.T) {
+	d, _ := memory.NewDao()
+	id := uuid.NewRandom()
+	if err := d.SetServiceInstance(id.String(), &bundle.ServiceInstance{ID: id}); err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	lock := sync.Mutex{}
+	added := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bindingID := uuid.NewRandom()
+			_, err := UpdateServiceInstance(d, id.String(), func(si *bundle.ServiceInstance) error {
+				si.AddBinding(bindingID)
+				return nil
+			})
+			// running out of attempts is allowed, silently losing the
+			// binding is not.
+			if err != nil && !IsConflictError(err) {
+				t.Error(err)
+			} else if err == nil {
+				lock.Lock()
+				added[bindingID.String()] = true
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	si, err := d.GetServiceInstance(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, len(added), len(si.BindingIDs))
+	for bindingID := range added {
+		ft.AssertTrue(t, si.BindingIDs[bindingID])
+	}
+}
+
+func TestRetryOnConflictGivesUp(t *testing.T) {
+	conflict := errors.New("conflict")
+	calls := 0
+	err := RetryOnConflict("service instance", "id", func(err error) bool { return err == conflict }, func() error {
+		calls++
+		return conflict
+	})
+	ft.AssertTrue(t, IsConflictError(err))
+	ft.AssertEqual(t, ConflictRetries, calls)
+
+	calls = 0
+	other := errors.New("other")
+	err = RetryOnConflict("service instance", "id", func(err error) bool { return err == conflict }, func() error {
+		calls++
+		return other
+	})
+	ft.AssertEqual(t, other, err)
+	ft.AssertEqual(t, 1, calls)
+}
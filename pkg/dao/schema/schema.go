@@ -0,0 +1,205 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package schema versions the records the dao backends store as JSON. Every
+// record is written with the current schema version of its kind, and the
+// records written with an older version are upgraded when they are read, so
+// they are rewritten in the current shape by their next write.
+//
+// A change to the shape of a stored record registers an upgrade of the
+// previous version from an init function:
+//
+//	func init() {
+//		schema.Register(types.KindServiceInstance, 1, func(r map[string]interface{}) error {
+//			r["context"] = map[string]interface{}{"platform": "kubernetes"}
+//			return nil
+//		})
+//	}
+//
+// Brokers that have not been upgraded yet keep writing the older shape while
+// a new broker version rolls out, so an upgrade must accept a record that is
+// already in the newer shape.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Field - the name of the record field holding its schema version.
+const Field = "schema_version"
+
+// Upgrade - upgrades a record, decoded to a generic JSON object, to the next
+// schema version of its kind.
+type Upgrade func(record map[string]interface{}) error
+
+var (
+	lock     sync.RWMutex
+	upgrades = map[types.Kind][]Upgrade{}
+)
+
+// Register - adds the upgrade of the records of kind from version to the
+// next version, which becomes the current one. The upgrades of a kind must be
+// registered in order, starting from version 1.
+func Register(kind types.Kind, from int, upgrade Upgrade) {
+	lock.Lock()
+	defer lock.Unlock()
+	if current := len(upgrades[kind]) + 1; from != current {
+		panic(fmt.Sprintf("schema: upgrade of %s from version %d registered at version %d", kind, from, current))
+	}
+	upgrades[kind] = append(upgrades[kind], upgrade)
+}
+
+// Current - the schema version the records of kind are written with. It is 1
+// until an upgrade is registered.
+func Current(kind types.Kind) int {
+	lock.RLock()
+	defer lock.RUnlock()
+	return len(upgrades[kind]) + 1
+}
+
+// Version - the schema version the raw record was written with, 0 for the
+// records written before the records were versioned, which have the shape of
+// version 1.
+func Version(raw string) (int, error) {
+	v := struct {
+		Version int `json:"schema_version"`
+	}{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return 0, err
+	}
+	return v.Version, nil
+}
+
+// Marshal - the JSON of a record of kind, with the current schema version.
+func Marshal(kind types.Kind, record interface{}) (string, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	return stamp(payload, Current(kind))
+}
+
+// Unmarshal - decodes the raw record of kind into record, upgrading it from
+// the schema version it was written with.
+func Unmarshal(kind types.Kind, raw string, record interface{}) error {
+	upgraded, err := UpgradeRaw(kind, raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(upgraded), record)
+}
+
+// UpgradeRaw - the raw record of kind upgraded to the current schema version.
+// Records that are already current, or were written by a newer broker, are
+// returned as they are.
+func UpgradeRaw(kind types.Kind, raw string) (string, error) {
+	version, err := Version(raw)
+	if err != nil {
+		return "", err
+	}
+	if version == 0 {
+		version = 1
+	}
+
+	lock.RLock()
+	pending := []Upgrade{}
+	if version <= len(upgrades[kind]) {
+		pending = upgrades[kind][version-1:]
+	}
+	current := len(upgrades[kind]) + 1
+	lock.RUnlock()
+
+	if version > current {
+		log.Debugf("%s record has schema version %d, newer than %d", kind, version, current)
+	}
+	if len(pending) == 0 {
+		return raw, nil
+	}
+
+	record, err := decodeObject(raw)
+	if err != nil {
+		return "", err
+	}
+	for i, upgrade := range pending {
+		if err := upgrade(record); err != nil {
+			return "", fmt.Errorf("unable to upgrade %s record from schema version %d - %v", kind, version+i, err)
+		}
+	}
+	record[Field] = current
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
+
+// NeedsRewrite - whether the raw record of kind was written with an older
+// schema version, or before the records were versioned.
+func NeedsRewrite(kind types.Kind, raw string) (bool, error) {
+	version, err := Version(raw)
+	if err != nil {
+		return false, err
+	}
+	return version < Current(kind), nil
+}
+
+// Rewrite - the raw record of kind upgraded to, and stamped with, the current
+// schema version.
+func Rewrite(kind types.Kind, raw string) (string, error) {
+	upgraded, err := UpgradeRaw(kind, raw)
+	if err != nil {
+		return "", err
+	}
+	record, err := decodeObject(upgraded)
+	if err != nil {
+		return "", err
+	}
+	record[Field] = Current(kind)
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
+
+// decodeObject - decodes a JSON object keeping its numbers as they are.
+func decodeObject(raw string) (map[string]interface{}, error) {
+	record := map[string]interface{}{}
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// stamp - adds the schema version to the JSON object payload.
+func stamp(payload []byte, version int) (string, error) {
+	if len(payload) < 2 || payload[0] != '{' {
+		return "", fmt.Errorf("a record must be a JSON object, not %s", payload)
+	}
+	field := fmt.Sprintf(`{"%s":%d`, Field, version)
+	if string(payload) == "{}" {
+		return field + "}", nil
+	}
+	return field + "," + string(payload[1:]), nil
+}
@@ -0,0 +1,103 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package schema
+
+import (
+	"testing"
+
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+)
+
+type record struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestMarshalStampsVersion(t *testing.T) {
+	kind := types.Kind("test_marshal")
+	raw, err := Marshal(kind, record{Name: "a"})
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, raw, `{"schema_version":1,"name":"a","count":0}`)
+
+	version, err := Version(raw)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, version, 1)
+
+	raw, err = Marshal(kind, map[string]string{})
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, raw, `{"schema_version":1}`)
+
+	_, err = Marshal(kind, []string{"a"})
+	ft.AssertNotNil(t, err)
+}
+
+func TestUnmarshalUpgrades(t *testing.T) {
+	kind := types.Kind("test_unmarshal")
+	Register(kind, 1, func(r map[string]interface{}) error {
+		if _, ok := r["count"]; !ok {
+			r["count"] = 1
+		}
+		return nil
+	})
+	ft.AssertEqual(t, Current(kind), 2)
+
+	r := record{}
+	ft.AssertNil(t, Unmarshal(kind, `{"name":"old"}`, &r))
+	ft.AssertEqual(t, r, record{Name: "old", Count: 1})
+
+	// an older broker may write the new shape with the old version.
+	r = record{}
+	ft.AssertNil(t, Unmarshal(kind, `{"schema_version":1,"name":"old","count":5}`, &r))
+	ft.AssertEqual(t, r, record{Name: "old", Count: 5})
+
+	r = record{}
+	ft.AssertNil(t, Unmarshal(kind, `{"schema_version":2,"name":"new"}`, &r))
+	ft.AssertEqual(t, r, record{Name: "new"})
+}
+
+func TestRewrite(t *testing.T) {
+	kind := types.Kind("test_rewrite")
+	stale, err := NeedsRewrite(kind, `{"name":"a"}`)
+	ft.AssertNil(t, err)
+	ft.AssertTrue(t, stale)
+
+	raw, err := Rewrite(kind, `{"name":"a"}`)
+	ft.AssertNil(t, err)
+	stale, err = NeedsRewrite(kind, raw)
+	ft.AssertNil(t, err)
+	ft.AssertFalse(t, stale)
+
+	Register(kind, 1, func(r map[string]interface{}) error {
+		r["name"] = "upgraded"
+		return nil
+	})
+	stale, err = NeedsRewrite(kind, raw)
+	ft.AssertNil(t, err)
+	ft.AssertTrue(t, stale)
+
+	raw, err = Rewrite(kind, raw)
+	ft.AssertNil(t, err)
+	ft.AssertEqual(t, raw, `{"name":"upgraded","schema_version":2}`)
+}
+
+func TestRegisterOutOfOrder(t *testing.T) {
+	defer func() {
+		ft.AssertNotNil(t, recover())
+	}()
+	Register(types.Kind("test_order"), 2, func(r map[string]interface{}) error { return nil })
+}
@@ -0,0 +1,40 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import (
+	"sort"
+	"time"
+)
+
+// AuditRecord - An audit event kept in the dao.
+type AuditRecord struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Event is the audit event, with its sensitive parameters redacted.
+	Event map[string]interface{} `json:"event"`
+}
+
+// SortAuditRecords - orders the audit records oldest first.
+func SortAuditRecords(records []AuditRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Time.Equal(records[j].Time) {
+			return records[i].ID < records[j].ID
+		}
+		return records[i].Time.Before(records[j].Time)
+	})
+}
@@ -0,0 +1,100 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import (
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+)
+
+// OpType - the write an Op makes.
+type OpType string
+
+const (
+	// OpSetServiceInstance - writes ServiceInstance.
+	OpSetServiceInstance OpType = "set_service_instance"
+	// OpDeleteServiceInstance - deletes the service instance ID.
+	OpDeleteServiceInstance OpType = "delete_service_instance"
+	// OpSetBindInstance - writes BindInstance.
+	OpSetBindInstance OpType = "set_bind_instance"
+	// OpDeleteBindInstance - deletes the bind instance ID.
+	OpDeleteBindInstance OpType = "delete_bind_instance"
+	// OpRemoveBinding - removes BindingID from the bindings of the service
+	// instance ID as it is stored, if it still exists.
+	OpRemoveBinding OpType = "remove_binding"
+)
+
+// Op - a single write of a Batch.
+type Op struct {
+	Type OpType `json:"type"`
+	// ID is the id of the service or bind instance written.
+	ID              string                  `json:"id"`
+	ServiceInstance *bundle.ServiceInstance `json:"service_instance,omitempty"`
+	BindInstance    *bundle.BindInstance    `json:"bind_instance,omitempty"`
+	BindingID       string                  `json:"binding_id,omitempty"`
+}
+
+// IntentAction - an action outside of the data store an intent records.
+type IntentAction string
+
+const (
+	// IntentDeleteExtractedCredentials - deletes the extracted credentials of
+	// the service or bind instance Target.
+	IntentDeleteExtractedCredentials IntentAction = "delete_extracted_credentials"
+)
+
+// Intent - an action outside of the data store that has to be carried out
+// once the writes of its batch are. It stays pending until it is completed,
+// so the action is carried out again after a crash. Actions must be safe to
+// carry out more than once.
+type Intent struct {
+	ID     string       `json:"id"`
+	Action IntentAction `json:"action"`
+	Target string       `json:"target"`
+}
+
+// Batch - writes applied all together or not at all, along with the intents
+// recorded once they are. Deleting a record that does not exist fails the
+// whole batch.
+type Batch struct {
+	Ops     []Op     `json:"ops"`
+	Intents []Intent `json:"intents,omitempty"`
+}
+
+// JournalEntry - a batch as it is recorded by the backends without
+// transactions before its writes are made one by one. An entry left behind
+// by a crash is replayed, deletes of records that are already gone are then
+// skipped.
+type JournalEntry struct {
+	ID      string    `json:"id"`
+	Batch   Batch     `json:"batch"`
+	Created time.Time `json:"created"`
+}
+
+// JournalReplayAge - how old a journal entry has to be before it is
+// replayed, so the batches another broker is still applying are left alone.
+const JournalReplayAge = time.Minute
+
+// DeleteBindingBatch - the batch deleting a binding and removing it from its
+// service instance.
+func DeleteBindingBatch(bindingID string, instanceID string) Batch {
+	return Batch{Ops: []Op{
+		{Type: OpDeleteBindInstance, ID: bindingID},
+		{Type: OpRemoveBinding, ID: instanceID, BindingID: bindingID},
+	}}
+}
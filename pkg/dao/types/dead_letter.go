@@ -0,0 +1,65 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import (
+	"sort"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+)
+
+// DeadLetter - A job message a work engine subscriber failed to handle after
+// every delivery attempt, stored until it is replayed.
+type DeadLetter struct {
+	ID string `json:"id"`
+	// Subscriber is the ID of the subscriber the message is for.
+	Subscriber string `json:"subscriber"`
+	Topic      string `json:"topic"`
+	Token      string `json:"token"`
+	// Message is the job message. It is kept as a map so that the encrypted
+	// dao can seal the credentials it may carry.
+	Message  map[string]interface{} `json:"message"`
+	Error    string                 `json:"error"`
+	Attempts int                    `json:"attempts"`
+	Created  time.Time              `json:"created"`
+	// Prior is the job state the message was to replace, for the subscribers
+	// writing the job states, nil when it could not be read.
+	Prior *PriorState `json:"prior,omitempty"`
+	// Superseded is set once the job state moved on past the message, which
+	// is then kept but never replayed.
+	Superseded bool `json:"superseded,omitempty"`
+}
+
+// PriorState - A job state as read before it is replaced, with its version.
+type PriorState struct {
+	// Found is false when there was no job state yet.
+	Found   bool            `json:"found"`
+	State   bundle.JobState `json:"state"`
+	Version string          `json:"version"`
+}
+
+// SortDeadLetters - orders the dead letters oldest first, in the order the
+// messages were sent.
+func SortDeadLetters(letters []DeadLetter) {
+	sort.SliceStable(letters, func(i, j int) bool {
+		if letters[i].Created.Equal(letters[j].Created) {
+			return letters[i].ID < letters[j].ID
+		}
+		return letters[i].Created.Before(letters[j].Created)
+	})
+}
@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import (
+	"github.com/automationbroker/bundle-lib/bundle"
+)
+
+// planParameter - the service instance parameter holding the plan id.
+const planParameter = "_apb_plan_id"
+
+// InstanceFilter - Selects service instances, the fields left empty match
+// every instance.
+type InstanceFilter struct {
+	// Namespace is the namespace the instance was provisioned in.
+	Namespace string
+	SpecID    string
+	PlanID    string
+	// State is the state of the job state last written for the instance.
+	State bundle.State
+}
+
+// Matches - whether the service instance, whose last job is in state, is
+// selected by the filter.
+func (f InstanceFilter) Matches(si *bundle.ServiceInstance, state bundle.State) bool {
+	if f.Namespace != "" && (si.Context == nil || si.Context.Namespace != f.Namespace) {
+		return false
+	}
+	if f.SpecID != "" && (si.Spec == nil || si.Spec.ID != f.SpecID) {
+		return false
+	}
+	if f.PlanID != "" && PlanID(si) != f.PlanID {
+		return false
+	}
+	return f.State == "" || f.State == state
+}
+
+// BindingFilter - Selects bind instances, the fields left empty match every
+// binding.
+type BindingFilter struct {
+	ServiceInstanceID string
+	// State is the state of the job state last written for the binding.
+	State bundle.State
+}
+
+// Matches - whether the bind instance, whose last job is in state, is
+// selected by the filter.
+func (f BindingFilter) Matches(bi *bundle.BindInstance, state bundle.State) bool {
+	if f.ServiceInstanceID != "" && bi.ServiceID.String() != f.ServiceInstanceID {
+		return false
+	}
+	return f.State == "" || f.State == state
+}
+
+// PlanID - the id of the plan the service instance was provisioned with.
+func PlanID(si *bundle.ServiceInstance) string {
+	if si.Parameters == nil {
+		return ""
+	}
+	id, _ := (*si.Parameters)[planParameter].(string)
+	return id
+}
+
+// LatestStates - The state of the job state last written for each id. Ties,
+// and records without a modification time, go to the later record.
+func LatestStates(records []JobStateRecord) map[string]bundle.State {
+	latest := map[string]JobStateRecord{}
+	for _, r := range records {
+		if l, ok := latest[r.ID]; ok && l.LastModified.After(r.LastModified) {
+			continue
+		}
+		latest[r.ID] = r
+	}
+	states := map[string]bundle.State{}
+	for id, r := range latest {
+		states[id] = r.State.State
+	}
+	return states
+}
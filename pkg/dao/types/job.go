@@ -0,0 +1,55 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import (
+	"sort"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+)
+
+// StateCancelled - the state of a job cancelled through the work engine. The
+// last operation of a cancelled job is reported as failed.
+const StateCancelled bundle.State = "cancelled"
+
+// JobRecord - A job accepted by the work engine, stored until it has run so
+// that it can be started again under the same token after a restart.
+type JobRecord struct {
+	Token  string           `json:"token"`
+	Topic  string           `json:"topic"`
+	Method bundle.JobMethod `json:"method"`
+	// ServiceInstance is the service instance the job runs for, as it was
+	// when the job was accepted.
+	ServiceInstance *bundle.ServiceInstance `json:"service_instance"`
+	BindingID       string                  `json:"binding_id,omitempty"`
+	// Parameters are the parameters of bind and unbind jobs.
+	Parameters    *bundle.Parameters `json:"parameters,omitempty"`
+	SkipExecution bool               `json:"skip_execution,omitempty"`
+	Created       time.Time          `json:"created"`
+}
+
+// SortJobRecords - orders the records oldest first, in the order the jobs
+// were accepted.
+func SortJobRecords(records []JobRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Created.Equal(records[j].Created) {
+			return records[i].Token < records[j].Token
+		}
+		return records[i].Created.Before(records[j].Created)
+	})
+}
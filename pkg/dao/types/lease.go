@@ -0,0 +1,43 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLeasesUnsupported - the data store is not shared by the brokers, so a
+// lease stored in it cannot elect a leader among them.
+var ErrLeasesUnsupported = errors.New("leader election needs a data store shared by the brokers: etcd, etcd3 or crd")
+
+// Lease - A lock held by one broker at a time, which keeps it by renewing it
+// before its duration is over.
+type Lease struct {
+	Name string `json:"name"`
+	// Holder is the identity of the broker holding the lease, empty once it
+	// has been released.
+	Holder string `json:"holder"`
+	// Acquired is when the holder took the lease, Renewed when it last
+	// renewed it.
+	Acquired time.Time `json:"acquired"`
+	Renewed  time.Time `json:"renewed"`
+	// Duration is how long the lease is held without being renewed.
+	Duration time.Duration `json:"duration"`
+	// Transitions counts the times the lease changed holder.
+	Transitions int `json:"transitions"`
+}
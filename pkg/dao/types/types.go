@@ -0,0 +1,77 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package types holds the types shared by the dao interface and the
+// packages implementing it, which cannot import the dao package itself.
+package types
+
+import (
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+)
+
+// JobStateRecord - A stored job state along with the id of the service
+// instance or binding it belongs to.
+type JobStateRecord struct {
+	ID    string
+	State bundle.JobState
+	// LastModified is when the job state was last written, it is the zero
+	// time for records written before it was tracked.
+	LastModified time.Time
+}
+
+// EventType - what happened to a watched record.
+type EventType string
+
+const (
+	// Added - the record was created.
+	Added EventType = "added"
+	// Updated - the record was written again.
+	Updated EventType = "updated"
+	// Deleted - the record was removed.
+	Deleted EventType = "deleted"
+)
+
+// Kind - the kind of record an event is about.
+type Kind string
+
+const (
+	// KindSpec - a spec.
+	KindSpec Kind = "spec"
+	// KindServiceInstance - a service instance.
+	KindServiceInstance Kind = "service_instance"
+	// KindBindInstance - a bind instance.
+	KindBindInstance Kind = "bind_instance"
+	// KindJobState - a job state.
+	KindJobState Kind = "job_state"
+)
+
+// Event - A change to a record in the data store. Only the field matching
+// Kind is set, for deletes it holds the last known copy of the record when
+// the backend still had it.
+type Event struct {
+	Type EventType
+	Kind Kind
+	// ID is the id of the spec, instance or binding. For job states it is
+	// the id of the instance or binding the job belongs to, the job itself
+	// is identified by JobState.Token.
+	ID              string
+	Spec            *bundle.Spec
+	ServiceInstance *bundle.ServiceInstance
+	BindInstance    *bundle.BindInstance
+	JobState        *bundle.JobState
+}
@@ -0,0 +1,178 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package watch implements watching a data store that cannot report its own
+// changes by polling it and comparing what was read.
+package watch
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPollInterval - how often a polled data store is read.
+var DefaultPollInterval = 5 * time.Second
+
+// Source - the part of the Dao that is read to find changes.
+type Source interface {
+	BatchGetSpecs(string) ([]*bundle.Spec, error)
+	BatchGetBundleInstances() ([]*bundle.ServiceInstance, error)
+	BatchGetBindInstances() ([]*bundle.BindInstance, error)
+	BatchGetJobStates() ([]types.JobStateRecord, error)
+}
+
+// record - a record read from the source and its serialized form, which
+// tells whether it changed between two reads.
+type record struct {
+	event types.Event
+	data  string
+}
+
+// Poll - reads source every interval and reports the records that were
+// added, updated or deleted since the previous read. The first read is only
+// used to compare the next one against. The channel is closed once stop is.
+func Poll(source Source, interval time.Duration, stop <-chan struct{}) <-chan types.Event {
+	events := make(chan types.Event)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last map[string]record
+		for {
+			current, err := snapshot(source)
+			if err != nil {
+				log.Warningf("Unable to poll the dao for changes - %v", err)
+			} else if last == nil {
+				last = current
+			} else {
+				for _, e := range diff(last, current) {
+					select {
+					case events <- e:
+					case <-stop:
+						return
+					}
+				}
+				last = current
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return events
+}
+
+func snapshot(source Source) (map[string]record, error) {
+	records := map[string]record{}
+	add := func(key string, e types.Event, v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		records[key] = record{event: e, data: string(b)}
+		return nil
+	}
+
+	specs, err := source.BatchGetSpecs("/spec")
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range specs {
+		e := types.Event{Kind: types.KindSpec, ID: s.ID, Spec: s}
+		if err := add("spec/"+s.ID, e, s); err != nil {
+			return nil, err
+		}
+	}
+
+	instances, err := source.BatchGetBundleInstances()
+	if err != nil {
+		return nil, err
+	}
+	for _, si := range instances {
+		id := si.ID.String()
+		e := types.Event{Kind: types.KindServiceInstance, ID: id, ServiceInstance: si}
+		if err := add("service_instance/"+id, e, si); err != nil {
+			return nil, err
+		}
+	}
+
+	bindings, err := source.BatchGetBindInstances()
+	if err != nil {
+		return nil, err
+	}
+	for _, bi := range bindings {
+		id := bi.ID.String()
+		e := types.Event{Kind: types.KindBindInstance, ID: id, BindInstance: bi}
+		if err := add("bind_instance/"+id, e, bi); err != nil {
+			return nil, err
+		}
+	}
+
+	states, err := source.BatchGetJobStates()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range states {
+		state := r.State
+		e := types.Event{Kind: types.KindJobState, ID: r.ID, JobState: &state}
+		if err := add("state/"+r.ID+"/"+state.Token, e, state); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// diff - the events turning last into current, ordered by record key so
+// they are reported the same way every time.
+func diff(last, current map[string]record) []types.Event {
+	keys := []string{}
+	for k := range current {
+		keys = append(keys, k)
+	}
+	for k := range last {
+		if _, ok := current[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	events := []types.Event{}
+	for _, k := range keys {
+		prev, existed := last[k]
+		cur, exists := current[k]
+		switch {
+		case !existed:
+			cur.event.Type = types.Added
+			events = append(events, cur.event)
+		case !exists:
+			prev.event.Type = types.Deleted
+			events = append(events, prev.event)
+		case prev.data != cur.data:
+			cur.event.Type = types.Updated
+			events = append(events, cur.event)
+		}
+	}
+	return events
+}
@@ -0,0 +1,115 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package watch
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
+	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
+	"github.com/pborman/uuid"
+)
+
+type fakeSource struct {
+	lock      sync.Mutex
+	specs     []*bundle.Spec
+	instances []*bundle.ServiceInstance
+	bindings  []*bundle.BindInstance
+	states    []types.JobStateRecord
+}
+
+func (f *fakeSource) BatchGetSpecs(string) ([]*bundle.Spec, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.specs, nil
+}
+
+func (f *fakeSource) BatchGetBundleInstances() ([]*bundle.ServiceInstance, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.instances, nil
+}
+
+func (f *fakeSource) BatchGetBindInstances() ([]*bundle.BindInstance, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.bindings, nil
+}
+
+func (f *fakeSource) BatchGetJobStates() ([]types.JobStateRecord, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.states, nil
+}
+
+func next(t *testing.T, events <-chan types.Event) types.Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return types.Event{}
+}
+
+func TestPoll(t *testing.T) {
+	id := uuid.NewRandom()
+	source := &fakeSource{specs: []*bundle.Spec{{ID: "a"}}}
+	stop := make(chan struct{})
+	events := Poll(source, 10*time.Millisecond, stop)
+
+	// give the first read a moment, existing records are not reported.
+	time.Sleep(30 * time.Millisecond)
+	source.lock.Lock()
+	source.specs = []*bundle.Spec{{ID: "a", Description: "changed"}}
+	source.instances = []*bundle.ServiceInstance{{ID: id}}
+	source.states = []types.JobStateRecord{
+		{ID: id.String(), State: bundle.JobState{Token: "t", State: bundle.StateInProgress}},
+	}
+	source.lock.Unlock()
+
+	// changes are reported ordered by record key.
+	e := next(t, events)
+	ft.AssertEqual(t, types.Added, e.Type)
+	ft.AssertEqual(t, types.KindServiceInstance, e.Kind)
+	ft.AssertEqual(t, id.String(), e.ID)
+
+	e = next(t, events)
+	ft.AssertEqual(t, types.Updated, e.Type)
+	ft.AssertEqual(t, types.KindSpec, e.Kind)
+	ft.AssertEqual(t, "changed", e.Spec.Description)
+
+	e = next(t, events)
+	ft.AssertEqual(t, types.Added, e.Type)
+	ft.AssertEqual(t, types.KindJobState, e.Kind)
+	ft.AssertEqual(t, "t", e.JobState.Token)
+
+	source.lock.Lock()
+	source.specs = nil
+	source.lock.Unlock()
+	e = next(t, events)
+	ft.AssertEqual(t, types.Deleted, e.Type)
+	ft.AssertEqual(t, "a", e.ID)
+	ft.AssertEqual(t, "changed", e.Spec.Description)
+
+	close(stop)
+	for range events {
+	}
+}
@@ -0,0 +1,140 @@
+//
+// Copyright (c) 2018 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/openshift/ansible-service-broker/pkg/audit"
+	"github.com/openshift/ansible-service-broker/pkg/auth"
+	"github.com/openshift/ansible-service-broker/pkg/broker"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxAuditedResponse - how much of the response is kept to find the
+// operation the broker answered with.
+const maxAuditedResponse = 4096
+
+// auditedRequest - the fields of the request bodies the audit log keeps.
+type auditedRequest struct {
+	ServiceID  string                 `json:"service_id"`
+	PlanID     string                 `json:"plan_id"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Context    struct {
+		Namespace string `json:"namespace"`
+	} `json:"context"`
+}
+
+// auditRecorder - keeps the status and the start of the response.
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (a *auditRecorder) WriteHeader(status int) {
+	a.status = status
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditRecorder) Write(b []byte) (int, error) {
+	if room := maxAuditedResponse - a.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		a.body.Write(b[:room])
+	}
+	return a.ResponseWriter.Write(b)
+}
+
+// audited - records the request in the audit log once the handler has
+// answered it.
+func (h handler) audited(action string, f VarHandler) VarHandler {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		if !h.auditor.Enabled() {
+			f(w, r, params)
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = ioutil.ReadAll(r.Body); err != nil {
+				log.Warningf("Unable to read the %s request for the audit log - %v", action, err)
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		recorder := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+		f(recorder, r, params)
+
+		h.auditor.Record(requestEvent(action, r, params, body, recorder))
+	}
+}
+
+// requestEvent - the audit event of the request.
+func requestEvent(action string, r *http.Request, params map[string]string,
+	body []byte, recorder *auditRecorder) audit.Event {
+	event := audit.Event{
+		Kind:       audit.KindRequest,
+		Action:     action,
+		InstanceID: params["instance_uuid"],
+		BindingID:  params["binding_uuid"],
+		ServiceID:  r.URL.Query().Get("service_id"),
+		PlanID:     r.URL.Query().Get("plan_id"),
+		RemoteAddr: r.RemoteAddr,
+		Status:     recorder.status,
+	}
+	if token := params["job_token"]; token != "" {
+		event.Operation = token
+	}
+
+	var req auditedRequest
+	if len(body) > 0 && json.Unmarshal(body, &req) == nil {
+		if req.ServiceID != "" {
+			event.ServiceID = req.ServiceID
+		}
+		if req.PlanID != "" {
+			event.PlanID = req.PlanID
+		}
+		event.Namespace = req.Context.Namespace
+		event.Parameters = req.Parameters
+	}
+
+	if userInfo, ok := r.Context().Value(UserInfoContext).(broker.UserInfo); ok {
+		event.User = &audit.User{Username: userInfo.Username, UID: userInfo.UID, Groups: userInfo.Groups}
+	}
+	if principal, ok := r.Context().Value(PrincipalContext).(auth.Principal); ok {
+		event.Principal = &audit.Principal{Type: principal.GetType(), Name: principal.GetName()}
+	}
+
+	var resp struct {
+		Operation   string `json:"operation"`
+		Description string `json:"description"`
+	}
+	if json.Unmarshal(recorder.body.Bytes(), &resp) == nil {
+		if resp.Operation != "" {
+			event.Operation = resp.Operation
+		}
+		if recorder.status >= http.StatusBadRequest {
+			event.Error = resp.Description
+		}
+	}
+	return event
+}
@@ -37,6 +37,7 @@ import (
 	"github.com/automationbroker/config"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/openshift/ansible-service-broker/pkg/audit"
 	"github.com/openshift/ansible-service-broker/pkg/auth"
 	"github.com/openshift/ansible-service-broker/pkg/broker"
 	"github.com/openshift/ansible-service-broker/pkg/version"
@@ -57,6 +58,8 @@ const (
 	// UserInfoContext - Broker.UserInfo retrieved from the
 	// originating identity header
 	UserInfoContext RequestContextKey = "userInfo"
+	// PrincipalContext - auth.Principal the auth providers authenticated
+	PrincipalContext RequestContextKey = "principal"
 )
 
 type handler struct {
@@ -64,6 +67,7 @@ type handler struct {
 	broker       broker.Broker
 	brokerConfig *config.Config
 	authorizer   authorization.Authorizer
+	auditor      *audit.Auditor
 }
 
 // authHandler - does the authentication for the routes
@@ -75,6 +79,7 @@ func authHandler(h http.Handler, providers []auth.Provider) http.Handler {
 			principal, err := provider.GetPrincipal(r)
 			if principal != nil {
 				log.Debug("We found one. HOORAY!")
+				r = r.WithContext(context.WithValue(r.Context(), PrincipalContext, principal))
 				// we found our principal, stop looking
 				break
 			}
@@ -156,12 +161,13 @@ func createVarHandler(r VarHandler) GorillaRouteHandler {
 
 // NewHandler - Create a new handler by attaching the routes and setting logger and broker.
 func NewHandler(b broker.Broker, brokerConfig *config.Config, prefix string,
-	providers []auth.Provider, a authorization.Authorizer) http.Handler {
+	providers []auth.Provider, a authorization.Authorizer, auditor *audit.Auditor) http.Handler {
 	h := handler{
 		router:       *mux.NewRouter(),
 		broker:       b,
 		brokerConfig: brokerConfig,
 		authorizer:   a,
+		auditor:      auditor,
 	}
 	var s *mux.Router
 	if prefix == "/" {
@@ -170,27 +176,32 @@ func NewHandler(b broker.Broker, brokerConfig *config.Config, prefix string,
 		s = h.router.PathPrefix(prefix).Subrouter()
 	}
 
-	s.HandleFunc("/v2/bootstrap", createVarHandler(h.bootstrap)).Methods("POST")
+	s.HandleFunc("/v2/bootstrap", createVarHandler(h.audited("bootstrap", h.bootstrap))).Methods("POST")
 	s.HandleFunc("/v2/catalog", createVarHandler(h.catalog)).Methods("GET")
 	s.HandleFunc("/v2/service_instances/{instance_uuid}", createVarHandler(h.getinstance)).Methods("GET")
-	s.HandleFunc("/v2/service_instances/{instance_uuid}", createVarHandler(h.provision)).Methods("PUT")
-	s.HandleFunc("/v2/service_instances/{instance_uuid}", createVarHandler(h.update)).Methods("PATCH")
-	s.HandleFunc("/v2/service_instances/{instance_uuid}", createVarHandler(h.deprovision)).Methods("DELETE")
+	s.HandleFunc("/v2/service_instances/{instance_uuid}", createVarHandler(h.audited("provision", h.provision))).Methods("PUT")
+	s.HandleFunc("/v2/service_instances/{instance_uuid}", createVarHandler(h.audited("update", h.update))).Methods("PATCH")
+	s.HandleFunc("/v2/service_instances/{instance_uuid}", createVarHandler(h.audited("deprovision", h.deprovision))).Methods("DELETE")
 	s.HandleFunc("/v2/service_instances/{instance_uuid}/service_bindings/{binding_uuid}",
 		createVarHandler(h.getbind)).Methods("GET")
 	s.HandleFunc("/v2/service_instances/{instance_uuid}/service_bindings/{binding_uuid}",
-		createVarHandler(h.bind)).Methods("PUT")
+		createVarHandler(h.audited("bind", h.bind))).Methods("PUT")
 	s.HandleFunc("/v2/service_instances/{instance_uuid}/service_bindings/{binding_uuid}",
-		createVarHandler(h.unbind)).Methods("DELETE")
+		createVarHandler(h.audited("unbind", h.unbind))).Methods("DELETE")
 	s.HandleFunc("/v2/service_instances/{instance_uuid}/last_operation",
 		createVarHandler(h.lastoperation)).Methods("GET")
 	s.HandleFunc("/v2/service_instances/{instance_uuid}/service_bindings/{binding_uuid}/last_operation",
 		createVarHandler(h.lastoperation)).Methods("GET")
 
 	if brokerConfig.GetBool("broker.dev_broker") {
-		s.HandleFunc("/v2/apb", createVarHandler(h.apbAddSpec)).Methods("POST")
-		s.HandleFunc("/v2/apb/{spec_id}", createVarHandler(h.apbRemoveSpec)).Methods("DELETE")
-		s.HandleFunc("/v2/apb", createVarHandler(h.apbRemoveSpecs)).Methods("DELETE")
+		s.HandleFunc("/v2/apb", createVarHandler(h.audited("add_spec", h.apbAddSpec))).Methods("POST")
+		s.HandleFunc("/v2/apb/{spec_id}", createVarHandler(h.audited("remove_spec", h.apbRemoveSpec))).Methods("DELETE")
+		s.HandleFunc("/v2/apb", createVarHandler(h.audited("remove_specs", h.apbRemoveSpecs))).Methods("DELETE")
+	}
+
+	if brokerConfig.GetBool("broker.admin_api") {
+		s.HandleFunc("/v2/admin/jobs/{job_token}/cancel", createVarHandler(h.audited("cancel_job", h.cancelJob))).Methods("POST")
+		s.HandleFunc("/v2/admin/dead_letters/replay", createVarHandler(h.audited("replay_dead_letters", h.replayDeadLetters))).Methods("POST")
 	}
 
 	return handlers.LoggingHandler(os.Stdout, userInfoHandler(authHandler(h, providers)))
@@ -870,6 +881,39 @@ func (h handler) apbRemoveSpecs(w http.ResponseWriter, r *http.Request, params m
 	writeDefaultResponse(w, http.StatusNoContent, struct{}{}, err)
 }
 
+// cancelJob - Admin route. Cancels the job of the token, which reports its
+// state as cancelled once it has stopped.
+func (h handler) cancelJob(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	adminBroker, ok := h.broker.(broker.AdminBroker)
+	if !ok {
+		log.Errorf("unable to use broker - %T as ansible service broker", h.broker)
+		writeResponse(w, http.StatusInternalServerError, broker.ErrorResponse{Description: "Internal server error"})
+		return
+	}
+	token := params["job_token"]
+	log.Infof("Cancelling job [ %s ]", token)
+	err := adminBroker.CancelJob(token)
+	if err == broker.ErrJobNotFound {
+		writeResponse(w, http.StatusNotFound, broker.ErrorResponse{Description: err.Error()})
+		return
+	}
+	writeDefaultResponse(w, http.StatusAccepted, struct{}{}, err)
+}
+
+// replayDeadLetters - Admin route. Hands the job messages the subscribers
+// failed to handle to them again.
+func (h handler) replayDeadLetters(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	adminBroker, ok := h.broker.(broker.AdminBroker)
+	if !ok {
+		log.Errorf("unable to use broker - %T as ansible service broker", h.broker)
+		writeResponse(w, http.StatusInternalServerError, broker.ErrorResponse{Description: "Internal server error"})
+		return
+	}
+	log.Info("Replaying dead letters")
+	resp, err := adminBroker.ReplayDeadLetters()
+	writeDefaultResponse(w, http.StatusOK, resp, err)
+}
+
 // printRequest - will print the request with the body.
 func (h handler) printRequest(req *http.Request) {
 	if h.brokerConfig.GetBool("broker.output_request") {
@@ -17,7 +17,10 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -28,6 +31,7 @@ import (
 	apb "github.com/automationbroker/bundle-lib/bundle"
 	"github.com/automationbroker/config"
 	"github.com/gorilla/mux"
+	"github.com/openshift/ansible-service-broker/pkg/audit"
 	"github.com/openshift/ansible-service-broker/pkg/auth"
 	"github.com/openshift/ansible-service-broker/pkg/broker"
 	ft "github.com/openshift/ansible-service-broker/pkg/fusortest"
@@ -133,6 +137,19 @@ func (m MockBroker) RemoveSpecs() error {
 	return nil
 }
 
+func (m MockBroker) CancelJob(token string) error {
+	m.called("cancelJob", true)
+	return m.Err
+}
+
+func (m MockBroker) ReplayDeadLetters() (*broker.ReplayResponse, error) {
+	m.called("replayDeadLetters", true)
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &broker.ReplayResponse{Replayed: 2, Left: 1}, nil
+}
+
 func (m MockBroker) GetBind(si apb.ServiceInstance, bindid uuid.UUID) (*broker.BindResponse, error) {
 	m.called("getBind", true)
 	return nil, nil
@@ -144,7 +161,7 @@ func TestNewHandler(t *testing.T) {
 	if err != nil {
 		t.Fail()
 	}
-	testhandler := NewHandler(testb, c, "", nil, nil)
+	testhandler := NewHandler(testb, c, "", nil, nil, nil)
 	ft.AssertNotNil(t, testhandler, "handler wasn't created")
 }
 
@@ -154,7 +171,7 @@ func TestNewHandlerDoesNotHaveAPBRoute(t *testing.T) {
 	if err != nil {
 		t.Fail()
 	}
-	testhandler := NewHandler(testb, c, "", nil, nil)
+	testhandler := NewHandler(testb, c, "", nil, nil, nil)
 	req, err := http.NewRequest(http.MethodPost, "/v2/spec", nil)
 	if err != nil {
 		ft.AssertTrue(t, false, err.Error())
@@ -175,7 +192,7 @@ func TestDevHandlerDoesHaveAPBRoute(t *testing.T) {
 	if err != nil {
 		t.Fail()
 	}
-	testhandler := NewHandler(testb, c, "", nil, nil)
+	testhandler := NewHandler(testb, c, "", nil, nil, nil)
 	req, err := http.NewRequest(http.MethodPost, "/v2/apb", nil)
 	if err != nil {
 		ft.AssertTrue(t, false, err.Error())
@@ -196,7 +213,7 @@ func TestNewHandlerDoesNotHaveAPBSpecDeleteRoute(t *testing.T) {
 	if err != nil {
 		t.Fail()
 	}
-	testhandler := NewHandler(testb, c, "", nil, nil)
+	testhandler := NewHandler(testb, c, "", nil, nil, nil)
 	req, err := http.NewRequest(http.MethodDelete, "/v2/apb", nil)
 	if err != nil {
 		ft.AssertTrue(t, false, err.Error())
@@ -213,7 +230,7 @@ func TestDevHandlerDoesHaveAPBSpecDeleteRoute(t *testing.T) {
 	if err != nil {
 		t.Fail()
 	}
-	testhandler := NewHandler(testb, c, "", nil, nil)
+	testhandler := NewHandler(testb, c, "", nil, nil, nil)
 	req, err := http.NewRequest(http.MethodDelete, "/v2/apb", nil)
 	if err != nil {
 		ft.AssertTrue(t, false, err.Error())
@@ -224,13 +241,147 @@ func TestDevHandlerDoesHaveAPBSpecDeleteRoute(t *testing.T) {
 	ft.AssertNotNil(t, testhandler, "handler wasn't created")
 }
 
+func TestNewHandlerDoesNotHaveCancelJobRoute(t *testing.T) {
+	testb := MockBroker{Name: "testbroker"}
+	c, err := config.CreateConfig("testdata/broker.yaml")
+	if err != nil {
+		t.Fail()
+	}
+	testhandler := NewHandler(testb, c, "", nil, nil, nil)
+	req, err := http.NewRequest(http.MethodPost, "/v2/admin/jobs/token/cancel", nil)
+	if err != nil {
+		ft.AssertTrue(t, false, err.Error())
+	}
+	w := httptest.NewRecorder()
+	testhandler.ServeHTTP(w, req)
+	ft.AssertEqual(t, w.Result().StatusCode, http.StatusNotFound, fmt.Sprintf("resulting status was not 404 - %v", w.Result().Status))
+}
+
+func TestAdminHandlerCancelJob(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{name: "cancelled", status: http.StatusAccepted},
+		{name: "unknown job", err: broker.ErrJobNotFound, status: http.StatusNotFound},
+		{name: "failure", err: errors.New("dao down"), status: http.StatusInternalServerError},
+	}
+	c, err := config.CreateConfig("testdata/dev_broker.yaml")
+	if err != nil {
+		t.Fail()
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			testhandler := NewHandler(MockBroker{Name: "testbroker", Err: tc.err}, c, "", nil, nil, nil)
+			req, err := http.NewRequest(http.MethodPost, "/v2/admin/jobs/token/cancel", nil)
+			if err != nil {
+				ft.AssertTrue(t, false, err.Error())
+			}
+			w := httptest.NewRecorder()
+			testhandler.ServeHTTP(w, req)
+			ft.AssertEqual(t, w.Result().StatusCode, tc.status, fmt.Sprintf("unexpected status - %v", w.Result().Status))
+		})
+	}
+}
+
+func TestAdminHandlerReplayDeadLetters(t *testing.T) {
+	c, err := config.CreateConfig("testdata/dev_broker.yaml")
+	if err != nil {
+		t.Fail()
+	}
+	testhandler := NewHandler(MockBroker{Name: "testbroker"}, c, "", nil, nil, nil)
+	req, err := http.NewRequest(http.MethodPost, "/v2/admin/dead_letters/replay", nil)
+	if err != nil {
+		ft.AssertTrue(t, false, err.Error())
+	}
+	w := httptest.NewRecorder()
+	testhandler.ServeHTTP(w, req)
+	ft.AssertEqual(t, w.Result().StatusCode, http.StatusOK, fmt.Sprintf("unexpected status - %v", w.Result().Status))
+	resp := broker.ReplayResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	ft.AssertEqual(t, 2, resp.Replayed)
+	ft.AssertEqual(t, 1, resp.Left)
+}
+
+type auditSink struct {
+	events []audit.Event
+}
+
+func (s *auditSink) Write(event audit.Event) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+type adminPrincipal struct{}
+
+func (adminPrincipal) GetType() string { return "user" }
+func (adminPrincipal) GetName() string { return "admin" }
+
+type adminProvider struct{}
+
+func (adminProvider) GetPrincipal(*http.Request) (auth.Principal, error) {
+	return adminPrincipal{}, nil
+}
+
+func TestAuditedRequest(t *testing.T) {
+	sink := &auditSink{}
+	c, err := config.CreateConfig("testdata/dev_broker.yaml")
+	if err != nil {
+		t.Fail()
+	}
+	testhandler := NewHandler(MockBroker{Name: "testbroker", Err: broker.ErrJobNotFound}, c, "",
+		[]auth.Provider{adminProvider{}}, nil, audit.NewAuditor([]audit.Sink{sink}))
+	req, err := http.NewRequest(http.MethodPost, "/v2/admin/jobs/token/cancel", nil)
+	if err != nil {
+		ft.AssertTrue(t, false, err.Error())
+	}
+	user := base64.StdEncoding.EncodeToString([]byte(`{"username":"alice","uid":"1","groups":["devs"]}`))
+	req.Header.Set(OriginatingIdentityHeader, "kubernetes "+user)
+	w := httptest.NewRecorder()
+	testhandler.ServeHTTP(w, req)
+	ft.AssertEqual(t, w.Result().StatusCode, http.StatusNotFound, fmt.Sprintf("unexpected status - %v", w.Result().Status))
+
+	ft.AssertEqual(t, 1, len(sink.events))
+	event := sink.events[0]
+	ft.AssertEqual(t, audit.KindRequest, event.Kind)
+	ft.AssertEqual(t, "cancel_job", event.Action)
+	ft.AssertEqual(t, "token", event.Operation)
+	ft.AssertEqual(t, http.StatusNotFound, event.Status)
+	ft.AssertTrue(t, event.Error != "")
+	ft.AssertEqual(t, "alice", event.User.Username)
+	ft.AssertEqual(t, "devs", event.User.Groups[0])
+	ft.AssertEqual(t, "admin", event.Principal.Name)
+}
+
+func TestAuditedRequestBody(t *testing.T) {
+	body := []byte(`{"service_id":"svc","plan_id":"dev","context":{"platform":"kubernetes","namespace":"project"},` +
+		`"parameters":{"db_password":"secret","size":"small"}}`)
+	req := httptest.NewRequest(http.MethodPut, "/v2/service_instances/instance?accepts_incomplete=true", bytes.NewReader(body))
+	recorder := &auditRecorder{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+	recorder.WriteHeader(http.StatusAccepted)
+	recorder.Write([]byte(`{"operation":"job-token"}`))
+
+	event := requestEvent("provision", req, map[string]string{"instance_uuid": "instance"}, body, recorder)
+	ft.AssertEqual(t, "instance", event.InstanceID)
+	ft.AssertEqual(t, "svc", event.ServiceID)
+	ft.AssertEqual(t, "dev", event.PlanID)
+	ft.AssertEqual(t, "project", event.Namespace)
+	ft.AssertEqual(t, "small", event.Parameters["size"])
+	ft.AssertEqual(t, http.StatusAccepted, event.Status)
+	ft.AssertEqual(t, "job-token", event.Operation)
+	ft.AssertEqual(t, "", event.Error)
+}
+
 func TestNewHandlerDoesNotHaveAPBSpecsDeleteRoute(t *testing.T) {
 	testb := MockBroker{Name: "testbroker"}
 	c, err := config.CreateConfig("testdata/broker.yaml")
 	if err != nil {
 		t.Fail()
 	}
-	testhandler := NewHandler(testb, c, "", nil, nil)
+	testhandler := NewHandler(testb, c, "", nil, nil, nil)
 	req, err := http.NewRequest(http.MethodDelete, "/v2/apb", nil)
 	if err != nil {
 		ft.AssertTrue(t, false, err.Error())
@@ -247,7 +398,7 @@ func TestDevHandlerDoesHaveAPBSpecsDeleteRoute(t *testing.T) {
 	if err != nil {
 		t.Fail()
 	}
-	testhandler := NewHandler(testb, c, "", nil, nil)
+	testhandler := NewHandler(testb, c, "", nil, nil, nil)
 	req, err := http.NewRequest(http.MethodDelete, "/v2/apb", nil)
 	if err != nil {
 		ft.AssertTrue(t, false, err.Error())
@@ -436,7 +587,7 @@ func TestLastOperation(t *testing.T) {
 func buildBootstrapHandler(err error) (handler, *httptest.ResponseRecorder, *http.Request) {
 	testb := MockBroker{Name: "testbroker", Err: err}
 	c, err := config.CreateConfig("testdata/broker.yaml")
-	testhandler := handler{*mux.NewRouter(), testb, c, nil}
+	testhandler := handler{*mux.NewRouter(), testb, c, nil, nil}
 
 	r := httptest.NewRequest("POST", "/v2/bootstrap", nil)
 	w := httptest.NewRecorder()
@@ -446,7 +597,7 @@ func buildBootstrapHandler(err error) (handler, *httptest.ResponseRecorder, *htt
 func buildCatalogHandler(err error) (handler, *httptest.ResponseRecorder, *http.Request) {
 	testb := MockBroker{Name: "testbroker", Err: err}
 	c, err := config.CreateConfig("testdata/broker.yaml")
-	testhandler := handler{*mux.NewRouter(), testb, c, nil}
+	testhandler := handler{*mux.NewRouter(), testb, c, nil, nil}
 	r := httptest.NewRequest("GET", "/v2/catalog", nil)
 	w := httptest.NewRecorder()
 	return testhandler, w, r
@@ -456,7 +607,7 @@ func buildProvisionHandler(testuuid string, err error, operation string) (handle
 
 	testb := MockBroker{Name: "testbroker", Err: err, Operation: operation}
 	c, err := config.CreateConfig("testdata/broker.yaml")
-	testhandler := handler{*mux.NewRouter(), testb, c, nil}
+	testhandler := handler{*mux.NewRouter(), testb, c, nil, nil}
 	trr := TestRequest{Msg: fmt.Sprintf("{\"plan_id\": \"%s\",\"service_id\": \"%s\"}", testuuid, testuuid)}
 	r := httptest.NewRequest("PUT", fmt.Sprintf("/v2/service_instance/%s", testuuid), trr)
 	r.Header.Add("Content-Type", "application/json")
@@ -473,7 +624,7 @@ func buildLastOperationHandler(testuuid string, err error) (handler, *httptest.R
 
 	testb := MockBroker{Name: "testbroker", Err: err}
 	c, _ := config.CreateConfig("testdata/broker.yaml")
-	testhandler := handler{*mux.NewRouter(), testb, c, nil}
+	testhandler := handler{*mux.NewRouter(), testb, c, nil, nil}
 	r := httptest.NewRequest("GET",
 		fmt.Sprintf("/v2/service_instance/%s/last_operation?operation=%s", testuuid, testuuid), nil)
 	r.Header.Add("Content-Type", "application/json")
@@ -489,7 +640,7 @@ func buildBindHandler(instanceuuid string, bindinguuid string, err error) (handl
 
 	testb := MockBroker{Name: "testbroker", Err: err}
 	c, _ := config.CreateConfig("testdata/broker.yaml")
-	testhandler := handler{*mux.NewRouter(), testb, c, nil}
+	testhandler := handler{*mux.NewRouter(), testb, c, nil, nil}
 	trr := TestRequest{Msg: fmt.Sprintf("{\"plan_id\": \"%s\",\"service_id\": \"%s\"}", uuid.New(), uuid.New())}
 	r := httptest.NewRequest("PUT",
 		fmt.Sprintf("/v2/service_instance/%s/service_bindings/%s", instanceuuid, bindinguuid), trr)
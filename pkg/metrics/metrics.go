@@ -17,6 +17,8 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
@@ -90,6 +92,70 @@ var (
 			Name:      "actions_requested",
 			Help:      "How many actions have been made.",
 		}, []string{"action"})
+
+	jobStatesPruned = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "job_states_pruned",
+			Help:      "How many job states have been removed by the job state garbage collector.",
+		}, []string{"reason"})
+
+	daoCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "dao_cache_requests",
+			Help:      "How many reads the dao cache answered (hit) or passed on to the data store (miss).",
+		}, []string{"kind", "result"})
+
+	daoRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: subsystem,
+			Name:      "dao_request_duration_seconds",
+			Help:      "How long the dao calls took, by method and backend type.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "backend"})
+
+	daoErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "dao_errors",
+			Help:      "How many dao calls failed, by method, backend type and reason: not_found, conflict or error.",
+		}, []string{"method", "backend", "reason"})
+
+	workQueueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: subsystem,
+			Name:      "work_queue_depth",
+			Help:      "How many jobs are waiting for the concurrency limits of the work engine to let them run.",
+		})
+
+	jobRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "job_retries",
+			Help:      "How many failed jobs the work engine ran again, by action.",
+		}, []string{"action"})
+
+	deadLetters = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "dead_letters",
+			Help:      "How many job messages a subscriber failed to handle were stored as dead letters, by subscriber.",
+		}, []string{"subscriber"})
+
+	leader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: subsystem,
+			Name:      "leader",
+			Help:      "Whether the broker holds the leader lease, 1 when it does.",
+		})
+
+	daoInconsistencies = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: subsystem,
+			Name:      "dao_inconsistencies",
+			Help:      "How many inconsistent records the last dao consistency check found.",
+		}, []string{"kind"})
 )
 
 func init() {
@@ -100,6 +166,15 @@ func init() {
 	prometheus.MustRegister(deprovisionJob)
 	prometheus.MustRegister(updateJob)
 	prometheus.MustRegister(requests)
+	prometheus.MustRegister(jobStatesPruned)
+	prometheus.MustRegister(daoCacheRequests)
+	prometheus.MustRegister(daoInconsistencies)
+	prometheus.MustRegister(daoRequestDuration)
+	prometheus.MustRegister(daoErrors)
+	prometheus.MustRegister(workQueueDepth)
+	prometheus.MustRegister(jobRetries)
+	prometheus.MustRegister(deadLetters)
+	prometheus.MustRegister(leader)
 }
 
 // We will never want to panic our app because of metric saving.
@@ -194,3 +269,74 @@ func ActionStarted(action string) {
 	defer recoverMetricPanic()
 	requests.WithLabelValues(action).Inc()
 }
+
+// JobStatesPruned - Registers the number of job states removed for a reason.
+func JobStatesPruned(reason string, count int) {
+	defer recoverMetricPanic()
+	jobStatesPruned.WithLabelValues(reason).Add(float64(count))
+}
+
+// DAOCacheHit - Registers a read of kind answered by the dao cache.
+func DAOCacheHit(kind string) {
+	defer recoverMetricPanic()
+	daoCacheRequests.WithLabelValues(kind, "hit").Inc()
+}
+
+// DAOCacheMiss - Registers a read of kind the dao cache passed on to the data
+// store.
+func DAOCacheMiss(kind string) {
+	defer recoverMetricPanic()
+	daoCacheRequests.WithLabelValues(kind, "miss").Inc()
+}
+
+// DAORequest - Registers how long a call of method on the backend dao took.
+func DAORequest(method, backend string, duration time.Duration) {
+	defer recoverMetricPanic()
+	daoRequestDuration.WithLabelValues(method, backend).Observe(duration.Seconds())
+}
+
+// DAOError - Registers a failed call of method on the backend dao.
+func DAOError(method, backend, reason string) {
+	defer recoverMetricPanic()
+	daoErrors.WithLabelValues(method, backend, reason).Inc()
+}
+
+// DAOInconsistencies - Registers how many inconsistent records of each kind
+// the last dao consistency check found.
+func DAOInconsistencies(counts map[string]int) {
+	defer recoverMetricPanic()
+	daoInconsistencies.Reset()
+	for kind, count := range counts {
+		daoInconsistencies.WithLabelValues(kind).Set(float64(count))
+	}
+}
+
+// WorkQueueDepth - Registers how many jobs are waiting in the work engine
+// queue.
+func WorkQueueDepth(depth int) {
+	defer recoverMetricPanic()
+	workQueueDepth.Set(float64(depth))
+}
+
+// JobRetried - Registers a failed job of action run again.
+func JobRetried(action string) {
+	defer recoverMetricPanic()
+	jobRetries.WithLabelValues(action).Inc()
+}
+
+// DeadLettered - Registers a job message the subscriber failed to handle
+// stored as a dead letter.
+func DeadLettered(subscriber string) {
+	defer recoverMetricPanic()
+	deadLetters.WithLabelValues(subscriber).Inc()
+}
+
+// Leader - Registers whether the broker holds the leader lease.
+func Leader(isLeader bool) {
+	defer recoverMetricPanic()
+	if isLeader {
+		leader.Set(1)
+	} else {
+		leader.Set(0)
+	}
+}
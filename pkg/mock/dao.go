@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	apb "github.com/automationbroker/bundle-lib/bundle"
+	"github.com/openshift/ansible-service-broker/pkg/dao/types"
 )
 
 // SubscriberDAO is mock DAO
@@ -43,6 +44,36 @@ func (mp *SubscriberDAO) SetState(id string, state apb.JobState) (string, error)
 
 }
 
+// GetStateVersion mock impl
+func (mp *SubscriberDAO) GetStateVersion(id string, token string) (apb.JobState, string, error) {
+	assert := mp.AssertOn["GetStateVersion"]
+	if nil != assert {
+		if err := assert(id, token); err != nil {
+			mp.assertErr = append(mp.assertErr, err)
+			return apb.JobState{}, "", err
+		}
+	}
+	mp.calls["GetStateVersion"]++
+	retOb := mp.Object["GetStateVersion"]
+	if nil == retOb {
+		return apb.JobState{}, "", mp.Errs["GetStateVersion"]
+	}
+	return retOb.(apb.JobState), "1", mp.Errs["GetStateVersion"]
+}
+
+// CompareAndSetState mock impl
+func (mp *SubscriberDAO) CompareAndSetState(id string, state apb.JobState, version string) (string, error) {
+	assert := mp.AssertOn["CompareAndSetState"]
+	if nil != assert {
+		if err := assert(id, state, version); err != nil {
+			mp.assertErr = append(mp.assertErr, err)
+			return "", err
+		}
+	}
+	mp.calls["CompareAndSetState"]++
+	return "2", mp.Errs["CompareAndSetState"]
+}
+
 // DeleteExtractedCredentials deletes extracted credentials
 func (mp *SubscriberDAO) DeleteExtractedCredentials(id string) error {
 	assert := mp.AssertOn["DeleteExtractedCredentials"]
@@ -129,6 +160,74 @@ func (mp *SubscriberDAO) GetBindInstance(id string) (*apb.BindInstance, error) {
 	return retOb.(*apb.BindInstance), mp.Errs["GetBindInstance"]
 }
 
+// GetServiceInstanceVersion mock impl
+func (mp *SubscriberDAO) GetServiceInstanceVersion(id string) (*apb.ServiceInstance, string, error) {
+	assert := mp.AssertOn["GetServiceInstanceVersion"]
+	if nil != assert {
+		if err := assert(id); err != nil {
+			mp.assertErr = append(mp.assertErr, err)
+			return nil, "", err
+		}
+	}
+	mp.calls["GetServiceInstanceVersion"]++
+	retOb := mp.Object["GetServiceInstanceVersion"]
+	if nil == retOb {
+		return nil, "", mp.Errs["GetServiceInstanceVersion"]
+	}
+	return retOb.(*apb.ServiceInstance), "1", mp.Errs["GetServiceInstanceVersion"]
+}
+
+// CompareAndSetServiceInstance mock impl
+func (mp *SubscriberDAO) CompareAndSetServiceInstance(id string, serviceInstance *apb.ServiceInstance, version string) (string, error) {
+	assert := mp.AssertOn["CompareAndSetServiceInstance"]
+	if nil != assert {
+		if err := assert(id, serviceInstance, version); err != nil {
+			mp.assertErr = append(mp.assertErr, err)
+			return "", err
+		}
+	}
+	mp.calls["CompareAndSetServiceInstance"]++
+	return "2", mp.Errs["CompareAndSetServiceInstance"]
+}
+
+// IsConflictError mock impl, an error is a conflict when it is the one set
+// in Errs["IsConflictError"]
+func (mp *SubscriberDAO) IsConflictError(err error) bool {
+	return err != nil && err == mp.Errs["IsConflictError"]
+}
+
+// IsNotFoundError mock impl, an error is a not found error when it is the one
+// set in Errs["IsNotFoundError"]
+func (mp *SubscriberDAO) IsNotFoundError(err error) bool {
+	return err != nil && err == mp.Errs["IsNotFoundError"]
+}
+
+// Apply mock impl
+func (mp *SubscriberDAO) Apply(batch types.Batch) error {
+	assert := mp.AssertOn["Apply"]
+	if nil != assert {
+		if err := assert(batch); err != nil {
+			mp.assertErr = append(mp.assertErr, err)
+			return err
+		}
+	}
+	mp.calls["Apply"]++
+	return mp.Errs["Apply"]
+}
+
+// CompleteIntent mock impl
+func (mp *SubscriberDAO) CompleteIntent(id string) error {
+	assert := mp.AssertOn["CompleteIntent"]
+	if nil != assert {
+		if err := assert(id); err != nil {
+			mp.assertErr = append(mp.assertErr, err)
+			return err
+		}
+	}
+	mp.calls["CompleteIntent"]++
+	return mp.Errs["CompleteIntent"]
+}
+
 // CheckCalls will check the calls made match the expected calls
 func (mp *SubscriberDAO) CheckCalls(calls map[string]int) error {
 	for k, v := range calls {